@@ -31,11 +31,15 @@ const (
 // CalendarClient is the client API for Calendar service.
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Calendar is also served as JSON over HTTP by a grpc-gateway proxy, using
+// the google.api.http mappings below.
 type CalendarClient interface {
 	CreateEvent(ctx context.Context, in *CreateEventRequest, opts ...grpc.CallOption) (*CreateEventResponse, error)
 	GetEvent(ctx context.Context, in *GetEventRequest, opts ...grpc.CallOption) (*GetEventResponse, error)
 	EditEvent(ctx context.Context, in *EditEventRequest, opts ...grpc.CallOption) (*EditEventResponse, error)
 	DeleteEvent(ctx context.Context, in *DeleteEventRequest, opts ...grpc.CallOption) (*DeleteEventResponse, error)
+	// date is a Unix time in seconds.
 	GetEventsDay(ctx context.Context, in *GetEventsDayRequest, opts ...grpc.CallOption) (*GetEventsDayResponse, error)
 	GetEventsWeek(ctx context.Context, in *GetEventsWeekRequest, opts ...grpc.CallOption) (*GetEventsWeekResponse, error)
 	GetEventsMonth(ctx context.Context, in *GetEventsMonthRequest, opts ...grpc.CallOption) (*GetEventsMonthResponse, error)
@@ -122,11 +126,15 @@ func (c *calendarClient) GetEventsMonth(ctx context.Context, in *GetEventsMonthR
 // CalendarServer is the server API for Calendar service.
 // All implementations must embed UnimplementedCalendarServer
 // for forward compatibility.
+//
+// Calendar is also served as JSON over HTTP by a grpc-gateway proxy, using
+// the google.api.http mappings below.
 type CalendarServer interface {
 	CreateEvent(context.Context, *CreateEventRequest) (*CreateEventResponse, error)
 	GetEvent(context.Context, *GetEventRequest) (*GetEventResponse, error)
 	EditEvent(context.Context, *EditEventRequest) (*EditEventResponse, error)
 	DeleteEvent(context.Context, *DeleteEventRequest) (*DeleteEventResponse, error)
+	// date is a Unix time in seconds.
 	GetEventsDay(context.Context, *GetEventsDayRequest) (*GetEventsDayResponse, error)
 	GetEventsWeek(context.Context, *GetEventsWeekRequest) (*GetEventsWeekResponse, error)
 	GetEventsMonth(context.Context, *GetEventsMonthRequest) (*GetEventsMonthResponse, error)
@@ -0,0 +1,359 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.28.2
+// source: v2/EventService.proto
+
+package apiv2
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Calendar_CreateEvent_FullMethodName    = "/event.v2.Calendar/CreateEvent"
+	Calendar_GetEvent_FullMethodName       = "/event.v2.Calendar/GetEvent"
+	Calendar_EditEvent_FullMethodName      = "/event.v2.Calendar/EditEvent"
+	Calendar_DeleteEvent_FullMethodName    = "/event.v2.Calendar/DeleteEvent"
+	Calendar_GetEventsDay_FullMethodName   = "/event.v2.Calendar/GetEventsDay"
+	Calendar_GetEventsWeek_FullMethodName  = "/event.v2.Calendar/GetEventsWeek"
+	Calendar_GetEventsMonth_FullMethodName = "/event.v2.Calendar/GetEventsMonth"
+)
+
+// CalendarClient is the client API for Calendar service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Calendar is event.Calendar with times and periods as well-known types
+// instead of bare integers. Both versions are served side by side and work
+// on the same events. Over HTTP, timestamps are RFC 3339 strings and
+// durations are strings such as "900s".
+type CalendarClient interface {
+	CreateEvent(ctx context.Context, in *CreateEventRequest, opts ...grpc.CallOption) (*CreateEventResponse, error)
+	GetEvent(ctx context.Context, in *GetEventRequest, opts ...grpc.CallOption) (*GetEventResponse, error)
+	EditEvent(ctx context.Context, in *EditEventRequest, opts ...grpc.CallOption) (*EditEventResponse, error)
+	DeleteEvent(ctx context.Context, in *DeleteEventRequest, opts ...grpc.CallOption) (*DeleteEventResponse, error)
+	GetEventsDay(ctx context.Context, in *GetEventsDayRequest, opts ...grpc.CallOption) (*GetEventsDayResponse, error)
+	GetEventsWeek(ctx context.Context, in *GetEventsWeekRequest, opts ...grpc.CallOption) (*GetEventsWeekResponse, error)
+	GetEventsMonth(ctx context.Context, in *GetEventsMonthRequest, opts ...grpc.CallOption) (*GetEventsMonthResponse, error)
+}
+
+type calendarClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCalendarClient(cc grpc.ClientConnInterface) CalendarClient {
+	return &calendarClient{cc}
+}
+
+func (c *calendarClient) CreateEvent(ctx context.Context, in *CreateEventRequest, opts ...grpc.CallOption) (*CreateEventResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CreateEventResponse)
+	err := c.cc.Invoke(ctx, Calendar_CreateEvent_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *calendarClient) GetEvent(ctx context.Context, in *GetEventRequest, opts ...grpc.CallOption) (*GetEventResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetEventResponse)
+	err := c.cc.Invoke(ctx, Calendar_GetEvent_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *calendarClient) EditEvent(ctx context.Context, in *EditEventRequest, opts ...grpc.CallOption) (*EditEventResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(EditEventResponse)
+	err := c.cc.Invoke(ctx, Calendar_EditEvent_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *calendarClient) DeleteEvent(ctx context.Context, in *DeleteEventRequest, opts ...grpc.CallOption) (*DeleteEventResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteEventResponse)
+	err := c.cc.Invoke(ctx, Calendar_DeleteEvent_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *calendarClient) GetEventsDay(ctx context.Context, in *GetEventsDayRequest, opts ...grpc.CallOption) (*GetEventsDayResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetEventsDayResponse)
+	err := c.cc.Invoke(ctx, Calendar_GetEventsDay_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *calendarClient) GetEventsWeek(ctx context.Context, in *GetEventsWeekRequest, opts ...grpc.CallOption) (*GetEventsWeekResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetEventsWeekResponse)
+	err := c.cc.Invoke(ctx, Calendar_GetEventsWeek_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *calendarClient) GetEventsMonth(ctx context.Context, in *GetEventsMonthRequest, opts ...grpc.CallOption) (*GetEventsMonthResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetEventsMonthResponse)
+	err := c.cc.Invoke(ctx, Calendar_GetEventsMonth_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CalendarServer is the server API for Calendar service.
+// All implementations must embed UnimplementedCalendarServer
+// for forward compatibility.
+//
+// Calendar is event.Calendar with times and periods as well-known types
+// instead of bare integers. Both versions are served side by side and work
+// on the same events. Over HTTP, timestamps are RFC 3339 strings and
+// durations are strings such as "900s".
+type CalendarServer interface {
+	CreateEvent(context.Context, *CreateEventRequest) (*CreateEventResponse, error)
+	GetEvent(context.Context, *GetEventRequest) (*GetEventResponse, error)
+	EditEvent(context.Context, *EditEventRequest) (*EditEventResponse, error)
+	DeleteEvent(context.Context, *DeleteEventRequest) (*DeleteEventResponse, error)
+	GetEventsDay(context.Context, *GetEventsDayRequest) (*GetEventsDayResponse, error)
+	GetEventsWeek(context.Context, *GetEventsWeekRequest) (*GetEventsWeekResponse, error)
+	GetEventsMonth(context.Context, *GetEventsMonthRequest) (*GetEventsMonthResponse, error)
+	mustEmbedUnimplementedCalendarServer()
+}
+
+// UnimplementedCalendarServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedCalendarServer struct{}
+
+func (UnimplementedCalendarServer) CreateEvent(context.Context, *CreateEventRequest) (*CreateEventResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateEvent not implemented")
+}
+func (UnimplementedCalendarServer) GetEvent(context.Context, *GetEventRequest) (*GetEventResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetEvent not implemented")
+}
+func (UnimplementedCalendarServer) EditEvent(context.Context, *EditEventRequest) (*EditEventResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EditEvent not implemented")
+}
+func (UnimplementedCalendarServer) DeleteEvent(context.Context, *DeleteEventRequest) (*DeleteEventResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteEvent not implemented")
+}
+func (UnimplementedCalendarServer) GetEventsDay(context.Context, *GetEventsDayRequest) (*GetEventsDayResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetEventsDay not implemented")
+}
+func (UnimplementedCalendarServer) GetEventsWeek(context.Context, *GetEventsWeekRequest) (*GetEventsWeekResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetEventsWeek not implemented")
+}
+func (UnimplementedCalendarServer) GetEventsMonth(context.Context, *GetEventsMonthRequest) (*GetEventsMonthResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetEventsMonth not implemented")
+}
+func (UnimplementedCalendarServer) mustEmbedUnimplementedCalendarServer() {}
+func (UnimplementedCalendarServer) testEmbeddedByValue()                  {}
+
+// UnsafeCalendarServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CalendarServer will
+// result in compilation errors.
+type UnsafeCalendarServer interface {
+	mustEmbedUnimplementedCalendarServer()
+}
+
+func RegisterCalendarServer(s grpc.ServiceRegistrar, srv CalendarServer) {
+	// If the following call pancis, it indicates UnimplementedCalendarServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Calendar_ServiceDesc, srv)
+}
+
+func _Calendar_CreateEvent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateEventRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalendarServer).CreateEvent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Calendar_CreateEvent_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalendarServer).CreateEvent(ctx, req.(*CreateEventRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Calendar_GetEvent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetEventRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalendarServer).GetEvent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Calendar_GetEvent_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalendarServer).GetEvent(ctx, req.(*GetEventRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Calendar_EditEvent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EditEventRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalendarServer).EditEvent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Calendar_EditEvent_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalendarServer).EditEvent(ctx, req.(*EditEventRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Calendar_DeleteEvent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteEventRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalendarServer).DeleteEvent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Calendar_DeleteEvent_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalendarServer).DeleteEvent(ctx, req.(*DeleteEventRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Calendar_GetEventsDay_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetEventsDayRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalendarServer).GetEventsDay(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Calendar_GetEventsDay_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalendarServer).GetEventsDay(ctx, req.(*GetEventsDayRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Calendar_GetEventsWeek_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetEventsWeekRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalendarServer).GetEventsWeek(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Calendar_GetEventsWeek_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalendarServer).GetEventsWeek(ctx, req.(*GetEventsWeekRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Calendar_GetEventsMonth_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetEventsMonthRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalendarServer).GetEventsMonth(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Calendar_GetEventsMonth_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalendarServer).GetEventsMonth(ctx, req.(*GetEventsMonthRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Calendar_ServiceDesc is the grpc.ServiceDesc for Calendar service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Calendar_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "event.v2.Calendar",
+	HandlerType: (*CalendarServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateEvent",
+			Handler:    _Calendar_CreateEvent_Handler,
+		},
+		{
+			MethodName: "GetEvent",
+			Handler:    _Calendar_GetEvent_Handler,
+		},
+		{
+			MethodName: "EditEvent",
+			Handler:    _Calendar_EditEvent_Handler,
+		},
+		{
+			MethodName: "DeleteEvent",
+			Handler:    _Calendar_DeleteEvent_Handler,
+		},
+		{
+			MethodName: "GetEventsDay",
+			Handler:    _Calendar_GetEventsDay_Handler,
+		},
+		{
+			MethodName: "GetEventsWeek",
+			Handler:    _Calendar_GetEventsWeek_Handler,
+		},
+		{
+			MethodName: "GetEventsMonth",
+			Handler:    _Calendar_GetEventsMonth_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "v2/EventService.proto",
+}
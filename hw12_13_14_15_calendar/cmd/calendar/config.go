@@ -3,40 +3,181 @@ package main
 import "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/helper"
 
 type Config struct {
-	Logger  LoggerConf
-	DB      DBConf
-	Storage string
-	Server  Server
-	GRPC    GRPC
+	Logger    LoggerConf
+	DB        DBConf
+	Storage   string `required:"true"`
+	Server    Server
+	GRPC      GRPC
+	Admin     AdminConf
+	TLS       TLSConf
+	Gateway   GatewayConf
+	Tracing   TracingConf
+	RateLimit RateLimitConf
+	Quota     QuotaConf
+	Cache     CacheConf
 }
 
+// LoggerConf lists in Redact the keys masked in logs on top of
+// loggerslog.DefaultRedactKeys. Format is "text" or "json". With File set,
+// logs go there instead of stderr and the file is rotated at MaxSize
+// megabytes, keeping MaxBackups old files.
 type LoggerConf struct {
-	Level string
+	Level      string
+	Format     string
+	Redact     []string
+	File       string
+	MaxSize    int
+	MaxBackups int
 }
 
+// DBConf is either DSN or the fields it would be built from. ConnectTimeout
+// and the lifetimes of Pool are in seconds. With Replica set, GetEvent and
+// the event lists are read from the replica.
+// Migrate applies pending migrations on startup.
 type DBConf struct {
-	User     string
-	Password string
-	Name     string
-	Host     string
-	Port     string
+	DSN             helper.Secret
+	User            string
+	Password        helper.Secret
+	Name            string
+	Host            string
+	Port            string
+	SSLMode         string
+	SSLRootCert     string
+	ConnectTimeout  int
+	ConnectAttempts int
+	QueryAttempts   int
+	Pool            helper.DBPoolConfig
+	Replica         helper.DBReplicaConfig
+	Migrate         bool
 }
 
 type Server struct {
 	Host string
-	Port string
+	Port string `required:"true"`
 }
 
 type GRPC struct {
+	Host string
+	Port string `required:"true"`
+}
+
+// AdminConf is where /config serves the effective configuration.
+type AdminConf struct {
 	Host string
 	Port string
 }
 
-func LoadConfig(path string) (Config, error) {
-	config, err := helper.NewConfig[Config](path)
+// TLSConf is shared by the REST and gRPC servers; without CertFile both
+// serve plaintext. ClientCAFile turns on mutual TLS. The files are checked
+// for changes every ReloadInterval seconds, 0 disables reloading.
+type TLSConf struct {
+	CertFile       string
+	KeyFile        string
+	ClientCAFile   string
+	MinVersion     string
+	ReloadInterval int
+}
+
+// GatewayConf enables the /api/v1 and /api/v2 REST mappings of the gRPC
+// service, proxied to the gRPC server of this process. When TLS is on, the
+// gateway trusts CAFile and, for mutual TLS, presents CertFile and KeyFile.
+type GatewayConf struct {
+	Enabled    bool
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	ServerName string
+}
+
+type TracingConf struct {
+	Exporter    string
+	Endpoint    string
+	Insecure    bool
+	SampleRatio float64
+}
+
+// RateLimitConf is the default token bucket per client; Routes overrides it
+// by HTTP pattern ("POST /event/create") or gRPC method
+// ("/event.Calendar/CreateEvent").
+type RateLimitConf struct {
+	Rate   float64
+	Burst  int
+	Routes map[string]RateLimitRule
+}
+
+type RateLimitRule struct {
+	Rate  float64
+	Burst int
+}
+
+type QuotaConf struct {
+	MaxEventsPerUser int
+}
+
+// CacheConf keeps up to Size results of GetEvent and the event lists in
+// memory for TTL seconds. The cache is per process: writes made through
+// other replicas show up once the entries expire. With a DB replica, reads
+// made less than ReplicaLag seconds after a write are not cached.
+type CacheConf struct {
+	Enabled    bool
+	Size       int
+	TTL        int
+	ReplicaLag int
+}
+
+func defaultConfig() Config {
+	return Config{
+		Logger:  LoggerConf{Level: "INFO", Format: "text", MaxSize: 100, MaxBackups: 5},
+		DB:      DBConf{Port: "5432", SSLMode: "disable"},
+		Admin:   AdminConf{Host: "127.0.0.1", Port: "9102"},
+		TLS:     TLSConf{MinVersion: "1.2", ReloadInterval: 60},
+		Gateway: GatewayConf{Enabled: true},
+		Tracing: TracingConf{Exporter: "none", Endpoint: "localhost:4317", Insecure: true, SampleRatio: 1},
+		Cache:   CacheConf{Size: 1024, TTL: 30, ReplicaLag: 5},
+	}
+}
+
+// LoadConfig layers files, environment variables prefixed with CALENDAR_ and
+// key=value overrides over defaultConfig.
+func LoadConfig(files, overrides []string) (Config, error) {
+	config, err := helper.LoadConfig(helper.ConfigOptions[Config]{
+		Defaults:  defaultConfig(),
+		Files:     files,
+		EnvPrefix: "CALENDAR",
+		Overrides: overrides,
+	})
 	if err != nil {
 		return Config{}, err
 	}
 
 	return *config, nil
 }
+
+func loggerConfig(config LoggerConf) helper.LoggerConfig {
+	return helper.LoggerConfig{
+		Level:      config.Level,
+		Format:     config.Format,
+		Redact:     config.Redact,
+		File:       config.File,
+		MaxSize:    config.MaxSize,
+		MaxBackups: config.MaxBackups,
+	}
+}
+
+func dbConfig(config DBConf) helper.DBConfig {
+	return helper.DBConfig{
+		DSN:             config.DSN,
+		User:            config.User,
+		Password:        config.Password,
+		Name:            config.Name,
+		Host:            config.Host,
+		Port:            config.Port,
+		SSLMode:         config.SSLMode,
+		SSLRootCert:     config.SSLRootCert,
+		ConnectTimeout:  config.ConnectTimeout,
+		ConnectAttempts: config.ConnectAttempts,
+		QueryAttempts:   config.QueryAttempts,
+		Pool:            config.Pool,
+		Replica:         config.Replica,
+	}
+}
@@ -1,28 +1,41 @@
-//go:generate protoc --proto_path=../../api --go_out=../../api --go_opt=paths=source_relative --go-grpc_out=../../api --go-grpc_opt=paths=source_relative ../../api/EventService.proto
+//go:generate protoc --proto_path=../../api --proto_path=../../api/third_party --go_out=../../api --go_opt=paths=source_relative --go-grpc_out=../../api --go-grpc_opt=paths=source_relative --grpc-gateway_out=../../api --grpc-gateway_opt=paths=source_relative ../../api/EventService.proto ../../api/v2/EventService.proto
 
 package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/app"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/clock"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/health"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/helper"
 	loggerslog "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger/slog"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/ratelimit"
+	internaladmin "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/server/admin"
 	internalgrpc "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/server/grpc"
 	internalhttp "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/server/http"
+	cachestorage "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage/cache"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/tlsconfig"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/tracing"
 	_ "github.com/lib/pq"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
-var configFile string
+var configFlags *helper.ConfigFlags
 
 func init() {
-	flag.StringVar(&configFile, "config", "/etc/calendar/config.toml", "Path to configuration file")
+	configFlags = helper.NewConfigFlags(flag.CommandLine, "/etc/calendar/config.toml")
 }
 
 func main() {
@@ -33,37 +46,110 @@ func main() {
 		return
 	}
 
-	config, err := LoadConfig(configFile)
+	loadConfig := func() (Config, error) {
+		return LoadConfig(configFlags.Files(), configFlags.Overrides())
+	}
+	config, err := loadConfig()
 	if err != nil {
-		log.Fatalf("failed to read config from %q: %v", configFile, err)
+		log.Fatalf("failed to read config from %q: %v", configFlags.Files(), err)
 	}
 
-	logg, err := loggerslog.New(os.Stderr, config.Logger.Level)
+	if flag.Arg(0) == "config" && flag.Arg(1) == "check" {
+		if err := helper.PrintConfig(os.Stdout, config); err != nil {
+			log.Fatalf("failed to print config: %v", err)
+		}
+		return
+	}
+
+	logg, closeLogger, err := helper.InitLogger(loggerConfig(config.Logger))
 	if err != nil {
 		log.Fatalf("failed to create logger: %v", err)
 	}
+	defer closeLogger()
+
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(context.Background(), dbConfig(config.DB), flag.Arg(1), os.Stdout); err != nil {
+			logg.Error("failed to migrate", "err", err)
+			os.Exit(1) //nolint:gocritic
+		}
+		return
+	}
 
-	ctx, cancel := signal.NotifyContext(context.Background(),
-		syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	storage, closeStorage, err := helper.InitStorage(ctx, helper.DBConfig{
-		User:     config.DB.User,
-		Password: config.DB.Password,
-		Name:     config.DB.Name,
-		Host:     config.DB.Host,
-		Port:     config.DB.Port,
-	}, config.Storage)
+	shutdownTracing, err := tracing.Init(ctx, "calendar", tracing.Config{
+		Exporter:    config.Tracing.Exporter,
+		Endpoint:    config.Tracing.Endpoint,
+		Insecure:    config.Tracing.Insecure,
+		SampleRatio: config.Tracing.SampleRatio,
+	})
+	if err != nil {
+		logg.Error("failed to init tracing", "err", err)
+		os.Exit(1) //nolint:gocritic
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logg.Error("failed to flush traces", "err", err)
+		}
+	}()
+
+	checker := health.New(health.DefaultTimeout)
+	if config.Storage == "sql" && config.DB.Migrate {
+		if err := migrateOnStartup(ctx, dbConfig(config.DB), logg); err != nil {
+			logg.Error("failed to migrate database", "err", err)
+			os.Exit(1) //nolint:gocritic
+		}
+	}
+	storage, closeStorage, err := helper.InitStorage(ctx, dbConfig(config.DB), config.Storage, clock.New(), logg)
 	if err != nil {
 		logg.Error("failed to run database", "err", err)
 		cancel()
+	} else {
+		checker.Add("storage", storage.Ping)
 	}
 	defer closeStorage()
 
-	calendar := app.New(logg, storage)
+	calendar := app.New(logg, cacheStorage(storage, config.Cache, config.DB.Replica),
+		app.Config{MaxEventsPerUser: config.Quota.MaxEventsPerUser})
+
+	limiter := ratelimit.New(rateLimitConfig(config.RateLimit), clock.New())
+
+	tlsConfig, err := initTLS(ctx, config.TLS, logg)
+	if err != nil {
+		logg.Error("failed to load TLS certificates", "err", err)
+		os.Exit(1) //nolint:gocritic
+	}
+
+	grpcServer := internalgrpc.NewServer(logg, calendar, checker, limiter, tlsConfig,
+		config.GRPC.Host, config.GRPC.Port)
+
+	// The gateway is always built so that reloading can switch it on.
+	gatewayHandler, err := initGateway(ctx, config, tlsConfig != nil, grpcServer)
+	if err != nil {
+		logg.Error("failed to create gateway", "err", err)
+		os.Exit(1) //nolint:gocritic
+	}
+	gateway := internalhttp.NewFeatureToggle(gatewayHandler, config.Gateway.Enabled)
+
+	reloader := helper.NewReloader(loadConfig, config, reloadable,
+		applyConfig(logg, limiter, calendar, gateway), logg)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go reloader.Watch(ctx, hup)
 
-	server := internalhttp.NewServer(logg, calendar, config.Server.Host, config.Server.Port)
-	grpc := internalgrpc.NewServer(logg, calendar, config.GRPC.Host, config.GRPC.Port)
+	admin := internaladmin.NewServer(logg, checker, config.Admin.Host, config.Admin.Port)
+	admin.Handle("GET /config", reloader.Handler())
+	go func() {
+		if err := admin.Start(); err != nil {
+			logg.Error("failed to start admin server", "err", err)
+		}
+	}()
+
+	server := internalhttp.NewServer(logg, calendar, checker, limiter, gateway, tlsConfig,
+		config.Server.Host, config.Server.Port)
 
 	go func() {
 		<-ctx.Done()
@@ -74,13 +160,16 @@ func main() {
 		if err := server.Stop(ctx); err != nil {
 			logg.Error("failed to stop http server: " + err.Error())
 		}
-		grpc.Stop()
+		if err := admin.Stop(ctx); err != nil {
+			logg.Error("failed to stop admin server", "err", err)
+		}
+		grpcServer.Stop()
 	}()
 
 	logg.Info("calendar is running...")
 
 	go func() {
-		if err := grpc.Start(); err != nil {
+		if err := grpcServer.Start(); err != nil {
 			logg.Error("failed to start grpc server: " + err.Error())
 			cancel()
 			os.Exit(1)
@@ -93,3 +182,77 @@ func main() {
 		os.Exit(1) //nolint:gocritic
 	}
 }
+
+// cacheStorage puts the cache in front of storage when it is enabled.
+func cacheStorage(storage app.Storage, config CacheConf, replica helper.DBReplicaConfig) app.Storage {
+	if !config.Enabled || storage == nil {
+		return storage
+	}
+	var lag time.Duration
+	if replica.DSN.Value() != "" || replica.Host != "" {
+		lag = time.Duration(config.ReplicaLag) * time.Second
+	}
+
+	return cachestorage.New(storage, cachestorage.Config{
+		Size:       config.Size,
+		TTL:        time.Duration(config.TTL) * time.Second,
+		ReplicaLag: lag,
+	}, clock.New())
+}
+
+// initTLS returns nil when TLS is off. Otherwise the certificates are
+// reloaded as they change until ctx is done.
+func initTLS(ctx context.Context, config TLSConf, logg *loggerslog.Logger) (*tls.Config, error) {
+	if config.CertFile == "" {
+		return nil, nil
+	}
+
+	certs, err := tlsconfig.New(tlsconfig.Config{
+		CertFile:     config.CertFile,
+		KeyFile:      config.KeyFile,
+		ClientCAFile: config.ClientCAFile,
+		MinVersion:   config.MinVersion,
+	}, logg)
+	if err != nil {
+		return nil, err
+	}
+	if config.ReloadInterval > 0 {
+		go certs.Watch(ctx, time.Duration(config.ReloadInterval)*time.Second)
+	}
+
+	return certs.ServerConfig(), nil
+}
+
+// initGateway connects the gateway to grpcServer in-process; that
+// connection alone may pass on the user and address of REST clients.
+func initGateway(
+	ctx context.Context,
+	config Config,
+	useTLS bool,
+	grpcServer *internalgrpc.Server,
+) (http.Handler, error) {
+	creds := insecure.NewCredentials()
+	if useTLS {
+		clientConfig, err := tlsconfig.ClientConfig(config.Gateway.CAFile,
+			config.Gateway.CertFile, config.Gateway.KeyFile, config.Gateway.ServerName)
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(clientConfig)
+	}
+
+	return internalhttp.NewGateway(ctx, "passthrough:///"+gatewayEndpoint(config.GRPC.Host, config.GRPC.Port),
+		grpc.WithContextDialer(grpcServer.DialGateway),
+		grpc.WithTransportCredentials(creds))
+}
+
+// gatewayEndpoint is the address the gateway names the gRPC server of this
+// process by, which TLS verifies its certificate against; a wildcard listen
+// address is named localhost.
+func gatewayEndpoint(host, port string) string {
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+
+	return net.JoinHostPort(host, port)
+}
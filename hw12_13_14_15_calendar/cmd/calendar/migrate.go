@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/clock"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/helper"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger"
+	sqlstorage "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage/sql"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/migrations"
+)
+
+var errUnknownMigrateCommand = errors.New(`want "migrate up", "migrate down", "migrate status" or "migrate version"`)
+
+// connectMigrations connects to the database and loads the embedded
+// migrations.
+func connectMigrations(
+	ctx context.Context,
+	config helper.DBConfig,
+) (*sqlstorage.Storage, []sqlstorage.Migration, error) {
+	all, err := sqlstorage.LoadMigrations(migrations.FS)
+	if err != nil {
+		return nil, nil, err
+	}
+	db := sqlstorage.New(config.SQLConfig(), clock.New())
+	if err := db.Connect(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	return db, all, nil
+}
+
+// runMigrate runs "calendar migrate <command>", reporting to w.
+func runMigrate(ctx context.Context, config helper.DBConfig, command string, w io.Writer) error {
+	switch command {
+	case "up", "down", "status", "version":
+	default:
+		return errUnknownMigrateCommand
+	}
+
+	db, all, err := connectMigrations(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch command {
+	case "up":
+		applied, err := db.MigrateUp(ctx, all)
+		for _, m := range applied {
+			fmt.Fprintf(w, "applied %05d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Fprintln(w, "no pending migrations")
+		}
+		return err
+	case "down":
+		reverted, err := db.MigrateDown(ctx, all)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "reverted %05d_%s\n", reverted.Version, reverted.Name)
+	case "status":
+		status, err := db.MigrationStatus(ctx, all)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range status {
+			appliedAt := "pending"
+			if s.Applied {
+				appliedAt = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%05d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		return tw.Flush()
+	case "version":
+		version, err := db.SchemaVersion(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, version)
+	}
+
+	return nil
+}
+
+// migrateOnStartup applies pending migrations before the service uses the
+// database. Replicas starting together wait for each other.
+func migrateOnStartup(ctx context.Context, config helper.DBConfig, logg logger.Logger) error {
+	db, all, err := connectMigrations(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	applied, err := db.MigrateUp(ctx, all)
+	for _, m := range applied {
+		logg.Info("migration applied", "version", m.Version, "name", m.Name)
+	}
+
+	return err
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/app"
+	loggerslog "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger/slog"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/ratelimit"
+	internalhttp "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/server/http"
+)
+
+// reloadable are the settings applied on SIGHUP; changing any other needs
+// a restart.
+var reloadable = []string{"logger.level", "rateLimit", "quota", "gateway.enabled"}
+
+func rateLimitConfig(config RateLimitConf) ratelimit.Config {
+	routes := make(map[string]ratelimit.Rule, len(config.Routes))
+	for route, rule := range config.Routes {
+		routes[route] = ratelimit.Rule{Rate: rule.Rate, Burst: rule.Burst}
+	}
+
+	return ratelimit.Config{
+		Default: ratelimit.Rule{Rate: config.Rate, Burst: config.Burst},
+		Routes:  routes,
+	}
+}
+
+// applyConfig puts the reloadable settings of a new configuration in effect.
+func applyConfig(
+	logg *loggerslog.Logger,
+	limiter *ratelimit.Limiter,
+	calendar *app.App,
+	gateway *internalhttp.FeatureToggle,
+) func(Config) error {
+	return func(config Config) error {
+		if err := logg.SetLevel(config.Logger.Level); err != nil {
+			return err
+		}
+		limiter.SetConfig(rateLimitConfig(config.RateLimit))
+		calendar.SetConfig(app.Config{MaxEventsPerUser: config.Quota.MaxEventsPerUser})
+		gateway.SetEnabled(config.Gateway.Enabled)
+
+		return nil
+	}
+}
@@ -4,36 +4,119 @@ import "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/helpe
 
 type Config struct {
 	ClearInterval int
-	Interval      int
+	WorkerID      string
+	BatchSize     int
+	Lease         int
+	Jobs          map[string]JobConf
 	Logger        LoggerConf
 	DB            DBConf
 	Queue         QueueConf
+	Admin         AdminConf
+	Tracing       TracingConf
 }
 
+type JobConf struct {
+	Schedule string
+	Jitter   int
+	Timeout  int
+}
+
+// LoggerConf lists in Redact the keys masked in logs on top of
+// loggerslog.DefaultRedactKeys. Format is "text" or "json". With File set,
+// logs go there instead of stderr and the file is rotated at MaxSize
+// megabytes, keeping MaxBackups old files.
 type LoggerConf struct {
-	Level string
+	Level      string
+	Format     string
+	Redact     []string
+	File       string
+	MaxSize    int
+	MaxBackups int
 }
 
+// DBConf is either DSN or the fields it would be built from. ConnectTimeout
+// and the lifetimes of Pool are in seconds. With Replica set, GetEvent and
+// the event lists are read from the replica.
 type DBConf struct {
-	User     string
-	Password string
-	Name     string
-	Host     string
-	Port     string
+	DSN             helper.Secret
+	User            string
+	Password        helper.Secret
+	Name            string
+	Host            string
+	Port            string
+	SSLMode         string
+	SSLRootCert     string
+	ConnectTimeout  int
+	ConnectAttempts int
+	QueryAttempts   int
+	Pool            helper.DBPoolConfig
+	Replica         helper.DBReplicaConfig
+}
+
+type AdminConf struct {
+	Host string
+	Port string
 }
 
 type QueueConf struct {
+	Broker   string
+	Dir      string
 	User     string
-	Password string
+	Password helper.Secret
 	Host     string
 	Port     string
 }
 
-func LoadConfig(path string) (Config, error) {
-	config, err := helper.NewConfig[Config](path)
+type TracingConf struct {
+	Exporter    string
+	Endpoint    string
+	Insecure    bool
+	SampleRatio float64
+}
+
+func defaultConfig() Config {
+	return Config{
+		ClearInterval: 365,
+		BatchSize:     100,
+		Lease:         60,
+		Logger:        LoggerConf{Level: "INFO", Format: "text", MaxSize: 100, MaxBackups: 5},
+		DB:            DBConf{Port: "5432", SSLMode: "disable"},
+		Queue:         QueueConf{Broker: "amqp", Dir: "/var/lib/calendar/queue"},
+		Admin:         AdminConf{Host: "0.0.0.0", Port: "9100"},
+		Tracing:       TracingConf{Exporter: "none", Endpoint: "localhost:4317", Insecure: true, SampleRatio: 1},
+	}
+}
+
+// LoadConfig layers files, environment variables prefixed with SCHEDULER_ and
+// key=value overrides over defaultConfig.
+func LoadConfig(files, overrides []string) (Config, error) {
+	config, err := helper.LoadConfig(helper.ConfigOptions[Config]{
+		Defaults:  defaultConfig(),
+		Files:     files,
+		EnvPrefix: "SCHEDULER",
+		Overrides: overrides,
+	})
 	if err != nil {
 		return Config{}, err
 	}
 
 	return *config, nil
 }
+
+func dbConfig(config DBConf) helper.DBConfig {
+	return helper.DBConfig{
+		DSN:             config.DSN,
+		User:            config.User,
+		Password:        config.Password,
+		Name:            config.Name,
+		Host:            config.Host,
+		Port:            config.Port,
+		SSLMode:         config.SSLMode,
+		SSLRootCert:     config.SSLRootCert,
+		ConnectTimeout:  config.ConnectTimeout,
+		ConnectAttempts: config.ConnectAttempts,
+		QueryAttempts:   config.QueryAttempts,
+		Pool:            config.Pool,
+		Replica:         config.Replica,
+	}
+}
@@ -3,70 +3,148 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
+	_ "time/tzdata"
 
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/clock"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/health"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/helper"
-	loggerslog "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger/slog"
-	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/queue"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/scheduler"
+	internaladmin "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/server/admin"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/tracing"
 	_ "github.com/lib/pq"
 )
 
-var configFile string
+var configFlags *helper.ConfigFlags
 
 func init() {
-	flag.StringVar(&configFile, "config", "/etc/calendar/config.toml", "Path to configuration file")
+	configFlags = helper.NewConfigFlags(flag.CommandLine, "/etc/calendar/config.toml")
 }
 
 func main() {
 	flag.Parse()
 
-	config, err := LoadConfig(configFile)
+	loadConfig := func() (Config, error) {
+		return LoadConfig(configFlags.Files(), configFlags.Overrides())
+	}
+	config, err := loadConfig()
 	if err != nil {
-		log.Fatalf("failed to read config from %q: %v", configFile, err)
+		log.Fatalf("failed to read config from %q: %v", configFlags.Files(), err)
+	}
+
+	if flag.Arg(0) == "config" && flag.Arg(1) == "check" {
+		if err := helper.PrintConfig(os.Stdout, config); err != nil {
+			log.Fatalf("failed to print config: %v", err)
+		}
+		return
 	}
 
-	logg, err := loggerslog.New(os.Stderr, config.Logger.Level)
+	logg, closeLogger, err := helper.InitLogger(helper.LoggerConfig{
+		Level:      config.Logger.Level,
+		Format:     config.Logger.Format,
+		Redact:     config.Logger.Redact,
+		File:       config.Logger.File,
+		MaxSize:    config.Logger.MaxSize,
+		MaxBackups: config.Logger.MaxBackups,
+	})
 	if err != nil {
 		log.Fatalf("failed to create logger: %v", err)
 	}
+	defer closeLogger()
 
-	ctx, cancel := signal.NotifyContext(context.Background(),
-		syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	q := queue.NewQueue(config.Queue.User, config.Queue.Password, config.Queue.Host, config.Queue.Port)
-	err = q.Start()
+	shutdownTracing, err := tracing.Init(ctx, "scheduler", tracing.Config{
+		Exporter:    config.Tracing.Exporter,
+		Endpoint:    config.Tracing.Endpoint,
+		Insecure:    config.Tracing.Insecure,
+		SampleRatio: config.Tracing.SampleRatio,
+	})
+	if err != nil {
+		logg.Error("failed to init tracing", "err", err)
+		os.Exit(1) //nolint:gocritic
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logg.Error("failed to flush traces", "err", err)
+		}
+	}()
+
+	checker := health.New(health.DefaultTimeout)
+	admin := internaladmin.NewServer(logg, checker, config.Admin.Host, config.Admin.Port)
+	go func() {
+		if err := admin.Start(); err != nil {
+			logg.Error("failed to start admin server", "err", err)
+		}
+	}()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		if err := admin.Stop(ctx); err != nil {
+			logg.Error("failed to stop admin server", "err", err)
+		}
+	}()
+
+	broker, err := helper.InitBroker(helper.QueueConfig{
+		Broker:   config.Queue.Broker,
+		User:     config.Queue.User,
+		Password: config.Queue.Password,
+		Host:     config.Queue.Host,
+		Port:     config.Queue.Port,
+		Dir:      config.Queue.Dir,
+	}, logg)
 	if err != nil {
 		logg.Error("failed start queue", "err", err)
-		cancel()
+		os.Exit(1) //nolint:gocritic
 	}
-	defer q.Stop()
-
-	storage, closeStorage, err := helper.InitStorage(ctx, helper.DBConfig{
-		User:     config.DB.User,
-		Password: config.DB.Password,
-		Name:     config.DB.Name,
-		Host:     config.DB.Host,
-		Port:     config.DB.Port,
-	}, "sql")
+	checker.Add("queue", broker.Check)
+	defer broker.Stop()
+
+	storage, closeStorage, err := helper.InitStorage(ctx, dbConfig(config.DB), "sql", clock.New(), logg)
 	if err != nil {
 		logg.Error("failed to run database", "err", err)
-		cancel()
+		os.Exit(1) //nolint:gocritic
 	}
+	checker.Add("storage", storage.Ping)
 	defer closeStorage()
 
-	producer := queue.NewProducer("notification_queue", q.Conn)
-	err = producer.Start()
+	producer, err := broker.Publisher("notification_queue")
 	if err != nil {
 		logg.Error("failed to start producer", "err", err)
-		cancel()
+		os.Exit(1) //nolint:gocritic
+	}
+
+	workerID := config.WorkerID
+	if workerID == "" {
+		hostname, _ := os.Hostname()
+		workerID = fmt.Sprintf("%s-%d", hostname, os.Getpid())
 	}
-	defer producer.Stop()
 
-	sch := scheduler.NewScheduler(producer, config.ClearInterval, config.Interval, logg, storage)
-	sch.Start(ctx)
+	sch := scheduler.NewScheduler(
+		schedulerConfig(config, workerID),
+		producer,
+		logg,
+		storage,
+		clock.New(),
+		storage.NewLocker(scheduler.LockKey),
+	)
+
+	reloader := helper.NewReloader(loadConfig, config, reloadable, applyConfig(logg, sch, workerID), logg)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go reloader.Watch(ctx, hup)
+	admin.Handle("GET /config", reloader.Handler())
+
+	err = sch.Start(ctx)
+	if err != nil {
+		logg.Error("scheduler failed", "err", err)
+	}
 }
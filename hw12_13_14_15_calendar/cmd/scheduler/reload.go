@@ -0,0 +1,42 @@
+package main
+
+import (
+	"time"
+
+	loggerslog "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger/slog"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/scheduler"
+)
+
+// reloadable are the settings applied on SIGHUP; changing any other needs
+// a restart.
+var reloadable = []string{"logger.level", "clearInterval", "batchSize", "lease", "jobs"}
+
+func schedulerConfig(config Config, workerID string) scheduler.Config {
+	jobs := make(map[string]scheduler.JobConfig, len(config.Jobs))
+	for name, job := range config.Jobs {
+		jobs[name] = scheduler.JobConfig{
+			Schedule: job.Schedule,
+			Jitter:   time.Duration(job.Jitter) * time.Second,
+			Timeout:  time.Duration(job.Timeout) * time.Second,
+		}
+	}
+
+	return scheduler.Config{
+		ClearInterval: config.ClearInterval,
+		WorkerID:      workerID,
+		BatchSize:     config.BatchSize,
+		Lease:         time.Duration(config.Lease) * time.Second,
+		Jobs:          jobs,
+	}
+}
+
+// applyConfig puts the reloadable settings of a new configuration in effect.
+func applyConfig(logg *loggerslog.Logger, sch *scheduler.Scheduler, workerID string) func(Config) error {
+	return func(config Config) error {
+		if err := logg.SetLevel(config.Logger.Level); err != nil {
+			return err
+		}
+
+		return sch.SetConfig(schedulerConfig(config, workerID))
+	}
+}
@@ -7,73 +7,123 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
+	_ "time/tzdata"
 
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/clock"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/health"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/helper"
-	loggerslog "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger/slog"
-	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/queue"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/sender"
+	internaladmin "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/server/admin"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/tracing"
 	_ "github.com/lib/pq"
 )
 
-var configFile string
+var configFlags *helper.ConfigFlags
 
 func init() {
-	flag.StringVar(&configFile, "config", "/etc/calendar/config.toml", "Path to configuration file")
+	configFlags = helper.NewConfigFlags(flag.CommandLine, "/etc/calendar/config.toml")
 }
 
 func main() {
 	flag.Parse()
 
-	config, err := LoadConfig(configFile)
+	config, err := LoadConfig(configFlags.Files(), configFlags.Overrides())
 	if err != nil {
-		log.Fatalf("failed to read config from %q: %v", configFile, err)
+		log.Fatalf("failed to read config from %q: %v", configFlags.Files(), err)
 	}
 
-	logg, err := loggerslog.New(os.Stderr, "INFO")
+	if flag.Arg(0) == "config" && flag.Arg(1) == "check" {
+		if err := helper.PrintConfig(os.Stdout, config); err != nil {
+			log.Fatalf("failed to print config: %v", err)
+		}
+		return
+	}
+
+	logg, closeLogger, err := helper.InitLogger(helper.LoggerConfig{
+		Level:      config.Logger.Level,
+		Format:     config.Logger.Format,
+		Redact:     config.Logger.Redact,
+		File:       config.Logger.File,
+		MaxSize:    config.Logger.MaxSize,
+		MaxBackups: config.Logger.MaxBackups,
+	})
 	if err != nil {
 		log.Fatalf("failed to create logger: %v", err)
 	}
+	defer closeLogger()
 
 	ctx, cancel := signal.NotifyContext(context.Background(),
 		syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 	defer cancel()
 
-	q := queue.NewQueue(config.Queue.User, config.Queue.Password, config.Queue.Host, config.Queue.Port)
-	err = q.Start()
+	shutdownTracing, err := tracing.Init(ctx, "sender", tracing.Config{
+		Exporter:    config.Tracing.Exporter,
+		Endpoint:    config.Tracing.Endpoint,
+		Insecure:    config.Tracing.Insecure,
+		SampleRatio: config.Tracing.SampleRatio,
+	})
+	if err != nil {
+		logg.Error("failed to init tracing", "err", err)
+		os.Exit(1) //nolint:gocritic
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logg.Error("failed to flush traces", "err", err)
+		}
+	}()
+
+	checker := health.New(health.DefaultTimeout)
+	admin := internaladmin.NewServer(logg, checker, config.Admin.Host, config.Admin.Port)
+	go func() {
+		if err := admin.Start(); err != nil {
+			logg.Error("failed to start admin server", "err", err)
+		}
+	}()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		if err := admin.Stop(ctx); err != nil {
+			logg.Error("failed to stop admin server", "err", err)
+		}
+	}()
+
+	broker, err := helper.InitBroker(helper.QueueConfig{
+		Broker:   config.Queue.Broker,
+		User:     config.Queue.User,
+		Password: config.Queue.Password,
+		Host:     config.Queue.Host,
+		Port:     config.Queue.Port,
+		Dir:      config.Queue.Dir,
+	}, logg)
 	if err != nil {
 		logg.Error("failed start queue", "err", err)
-		cancel()
+		os.Exit(1) //nolint:gocritic
 	}
-	defer q.Stop()
+	checker.Add("queue", broker.Check)
+	defer broker.Stop()
 
-	storage, closeStorage, err := helper.InitStorage(ctx, helper.DBConfig{
-		User:     config.DB.User,
-		Password: config.DB.Password,
-		Name:     config.DB.Name,
-		Host:     config.DB.Host,
-		Port:     config.DB.Port,
-	}, "sql")
+	storage, closeStorage, err := helper.InitStorage(ctx, dbConfig(config.DB), "sql", clock.New(), logg)
 	if err != nil {
 		logg.Error("failed to run database", "err", err)
 		cancel()
+	} else {
+		checker.Add("storage", storage.Ping)
 	}
 	defer closeStorage()
 
-	consumer := queue.NewConsumer("notification_queue", q.Conn)
-	err = consumer.Start()
+	consumer, err := broker.Subscriber("notification_queue")
 	if err != nil {
 		logg.Error("failed start consumer", "err", err)
-		cancel()
+		os.Exit(1) //nolint:gocritic
 	}
-	defer consumer.Stop()
-
-	go func() {
-		<-ctx.Done()
-		consumer.Stop()
-	}()
 
-	s := sender.NewSender(consumer, logg, storage)
-	err = s.Start()
+	s := sender.NewSender(consumer, logg, storage, sender.NewLogChannel(logg))
+	// Start returns once ctx is cancelled and in-flight messages are
+	// handled; the deferred broker.Stop then closes the connection.
+	err = s.Start(ctx)
 	if err != nil {
 		logg.Error("sender failed", "err", err)
 	}
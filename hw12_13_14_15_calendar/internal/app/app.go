@@ -2,25 +2,44 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"sync/atomic"
 	"time"
 
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/auth"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = tracing.Tracer("app")
+
+var ErrQuotaExceeded = errors.New("event quota exceeded")
+
 type App struct {
 	logger  Logger
 	storage Storage
+	config  atomic.Pointer[Config]
+}
+
+type Config struct {
+	// MaxEventsPerUser limits how many events one user may store. Zero
+	// means unlimited. The check is not atomic with the insert, so
+	// concurrent creates may overshoot it slightly.
+	MaxEventsPerUser int
 }
 
 type Logger interface {
-	Info(msg string, keysAndValues ...interface{})
-	Error(msg string, keysAndValues ...interface{})
+	InfoContext(ctx context.Context, msg string, keysAndValues ...interface{})
+	ErrorContext(ctx context.Context, msg string, keysAndValues ...interface{})
 }
 
 type Storage interface {
 	CreateEvent(context.Context, storage.Event) error
+	CountUserEvents(ctx context.Context, userID string) (int, error)
 	GetEvent(context.Context, string) (*storage.Event, error)
 	EditEvent(context.Context, string, storage.Event) error
 	DeleteEvent(context.Context, string) error
@@ -29,27 +48,73 @@ type Storage interface {
 	GetEventsListMonth(ctx context.Context, date time.Time) ([]storage.Event, error)
 }
 
-func New(logger Logger, storage Storage) *App {
-	return &App{
+func New(logger Logger, storage Storage, config Config) *App {
+	a := &App{
 		logger:  logger,
 		storage: storage,
 	}
+	a.config.Store(&config)
+
+	return a
+}
+
+// SetConfig replaces the configuration; calls in flight keep the old one.
+func (a *App) SetConfig(config Config) {
+	a.config.Store(&config)
 }
 
 func (a *App) CreateEvent(ctx context.Context, event storage.Event) error {
-	err := a.storage.CreateEvent(ctx, event)
+	ctx, span := tracer.Start(ctx, "App.CreateEvent", trace.WithAttributes(attribute.String("event.id", event.ID)))
+	defer span.End()
+
+	err := a.checkQuota(ctx, event.UserID)
 	if err != nil {
-		a.logger.Error("failed to create event", slog.String("error", err.Error()))
+		tracing.RecordError(span, err)
+		return fmt.Errorf("failed to create event: %w", err)
+	}
+
+	err = a.storage.CreateEvent(ctx, event)
+	if err != nil {
+		tracing.RecordError(span, err)
+		a.logger.ErrorContext(ctx, "failed to create event", slog.String("error", err.Error()))
 		return fmt.Errorf("failed to create event: %w", err)
 	}
 
 	return nil
 }
 
+// checkQuota fails with ErrQuotaExceeded when the authenticated user, or
+// userID for callers that are not authenticated, has no room for one more
+// event.
+func (a *App) checkQuota(ctx context.Context, userID string) error {
+	limit := a.config.Load().MaxEventsPerUser
+	if limit <= 0 {
+		return nil
+	}
+	if authUser, ok := auth.UserFromContext(ctx); ok {
+		userID = authUser
+	}
+
+	count, err := a.storage.CountUserEvents(ctx, userID)
+	if err != nil {
+		a.logger.ErrorContext(ctx, "failed to count user events", slog.String("error", err.Error()))
+		return err
+	}
+	if count >= limit {
+		return fmt.Errorf("user %s has %d events: %w", userID, count, ErrQuotaExceeded)
+	}
+
+	return nil
+}
+
 func (a *App) GetEvent(ctx context.Context, id string) (*storage.Event, error) {
+	ctx, span := tracer.Start(ctx, "App.GetEvent", trace.WithAttributes(attribute.String("event.id", id)))
+	defer span.End()
+
 	event, err := a.storage.GetEvent(ctx, id)
 	if err != nil {
-		a.logger.Error("failed to get event", slog.String("error", err.Error()))
+		tracing.RecordError(span, err)
+		a.logger.ErrorContext(ctx, "failed to get event", slog.String("error", err.Error()))
 		return nil, fmt.Errorf("failed to get event: %w", err)
 	}
 
@@ -57,9 +122,13 @@ func (a *App) GetEvent(ctx context.Context, id string) (*storage.Event, error) {
 }
 
 func (a *App) DeleteEvent(ctx context.Context, id string) error {
+	ctx, span := tracer.Start(ctx, "App.DeleteEvent", trace.WithAttributes(attribute.String("event.id", id)))
+	defer span.End()
+
 	err := a.storage.DeleteEvent(ctx, id)
 	if err != nil {
-		a.logger.Error("failed to get event", slog.String("error", err.Error()))
+		tracing.RecordError(span, err)
+		a.logger.ErrorContext(ctx, "failed to get event", slog.String("error", err.Error()))
 		return fmt.Errorf("failed to get event: %w", err)
 	}
 
@@ -67,9 +136,28 @@ func (a *App) DeleteEvent(ctx context.Context, id string) error {
 }
 
 func (a *App) EditEvent(ctx context.Context, id string, event storage.Event) error {
+	ctx, span := tracer.Start(ctx, "App.EditEvent", trace.WithAttributes(attribute.String("event.id", id)))
+	defer span.End()
+
+	if a.config.Load().MaxEventsPerUser > 0 {
+		stored, err := a.storage.GetEvent(ctx, id)
+		if err != nil {
+			tracing.RecordError(span, err)
+			a.logger.ErrorContext(ctx, "failed to edit event", slog.String("error", err.Error()))
+			return fmt.Errorf("failed to edit event: %w", err)
+		}
+		if stored.UserID != event.UserID {
+			if err := a.checkQuota(ctx, event.UserID); err != nil {
+				tracing.RecordError(span, err)
+				return fmt.Errorf("failed to edit event: %w", err)
+			}
+		}
+	}
+
 	err := a.storage.EditEvent(ctx, id, event)
 	if err != nil {
-		a.logger.Error("failed to edit event", slog.String("error", err.Error()))
+		tracing.RecordError(span, err)
+		a.logger.ErrorContext(ctx, "failed to edit event", slog.String("error", err.Error()))
 		return fmt.Errorf("failed to edit event: %w", err)
 	}
 
@@ -77,9 +165,14 @@ func (a *App) EditEvent(ctx context.Context, id string, event storage.Event) err
 }
 
 func (a *App) GetEventsListDay(ctx context.Context, date time.Time) ([]storage.Event, error) {
+	ctx, span := tracer.Start(ctx, "App.GetEventsListDay",
+		trace.WithAttributes(attribute.String("date", date.Format(time.DateOnly))))
+	defer span.End()
+
 	events, err := a.storage.GetEventsListDay(ctx, date)
 	if err != nil {
-		a.logger.Error("failed to get events", slog.String("error", err.Error()))
+		tracing.RecordError(span, err)
+		a.logger.ErrorContext(ctx, "failed to get events", slog.String("error", err.Error()))
 		return nil, fmt.Errorf("failed to get events: %w", err)
 	}
 
@@ -87,9 +180,14 @@ func (a *App) GetEventsListDay(ctx context.Context, date time.Time) ([]storage.E
 }
 
 func (a *App) GetEventsListWeek(ctx context.Context, date time.Time) ([]storage.Event, error) {
+	ctx, span := tracer.Start(ctx, "App.GetEventsListWeek",
+		trace.WithAttributes(attribute.String("date", date.Format(time.DateOnly))))
+	defer span.End()
+
 	events, err := a.storage.GetEventsListWeek(ctx, date)
 	if err != nil {
-		a.logger.Error("failed to get events", slog.String("error", err.Error()))
+		tracing.RecordError(span, err)
+		a.logger.ErrorContext(ctx, "failed to get events", slog.String("error", err.Error()))
 		return nil, fmt.Errorf("failed to get events: %w", err)
 	}
 
@@ -97,9 +195,14 @@ func (a *App) GetEventsListWeek(ctx context.Context, date time.Time) ([]storage.
 }
 
 func (a *App) GetEventsListMonth(ctx context.Context, date time.Time) ([]storage.Event, error) {
+	ctx, span := tracer.Start(ctx, "App.GetEventsListMonth",
+		trace.WithAttributes(attribute.String("date", date.Format(time.DateOnly))))
+	defer span.End()
+
 	events, err := a.storage.GetEventsListMonth(ctx, date)
 	if err != nil {
-		a.logger.Error("failed to get events", slog.String("error", err.Error()))
+		tracing.RecordError(span, err)
+		a.logger.ErrorContext(ctx, "failed to get events", slog.String("error", err.Error()))
 		return nil, fmt.Errorf("failed to get events: %w", err)
 	}
 
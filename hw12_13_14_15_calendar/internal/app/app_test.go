@@ -0,0 +1,48 @@
+package app
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/auth"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/clock"
+	loggerslog "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger/slog"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+	memorystorage "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage/memory"
+	"github.com/stretchr/testify/require"
+)
+
+func newQuotaApp(t *testing.T) *App {
+	t.Helper()
+	logg, err := loggerslog.New(io.Discard, "INFO")
+	require.NoError(t, err)
+
+	return New(logg, memorystorage.New(clock.New()), Config{MaxEventsPerUser: 1})
+}
+
+func TestCreateEventQuotaCountsAuthenticatedUser(t *testing.T) {
+	a := newQuotaApp(t)
+	ctx := auth.WithUser(context.Background(), "alice")
+	require.NoError(t, a.CreateEvent(ctx, storage.Event{ID: "1", UserID: "alice"}))
+
+	err := a.CreateEvent(ctx, storage.Event{ID: "2", UserID: "bob"})
+
+	require.ErrorIs(t, err, ErrQuotaExceeded, "events for other users count for the caller")
+	require.NoError(t, a.CreateEvent(context.Background(), storage.Event{ID: "3", UserID: "bob"}))
+}
+
+func TestEditEventQuota(t *testing.T) {
+	a := newQuotaApp(t)
+	ctx := context.Background()
+	require.NoError(t, a.CreateEvent(ctx, storage.Event{ID: "1", UserID: "alice"}))
+	require.NoError(t, a.CreateEvent(ctx, storage.Event{ID: "2", UserID: "bob"}))
+
+	require.NoError(t, a.EditEvent(ctx, "1", storage.Event{ID: "1", Title: "renamed", UserID: "alice"}))
+	err := a.EditEvent(ctx, "2", storage.Event{ID: "2", UserID: "alice"})
+	require.ErrorIs(t, err, ErrQuotaExceeded)
+
+	event, err := a.GetEvent(ctx, "2")
+	require.NoError(t, err)
+	require.Equal(t, "bob", event.UserID)
+}
@@ -0,0 +1,89 @@
+package app
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+)
+
+// Code classifies an error for API clients. Transports map each code to
+// their own status once: HTTP in internalhttp, gRPC in internalgrpc.
+type Code string
+
+const (
+	CodeInvalidArgument Code = "invalid_argument"
+	CodeNotFound        Code = "not_found"
+	CodeAlreadyExists   Code = "already_exists"
+	CodeQuotaExceeded   Code = "quota_exceeded"
+	CodeRateLimited     Code = "rate_limited"
+	CodeUnavailable     Code = "unavailable"
+	CodeInternal        Code = "internal"
+)
+
+// FieldViolation describes why one request field was rejected.
+type FieldViolation struct {
+	Field       string
+	Description string
+}
+
+// Error is an error that is safe to show to API clients. Message never
+// contains internal details; the cause is kept in Err for logs.
+type Error struct {
+	Code    Code
+	Message string
+	Fields  []FieldViolation
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func NewError(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// ValidationError reports rejected fields, sorted by name so responses are
+// stable.
+func ValidationError(fields map[string]string) *Error {
+	violations := make([]FieldViolation, 0, len(fields))
+	for field, description := range fields {
+		violations = append(violations, FieldViolation{Field: field, Description: description})
+	}
+	sort.Slice(violations, func(i, j int) bool {
+		return violations[i].Field < violations[j].Field
+	})
+
+	return &Error{Code: CodeInvalidArgument, Message: "validation failed", Fields: violations}
+}
+
+// AsError classifies err. Known domain and storage errors get their own
+// code; anything else becomes CodeInternal with a generic message.
+func AsError(err error) *Error {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+
+	switch {
+	case errors.Is(err, storage.ErrEventDoesntExist):
+		return &Error{Code: CodeNotFound, Message: "event not found", Err: err}
+	case errors.Is(err, storage.ErrNoEventsFound):
+		return &Error{Code: CodeNotFound, Message: "no events found", Err: err}
+	case errors.Is(err, storage.ErrEventAlreadyExists):
+		return &Error{Code: CodeAlreadyExists, Message: "event already exists", Err: err}
+	case errors.Is(err, ErrQuotaExceeded):
+		return &Error{Code: CodeQuotaExceeded, Message: "event quota exceeded", Err: err}
+	default:
+		return &Error{Code: CodeInternal, Message: "internal error", Err: err}
+	}
+}
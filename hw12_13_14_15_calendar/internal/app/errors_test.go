@@ -0,0 +1,48 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAsError(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		code    Code
+		message string
+	}{
+		{"event not found", fmt.Errorf("failed: %w", storage.ErrEventDoesntExist), CodeNotFound, "event not found"},
+		{"no events", storage.ErrNoEventsFound, CodeNotFound, "no events found"},
+		{"already exists", storage.ErrEventAlreadyExists, CodeAlreadyExists, "event already exists"},
+		{"quota", fmt.Errorf("user 1: %w", ErrQuotaExceeded), CodeQuotaExceeded, "event quota exceeded"},
+		{"app error", fmt.Errorf("wrapped: %w", NewError(CodeRateLimited, "slow down")), CodeRateLimited, "slow down"},
+		{"unknown", errors.New("pq: connection refused"), CodeInternal, "internal error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			appErr := AsError(tt.err)
+
+			require.Equal(t, tt.code, appErr.Code)
+			require.Equal(t, tt.message, appErr.Message)
+			if tt.code != CodeRateLimited {
+				require.ErrorIs(t, appErr, tt.err, "cause is kept for logs")
+			}
+		})
+	}
+}
+
+func TestValidationErrorSortsFields(t *testing.T) {
+	err := ValidationError(map[string]string{"title": "too long", "end_date": "too early", "id": "not valid uuid"})
+
+	require.Equal(t, CodeInvalidArgument, err.Code)
+	require.Equal(t, []FieldViolation{
+		{Field: "end_date", Description: "too early"},
+		{Field: "id", Description: "not valid uuid"},
+		{Field: "title", Description: "too long"},
+	}, err.Fields)
+}
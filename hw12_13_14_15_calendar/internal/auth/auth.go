@@ -0,0 +1,17 @@
+package auth
+
+import "context"
+
+type userKey struct{}
+
+// WithUser returns a copy of ctx carrying the ID of the authenticated user.
+// Transports call it once they have verified who the caller is.
+func WithUser(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userKey{}, userID)
+}
+
+// UserFromContext returns the authenticated user, if any.
+func UserFromContext(ctx context.Context) (string, bool) {
+	userID, ok := ctx.Value(userKey{}).(string)
+	return userID, ok && userID != ""
+}
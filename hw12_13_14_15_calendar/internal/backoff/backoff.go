@@ -0,0 +1,46 @@
+// Package backoff spaces out retries of an operation that failed for a
+// reason that may go away, such as a dropped connection.
+package backoff
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// Backoff grows the delay between attempts exponentially from Initial up to
+// Max.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+}
+
+// Delay returns the wait before attempt n (starting at 0), with up to 20%
+// random jitter so replicas do not retry in lockstep.
+func (b Backoff) Delay(n int) time.Duration {
+	d := b.Initial
+	for i := 0; i < n && d < b.Max; i++ {
+		d *= 2
+	}
+	if d > b.Max {
+		d = b.Max
+	}
+
+	return d - time.Duration(rand.Int64N(int64(d)/5+1))
+}
+
+// Wait sleeps for the n-th delay or until ctx or done is closed. It reports
+// whether the caller should keep trying. done may be nil.
+func (b Backoff) Wait(ctx context.Context, done <-chan struct{}, n int) bool {
+	t := time.NewTimer(b.Delay(n))
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-done:
+		return false
+	case <-t.C:
+		return true
+	}
+}
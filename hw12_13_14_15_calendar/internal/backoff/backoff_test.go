@@ -0,0 +1,45 @@
+package backoff
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Initial: 100 * time.Millisecond, Max: time.Second}
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{attempt: 0, max: 100 * time.Millisecond},
+		{attempt: 1, max: 200 * time.Millisecond},
+		{attempt: 3, max: 800 * time.Millisecond},
+		{attempt: 4, max: time.Second},
+		{attempt: 100, max: time.Second},
+	}
+
+	for _, tc := range tests {
+		for i := 0; i < 50; i++ {
+			d := b.Delay(tc.attempt)
+			require.LessOrEqual(t, d, tc.max, "attempt %d", tc.attempt)
+			require.GreaterOrEqual(t, d, tc.max*4/5, "attempt %d", tc.attempt)
+		}
+	}
+}
+
+func TestBackoffWaitStops(t *testing.T) {
+	b := Backoff{Initial: time.Hour, Max: time.Hour}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.False(t, b.Wait(ctx, nil, 0))
+
+	done := make(chan struct{})
+	close(done)
+	require.False(t, b.Wait(context.Background(), done, 0))
+
+	require.True(t, Backoff{Initial: time.Millisecond, Max: time.Millisecond}.Wait(context.Background(), nil, 0))
+}
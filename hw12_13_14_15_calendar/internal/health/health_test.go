@@ -0,0 +1,97 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var errDown = errors.New("connection refused")
+
+func readyz(t *testing.T, checker *Checker) (int, Report) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	checker.ReadinessHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var report Report
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	return w.Code, report
+}
+
+func TestReadiness(t *testing.T) {
+	tests := []struct {
+		name   string
+		checks map[string]Check
+		code   int
+		report Report
+	}{
+		{
+			name:   "no checks",
+			checks: map[string]Check{},
+			code:   http.StatusOK,
+			report: Report{Status: StatusOK},
+		},
+		{
+			name: "all pass",
+			checks: map[string]Check{
+				"storage": func(context.Context) error { return nil },
+				"queue":   func(context.Context) error { return nil },
+			},
+			code:   http.StatusOK,
+			report: Report{Status: StatusOK, Checks: map[string]string{"storage": StatusOK, "queue": StatusOK}},
+		},
+		{
+			name: "one fails",
+			checks: map[string]Check{
+				"storage": func(context.Context) error { return nil },
+				"queue":   func(context.Context) error { return errDown },
+			},
+			code:   http.StatusServiceUnavailable,
+			report: Report{Status: StatusFail, Checks: map[string]string{"storage": StatusOK, "queue": errDown.Error()}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			checker := New(DefaultTimeout)
+			for name, check := range tc.checks {
+				checker.Add(name, check)
+			}
+
+			code, report := readyz(t, checker)
+			require.Equal(t, tc.code, code)
+			require.Equal(t, tc.report.Status, report.Status)
+			if len(tc.report.Checks) > 0 {
+				require.Equal(t, tc.report.Checks, report.Checks)
+			}
+		})
+	}
+}
+
+func TestCheckTimesOut(t *testing.T) {
+	checker := New(10 * time.Millisecond)
+	checker.Add("storage", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	_, err := checker.Check(context.Background())
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestLivenessIgnoresChecks(t *testing.T) {
+	checker := New(DefaultTimeout)
+	checker.Add("storage", func(context.Context) error { return errDown })
+
+	w := httptest.NewRecorder()
+	checker.LivenessHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+}
@@ -1,43 +1,523 @@
 package helper
 
 import (
+	"bytes"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
+	"reflect"
 	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
 
 	"github.com/pelletier/go-toml/v2"
 )
 
-func NewConfig[C interface{}](path string) (*C, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, fmt.Errorf("NewConfig: failed opening file: %w", err)
+var (
+	ErrUnknownKey      = errors.New("unknown key")
+	ErrMissingRequired = errors.New("missing required key")
+	ErrUndefinedEnv    = errors.New("undefined environment variable")
+)
+
+// redacted replaces the values of config fields tagged `secret:"true"`.
+const redacted = "[REDACTED]"
+
+// ConfigOptions are the layers of a configuration, lowest precedence first.
+// Keys are named as in the TOML files and joined with dots: "db.host",
+// "rateLimit.routes.\"POST /event/create\".rate".
+type ConfigOptions[C any] struct {
+	// Defaults is what the other layers override.
+	Defaults C
+	// Files are read in order, each overriding the ones before. ${VAR} in
+	// them is replaced by the environment variable VAR, which must be set.
+	Files []string
+	// EnvPrefix enables overrides from environment variables named by the
+	// prefix and the upper-cased key path: with prefix "CALENDAR",
+	// CALENDAR_DB_HOST sets db.host. Keys inside maps cannot be set this way.
+	EnvPrefix string
+	// Overrides are key=value pairs, typically from -set flags.
+	Overrides []string
+}
+
+// LoadConfig merges the layers of options. Keys that C has no field for are
+// an error, as are fields tagged `required:"true"` that end up empty.
+func LoadConfig[C any](options ConfigOptions[C]) (*C, error) {
+	typ := reflect.TypeOf(options.Defaults)
+	tree := toTree(reflect.ValueOf(options.Defaults), false).(map[string]interface{})
+
+	for _, file := range options.Files {
+		layer, err := readConfigFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("helper.LoadConfig: %w", err)
+		}
+		layer, err = normalize(layer, typ, "")
+		if err != nil {
+			return nil, fmt.Errorf("helper.LoadConfig: %s: %w", file, err)
+		}
+		merge(tree, layer)
+	}
+
+	if options.EnvPrefix != "" {
+		if err := applyEnv(tree, typ, options.EnvPrefix, nil); err != nil {
+			return nil, fmt.Errorf("helper.LoadConfig: %w", err)
+		}
+	}
+
+	for _, override := range options.Overrides {
+		key, value, ok := strings.Cut(override, "=")
+		if !ok {
+			return nil, fmt.Errorf("helper.LoadConfig: override %q is not key=value", override)
+		}
+		if err := set(tree, typ, splitKey(key), value); err != nil {
+			return nil, fmt.Errorf("helper.LoadConfig: override %q: %w", override, err)
+		}
 	}
-	defer file.Close()
 
+	b, err := toml.Marshal(tree)
+	if err != nil {
+		return nil, fmt.Errorf("helper.LoadConfig: %w", err)
+	}
 	var config C
+	decoder := toml.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("helper.LoadConfig: %w", err)
+	}
+
+	if missing := missingRequired(reflect.ValueOf(config), ""); len(missing) > 0 {
+		return nil, fmt.Errorf("helper.LoadConfig: %w: %s", ErrMissingRequired, strings.Join(missing, ", "))
+	}
+
+	return &config, nil
+}
 
-	b, err := io.ReadAll(file)
+var placeholder = regexp.MustCompile(`\$\{(\w+)\}`)
+
+func readConfigFile(path string) (map[string]interface{}, error) {
+	b, err := os.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("NewConfig: failed reading file: %w", err)
+		return nil, fmt.Errorf("failed reading file: %w", err)
+	}
+
+	var undefined []string
+	content := placeholder.ReplaceAllStringFunc(string(b), func(match string) string {
+		name := match[2 : len(match)-1]
+		value, ok := os.LookupEnv(name)
+		if !ok && !slices.Contains(undefined, name) {
+			undefined = append(undefined, name)
+		}
+		return value
+	})
+	if len(undefined) > 0 {
+		return nil, fmt.Errorf("%s: %w: %s", path, ErrUndefinedEnv, strings.Join(undefined, ", "))
+	}
+
+	var tree map[string]interface{}
+	if err := toml.Unmarshal([]byte(content), &tree); err != nil {
+		return nil, fmt.Errorf("%s: failed unmarshal toml: %w", path, err)
+	}
+
+	return tree, nil
+}
+
+// configKey is how a field is named in the TOML files: "RateLimit" is
+// "rateLimit", "DB" is "db" and "ClientCAFile" is "clientCAFile".
+func configKey(field string) string {
+	runes := []rune(field)
+	upper := 0
+	for upper < len(runes) && unicode.IsUpper(runes[upper]) {
+		upper++
+	}
+	if upper > 1 && upper < len(runes) {
+		// The last capital starts the next word.
+		upper--
+	}
+	for i := 0; i < upper; i++ {
+		runes[i] = unicode.ToLower(runes[i])
+	}
+
+	return string(runes)
+}
+
+// fieldByKey finds the field of a struct type named key in a file. Like the
+// TOML decoder, it ignores case.
+func fieldByKey(typ reflect.Type, key string) (reflect.StructField, bool) {
+	return typ.FieldByNameFunc(func(name string) bool {
+		return strings.EqualFold(name, key)
+	})
+}
+
+func joinKey(prefix, key string) string {
+	if strings.ContainsAny(key, ". \"") {
+		key = strconv.Quote(key)
+	}
+	if prefix == "" {
+		return key
+	}
+
+	return prefix + "." + key
+}
+
+// splitKey splits a key path at dots outside double quotes.
+func splitKey(path string) []string {
+	var (
+		keys   []string
+		key    strings.Builder
+		quoted bool
+	)
+	for _, r := range path {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == '.' && !quoted:
+			keys = append(keys, key.String())
+			key.Reset()
+		default:
+			key.WriteRune(r)
+		}
 	}
 
-	tomlStr := string(b)
-	re := regexp.MustCompile(`\$\{(\w+)\}`)
+	return append(keys, key.String())
+}
+
+// normalize renames the keys of tree, read from a file, as configKey does,
+// reads the secrets of _file keys, and fails on keys that typ, a struct type,
+// has no field for.
+func normalize(tree map[string]interface{}, typ reflect.Type, prefix string) (map[string]interface{}, error) {
+	result := make(map[string]interface{}, len(tree))
+	var errs []error
+	for key, value := range tree {
+		field, ok := fieldByKey(typ, key)
+		fromFile := false
+		if base, isFile := secretFileKey(key); !ok && isFile {
+			field, ok = fieldByKey(typ, base)
+			fromFile = ok && field.Type == secretType
+			ok = fromFile
+		}
+		if !ok || !field.IsExported() {
+			errs = append(errs, fmt.Errorf("%w %s", ErrUnknownKey, joinKey(prefix, key)))
+			continue
+		}
+		name := configKey(field.Name)
+		path := joinKey(prefix, name)
+		if _, ok := result[name]; ok {
+			errs = append(errs, fmt.Errorf("%s is set twice", path))
+			continue
+		}
+		if fromFile {
+			file, _ := value.(string)
+			secret, err := readSecretFile(file)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s%s: %w", path, fileSuffix, err))
+				continue
+			}
+			value = secret
+		}
 
-	tomlStr = re.ReplaceAllStringFunc(tomlStr, func(match string) string {
-		varName := match[2 : len(match)-1]
-		if value, exists := os.LookupEnv(varName); exists {
-			return value
+		switch nested, isTable := value.(map[string]interface{}); {
+		case isTable && field.Type.Kind() == reflect.Struct:
+			normalized, err := normalize(nested, field.Type, path)
+			errs = append(errs, err)
+			value = normalized
+		case isTable && field.Type.Kind() == reflect.Map && field.Type.Elem().Kind() == reflect.Struct:
+			entries := make(map[string]interface{}, len(nested))
+			for entry, entryValue := range nested {
+				entryTable, ok := entryValue.(map[string]interface{})
+				if !ok {
+					entries[entry] = entryValue
+					continue
+				}
+				normalized, err := normalize(entryTable, field.Type.Elem(), joinKey(path, entry))
+				errs = append(errs, err)
+				entries[entry] = normalized
+			}
+			value = entries
 		}
-		return match
+		result[name] = value
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i] != nil && (errs[j] == nil || errs[i].Error() < errs[j].Error())
 	})
 
-	err = toml.Unmarshal([]byte(tomlStr), &config)
+	return result, errors.Join(errs...)
+}
+
+// merge copies src over dst, merging tables key by key.
+func merge(dst, src map[string]interface{}) {
+	for key, value := range src {
+		if srcTable, ok := value.(map[string]interface{}); ok {
+			if dstTable, ok := dst[key].(map[string]interface{}); ok {
+				merge(dstTable, srcTable)
+				continue
+			}
+		}
+		dst[key] = value
+	}
+}
+
+// applyEnv sets the fields of typ, a struct type, that have an environment
+// variable.
+func applyEnv(tree map[string]interface{}, typ reflect.Type, prefix string, path []string) error {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fieldPath := append(slices.Clip(path), configKey(field.Name))
+		if field.Type.Kind() == reflect.Struct {
+			if err := applyEnv(tree, field.Type, prefix, fieldPath); err != nil {
+				return err
+			}
+			continue
+		}
+		if field.Type.Kind() == reflect.Map {
+			continue
+		}
+
+		name := strings.ToUpper(prefix + "_" + strings.Join(fieldPath, "_"))
+		value, ok := os.LookupEnv(name)
+		if file, isSet := os.LookupEnv(name + strings.ToUpper(fileSuffix)); isSet && field.Type == secretType {
+			if ok {
+				return fmt.Errorf("both %s and %s%s are set", name, name, strings.ToUpper(fileSuffix))
+			}
+			secret, err := readSecretFile(file)
+			if err != nil {
+				return fmt.Errorf("%s%s: %w", name, strings.ToUpper(fileSuffix), err)
+			}
+			value, ok = secret, true
+		}
+		if ok {
+			parsed, err := parseValue(field.Type, value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			putKey(tree, fieldPath, parsed)
+		}
+	}
+
+	return nil
+}
+
+// set puts value, converted to the type of the field at path, into tree. If
+// path ends in the _file key of a Secret, value is the file to read it from.
+func set(tree map[string]interface{}, typ reflect.Type, path []string, value string) error {
+	canonical := make([]string, 0, len(path))
+	fromFile := false
+	for i, key := range path {
+		switch typ.Kind() {
+		case reflect.Struct:
+			field, ok := fieldByKey(typ, key)
+			if base, isFile := secretFileKey(key); !ok && isFile && i == len(path)-1 {
+				field, ok = fieldByKey(typ, base)
+				fromFile = ok && field.Type == secretType
+				ok = fromFile
+			}
+			if !ok || !field.IsExported() {
+				return fmt.Errorf("%w %s", ErrUnknownKey, strings.Join(path[:i+1], "."))
+			}
+			canonical = append(canonical, configKey(field.Name))
+			typ = field.Type
+		case reflect.Map:
+			canonical = append(canonical, key)
+			typ = typ.Elem()
+		default:
+			return fmt.Errorf("%w %s", ErrUnknownKey, strings.Join(path[:i+1], "."))
+		}
+	}
+
+	if fromFile {
+		secret, err := readSecretFile(value)
+		if err != nil {
+			return err
+		}
+		value = secret
+	}
+	parsed, err := parseValue(typ, value)
 	if err != nil {
-		return nil, fmt.Errorf("NewConfig: failed unmarshal toml: %w", err)
+		return err
 	}
+	putKey(tree, canonical, parsed)
 
-	return &config, nil
+	return nil
+}
+
+func putKey(tree map[string]interface{}, path []string, value interface{}) {
+	for _, key := range path[:len(path)-1] {
+		table, ok := tree[key].(map[string]interface{})
+		if !ok {
+			table = make(map[string]interface{})
+			tree[key] = table
+		}
+		tree = table
+	}
+	tree[path[len(path)-1]] = value
+}
+
+func parseValue(typ reflect.Type, value string) (interface{}, error) {
+	switch typ.Kind() {
+	case reflect.String:
+		return value, nil
+	case reflect.Bool:
+		return strconv.ParseBool(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(value, 10, 64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.ParseUint(value, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(value, 64)
+	case reflect.Slice:
+		if typ.Elem().Kind() != reflect.String {
+			return nil, fmt.Errorf("a list of %s cannot be set from a single value", typ.Elem().Kind())
+		}
+		// Lists of strings are comma-separated.
+		var values []interface{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("a %s cannot be set from a single value", typ.Kind())
+	}
+}
+
+// missingRequired lists the fields tagged `required:"true"` left empty.
+func missingRequired(v reflect.Value, prefix string) []string {
+	var missing []string
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		path := joinKey(prefix, configKey(field.Name))
+		if field.Tag.Get("required") == "true" && v.Field(i).IsZero() {
+			missing = append(missing, path)
+		}
+		if field.Type.Kind() == reflect.Struct {
+			missing = append(missing, missingRequired(v.Field(i), path)...)
+		}
+	}
+
+	return missing
+}
+
+// toTree turns a configuration into tables keyed as in the TOML files,
+// leaving out nil maps and slices. With redactSecrets, the non-empty
+// values of Secret fields and of fields tagged `secret:"true"` are replaced.
+func toTree(v reflect.Value, redactSecrets bool) interface{} {
+	switch v.Kind() {
+	case reflect.Struct:
+		result := make(map[string]interface{}, v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			value := v.Field(i)
+			if !field.IsExported() || isNil(value) {
+				continue
+			}
+			if field.Type == secretType {
+				secret := value.String()
+				if redactSecrets && secret != "" {
+					secret = redacted
+				}
+				result[configKey(field.Name)] = secret
+				continue
+			}
+			if redactSecrets && field.Tag.Get("secret") == "true" && !value.IsZero() {
+				result[configKey(field.Name)] = redacted
+				continue
+			}
+			result[configKey(field.Name)] = toTree(value, redactSecrets)
+		}
+		return result
+	case reflect.Map:
+		result := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			result[fmt.Sprint(iter.Key().Interface())] = toTree(iter.Value(), redactSecrets)
+		}
+		return result
+	case reflect.Slice, reflect.Array:
+		result := make([]interface{}, v.Len())
+		for i := range result {
+			result[i] = toTree(v.Index(i), redactSecrets)
+		}
+		return result
+	default:
+		return v.Interface()
+	}
+}
+
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	default:
+		return false
+	}
+}
+
+// Redact turns a configuration into tables keyed as in the TOML files, with
+// the non-empty values of Secret fields and of fields tagged `secret:"true"`
+// replaced.
+func Redact(config interface{}) interface{} {
+	return toTree(reflect.ValueOf(config), true)
+}
+
+// PrintConfig writes config as TOML, secrets redacted.
+func PrintConfig(w io.Writer, config interface{}) error {
+	b, err := toml.Marshal(Redact(config))
+	if err != nil {
+		return fmt.Errorf("helper.PrintConfig: %w", err)
+	}
+	_, err = w.Write(b)
+
+	return err
+}
+
+// ConfigFlags are the command-line layers of a configuration: -config,
+// which may be repeated, and -set key=value, which may be too.
+type ConfigFlags struct {
+	defaultFile string
+	files       stringsFlag
+	overrides   stringsFlag
+}
+
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// NewConfigFlags registers the flags on fs. defaultFile is read when no
+// -config is given.
+func NewConfigFlags(fs *flag.FlagSet, defaultFile string) *ConfigFlags {
+	f := &ConfigFlags{defaultFile: defaultFile}
+	fs.Var(&f.files, "config",
+		fmt.Sprintf("Path to configuration file; repeat to layer several (default %q)", defaultFile))
+	fs.Var(&f.overrides, "set", "Override a configuration key, as key=value; may be repeated")
+
+	return f
+}
+
+func (f *ConfigFlags) Files() []string {
+	if len(f.files) == 0 {
+		return []string{f.defaultFile}
+	}
+
+	return f.files
+}
+
+func (f *ConfigFlags) Overrides() []string {
+	return f.overrides
 }
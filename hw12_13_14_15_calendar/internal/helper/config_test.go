@@ -0,0 +1,214 @@
+package helper
+
+import (
+	"bytes"
+	"flag"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type layeredConfig struct {
+	Storage string `required:"true"`
+	Logger  struct {
+		Level string
+	}
+	Server struct {
+		Host string
+		Port string `required:"true"`
+	}
+	DB struct {
+		Password Secret
+	}
+	RateLimit struct {
+		Rate   float64
+		Routes map[string]struct {
+			Rate  float64
+			Burst int
+		}
+	}
+}
+
+func layeredDefaults() layeredConfig {
+	var config layeredConfig
+	config.Logger.Level = "INFO"
+	config.Server.Host = "localhost"
+	config.RateLimit.Rate = 20
+
+	return config
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	writeConfig(t, path, content)
+
+	return path
+}
+
+func TestConfigKey(t *testing.T) {
+	for field, want := range map[string]string{
+		"RateLimit":        "rateLimit",
+		"DB":               "db",
+		"GRPC":             "grpc",
+		"ClientCAFile":     "clientCAFile",
+		"CAFile":           "caFile",
+		"MaxEventsPerUser": "maxEventsPerUser",
+		"WorkerID":         "workerID",
+	} {
+		require.Equal(t, want, configKey(field), field)
+	}
+}
+
+func TestLoadConfigLayers(t *testing.T) {
+	base := writeConfigFile(t, `
+storage = "sql"
+
+[server]
+port = "8080"
+
+[rateLimit.routes."POST /event/create"]
+rate = 1
+burst = 10
+`)
+	local := writeConfigFile(t, `
+[Server]
+Host = "0.0.0.0"
+
+[rateLimit.routes."POST /event/create"]
+burst = 5
+`)
+	t.Setenv("TEST_SERVER_PORT", "9090")
+	t.Setenv("TEST_LOGGER_LEVEL", "DEBUG")
+
+	config, err := LoadConfig(ConfigOptions[layeredConfig]{
+		Defaults:  layeredDefaults(),
+		Files:     []string{base, local},
+		EnvPrefix: "TEST",
+		Overrides: []string{
+			"logger.level=WARN",
+			`rateLimit.routes."POST /event/create".rate=2.5`,
+			`rateLimit.routes."GET /events".burst=3`,
+		},
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, "sql", config.Storage)
+	require.Equal(t, "0.0.0.0", config.Server.Host, "the second file overrides the first")
+	require.Equal(t, "9090", config.Server.Port, "the environment overrides the files")
+	require.Equal(t, "WARN", config.Logger.Level, "overrides win over the environment")
+	require.InDelta(t, 20.0, config.RateLimit.Rate, 0, "defaults stay when nothing overrides them")
+
+	route := config.RateLimit.Routes["POST /event/create"]
+	require.InDelta(t, 2.5, route.Rate, 0)
+	require.Equal(t, 5, route.Burst, "tables are merged key by key")
+	require.Equal(t, 3, config.RateLimit.Routes["GET /events"].Burst)
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	valid := "storage = \"memory\"\n[server]\nport = \"8080\"\n"
+
+	tests := []struct {
+		name      string
+		content   string
+		overrides []string
+		env       map[string]string
+		err       error
+		message   string
+	}{
+		{
+			name:    "unknown keys",
+			content: valid + "[db]\npasword = \"x\"\n[rateLimit.routes.\"GET /\"]\nrat = 1\n",
+			err:     ErrUnknownKey,
+			message: `unknown key db.pasword` + "\n" + `unknown key rateLimit.routes."GET /".rat`,
+		},
+		{
+			name:    "missing required",
+			content: "[logger]\nlevel = \"INFO\"\n",
+			err:     ErrMissingRequired,
+			message: "storage, server.port",
+		},
+		{
+			name:    "undefined placeholders",
+			content: valid + "[db]\npassword = \"${TEST_UNSET_A}${TEST_UNSET_B}\"\n",
+			err:     ErrUndefinedEnv,
+			message: "TEST_UNSET_A, TEST_UNSET_B",
+		},
+		{
+			name:      "unknown override",
+			content:   valid,
+			overrides: []string{"server.hots=x"},
+			err:       ErrUnknownKey,
+			message:   "server.hots",
+		},
+		{
+			name:      "override of the wrong type",
+			content:   valid,
+			overrides: []string{"rateLimit.rate=fast"},
+			message:   "invalid syntax",
+		},
+		{
+			name:    "environment of the wrong type",
+			content: valid,
+			env:     map[string]string{"TEST_RATELIMIT_RATE": "fast"},
+			message: "TEST_RATELIMIT_RATE",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			_, err := LoadConfig(ConfigOptions[layeredConfig]{
+				Defaults:  layeredDefaults(),
+				Files:     []string{writeConfigFile(t, tt.content)},
+				EnvPrefix: "TEST",
+				Overrides: tt.overrides,
+			})
+			require.Error(t, err)
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+			}
+			require.Contains(t, err.Error(), tt.message)
+		})
+	}
+}
+
+func TestConfigFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := NewConfigFlags(fs, "/etc/calendar/config.toml")
+	require.NoError(t, fs.Parse(nil))
+	require.Equal(t, []string{"/etc/calendar/config.toml"}, flags.Files())
+
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	flags = NewConfigFlags(fs, "/etc/calendar/config.toml")
+	require.NoError(t, fs.Parse([]string{
+		"-config", "base.toml", "-config", "local.toml", "-set", "logger.level=DEBUG", "config", "check",
+	}))
+	require.Equal(t, []string{"base.toml", "local.toml"}, flags.Files())
+	require.Equal(t, []string{"logger.level=DEBUG"}, flags.Overrides())
+	require.Equal(t, []string{"config", "check"}, fs.Args())
+}
+
+func TestPrintConfig(t *testing.T) {
+	config := layeredDefaults()
+	config.Storage = "sql"
+	config.DB.Password = "hunter2"
+
+	var out bytes.Buffer
+	require.NoError(t, PrintConfig(&out, config))
+	require.NotContains(t, out.String(), "hunter2")
+	require.Contains(t, out.String(), `password = '[REDACTED]'`)
+
+	// What is printed loads back as the same configuration.
+	config.DB.Password = "[REDACTED]"
+	loaded, err := LoadConfig(ConfigOptions[layeredConfig]{
+		Files:     []string{writeConfigFile(t, out.String())},
+		Overrides: []string{"server.port=1"},
+	})
+	require.NoError(t, err)
+	config.Server.Port = "1"
+	require.Equal(t, config, *loaded)
+}
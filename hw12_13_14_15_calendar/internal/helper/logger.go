@@ -0,0 +1,48 @@
+package helper
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	loggerslog "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger/slog"
+)
+
+type LoggerConfig struct {
+	Level  string
+	Format string
+	Redact []string
+	// File is written instead of stderr. It is rotated once it reaches
+	// MaxSize megabytes, keeping MaxBackups old files; a MaxSize of 0 never
+	// rotates.
+	File       string
+	MaxSize    int
+	MaxBackups int
+}
+
+type closeLogger = func() error
+
+// InitLogger creates the logger of a service as config describes it.
+func InitLogger(config LoggerConfig) (*loggerslog.Logger, closeLogger, error) {
+	var w io.Writer = os.Stderr
+	c := cl
+	if config.File != "" {
+		file, err := loggerslog.OpenFile(config.File, int64(config.MaxSize)<<20, config.MaxBackups)
+		if err != nil {
+			return nil, c, fmt.Errorf("InitLogger: %w", err)
+		}
+		w, c = file, file.Close
+	}
+
+	logg, err := loggerslog.NewWithConfig(w, loggerslog.Config{
+		Level:  config.Level,
+		Format: config.Format,
+		Redact: config.Redact,
+	})
+	if err != nil {
+		c()
+		return nil, cl, fmt.Errorf("InitLogger: %w", err)
+	}
+
+	return logg, c, nil
+}
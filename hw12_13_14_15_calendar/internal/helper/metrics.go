@@ -0,0 +1,222 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/metrics"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = tracing.Tracer("storage")
+
+// instrumentedStorage records latency and failures of every storage call,
+// wraps it in a span and logs its failure with the ID of the request.
+type instrumentedStorage struct {
+	next   Storage
+	system string
+	logger logger.Logger
+}
+
+// InstrumentStorage decorates next; system names the backend ("postgresql" or
+// "memory") on spans and in logs.
+func InstrumentStorage(next Storage, system string, logger logger.Logger) Storage {
+	return instrumentedStorage{next: next, system: system, logger: logger}
+}
+
+func (s instrumentedStorage) observe(ctx context.Context, operation string) (context.Context, func(error)) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "Storage."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("storage.system", s.system)),
+	)
+
+	return ctx, func(err error) {
+		metrics.StorageOperationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+		if errors.Is(err, storage.ErrNoEventsFound) {
+			err = nil
+		}
+		if err != nil {
+			metrics.StorageErrors.WithLabelValues(operation).Inc()
+		}
+		if err != nil && !errors.Is(err, storage.ErrEventDoesntExist) {
+			s.logger.WarnContext(ctx, "storage call failed",
+				"system", s.system, "operation", operation, "err", err)
+		}
+		tracing.End(span, err)
+	}
+}
+
+func (s instrumentedStorage) CreateEvent(ctx context.Context, event storage.Event) (err error) {
+	ctx, done := s.observe(ctx, "CreateEvent")
+	defer func() { done(err) }()
+	return s.next.CreateEvent(ctx, event)
+}
+
+func (s instrumentedStorage) CountUserEvents(ctx context.Context, userID string) (_ int, err error) {
+	ctx, done := s.observe(ctx, "CountUserEvents")
+	defer func() { done(err) }()
+	return s.next.CountUserEvents(ctx, userID)
+}
+
+func (s instrumentedStorage) GetEvent(ctx context.Context, id string) (_ *storage.Event, err error) {
+	ctx, done := s.observe(ctx, "GetEvent")
+	defer func() { done(err) }()
+	return s.next.GetEvent(ctx, id)
+}
+
+func (s instrumentedStorage) EditEvent(ctx context.Context, id string, event storage.Event) (err error) {
+	ctx, done := s.observe(ctx, "EditEvent")
+	defer func() { done(err) }()
+	return s.next.EditEvent(ctx, id, event)
+}
+
+func (s instrumentedStorage) DeleteEvent(ctx context.Context, id string) (err error) {
+	ctx, done := s.observe(ctx, "DeleteEvent")
+	defer func() { done(err) }()
+	return s.next.DeleteEvent(ctx, id)
+}
+
+func (s instrumentedStorage) GetEventsListDay(ctx context.Context, date time.Time) (_ []storage.Event, err error) {
+	ctx, done := s.observe(ctx, "GetEventsListDay")
+	defer func() { done(err) }()
+	return s.next.GetEventsListDay(ctx, date)
+}
+
+func (s instrumentedStorage) GetEventsListWeek(ctx context.Context, date time.Time) (_ []storage.Event, err error) {
+	ctx, done := s.observe(ctx, "GetEventsListWeek")
+	defer func() { done(err) }()
+	return s.next.GetEventsListWeek(ctx, date)
+}
+
+func (s instrumentedStorage) GetEventsListMonth(ctx context.Context, date time.Time) (_ []storage.Event, err error) {
+	ctx, done := s.observe(ctx, "GetEventsListMonth")
+	defer func() { done(err) }()
+	return s.next.GetEventsListMonth(ctx, date)
+}
+
+func (s instrumentedStorage) GetUserEvents(
+	ctx context.Context,
+	userID string,
+	from, to time.Time,
+) (_ []storage.Event, err error) {
+	ctx, done := s.observe(ctx, "GetUserEvents")
+	defer func() { done(err) }()
+	return s.next.GetUserEvents(ctx, userID, from, to)
+}
+
+func (s instrumentedStorage) GetEventsToNotify(ctx context.Context) (_ []storage.Event, err error) {
+	ctx, done := s.observe(ctx, "GetEventsToNotify")
+	defer func() { done(err) }()
+	return s.next.GetEventsToNotify(ctx)
+}
+
+func (s instrumentedStorage) ClaimEventsToNotify(
+	ctx context.Context,
+	workerID string,
+	limit int,
+	lease time.Duration,
+) (_ []storage.Event, err error) {
+	ctx, done := s.observe(ctx, "ClaimEventsToNotify")
+	defer func() { done(err) }()
+	return s.next.ClaimEventsToNotify(ctx, workerID, limit, lease)
+}
+
+func (s instrumentedStorage) ReleaseExpiredClaims(ctx context.Context) (err error) {
+	ctx, done := s.observe(ctx, "ReleaseExpiredClaims")
+	defer func() { done(err) }()
+	return s.next.ReleaseExpiredClaims(ctx)
+}
+
+func (s instrumentedStorage) MarkNotified(ctx context.Context, workerID string, ids []string) (err error) {
+	ctx, done := s.observe(ctx, "MarkNotified")
+	defer func() { done(err) }()
+	return s.next.MarkNotified(ctx, workerID, ids)
+}
+
+func (s instrumentedStorage) ClearEvents(ctx context.Context, duration time.Duration) (err error) {
+	ctx, done := s.observe(ctx, "ClearEvents")
+	defer func() { done(err) }()
+	return s.next.ClearEvents(ctx, duration)
+}
+
+func (s instrumentedStorage) SetNotified(ctx context.Context, id string) (err error) {
+	ctx, done := s.observe(ctx, "SetNotified")
+	defer func() { done(err) }()
+	return s.next.SetNotified(ctx, id)
+}
+
+func (s instrumentedStorage) SetDigestSubscription(
+	ctx context.Context,
+	subscription storage.DigestSubscription,
+) (err error) {
+	ctx, done := s.observe(ctx, "SetDigestSubscription")
+	defer func() { done(err) }()
+	return s.next.SetDigestSubscription(ctx, subscription)
+}
+
+func (s instrumentedStorage) GetDigestSubscriptions(ctx context.Context) (_ []storage.DigestSubscription, err error) {
+	ctx, done := s.observe(ctx, "GetDigestSubscriptions")
+	defer func() { done(err) }()
+	return s.next.GetDigestSubscriptions(ctx)
+}
+
+func (s instrumentedStorage) ClaimDigest(
+	ctx context.Context,
+	userID string,
+	kind storage.DigestKind,
+	periodStart time.Time,
+	lease time.Duration,
+) (_ bool, err error) {
+	ctx, done := s.observe(ctx, "ClaimDigest")
+	defer func() { done(err) }()
+	return s.next.ClaimDigest(ctx, userID, kind, periodStart, lease)
+}
+
+func (s instrumentedStorage) MarkDigestPublished(
+	ctx context.Context,
+	userID string,
+	kind storage.DigestKind,
+	periodStart time.Time,
+) (err error) {
+	ctx, done := s.observe(ctx, "MarkDigestPublished")
+	defer func() { done(err) }()
+	return s.next.MarkDigestPublished(ctx, userID, kind, periodStart)
+}
+
+func (s instrumentedStorage) ReleaseDigest(
+	ctx context.Context,
+	userID string,
+	kind storage.DigestKind,
+	periodStart time.Time,
+) (err error) {
+	ctx, done := s.observe(ctx, "ReleaseDigest")
+	defer func() { done(err) }()
+	return s.next.ReleaseDigest(ctx, userID, kind, periodStart)
+}
+
+func (s instrumentedStorage) SetDigestSent(
+	ctx context.Context,
+	userID string,
+	kind storage.DigestKind,
+	periodStart time.Time,
+) (err error) {
+	ctx, done := s.observe(ctx, "SetDigestSent")
+	defer func() { done(err) }()
+	return s.next.SetDigestSent(ctx, userID, kind, periodStart)
+}
+
+func (s instrumentedStorage) NewLocker(key int64) storage.Locker {
+	return s.next.NewLocker(key)
+}
+
+// Ping is polled by health checks and is deliberately left out of metrics and
+// traces.
+func (s instrumentedStorage) Ping(ctx context.Context) error {
+	return s.next.Ping(ctx)
+}
@@ -0,0 +1,40 @@
+package helper
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/clock"
+	loggerslog "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger/slog"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/metrics"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/requestid"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+	memorystorage "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage/memory"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstrumentStorage(t *testing.T) {
+	var logs bytes.Buffer
+	logg, err := loggerslog.New(&logs, "INFO")
+	require.NoError(t, err)
+	s := InstrumentStorage(memorystorage.New(clock.New()), "memory", logg)
+	errors := metrics.StorageErrors.WithLabelValues("GetEvent")
+	before := testutil.ToFloat64(errors)
+
+	require.NoError(t, s.CreateEvent(context.TODO(), storage.Event{ID: "1"}))
+	_, err = s.GetEvent(context.TODO(), "1")
+	require.NoError(t, err)
+	_, err = s.GetEvent(context.TODO(), "2")
+	require.ErrorIs(t, err, storage.ErrEventDoesntExist)
+
+	require.Equal(t, before+1, testutil.ToFloat64(errors))
+	require.Equal(t, 2, testutil.CollectAndCount(metrics.StorageOperationDuration), "one series per operation")
+	require.Empty(t, logs.String(), "missing events are not logged")
+
+	ctx := requestid.WithID(context.Background(), "abc-123")
+	require.ErrorIs(t, s.CreateEvent(ctx, storage.Event{ID: "1"}), storage.ErrEventAlreadyExists)
+	require.Contains(t, logs.String(), "operation=CreateEvent")
+	require.Contains(t, logs.String(), "requestID=abc-123")
+}
@@ -0,0 +1,43 @@
+package helper
+
+import (
+	"fmt"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/queue"
+)
+
+type QueueConfig struct {
+	// Broker is "amqp" (default), "memory" or "file".
+	Broker   string
+	User     string
+	Password Secret
+	Host     string
+	Port     string
+	// Dir holds the queues of the file broker.
+	Dir string
+}
+
+// InitBroker creates and starts the broker selected in config.
+func InitBroker(config QueueConfig, logger queue.Logger) (queue.Broker, error) {
+	var broker queue.Broker
+	switch config.Broker {
+	case "", queue.BrokerAMQP:
+		broker = queue.NewQueue(config.User, config.Password.Value(), config.Host, config.Port, logger)
+	case queue.BrokerMemory:
+		broker = queue.NewMemoryBroker()
+	case queue.BrokerFile:
+		if config.Dir == "" {
+			return nil, fmt.Errorf("InitBroker: file broker needs a directory")
+		}
+		broker = queue.NewFileBroker(config.Dir, logger)
+	default:
+		return nil, fmt.Errorf("InitBroker: unknown broker %q", config.Broker)
+	}
+
+	err := broker.Start()
+	if err != nil {
+		return nil, fmt.Errorf("InitBroker: %w", err)
+	}
+
+	return broker, nil
+}
@@ -0,0 +1,170 @@
+package helper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger"
+)
+
+// Reloader keeps the effective configuration of a service: the one it
+// started with plus the changes applied by every reload since.
+type Reloader[C any] struct {
+	load   func() (C, error)
+	safe   []string
+	apply  func(C) error
+	logger logger.Logger
+
+	mu     sync.RWMutex
+	config C
+}
+
+// NewReloader starts from config, which load loaded. Reloads pass the keys in
+// safe, such as "logger.level", or anything under them, such as "rateLimit",
+// to apply; changes to other keys need a restart and are ignored.
+func NewReloader[C any](
+	load func() (C, error),
+	config C,
+	safe []string,
+	apply func(C) error,
+	logger logger.Logger,
+) *Reloader[C] {
+	return &Reloader[C]{
+		load:   load,
+		safe:   safe,
+		apply:  apply,
+		logger: logger,
+		config: config,
+	}
+}
+
+// Config is the effective configuration.
+func (r *Reloader[C]) Config() C {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.config
+}
+
+func (r *Reloader[C]) isSafe(key string) bool {
+	return slices.ContainsFunc(r.safe, func(safe string) bool {
+		return key == safe || strings.HasPrefix(key, safe+".")
+	})
+}
+
+// Reload loads the configuration again and applies its safe changes. If it is
+// invalid or apply fails, the effective configuration stays as it was.
+func (r *Reloader[C]) Reload() error {
+	loaded, err := r.load()
+	if err != nil {
+		return fmt.Errorf("helper.Reload: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next := r.config
+	var applied []string
+	for _, key := range ConfigDiff(r.config, loaded) {
+		if !r.isSafe(key) {
+			r.logger.Warn("config change needs a restart, ignoring it", "key", key)
+			continue
+		}
+		copyKey(reflect.ValueOf(&next).Elem(), reflect.ValueOf(loaded), strings.Split(key, "."))
+		applied = append(applied, key)
+	}
+	if len(applied) == 0 {
+		r.logger.Info("config reloaded, nothing to apply")
+		return nil
+	}
+
+	if err := r.apply(next); err != nil {
+		return fmt.Errorf("helper.Reload: %w", err)
+	}
+	r.config = next
+	r.logger.Info("config reloaded", "applied", strings.Join(applied, ","))
+
+	return nil
+}
+
+// Watch reloads whenever signals delivers, typically SIGHUP, until ctx is
+// done.
+func (r *Reloader[C]) Watch(ctx context.Context, signals <-chan os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			if err := r.Reload(); err != nil {
+				r.logger.Error("failed to reload config", "err", err)
+			}
+		}
+	}
+}
+
+// Handler serves the effective configuration as JSON, secrets redacted.
+func (r *Reloader[C]) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		js, err := json.MarshalIndent(Redact(r.Config()), "", "\t")
+		if err != nil {
+			r.logger.Error("failed to encode config", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(js)
+	})
+}
+
+// ConfigDiff lists the keys whose values differ between two configurations
+// of the same type. Structs are compared field by field; maps, slices and
+// other values as a whole.
+func ConfigDiff(old, next interface{}) []string {
+	return diff(reflect.ValueOf(old), reflect.ValueOf(next), "")
+}
+
+func diff(old, next reflect.Value, prefix string) []string {
+	if old.Kind() != reflect.Struct {
+		if reflect.DeepEqual(old.Interface(), next.Interface()) {
+			return nil
+		}
+		return []string{prefix}
+	}
+
+	var keys []string
+	for i := 0; i < old.NumField(); i++ {
+		field := old.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		key := configKey(field.Name)
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		keys = append(keys, diff(old.Field(i), next.Field(i), key)...)
+	}
+
+	return keys
+}
+
+// copyKey sets the value at path in dst to the one in src.
+func copyKey(dst, src reflect.Value, path []string) {
+	for _, name := range path {
+		field, ok := dst.Type().FieldByNameFunc(func(field string) bool {
+			return configKey(field) == name
+		})
+		if !ok {
+			return
+		}
+		dst, src = dst.FieldByIndex(field.Index), src.FieldByIndex(field.Index)
+	}
+	dst.Set(src)
+}
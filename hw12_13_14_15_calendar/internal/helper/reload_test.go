@@ -0,0 +1,159 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	loggerslog "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger/slog"
+	"github.com/stretchr/testify/require"
+)
+
+type testConfig struct {
+	Logger struct {
+		Level string
+	}
+	DB struct {
+		Host     string
+		Password string `secret:"true"`
+	}
+	RateLimit struct {
+		Rate   float64
+		Routes map[string]int
+	}
+}
+
+const testConfigFile = `
+[logger]
+level = "INFO"
+
+[db]
+host = "db"
+password = "${TEST_DB_PASSWORD}"
+
+[rateLimit]
+rate = 1
+
+[rateLimit.routes]
+"POST /event/create" = 10
+`
+
+func writeConfig(t *testing.T, path, content string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+}
+
+func newTestReloader(t *testing.T, apply func(testConfig) error) (*Reloader[testConfig], string) {
+	t.Helper()
+	t.Setenv("TEST_DB_PASSWORD", "hunter2")
+	path := filepath.Join(t.TempDir(), "config.toml")
+	writeConfig(t, path, testConfigFile)
+	load := func() (testConfig, error) {
+		config, err := LoadConfig(ConfigOptions[testConfig]{Files: []string{path}})
+		if err != nil {
+			return testConfig{}, err
+		}
+		return *config, nil
+	}
+	config, err := load()
+	require.NoError(t, err)
+
+	logg, err := loggerslog.New(io.Discard, "INFO")
+	require.NoError(t, err)
+
+	return NewReloader(load, config, []string{"logger.level", "rateLimit"}, apply, logg), path
+}
+
+func TestReloadAppliesSafeChanges(t *testing.T) {
+	var applied []testConfig
+	reloader, path := newTestReloader(t, func(config testConfig) error {
+		applied = append(applied, config)
+		return nil
+	})
+
+	writeConfig(t, path, `
+[logger]
+level = "DEBUG"
+
+[db]
+host = "other-db"
+password = "${TEST_DB_PASSWORD}"
+
+[rateLimit]
+rate = 1
+
+[rateLimit.routes]
+"POST /event/create" = 20
+`)
+	require.NoError(t, reloader.Reload())
+
+	require.Len(t, applied, 1)
+	config := reloader.Config()
+	require.Equal(t, applied[0], config)
+	require.Equal(t, "DEBUG", config.Logger.Level)
+	require.Equal(t, map[string]int{"POST /event/create": 20}, config.RateLimit.Routes)
+	require.Equal(t, "db", config.DB.Host, "the host needs a restart")
+}
+
+func TestReloadKeepsConfigOnError(t *testing.T) {
+	reloader, path := newTestReloader(t, func(config testConfig) error {
+		if config.Logger.Level == "LOUD" {
+			return errors.New("wrong level")
+		}
+		return nil
+	})
+	before := reloader.Config()
+
+	writeConfig(t, path, "[logger\n")
+	require.Error(t, reloader.Reload())
+	require.Equal(t, before, reloader.Config())
+
+	writeConfig(t, path, "[logger]\nlevel = \"LOUD\"\n")
+	require.Error(t, reloader.Reload())
+	require.Equal(t, before, reloader.Config())
+}
+
+func TestReloaderWatch(t *testing.T) {
+	reloaded := make(chan testConfig, 1)
+	reloader, path := newTestReloader(t, func(config testConfig) error {
+		reloaded <- config
+		return nil
+	})
+	signals := make(chan os.Signal, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reloader.Watch(ctx, signals)
+
+	writeConfig(t, path, "[rateLimit]\nrate = 5\n")
+	signals <- os.Interrupt
+
+	select {
+	case config := <-reloaded:
+		require.InDelta(t, 5.0, config.RateLimit.Rate, 0)
+		require.Nil(t, config.RateLimit.Routes)
+	case <-time.After(time.Second):
+		t.Fatal("config was not reloaded")
+	}
+}
+
+func TestReloaderHandlerRedactsSecrets(t *testing.T) {
+	reloader, _ := newTestReloader(t, func(testConfig) error { return nil })
+
+	w := httptest.NewRecorder()
+	reloader.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/config", nil))
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	require.NotContains(t, w.Body.String(), "hunter2")
+	require.JSONEq(t, `{
+		"logger": {"level": "INFO"},
+		"db": {"host": "db", "password": "[REDACTED]"},
+		"rateLimit": {"rate": 1, "routes": {"POST /event/create": 10}}
+	}`, w.Body.String())
+}
@@ -0,0 +1,66 @@
+package helper
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// Secret is a configuration value, such as a password, that is never printed:
+// fmt, slog and encoding/json all see "[REDACTED]". Value returns the secret
+// itself.
+//
+// In TOML files, environment variables and -set overrides the key of a Secret
+// can be suffixed with _file, as in password_file or CALENDAR_DB_PASSWORD_FILE,
+// to read the value from a file such as a mounted Docker or Kubernetes secret.
+type Secret string
+
+var secretType = reflect.TypeOf(Secret(""))
+
+func (s Secret) Value() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	return redacted
+}
+
+func (s Secret) Format(f fmt.State, _ rune) {
+	io.WriteString(f, redacted)
+}
+
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(redacted)
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(redacted)
+}
+
+// fileSuffix marks a key whose value is the path of a file holding a Secret.
+const fileSuffix = "_file"
+
+// secretFileKey reports whether key is a _file key and returns the key it
+// stands for.
+func secretFileKey(key string) (string, bool) {
+	if len(key) <= len(fileSuffix) || !strings.EqualFold(key[len(key)-len(fileSuffix):], fileSuffix) {
+		return "", false
+	}
+
+	return key[:len(key)-len(fileSuffix)], true
+}
+
+// readSecretFile reads a secret, dropping the line break that editors and
+// echo leave at the end of the file.
+func readSecretFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed reading secret: %w", err)
+	}
+
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
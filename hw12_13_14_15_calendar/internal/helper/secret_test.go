@@ -0,0 +1,89 @@
+package helper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretIsNotPrinted(t *testing.T) {
+	secret := Secret("hunter2")
+	config := struct{ Password Secret }{secret}
+
+	for _, format := range []string{"%v", "%s", "%q", "%d", "%x", "%#v", "%+v"} {
+		out := fmt.Sprintf(format, secret) + fmt.Sprintf(format, config)
+		require.NotContains(t, out, "hunter2", format)
+		require.NotContains(t, out, "68756e74657232", format)
+	}
+
+	var logs bytes.Buffer
+	slog.New(slog.NewJSONHandler(&logs, nil)).Info("connect", "password", secret, "config", config)
+	require.NotContains(t, logs.String(), "hunter2")
+
+	js, err := json.Marshal(config)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"Password": "[REDACTED]"}`, string(js))
+
+	require.Equal(t, "hunter2", secret.Value())
+}
+
+func writeSecret(t *testing.T, secret string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(path, []byte(secret), 0o600))
+
+	return path
+}
+
+func TestLoadConfigSecretFiles(t *testing.T) {
+	valid := "storage = \"memory\"\n[server]\nport = \"8080\"\n"
+	load := func(content string, overrides ...string) (*layeredConfig, error) {
+		return LoadConfig(ConfigOptions[layeredConfig]{
+			Files:     []string{writeConfigFile(t, content)},
+			EnvPrefix: "TEST",
+			Overrides: overrides,
+		})
+	}
+
+	t.Run("file", func(t *testing.T) {
+		path := writeSecret(t, "from-file\n")
+		config, err := load(valid + fmt.Sprintf("[db]\npassword_file = %q\n", path))
+		require.NoError(t, err)
+		require.Equal(t, "from-file", config.DB.Password.Value())
+	})
+	t.Run("environment", func(t *testing.T) {
+		t.Setenv("TEST_DB_PASSWORD_FILE", writeSecret(t, "from-env\r\n"))
+		config, err := load(valid + "[db]\npassword = \"in-config\"\n")
+		require.NoError(t, err)
+		require.Equal(t, "from-env", config.DB.Password.Value())
+	})
+	t.Run("override", func(t *testing.T) {
+		config, err := load(valid, "db.password_file="+writeSecret(t, "from-flag"))
+		require.NoError(t, err)
+		require.Equal(t, "from-flag", config.DB.Password.Value())
+	})
+	t.Run("set twice", func(t *testing.T) {
+		path := writeSecret(t, "from-file")
+		_, err := load(valid + fmt.Sprintf("[db]\npassword = \"x\"\npassword_file = %q\n", path))
+		require.ErrorContains(t, err, "db.password is set twice")
+
+		t.Setenv("TEST_DB_PASSWORD", "x")
+		t.Setenv("TEST_DB_PASSWORD_FILE", path)
+		_, err = load(valid)
+		require.ErrorContains(t, err, "both TEST_DB_PASSWORD and TEST_DB_PASSWORD_FILE are set")
+	})
+	t.Run("missing file", func(t *testing.T) {
+		_, err := load(valid + "[db]\npassword_file = \"/nonexistent\"\n")
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+	t.Run("not a secret", func(t *testing.T) {
+		_, err := load(valid, "server.host_file=/etc/hostname")
+		require.ErrorIs(t, err, ErrUnknownKey)
+	})
+}
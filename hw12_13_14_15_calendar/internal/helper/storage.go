@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/clock"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
 	memorystorage "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage/memory"
 	sqlstorage "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage/sql"
@@ -12,24 +14,101 @@ import (
 
 type Storage interface {
 	CreateEvent(context.Context, storage.Event) error
+	CountUserEvents(ctx context.Context, userID string) (int, error)
 	GetEvent(context.Context, string) (*storage.Event, error)
 	EditEvent(context.Context, string, storage.Event) error
 	DeleteEvent(context.Context, string) error
 	GetEventsListDay(ctx context.Context, date time.Time) ([]storage.Event, error)
 	GetEventsListWeek(ctx context.Context, date time.Time) ([]storage.Event, error)
 	GetEventsListMonth(ctx context.Context, date time.Time) ([]storage.Event, error)
+	GetUserEvents(ctx context.Context, userID string, from, to time.Time) ([]storage.Event, error)
 	GetEventsToNotify(ctx context.Context) ([]storage.Event, error)
-	MarkNotified(ctx context.Context, ids []string) error
+	ClaimEventsToNotify(ctx context.Context, workerID string, limit int, lease time.Duration) ([]storage.Event, error)
+	ReleaseExpiredClaims(ctx context.Context) error
+	MarkNotified(ctx context.Context, workerID string, ids []string) error
 	ClearEvents(ctx context.Context, duration time.Duration) error
 	SetNotified(ctx context.Context, id string) error
+	SetDigestSubscription(ctx context.Context, subscription storage.DigestSubscription) error
+	GetDigestSubscriptions(ctx context.Context) ([]storage.DigestSubscription, error)
+	ClaimDigest(
+		ctx context.Context,
+		userID string,
+		kind storage.DigestKind,
+		periodStart time.Time,
+		lease time.Duration,
+	) (bool, error)
+	MarkDigestPublished(ctx context.Context, userID string, kind storage.DigestKind, periodStart time.Time) error
+	ReleaseDigest(ctx context.Context, userID string, kind storage.DigestKind, periodStart time.Time) error
+	SetDigestSent(ctx context.Context, userID string, kind storage.DigestKind, periodStart time.Time) error
+	NewLocker(key int64) storage.Locker
+	Ping(ctx context.Context) error
 }
 
+// DBConfig is either DSN or the fields it would be built from, see
+// sqlstorage.Config. Durations are in seconds, zero keeps the default.
 type DBConfig struct {
-	User     string
-	Password string
-	Name     string
-	Host     string
-	Port     string
+	DSN             Secret
+	User            string
+	Password        Secret
+	Name            string
+	Host            string
+	Port            string
+	SSLMode         string
+	SSLRootCert     string
+	ConnectTimeout  int
+	ConnectAttempts int
+	QueryAttempts   int
+	Pool            DBPoolConfig
+	Replica         DBReplicaConfig
+}
+
+type DBPoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime int
+	ConnMaxIdleTime int
+}
+
+// DBReplicaConfig is where GetEvent and the event lists are read from; it is
+// reached with the credentials of the primary unless DSN is set.
+type DBReplicaConfig struct {
+	DSN  Secret
+	Host string
+	Port string
+}
+
+// SQLConfig is the sqlstorage.Config for c.
+func (c DBConfig) SQLConfig() sqlstorage.Config {
+	config := sqlstorage.Config{
+		DSN:             c.DSN.Value(),
+		User:            c.User,
+		Password:        c.Password.Value(),
+		Name:            c.Name,
+		Host:            c.Host,
+		Port:            c.Port,
+		SSLMode:         c.SSLMode,
+		SSLRootCert:     c.SSLRootCert,
+		ConnectTimeout:  time.Duration(c.ConnectTimeout) * time.Second,
+		MaxOpenConns:    c.Pool.MaxOpenConns,
+		MaxIdleConns:    c.Pool.MaxIdleConns,
+		ConnMaxLifetime: time.Duration(c.Pool.ConnMaxLifetime) * time.Second,
+		ConnMaxIdleTime: time.Duration(c.Pool.ConnMaxIdleTime) * time.Second,
+		Replica: sqlstorage.Replica{
+			DSN:  c.Replica.DSN.Value(),
+			Host: c.Replica.Host,
+			Port: c.Replica.Port,
+		},
+	}
+	if c.ConnectAttempts != 0 {
+		config.ConnectRetry = sqlstorage.DefaultConnectRetry
+		config.ConnectRetry.Attempts = c.ConnectAttempts
+	}
+	if c.QueryAttempts != 0 {
+		config.QueryRetry = sqlstorage.DefaultQueryRetry
+		config.QueryRetry.Attempts = c.QueryAttempts
+	}
+
+	return config
 }
 
 type closeStorage = func() error
@@ -38,11 +117,19 @@ func cl() error {
 	return nil
 }
 
-func InitStorage(ctx context.Context, dbConfig DBConfig, storageType string) (Storage, closeStorage, error) {
+func InitStorage(
+	ctx context.Context,
+	dbConfig DBConfig,
+	storageType string,
+	clock clock.Clock,
+	logger logger.Logger,
+) (Storage, closeStorage, error) {
 	var storage Storage
 	c := cl
+	system := "memory"
 	if storageType == "sql" {
-		sql := sqlstorage.New(dbConfig.User, dbConfig.Password, dbConfig.Name, dbConfig.Host, dbConfig.Port)
+		system = "postgresql"
+		sql := sqlstorage.New(dbConfig.SQLConfig(), clock)
 		c = sql.Close
 		err := sql.Connect(ctx)
 		if err != nil {
@@ -51,8 +138,8 @@ func InitStorage(ctx context.Context, dbConfig DBConfig, storageType string) (St
 
 		storage = sql
 	} else {
-		storage = memorystorage.New()
+		storage = memorystorage.New(clock)
 	}
 
-	return storage, c, nil
+	return InstrumentStorage(storage, system, logger), c, nil
 }
@@ -1,8 +1,15 @@
 package logger
 
+import "context"
+
+// Logger takes alternating keys and values after the message. The Context
+// variants add what ctx knows about the request, such as its ID.
 type Logger interface {
 	Info(msg string, keysAndValues ...interface{})
 	Error(msg string, keysAndValues ...interface{})
 	Warn(msg string, keysAndValues ...interface{})
+	InfoContext(ctx context.Context, msg string, keysAndValues ...interface{})
+	ErrorContext(ctx context.Context, msg string, keysAndValues ...interface{})
+	WarnContext(ctx context.Context, msg string, keysAndValues ...interface{})
 	With(keysAndValues ...interface{}) Logger
 }
@@ -1,30 +1,133 @@
 package loggerslog
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
+	"slices"
+	"strings"
 
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/requestid"
 )
 
 type Logger struct {
-	slog *slog.Logger
+	slog  *slog.Logger
+	level *slog.LevelVar
 }
 
-func New(w io.Writer, level string) (*Logger, error) {
-	var lvl slog.Level
-	err := lvl.UnmarshalText([]byte(level))
+// redacted replaces the values of sensitive keys.
+const redacted = "[REDACTED]"
+
+// DefaultRedactKeys are masked by every logger.
+var DefaultRedactKeys = []string{"password", "secret", "token", "authorization", "cookie"}
+
+// Config selects the level, the output format, "text" (the default) or
+// "json", and the keys masked on top of DefaultRedactKeys.
+type Config struct {
+	Level  string
+	Format string
+	Redact []string
+}
+
+// New creates a text logger. See NewWithConfig.
+func New(w io.Writer, level string, redactKeys ...string) (*Logger, error) {
+	return NewWithConfig(w, Config{Level: level, Redact: redactKeys})
+}
+
+// NewWithConfig creates a logger that masks the values of keys ending in one
+// of DefaultRedactKeys or config.Redact, ignoring case: "password",
+// "dbPassword" and "access_token" are all masked. Keys nested in groups are
+// matched too. Lines logged with a context carry its request ID.
+func NewWithConfig(w io.Writer, config Config) (*Logger, error) {
+	lvl, err := parseLevel(config.Level)
 	if err != nil {
-		return nil, fmt.Errorf("wrong level value %q: %w", level, err)
+		return nil, err
+	}
+	levelVar := new(slog.LevelVar)
+	levelVar.Set(lvl)
+	options := &slog.HandlerOptions{
+		Level:       levelVar,
+		ReplaceAttr: redact(append(slices.Clone(DefaultRedactKeys), config.Redact...)),
+	}
+
+	var handler slog.Handler
+	switch config.Format {
+	case "", "text":
+		handler = slog.NewTextHandler(w, options)
+	case "json":
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return nil, fmt.Errorf("wrong format value %q", config.Format)
 	}
-	log := slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: lvl}))
 
 	return &Logger{
-		slog: log,
+		slog:  slog.New(contextHandler{handler}),
+		level: levelVar,
 	}, nil
 }
 
+// contextHandler adds the request ID of the context to records.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id, ok := requestid.FromContext(ctx); ok {
+		record.AddAttrs(slog.String("requestID", id))
+	}
+
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+func redact(keys []string) func([]string, slog.Attr) slog.Attr {
+	for i, key := range keys {
+		keys[i] = strings.ToLower(key)
+	}
+	keys = slices.DeleteFunc(keys, func(key string) bool { return key == "" })
+
+	return func(_ []string, attr slog.Attr) slog.Attr {
+		key := strings.ToLower(attr.Key)
+		for _, sensitive := range keys {
+			if strings.HasSuffix(key, sensitive) {
+				return slog.String(attr.Key, redacted)
+			}
+		}
+		return attr
+	}
+}
+
+func parseLevel(level string) (slog.Level, error) {
+	var lvl slog.Level
+	err := lvl.UnmarshalText([]byte(level))
+	if err != nil {
+		return 0, fmt.Errorf("wrong level value %q: %w", level, err)
+	}
+
+	return lvl, nil
+}
+
+// SetLevel changes the level of this logger and of all loggers derived from
+// it with With.
+func (l Logger) SetLevel(level string) error {
+	lvl, err := parseLevel(level)
+	if err != nil {
+		return err
+	}
+	l.level.Set(lvl)
+
+	return nil
+}
+
 func (l Logger) Info(msg string, keysAndValues ...interface{}) {
 	l.slog.Info(msg, keysAndValues...)
 }
@@ -37,8 +140,21 @@ func (l Logger) Warn(msg string, keysAndValues ...interface{}) {
 	l.slog.Warn(msg, keysAndValues...)
 }
 
+func (l Logger) InfoContext(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	l.slog.InfoContext(ctx, msg, keysAndValues...)
+}
+
+func (l Logger) ErrorContext(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	l.slog.ErrorContext(ctx, msg, keysAndValues...)
+}
+
+func (l Logger) WarnContext(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	l.slog.WarnContext(ctx, msg, keysAndValues...)
+}
+
 func (l Logger) With(keysAndValues ...interface{}) logger.Logger {
 	return &Logger{
-		slog: l.slog.With(keysAndValues...),
+		slog:  l.slog.With(keysAndValues...),
+		level: l.level,
 	}
 }
@@ -2,10 +2,13 @@ package loggerslog
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"log/slog"
 	"strings"
 	"testing"
 
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/requestid"
 	"github.com/stretchr/testify/require"
 )
 
@@ -32,4 +35,70 @@ func TestLogger(t *testing.T) {
 		require.Equal(t, "msg=test", s[2])
 		require.Equal(t, "key=value\n", s[3])
 	})
+	t.Run("set level", func(t *testing.T) {
+		var buffer bytes.Buffer
+		l, err := New(&buffer, "Info")
+		require.NoError(t, err)
+		derived := l.With("key", "value")
+
+		require.NoError(t, l.SetLevel("Error"))
+		derived.Info("hidden")
+		require.Empty(t, buffer.String())
+
+		require.Error(t, l.SetLevel("loud"))
+		derived.Error("shown")
+		require.Contains(t, buffer.String(), "msg=shown")
+	})
+}
+
+type secret string
+
+func (secret) LogValue() slog.Value {
+	return slog.StringValue("***")
+}
+
+func TestLoggerRedacts(t *testing.T) {
+	var buffer bytes.Buffer
+	l, err := New(&buffer, "Info", "apiKey", "")
+	require.NoError(t, err)
+
+	l.With("dbPassword", "hunter2").Info("connect",
+		"user", "calendar",
+		"Access_Token", "abc",
+		"apikey", "xyz",
+		slog.Group("db", slog.String("password", "hunter3")),
+		"dsn", secret("postgres://calendar:hunter4@db"),
+	)
+
+	out := buffer.String()
+	for _, leaked := range []string{"hunter2", "abc", "xyz", "hunter3", "hunter4"} {
+		require.NotContains(t, out, leaked)
+	}
+	require.Contains(t, out, "dbPassword=[REDACTED]")
+	require.Contains(t, out, "db.password=[REDACTED]")
+	require.Contains(t, out, "dsn=***")
+	require.Contains(t, out, "user=calendar")
+}
+
+func TestLoggerJSONWithRequestID(t *testing.T) {
+	var buffer bytes.Buffer
+	l, err := NewWithConfig(&buffer, Config{Level: "Info", Format: "json"})
+	require.NoError(t, err)
+
+	ctx := requestid.WithID(context.Background(), "abc-123")
+	l.With("component", "app").InfoContext(ctx, "handled", "statusCode", 200)
+	l.Warn("no context")
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	require.Len(t, lines, 2)
+	var line map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &line))
+	require.Equal(t, "handled", line["msg"])
+	require.Equal(t, "abc-123", line["requestID"])
+	require.Equal(t, "app", line["component"])
+	require.InDelta(t, 200.0, line["statusCode"], 0)
+	require.NotContains(t, lines[1], "requestID")
+
+	_, err = NewWithConfig(&buffer, Config{Level: "Info", Format: "xml"})
+	require.Error(t, err)
 }
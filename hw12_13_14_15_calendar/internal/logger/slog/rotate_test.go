@@ -0,0 +1,57 @@
+package loggerslog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	return string(b)
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calendar.log")
+	require.NoError(t, os.WriteFile(path, []byte("old\n"), 0o600))
+
+	f, err := OpenFile(path, 10, 2)
+	require.NoError(t, err)
+	defer f.Close()
+
+	for _, line := range []string{"line1\n", "line2\n", "line3\n", "line4\n"} {
+		_, err := f.Write([]byte(line))
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, "line4\n", readFile(t, path))
+	require.Equal(t, "line3\n", readFile(t, path+".1"))
+	require.Equal(t, "line2\n", readFile(t, path+".2"))
+	_, err = os.Stat(path + ".3")
+	require.ErrorIs(t, err, os.ErrNotExist, "only maxBackups files are kept")
+}
+
+func TestRotatingFileWithLogger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calendar.log")
+	f, err := OpenFile(path, 200, 0)
+	require.NoError(t, err)
+	defer f.Close()
+
+	l, err := New(f, "Info")
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		l.Info("a line long enough to rotate the file every few lines")
+	}
+
+	content := readFile(t, path)
+	require.LessOrEqual(t, len(content), 200)
+	require.True(t, strings.HasSuffix(content, "\n"), "lines are never split")
+	_, err = os.Stat(path + ".1")
+	require.ErrorIs(t, err, os.ErrNotExist, "without backups old lines are dropped")
+}
@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds every metric exported by the calendar binaries.
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+var (
+	HTTPRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "calendar_http_requests_total",
+		Help: "HTTP requests handled, by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	HTTPRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "calendar_http_request_duration_seconds",
+		Help:    "HTTP request latency, by route and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	GRPCRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "calendar_grpc_requests_total",
+		Help: "gRPC requests handled, by method and status code.",
+	}, []string{"method", "code"})
+
+	GRPCRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "calendar_grpc_request_duration_seconds",
+		Help:    "gRPC request latency, by method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method"})
+
+	StorageOperationDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "calendar_storage_operation_duration_seconds",
+		Help:    "Storage operation latency, by operation.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	StorageErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "calendar_storage_errors_total",
+		Help: "Failed storage operations, by operation.",
+	}, []string{"operation"})
+
+	CacheRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "calendar_cache_requests_total",
+		Help: "Storage reads looked up in the cache, by operation and result (hit or miss).",
+	}, []string{"operation", "result"})
+
+	SchedulerBatchSize = factory.NewHistogram(prometheus.HistogramOpts{
+		Name:    "calendar_scheduler_batch_size",
+		Help:    "Events claimed by one notify run.",
+		Buckets: []float64{0, 1, 5, 10, 25, 50, 100, 250, 500},
+	})
+
+	SchedulerPublishFailures = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "calendar_scheduler_publish_failures_total",
+		Help: "Messages the scheduler failed to publish, by message type.",
+	}, []string{"type"})
+
+	SenderConsumed = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "calendar_sender_messages_consumed_total",
+		Help: "Messages received from the queue, by message type.",
+	}, []string{"type"})
+
+	SenderAcked = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "calendar_sender_messages_acked_total",
+		Help: "Messages delivered and recorded, by message type.",
+	}, []string{"type"})
+
+	SenderFailures = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "calendar_sender_messages_failed_total",
+		Help: "Messages that could not be handled, by message type.",
+	}, []string{"type"})
+)
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
@@ -0,0 +1,11 @@
+package queue
+
+import (
+	"time"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/backoff"
+)
+
+type Backoff = backoff.Backoff
+
+var DefaultBackoff = Backoff{Initial: 500 * time.Millisecond, Max: 30 * time.Second}
@@ -0,0 +1,77 @@
+package queue
+
+import "context"
+
+const (
+	BrokerAMQP   = "amqp"
+	BrokerMemory = "memory"
+	BrokerFile   = "file"
+)
+
+// Publisher sends messages to one queue.
+type Publisher interface {
+	Publish(ctx context.Context, body interface{}) error
+}
+
+// Subscriber receives messages from one queue until ctx is done. Every
+// message must be acked or nacked.
+type Subscriber interface {
+	Consume(ctx context.Context) (<-chan Message, error)
+}
+
+// Broker is a message transport: RabbitMQ in production, or an in-process or
+// file-backed one for running everything on a single machine.
+type Broker interface {
+	Start() error
+	Stop()
+	Check(ctx context.Context) error
+	Publisher(name string) (Publisher, error)
+	Subscriber(name string) (Subscriber, error)
+}
+
+// Acknowledger settles a consumed message with the broker.
+type Acknowledger interface {
+	Ack() error
+	Nack(requeue bool) error
+}
+
+// Message is a consumed message body together with the trace context its
+// producer propagated in the headers.
+type Message struct {
+	ctx   context.Context
+	Body  []byte
+	acker Acknowledger
+}
+
+// NewMessage builds a message; acker may be nil for messages that need no
+// acknowledgement.
+func NewMessage(ctx context.Context, body []byte, acker Acknowledger) Message {
+	return Message{ctx: ctx, Body: body, acker: acker}
+}
+
+// Context returns the context carrying the message's receive span.
+func (m Message) Context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+
+	return m.ctx
+}
+
+// Ack confirms the message was handled and may be dropped by the broker.
+func (m Message) Ack() error {
+	if m.acker == nil {
+		return nil
+	}
+
+	return m.acker.Ack()
+}
+
+// Nack rejects the message, returning it to the queue if requeue is set.
+func (m Message) Nack(requeue bool) error {
+	if m.acker == nil {
+		return nil
+	}
+
+	return m.acker.Nack(requeue)
+}
@@ -0,0 +1,205 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	loggerslog "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger/slog"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newLogger(t *testing.T) *loggerslog.Logger {
+	t.Helper()
+	logg, err := loggerslog.New(io.Discard, "INFO")
+	require.NoError(t, err)
+
+	return logg
+}
+
+func newFileBroker(t *testing.T, dir string) *FileBroker {
+	t.Helper()
+	b := NewFileBroker(dir, newLogger(t))
+	b.poll = 5 * time.Millisecond
+	require.NoError(t, b.Start())
+	t.Cleanup(b.Stop)
+
+	return b
+}
+
+func brokers(t *testing.T) map[string]func(t *testing.T) Broker {
+	t.Helper()
+	return map[string]func(t *testing.T) Broker{
+		BrokerMemory: func(t *testing.T) Broker {
+			t.Helper()
+			b := NewMemoryBroker()
+			t.Cleanup(b.Stop)
+			return b
+		},
+		BrokerFile: func(t *testing.T) Broker {
+			t.Helper()
+			return newFileBroker(t, t.TempDir())
+		},
+	}
+}
+
+type payload struct {
+	N int
+}
+
+func receive(t *testing.T, msgs <-chan Message) (Message, payload) {
+	t.Helper()
+	select {
+	case m, ok := <-msgs:
+		require.True(t, ok, "channel closed")
+		var p payload
+		require.NoError(t, json.Unmarshal(m.Body, &p))
+		return m, p
+	case <-time.After(time.Second):
+		t.Fatal("no message received")
+		return Message{}, payload{}
+	}
+}
+
+func TestBrokers(t *testing.T) {
+	for name, newBroker := range brokers(t) {
+		t.Run(name+"/delivers in order", func(t *testing.T) {
+			b := newBroker(t)
+			pub, err := b.Publisher("q")
+			require.NoError(t, err)
+			sub, err := b.Subscriber("q")
+			require.NoError(t, err)
+			require.NoError(t, b.Check(context.Background()))
+
+			for i := 1; i <= 3; i++ {
+				require.NoError(t, pub.Publish(context.Background(), payload{N: i}))
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			msgs, err := sub.Consume(ctx)
+			require.NoError(t, err)
+
+			for i := 1; i <= 3; i++ {
+				m, p := receive(t, msgs)
+				require.Equal(t, i, p.N)
+				require.NoError(t, m.Ack())
+			}
+		})
+
+		t.Run(name+"/requeues nacked", func(t *testing.T) {
+			b := newBroker(t)
+			pub, err := b.Publisher("q")
+			require.NoError(t, err)
+			sub, err := b.Subscriber("q")
+			require.NoError(t, err)
+			require.NoError(t, pub.Publish(context.Background(), payload{N: 1}))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			msgs, err := sub.Consume(ctx)
+			require.NoError(t, err)
+
+			m, _ := receive(t, msgs)
+			require.NoError(t, m.Nack(true))
+			m, p := receive(t, msgs)
+			require.Equal(t, 1, p.N)
+			require.NoError(t, m.Nack(false))
+
+			require.NoError(t, pub.Publish(context.Background(), payload{N: 2}))
+			_, p = receive(t, msgs)
+			require.Equal(t, 2, p.N, "dropped message is not redelivered")
+		})
+
+		t.Run(name+"/closes on shutdown", func(t *testing.T) {
+			b := newBroker(t)
+			sub, err := b.Subscriber("q")
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			msgs, err := sub.Consume(ctx)
+			require.NoError(t, err)
+			cancel()
+
+			select {
+			case _, ok := <-msgs:
+				require.False(t, ok)
+			case <-time.After(time.Second):
+				t.Fatal("channel not closed")
+			}
+		})
+
+		t.Run(name+"/propagates trace", func(t *testing.T) {
+			provider := sdktrace.NewTracerProvider()
+			defer provider.Shutdown(context.Background())
+			otel.SetTextMapPropagator(propagation.TraceContext{})
+
+			b := newBroker(t)
+			pub, err := b.Publisher("q")
+			require.NoError(t, err)
+			sub, err := b.Subscriber("q")
+			require.NoError(t, err)
+
+			ctx, span := provider.Tracer("test").Start(context.Background(), "job")
+			defer span.End()
+			require.NoError(t, pub.Publish(ctx, payload{N: 1}))
+
+			consumeCtx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			msgs, err := sub.Consume(consumeCtx)
+			require.NoError(t, err)
+			m, _ := receive(t, msgs)
+			require.Equal(t, span.SpanContext().TraceID(), trace.SpanContextFromContext(m.Context()).TraceID())
+		})
+	}
+}
+
+func TestFileBrokerSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	first := newFileBroker(t, dir)
+	pub, err := first.Publisher("q")
+	require.NoError(t, err)
+	require.NoError(t, pub.Publish(context.Background(), payload{N: 1}))
+	require.NoError(t, pub.Publish(context.Background(), payload{N: 2}))
+
+	sub, err := first.Subscriber("q")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	msgs, err := sub.Consume(ctx)
+	require.NoError(t, err)
+	_, p := receive(t, msgs)
+	require.Equal(t, 1, p.N)
+	// Crash before settling the message.
+	cancel()
+	for range msgs {
+	}
+	first.Stop()
+
+	second := newFileBroker(t, dir)
+	sub, err = second.Subscriber("q")
+	require.NoError(t, err)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	msgs, err = sub.Consume(ctx)
+	require.NoError(t, err)
+
+	for i := 1; i <= 2; i++ {
+		m, p := receive(t, msgs)
+		require.Equal(t, i, p.N)
+		require.NoError(t, m.Ack())
+	}
+
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		entries, err := os.ReadDir(filepath.Join(dir, "q", sub))
+		require.NoError(t, err)
+		require.Empty(t, entries, sub)
+	}
+}
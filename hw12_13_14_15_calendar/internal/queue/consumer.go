@@ -1,33 +1,74 @@
 package queue
 
 import (
+	"context"
 	"fmt"
+	"sync"
 
 	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+const (
+	consumerTag = "sender"
+	// prefetch is how many unacknowledged messages the broker hands out at
+	// once. They are requeued if the consumer dies before acking them.
+	prefetch = 10
+)
+
+type deliveryAcker amqp.Delivery
+
+func (d deliveryAcker) Ack() error {
+	return amqp.Delivery(d).Ack(false)
+}
+
+func (d deliveryAcker) Nack(requeue bool) error {
+	return amqp.Delivery(d).Nack(false, requeue)
+}
+
 type Consumer struct {
-	queue string
-	conn  *amqp.Connection
-	ch    *amqp.Channel
+	name   string
+	queue  *Queue
+	logger Logger
+
+	mu sync.Mutex
+	ch *amqp.Channel
 }
 
-func NewConsumer(queue string, conn *amqp.Connection) Consumer {
-	return Consumer{
-		queue: queue,
-		conn:  conn,
+func NewConsumer(name string, queue *Queue) *Consumer {
+	return &Consumer{
+		name:   name,
+		queue:  queue,
+		logger: queue.logger,
 	}
 }
 
+// Start opens the channel once, so a broken broker fails fast.
 func (c *Consumer) Start() error {
-	var err error
-	c.ch, err = c.conn.Channel()
-	if err != nil {
-		return err
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err := c.channel(context.Background())
+	return err
+}
+
+// channel returns the open channel, reopening it and declaring the queue
+// again after a reconnect. c.mu must be held.
+func (c *Consumer) channel(ctx context.Context) (*amqp.Channel, error) {
+	if c.ch != nil && !c.ch.IsClosed() {
+		return c.ch, nil
 	}
 
-	_, err = c.ch.QueueDeclare(
-		c.queue,
+	conn, err := c.queue.Connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open channel: %w", err)
+	}
+	_, err = ch.QueueDeclare(
+		c.name,
 		true,
 		false,
 		false,
@@ -35,23 +76,40 @@ func (c *Consumer) Start() error {
 		nil,
 	)
 	if err != nil {
-		return fmt.Errorf("failed to declare queue: %w", err)
+		ch.Close()
+		return nil, fmt.Errorf("failed to declare queue: %w", err)
 	}
+	err = ch.Qos(prefetch, 0, false)
+	if err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to set prefetch: %w", err)
+	}
+	c.ch = ch
 
-	return nil
+	return ch, nil
 }
 
 func (c *Consumer) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if c.ch != nil {
 		c.ch.Close()
 	}
 }
 
-func (c Consumer) Consume() (<-chan []byte, error) {
-	msgs, err := c.ch.Consume(
-		c.queue,
-		"sender",
-		true,
+func (c *Consumer) deliveries(ctx context.Context) (<-chan amqp.Delivery, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch, err := c.channel(ctx)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := ch.Consume(
+		c.name,
+		consumerTag,
+		false,
 		false,
 		false,
 		false,
@@ -61,13 +119,81 @@ func (c Consumer) Consume() (<-chan []byte, error) {
 		return nil, fmt.Errorf("failed to consume: %w", err)
 	}
 
-	result := make(chan []byte)
+	return msgs, nil
+}
+
+// cancel stops new deliveries; the ones already prefetched are still handed
+// out before the delivery channel closes.
+func (c *Consumer) cancel() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ch != nil && !c.ch.IsClosed() {
+		if err := c.ch.Cancel(consumerTag, false); err != nil {
+			c.logger.Warn("failed to cancel consumer", "err", err)
+		}
+	}
+}
+
+// Consume delivers messages until ctx is done, resubscribing after lost
+// connections. On ctx cancellation the subscription is cancelled and the
+// messages already received are drained before the channel closes. Every
+// message must be acked or nacked.
+func (c *Consumer) Consume(ctx context.Context) (<-chan Message, error) {
+	msgs, err := c.deliveries(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(chan Message)
 	go func() {
 		defer close(result)
-		for m := range msgs {
-			result <- m.Body
+		for {
+			c.forward(ctx, msgs, result)
+			if ctx.Err() != nil {
+				return
+			}
+
+			c.logger.Warn("queue subscription lost, resubscribing", "queue", c.name)
+			for attempt := 0; ; attempt++ {
+				if !c.queue.backoff.Wait(ctx, c.queue.done, attempt) {
+					return
+				}
+				msgs, err = c.deliveries(ctx)
+				if err == nil {
+					break
+				}
+				c.logger.Warn("failed to resubscribe", "queue", c.name, "attempt", attempt+1, "err", err)
+			}
 		}
 	}()
 
 	return result, nil
 }
+
+func (c *Consumer) forward(ctx context.Context, msgs <-chan amqp.Delivery, result chan<- Message) {
+	done := ctx.Done()
+	for {
+		select {
+		case <-done:
+			done = nil
+			c.cancel()
+		case m, ok := <-msgs:
+			if !ok {
+				return
+			}
+			result <- c.receive(m)
+		}
+	}
+}
+
+func (c *Consumer) receive(m amqp.Delivery) Message {
+	if m.Headers == nil {
+		m.Headers = amqp.Table{}
+	}
+	ctx, span := startReceive("rabbitmq", c.name, headerCarrier(m.Headers), m.Body)
+	span.SetAttributes(attribute.Bool("messaging.rabbitmq.redelivered", m.Redelivered))
+	defer span.End()
+
+	return NewMessage(ctx, m.Body, deliveryAcker(m))
+}
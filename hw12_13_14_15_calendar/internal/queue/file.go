@@ -0,0 +1,333 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/tracing"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// filePollInterval is how often subscribers look for new messages.
+const filePollInterval = 200 * time.Millisecond
+
+// FileBroker keeps every queue as a directory, one file per message, so
+// separate processes on one machine can share it and messages survive
+// restarts. A message is written to tmp/ and renamed into new/; a consumer
+// claims it by renaming it into cur/ and deletes it on ack. Renames are
+// atomic, so no message is handed out twice while consumers are alive.
+//
+// Messages left in cur/ by a crashed consumer are returned to new/ when a
+// subscriber starts, so a queue directory should have a single consuming
+// process.
+type FileBroker struct {
+	dir    string
+	logger Logger
+	poll   time.Duration
+	seq    atomic.Uint64
+	done   chan struct{}
+	stop   sync.Once
+}
+
+var _ Broker = (*FileBroker)(nil)
+
+func NewFileBroker(dir string, logger Logger) *FileBroker {
+	return &FileBroker{
+		dir:    dir,
+		logger: logger,
+		poll:   filePollInterval,
+		done:   make(chan struct{}),
+	}
+}
+
+func (b *FileBroker) Start() error {
+	err := os.MkdirAll(b.dir, 0o750)
+	if err != nil {
+		return fmt.Errorf("failed to create queue directory: %w", err)
+	}
+
+	return nil
+}
+
+func (b *FileBroker) Stop() {
+	b.stop.Do(func() { close(b.done) })
+}
+
+func (b *FileBroker) Check(_ context.Context) error {
+	select {
+	case <-b.done:
+		return ErrStopped
+	default:
+	}
+
+	info, err := os.Stat(b.dir)
+	if err != nil {
+		return fmt.Errorf("queue directory: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("queue directory: %s is not a directory", b.dir)
+	}
+
+	return nil
+}
+
+func (b *FileBroker) queue(name string) (*fileQueue, error) {
+	q := &fileQueue{broker: b, name: name, dir: filepath.Join(b.dir, name)}
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		err := os.MkdirAll(filepath.Join(q.dir, sub), 0o750)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create queue %q: %w", name, err)
+		}
+	}
+
+	return q, nil
+}
+
+func (b *FileBroker) Publisher(name string) (Publisher, error) {
+	return b.queue(name)
+}
+
+func (b *FileBroker) Subscriber(name string) (Subscriber, error) {
+	q, err := b.queue(name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = q.recover()
+	if err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+type fileRecord struct {
+	Headers propagation.MapCarrier `json:"headers"`
+	Body    json.RawMessage        `json:"body"`
+}
+
+type fileQueue struct {
+	broker *FileBroker
+	name   string
+	dir    string
+}
+
+func (q *fileQueue) path(sub, file string) string {
+	return filepath.Join(q.dir, sub, file)
+}
+
+// nextName orders messages by publish time; pid and sequence keep names from
+// concurrent publishers unique.
+func (q *fileQueue) nextName() string {
+	return fmt.Sprintf("%019d-%d-%d.json", time.Now().UnixNano(), os.Getpid(), q.broker.seq.Add(1))
+}
+
+func (q *fileQueue) Publish(ctx context.Context, body interface{}) (err error) {
+	headers := propagation.MapCarrier{}
+	_, span := startPublish(ctx, BrokerFile, q.name, headers)
+	defer func() { tracing.End(span, err) }()
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed marshal body while publishing: %w", err)
+	}
+	record, err := json.Marshal(fileRecord{Headers: headers, Body: b})
+	if err != nil {
+		return fmt.Errorf("failed marshal message while publishing: %w", err)
+	}
+
+	name := q.nextName()
+	tmp := q.path("tmp", name)
+	err = writeFileSync(tmp, record)
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed publish: %w", err)
+	}
+	err = os.Rename(tmp, q.path("new", name))
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed publish: %w", err)
+	}
+	err = syncDir(filepath.Join(q.dir, "new"))
+	if err != nil {
+		return fmt.Errorf("failed publish: %w", err)
+	}
+
+	return nil
+}
+
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// recover returns messages claimed by a consumer that died before settling
+// them.
+func (q *fileQueue) recover() error {
+	entries, err := os.ReadDir(filepath.Join(q.dir, "cur"))
+	if err != nil {
+		return fmt.Errorf("failed to read queue %q: %w", q.name, err)
+	}
+	for _, e := range entries {
+		err = os.Rename(q.path("cur", e.Name()), q.path("new", e.Name()))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to requeue %s: %w", e.Name(), err)
+		}
+	}
+	if len(entries) > 0 {
+		q.broker.logger.Warn("requeued unacknowledged messages", "queue", q.name, "count", len(entries))
+	}
+
+	return nil
+}
+
+// pending lists waiting messages, oldest first.
+func (q *fileQueue) pending() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(q.dir, "new"))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// claim moves a message into cur/. It reports false if another consumer was
+// faster.
+func (q *fileQueue) claim(name string) (fileRecord, bool, error) {
+	err := os.Rename(q.path("new", name), q.path("cur", name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return fileRecord{}, false, nil
+	}
+	if err != nil {
+		return fileRecord{}, false, err
+	}
+
+	data, err := os.ReadFile(q.path("cur", name))
+	if err != nil {
+		return fileRecord{}, false, err
+	}
+	var record fileRecord
+	err = json.Unmarshal(data, &record)
+	if err != nil {
+		// Hand the raw bytes over; the consumer rejects what it can't parse.
+		return fileRecord{Headers: propagation.MapCarrier{}, Body: data}, true, nil
+	}
+	if record.Headers == nil {
+		record.Headers = propagation.MapCarrier{}
+	}
+
+	return record, true, nil
+}
+
+func (q *fileQueue) Consume(ctx context.Context) (<-chan Message, error) {
+	result := make(chan Message)
+	go func() {
+		defer close(result)
+		ticker := time.NewTicker(q.broker.poll)
+		defer ticker.Stop()
+
+		for {
+			q.deliver(ctx, result)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.broker.done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return result, nil
+}
+
+// deliver hands out every waiting message, stopping early on shutdown so
+// unclaimed messages stay in new/.
+func (q *fileQueue) deliver(ctx context.Context, result chan<- Message) {
+	names, err := q.pending()
+	if err != nil {
+		q.broker.logger.Error("failed to list queue", "queue", q.name, "err", err)
+		return
+	}
+
+	for _, name := range names {
+		if ctx.Err() != nil {
+			return
+		}
+		record, ok, err := q.claim(name)
+		if err != nil {
+			q.broker.logger.Error("failed to claim message", "queue", q.name, "message", name, "err", err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		msgCtx, span := startReceive(BrokerFile, q.name, record.Headers, record.Body)
+		span.End()
+		result <- NewMessage(msgCtx, record.Body, fileAcker{queue: q, name: name})
+	}
+}
+
+type fileAcker struct {
+	queue *fileQueue
+	name  string
+}
+
+func (a fileAcker) Ack() error {
+	err := os.Remove(a.queue.path("cur", a.name))
+	if err != nil {
+		return fmt.Errorf("failed to ack %s: %w", a.name, err)
+	}
+
+	return nil
+}
+
+func (a fileAcker) Nack(requeue bool) error {
+	if !requeue {
+		return a.Ack()
+	}
+
+	err := os.Rename(a.queue.path("cur", a.name), a.queue.path("new", a.name))
+	if err != nil {
+		return fmt.Errorf("failed to requeue %s: %w", a.name, err)
+	}
+
+	return nil
+}
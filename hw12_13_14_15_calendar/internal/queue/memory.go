@@ -0,0 +1,179 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/tracing"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// MemoryBroker passes messages between goroutines of one process. Nothing
+// survives a restart, so it is meant for tests and all-in-one local runs.
+type MemoryBroker struct {
+	mu     sync.Mutex
+	queues map[string]*memoryQueue
+	done   chan struct{}
+	stop   sync.Once
+}
+
+var _ Broker = (*MemoryBroker)(nil)
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		queues: make(map[string]*memoryQueue),
+		done:   make(chan struct{}),
+	}
+}
+
+func (b *MemoryBroker) Start() error {
+	return nil
+}
+
+func (b *MemoryBroker) Stop() {
+	b.stop.Do(func() { close(b.done) })
+}
+
+func (b *MemoryBroker) Check(_ context.Context) error {
+	select {
+	case <-b.done:
+		return ErrStopped
+	default:
+		return nil
+	}
+}
+
+func (b *MemoryBroker) queue(name string) *memoryQueue {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.queues[name]
+	if !ok {
+		q = &memoryQueue{name: name, ready: make(chan struct{}, 1), done: b.done}
+		b.queues[name] = q
+	}
+
+	return q
+}
+
+func (b *MemoryBroker) Publisher(name string) (Publisher, error) {
+	return b.queue(name), nil
+}
+
+func (b *MemoryBroker) Subscriber(name string) (Subscriber, error) {
+	return b.queue(name), nil
+}
+
+type memoryItem struct {
+	headers propagation.MapCarrier
+	body    []byte
+}
+
+type memoryQueue struct {
+	name  string
+	mu    sync.Mutex
+	items []memoryItem
+	ready chan struct{} // signalled when items grows
+	done  <-chan struct{}
+}
+
+func (q *memoryQueue) push(item memoryItem, front bool) {
+	q.mu.Lock()
+	if front {
+		q.items = append([]memoryItem{item}, q.items...)
+	} else {
+		q.items = append(q.items, item)
+	}
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *memoryQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (q *memoryQueue) pop(ctx context.Context) (memoryItem, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.items) > 0 {
+			item := q.items[0]
+			q.items = q.items[1:]
+			more := len(q.items) > 0
+			q.mu.Unlock()
+			if more {
+				// Pass the wake-up on to other consumers.
+				q.signal()
+			}
+			return item, true
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return memoryItem{}, false
+		case <-q.done:
+			return memoryItem{}, false
+		case <-q.ready:
+		}
+	}
+}
+
+func (q *memoryQueue) Publish(ctx context.Context, body interface{}) (err error) {
+	headers := propagation.MapCarrier{}
+	_, span := startPublish(ctx, BrokerMemory, q.name, headers)
+	defer func() { tracing.End(span, err) }()
+
+	select {
+	case <-q.done:
+		return ErrStopped
+	default:
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed marshal body while publishing: %w", err)
+	}
+	q.push(memoryItem{headers: headers, body: b}, false)
+
+	return nil
+}
+
+func (q *memoryQueue) Consume(ctx context.Context) (<-chan Message, error) {
+	result := make(chan Message)
+	go func() {
+		defer close(result)
+		for {
+			item, ok := q.pop(ctx)
+			if !ok {
+				return
+			}
+			msgCtx, span := startReceive(BrokerMemory, q.name, item.headers, item.body)
+			span.End()
+			result <- NewMessage(msgCtx, item.body, memoryAcker{queue: q, item: item})
+		}
+	}()
+
+	return result, nil
+}
+
+type memoryAcker struct {
+	queue *memoryQueue
+	item  memoryItem
+}
+
+func (a memoryAcker) Ack() error {
+	return nil
+}
+
+func (a memoryAcker) Nack(requeue bool) error {
+	if requeue {
+		a.queue.push(a.item, true)
+	}
+
+	return nil
+}
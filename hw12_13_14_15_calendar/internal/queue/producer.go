@@ -1,33 +1,69 @@
 package queue
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/tracing"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const (
+	// publishAttempts bounds how often Publish reopens the channel and
+	// retries before giving up on a message.
+	publishAttempts = 5
+	// confirmTimeout is how long a sent message may wait for the broker's
+	// confirm, even if the caller is shutting down.
+	confirmTimeout = 5 * time.Second
+)
+
+var ErrNacked = errors.New("broker rejected the message")
+
 type Producer struct {
-	name string
-	ch   *amqp.Channel
-	conn *amqp.Connection
+	name  string
+	queue *Queue
+
+	mu sync.Mutex
+	ch *amqp.Channel
 }
 
-func NewProducer(name string, conn *amqp.Connection) Producer {
-	return Producer{
-		name: name,
-		conn: conn,
+func NewProducer(name string, queue *Queue) *Producer {
+	return &Producer{
+		name:  name,
+		queue: queue,
 	}
 }
 
 func (p *Producer) Start() error {
-	var err error
-	p.ch, err = p.conn.Channel()
-	if err != nil {
-		return fmt.Errorf("failed to open channel: %w", err)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, err := p.channel(context.Background())
+	return err
+}
+
+// channel returns the open confirm-mode channel, reopening it and declaring
+// the queue again after a reconnect. p.mu must be held.
+func (p *Producer) channel(ctx context.Context) (*amqp.Channel, error) {
+	if p.ch != nil && !p.ch.IsClosed() {
+		return p.ch, nil
 	}
 
-	_, err = p.ch.QueueDeclare(
+	conn, err := p.queue.Connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open channel: %w", err)
+	}
+	_, err = ch.QueueDeclare(
 		p.name,
 		true,
 		false,
@@ -36,35 +72,81 @@ func (p *Producer) Start() error {
 		nil,
 	)
 	if err != nil {
-		return fmt.Errorf("failed to declare queue: %w", err)
+		ch.Close()
+		return nil, fmt.Errorf("failed to declare queue: %w", err)
 	}
+	err = ch.Confirm(false)
+	if err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+	p.ch = ch
 
-	return nil
+	return ch, nil
 }
 
 func (p *Producer) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	if p.ch != nil {
 		p.ch.Close()
 	}
 }
 
-func (p Producer) Publish(body interface{}) error {
+// Publish sends body and returns once the broker has confirmed it. Lost
+// connections and channels are reopened and the message is retried.
+func (p *Producer) Publish(ctx context.Context, body interface{}) (err error) {
+	headers := amqp.Table{}
+	ctx, span := startPublish(ctx, "rabbitmq", p.name, headerCarrier(headers))
+	defer func() { tracing.End(span, err) }()
+
 	b, err := json.Marshal(body)
 	if err != nil {
 		return fmt.Errorf("failed marshal body while publishing: %w", err)
 	}
-	err = p.ch.Publish(
-		"",
-		p.name,
-		false,
-		false,
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        b,
-		},
-	)
+	msg := amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Headers:      headers,
+		Body:         b,
+	}
+
+	for attempt := 0; ; attempt++ {
+		err = p.publish(ctx, msg)
+		if err == nil {
+			return nil
+		}
+		if attempt+1 == publishAttempts || !p.queue.backoff.Wait(ctx, p.queue.done, attempt) {
+			return fmt.Errorf("failed publish: %w", err)
+		}
+		span.AddEvent("retry", trace.WithAttributes(attribute.String("error", err.Error())))
+	}
+}
+
+func (p *Producer) publish(ctx context.Context, msg amqp.Publishing) error {
+	p.mu.Lock()
+	ch, err := p.channel(ctx)
+	if err != nil {
+		p.mu.Unlock()
+		return err
+	}
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, "", p.name, false, false, msg)
+	p.mu.Unlock()
 	if err != nil {
-		return fmt.Errorf("failed publish: %w", err)
+		return err
+	}
+
+	// Once sent, wait for the confirm even if ctx is cancelled by shutdown,
+	// so the caller learns the real outcome.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), confirmTimeout)
+	defer cancel()
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("waiting for confirm: %w", err)
+	}
+	if !acked {
+		return ErrNacked
 	}
 
 	return nil
@@ -1,40 +1,214 @@
 package queue
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sync"
 
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+var (
+	ErrNotConnected = errors.New("queue is not connected")
+	ErrStopped      = errors.New("queue is stopped")
+)
+
+type Logger interface {
+	logger.Logger
+}
+
+// Queue owns the broker connection and redials it with backoff whenever the
+// broker drops it. Producers and consumers ask it for a live connection each
+// time they (re)open a channel.
 type Queue struct {
 	name     string
 	password string
 	host     string
 	port     string
-	Conn     *amqp.Connection
+	logger   Logger
+	backoff  Backoff
+
+	mu        sync.Mutex
+	conn      *amqp.Connection
+	ready     chan struct{} // closed while conn is usable
+	done      chan struct{}
+	stop      sync.Once
+	producers []*Producer
+	consumers []*Consumer
 }
 
-func NewQueue(name, password, host, port string) Queue {
-	return Queue{
+var _ Broker = (*Queue)(nil)
+
+func NewQueue(name, password, host, port string, logger Logger) *Queue {
+	return &Queue{
 		name:     name,
 		password: password,
 		host:     host,
 		port:     port,
+		logger:   logger,
+		backoff:  DefaultBackoff,
+		ready:    make(chan struct{}),
+		done:     make(chan struct{}),
 	}
 }
 
+func (q *Queue) url() string {
+	return fmt.Sprintf("amqp://%s:%s@%s:%s/", q.name, q.password, q.host, q.port)
+}
+
+// Start dials the broker once, so a wrong address still fails fast, and then
+// keeps the connection alive in the background until Stop.
 func (q *Queue) Start() error {
-	var err error
-	q.Conn, err = amqp.Dial(fmt.Sprintf("amqp://%s:%s@%s:%s/", q.name, q.password, q.host, q.port))
+	conn, err := amqp.Dial(q.url())
 	if err != nil {
 		return fmt.Errorf("failed connect queue: %w", err)
 	}
+	// Register before the connection is shared, so no close is missed.
+	closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	q.setConn(conn)
+
+	go q.watch(closed)
 
 	return nil
 }
 
+func (q *Queue) setConn(conn *amqp.Connection) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.conn = conn
+	close(q.ready)
+}
+
+// watch redials whenever the connection reports closed on closed, until
+// Stop. Each connection is watched through the channel registered right
+// after dialing it.
+func (q *Queue) watch(closed chan *amqp.Error) {
+	for {
+		select {
+		case <-q.done:
+			return
+		case amqpErr := <-closed:
+			select {
+			case <-q.done:
+				// Closed by Stop.
+				return
+			default:
+			}
+			q.logger.Warn("queue connection lost", "err", amqpErr)
+		}
+
+		q.mu.Lock()
+		q.ready = make(chan struct{})
+		q.mu.Unlock()
+
+		conn := q.redial()
+		if conn == nil {
+			return
+		}
+		closed = conn.NotifyClose(make(chan *amqp.Error, 1))
+		q.setConn(conn)
+		q.logger.Info("queue connection restored")
+	}
+}
+
+func (q *Queue) redial() *amqp.Connection {
+	for attempt := 0; ; attempt++ {
+		if !q.backoff.Wait(context.Background(), q.done, attempt) {
+			return nil
+		}
+
+		conn, err := amqp.Dial(q.url())
+		if err == nil {
+			return conn
+		}
+		q.logger.Warn("failed to reconnect queue", "attempt", attempt+1, "err", err)
+	}
+}
+
+// Connection returns an open connection, waiting for a reconnect if the
+// current one is down.
+func (q *Queue) Connection(ctx context.Context) (*amqp.Connection, error) {
+	for {
+		q.mu.Lock()
+		conn, ready := q.conn, q.ready
+		q.mu.Unlock()
+
+		select {
+		case <-q.done:
+			return nil, ErrStopped
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for queue connection: %w", ctx.Err())
+		case <-ready:
+			if !conn.IsClosed() {
+				return conn, nil
+			}
+			// The watcher has not noticed the drop yet.
+			if !q.backoff.Wait(ctx, q.done, 0) {
+				return nil, fmt.Errorf("waiting for queue connection: %w", ErrNotConnected)
+			}
+		}
+	}
+}
+
+// Check reports whether the broker connection is open.
+func (q *Queue) Check(_ context.Context) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.conn == nil || q.conn.IsClosed() {
+		return ErrNotConnected
+	}
+
+	return nil
+}
+
+// Publisher starts a producer for the named queue; Stop closes it.
+func (q *Queue) Publisher(name string) (Publisher, error) {
+	p := NewProducer(name, q)
+	if err := p.Start(); err != nil {
+		return nil, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.producers = append(q.producers, p)
+
+	return p, nil
+}
+
+// Subscriber starts a consumer for the named queue; Stop closes it.
+func (q *Queue) Subscriber(name string) (Subscriber, error) {
+	c := NewConsumer(name, q)
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.consumers = append(q.consumers, c)
+
+	return c, nil
+}
+
 func (q *Queue) Stop() {
-	if q.Conn != nil {
-		q.Conn.Close()
+	q.stop.Do(func() { close(q.done) })
+
+	q.mu.Lock()
+	producers, consumers := q.producers, q.consumers
+	q.mu.Unlock()
+	for _, p := range producers {
+		p.Stop()
+	}
+	for _, c := range consumers {
+		c.Stop()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.conn != nil {
+		q.conn.Close()
 	}
 }
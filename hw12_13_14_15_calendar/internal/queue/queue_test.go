@@ -0,0 +1,21 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectionWaitsForStop(t *testing.T) {
+	q := NewQueue("guest", "guest", "localhost", "5672", nil)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		q.Stop()
+	}()
+
+	_, err := q.Connection(context.Background())
+	require.ErrorIs(t, err, ErrStopped)
+	require.ErrorIs(t, q.Check(context.Background()), ErrNotConnected)
+}
@@ -0,0 +1,71 @@
+package queue
+
+import (
+	"context"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = tracing.Tracer("queue")
+
+// headerCarrier adapts AMQP message headers to the OpenTelemetry propagator,
+// so trace context travels from the scheduler to the sender.
+type headerCarrier amqp.Table
+
+func (c headerCarrier) Get(key string) string {
+	v, _ := c[key].(string)
+	return v
+}
+
+func (c headerCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
+// startPublish starts a producer span and injects its context into carrier.
+func startPublish(
+	ctx context.Context,
+	system, name string,
+	carrier propagation.TextMapCarrier,
+) (context.Context, trace.Span) {
+	ctx, span := tracer.Start(ctx, name+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", system),
+			attribute.String("messaging.destination.name", name),
+		),
+	)
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+
+	return ctx, span
+}
+
+// startReceive starts a consumer span continuing the trace found in carrier.
+func startReceive(
+	system, name string,
+	carrier propagation.TextMapCarrier,
+	body []byte,
+) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), carrier)
+	return tracer.Start(ctx, name+" receive",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", system),
+			attribute.String("messaging.destination.name", name),
+			attribute.Int("messaging.message.body.size", len(body)),
+		),
+	)
+}
@@ -0,0 +1,37 @@
+package queue
+
+import (
+	"context"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestHeaderCarrierPropagatesTrace(t *testing.T) {
+	provider := sdktrace.NewTracerProvider()
+	defer provider.Shutdown(context.Background())
+	propagator := propagation.TraceContext{}
+
+	ctx, span := provider.Tracer("test").Start(context.Background(), "publish")
+	defer span.End()
+
+	headers := amqp.Table{}
+	propagator.Inject(ctx, headerCarrier(headers))
+	require.Contains(t, headers, "traceparent")
+
+	received := propagator.Extract(context.Background(), headerCarrier(headers))
+	remote := trace.SpanContextFromContext(received)
+	require.True(t, remote.IsRemote())
+	require.Equal(t, span.SpanContext().TraceID(), remote.TraceID())
+	require.Equal(t, span.SpanContext().SpanID(), remote.SpanID())
+}
+
+func TestHeaderCarrierIgnoresNonStringValues(t *testing.T) {
+	headers := headerCarrier(amqp.Table{"traceparent": int32(1)})
+	require.Empty(t, headers.Get("traceparent"))
+	require.Empty(t, headers.Get("missing"))
+}
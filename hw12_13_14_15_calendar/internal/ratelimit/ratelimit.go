@@ -0,0 +1,147 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/auth"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/clock"
+	"golang.org/x/time/rate"
+)
+
+// idleTTL is how long a client's bucket is kept after its last request. A
+// bucket left idle that long has refilled anyway.
+const idleTTL = 10 * time.Minute
+
+// Rule is a token bucket: Burst requests at once, refilled at Rate per second.
+type Rule struct {
+	// Rate of zero or less disables limiting.
+	Rate  float64
+	Burst int
+}
+
+type Config struct {
+	Default Rule
+	// Routes overrides Default per route: an HTTP pattern such as
+	// "POST /event/create" or a gRPC method such as
+	// "/event.Calendar/CreateEvent".
+	Routes map[string]Rule
+}
+
+// Limiter keeps one token bucket per route and client.
+type Limiter struct {
+	config Config
+	clock  clock.Clock
+
+	mu        sync.Mutex
+	buckets   map[bucketKey]*bucket
+	lastSweep time.Time
+}
+
+type bucketKey struct {
+	route  string
+	client string
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func New(config Config, clock clock.Clock) *Limiter {
+	return &Limiter{
+		config:    config,
+		clock:     clock,
+		buckets:   make(map[bucketKey]*bucket),
+		lastSweep: clock.Now(),
+	}
+}
+
+// SetConfig replaces the rules. Existing buckets keep their tokens and
+// continue at the new rate and burst.
+func (l *Limiter) SetConfig(config Config) {
+	now := l.clock.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.config = config
+	for key, b := range l.buckets {
+		rule := l.rule(key.route)
+		if rule.Rate <= 0 {
+			delete(l.buckets, key)
+			continue
+		}
+		b.limiter.SetLimitAt(now, rate.Limit(rule.Rate))
+		b.limiter.SetBurstAt(now, max(rule.Burst, 1))
+	}
+}
+
+// rule looks up the rule for route. l.mu must be held.
+func (l *Limiter) rule(route string) Rule {
+	if rule, ok := l.config.Routes[route]; ok {
+		return rule
+	}
+
+	return l.config.Default
+}
+
+// Allow takes a token for client on route. When the bucket is empty it
+// returns false and how long until the next token.
+func (l *Limiter) Allow(route, client string) (bool, time.Duration) {
+	now := l.clock.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rule := l.rule(route)
+	if rule.Rate <= 0 {
+		return true, 0
+	}
+
+	l.sweep(now)
+	key := bucketKey{route: route, client: client}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(rule.Rate), max(rule.Burst, 1))}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	r := b.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return false, delay
+	}
+
+	return true, 0
+}
+
+// sweep drops idle buckets at most once per idleTTL. l.mu must be held.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < idleTTL {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) >= idleTTL {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// ClientKey identifies the caller for limiting: the authenticated user if
+// there is one, otherwise the remote IP.
+func ClientKey(ctx context.Context, remoteAddr string) string {
+	if userID, ok := auth.UserFromContext(ctx); ok {
+		return "user:" + userID
+	}
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	return "ip:" + host
+}
@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/auth"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiterBurstAndRefill(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, time.October, 1, 0, 0, 0, 0, time.UTC))
+	l := New(Config{Default: Rule{Rate: 1, Burst: 3}}, clk)
+
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("GET /event/{id}", "ip:10.0.0.1")
+		require.True(t, ok, "request %d", i)
+	}
+
+	ok, retryAfter := l.Allow("GET /event/{id}", "ip:10.0.0.1")
+	require.False(t, ok)
+	require.Equal(t, time.Second, retryAfter)
+
+	clk.Advance(time.Second)
+	ok, _ = l.Allow("GET /event/{id}", "ip:10.0.0.1")
+	require.True(t, ok)
+	ok, _ = l.Allow("GET /event/{id}", "ip:10.0.0.1")
+	require.False(t, ok)
+}
+
+func TestLimiterSeparatesRoutesAndClients(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, time.October, 1, 0, 0, 0, 0, time.UTC))
+	l := New(Config{
+		Default: Rule{Rate: 1, Burst: 1},
+		Routes: map[string]Rule{
+			"POST /event/create": {Rate: 1, Burst: 2},
+			"GET /healthz":       {},
+		},
+	}, clk)
+
+	ok, _ := l.Allow("GET /event/{id}", "ip:10.0.0.1")
+	require.True(t, ok)
+	ok, _ = l.Allow("GET /event/{id}", "ip:10.0.0.1")
+	require.False(t, ok)
+
+	ok, _ = l.Allow("GET /event/{id}", "ip:10.0.0.2")
+	require.True(t, ok, "other client has its own bucket")
+
+	for i := 0; i < 2; i++ {
+		ok, _ = l.Allow("POST /event/create", "ip:10.0.0.1")
+		require.True(t, ok, "route override burst, request %d", i)
+	}
+	ok, _ = l.Allow("POST /event/create", "ip:10.0.0.1")
+	require.False(t, ok)
+
+	for i := 0; i < 10; i++ {
+		ok, _ = l.Allow("GET /healthz", "ip:10.0.0.1")
+		require.True(t, ok, "zero rate is unlimited")
+	}
+}
+
+func TestLimiterDropsIdleBuckets(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, time.October, 1, 0, 0, 0, 0, time.UTC))
+	l := New(Config{Default: Rule{Rate: 1, Burst: 1}}, clk)
+
+	l.Allow("GET /event/{id}", "ip:10.0.0.1")
+	require.Len(t, l.buckets, 1)
+
+	clk.Advance(idleTTL)
+	l.Allow("GET /event/{id}", "ip:10.0.0.2")
+	require.Len(t, l.buckets, 1)
+}
+
+func TestLimiterSetConfig(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, time.October, 1, 0, 0, 0, 0, time.UTC))
+	l := New(Config{Default: Rule{Rate: 1, Burst: 1}}, clk)
+
+	ok, _ := l.Allow("GET /event/{id}", "ip:10.0.0.1")
+	require.True(t, ok)
+	ok, _ = l.Allow("GET /event/{id}", "ip:10.0.0.1")
+	require.False(t, ok)
+
+	l.SetConfig(Config{Default: Rule{Rate: 10, Burst: 1}})
+	ok, retryAfter := l.Allow("GET /event/{id}", "ip:10.0.0.1")
+	require.False(t, ok, "the bucket keeps its tokens")
+	require.Equal(t, 100*time.Millisecond, retryAfter)
+
+	clk.Advance(100 * time.Millisecond)
+	ok, _ = l.Allow("GET /event/{id}", "ip:10.0.0.1")
+	require.True(t, ok)
+
+	l.SetConfig(Config{Default: Rule{Rate: 10, Burst: 1}, Routes: map[string]Rule{"GET /event/{id}": {}}})
+	for i := 0; i < 3; i++ {
+		ok, _ = l.Allow("GET /event/{id}", "ip:10.0.0.1")
+		require.True(t, ok, "limiting is off for the route")
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	require.Equal(t, "ip:10.0.0.1", ClientKey(context.Background(), "10.0.0.1:5432"))
+	require.Equal(t, "ip:bufconn", ClientKey(context.Background(), "bufconn"))
+
+	ctx := auth.WithUser(context.Background(), "user-1")
+	require.Equal(t, "user:user-1", ClientKey(ctx, "10.0.0.1:5432"))
+}
@@ -0,0 +1,52 @@
+// Package requestid carries the ID that correlates the log lines of one
+// request across the HTTP and gRPC servers, the app and the storage.
+package requestid
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// Header is the HTTP header a client may set the ID with; responses
+	// always carry it.
+	Header = "X-Request-ID"
+	// MetadataKey is the gRPC metadata equivalent of Header.
+	MetadataKey = "x-request-id"
+)
+
+// maxLength bounds the IDs accepted from clients.
+const maxLength = 128
+
+type idKey struct{}
+
+// WithID returns a copy of ctx carrying id.
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, idKey{}, id)
+}
+
+// FromContext returns the ID of the request ctx belongs to, if any.
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(idKey{}).(string)
+	return id, ok && id != ""
+}
+
+// Accept returns the ID a client sent if it is safe to log, that is up to
+// 128 printable ASCII characters without spaces, and a new one otherwise.
+func Accept(id string) string {
+	if id == "" || len(id) > maxLength {
+		return New()
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return New()
+		}
+	}
+
+	return id
+}
+
+func New() string {
+	return uuid.NewString()
+}
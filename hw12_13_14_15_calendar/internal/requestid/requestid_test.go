@@ -0,0 +1,32 @@
+package requestid
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccept(t *testing.T) {
+	for _, id := range []string{"abc-123", "f81d4fae-7dec-11d0-a765-00a0c91e6bf6", strings.Repeat("a", 128)} {
+		require.Equal(t, id, Accept(id))
+	}
+
+	for _, id := range []string{"", "with space", "line\nbreak", "ünicode", strings.Repeat("a", 129)} {
+		generated := Accept(id)
+		require.NotEqual(t, id, generated, id)
+		_, err := uuid.Parse(generated)
+		require.NoError(t, err)
+	}
+}
+
+func TestContext(t *testing.T) {
+	_, ok := FromContext(context.Background())
+	require.False(t, ok)
+
+	id, ok := FromContext(WithID(context.Background(), "abc"))
+	require.True(t, ok)
+	require.Equal(t, "abc", id)
+}
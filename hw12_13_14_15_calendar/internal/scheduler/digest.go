@@ -0,0 +1,120 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/metrics"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+)
+
+type Digest struct {
+	Type        string
+	UserID      string
+	Kind        storage.DigestKind
+	Timezone    string
+	PeriodStart time.Time
+	Events      []DigestEvent
+}
+
+type DigestEvent struct {
+	ID    string
+	Title string
+	Date  time.Time
+}
+
+func (s *Scheduler) digestJob(ctx context.Context) error {
+	subscriptions, err := s.storage.GetDigestSubscriptions(ctx)
+	if err != nil {
+		return fmt.Errorf("failed get digest subscriptions: %w", err)
+	}
+
+	now := s.clock.Now()
+	var failed int
+	for _, subscription := range subscriptions {
+		err = s.sendDigest(ctx, subscription, now)
+		if err != nil {
+			s.logger.Warn("failed to send digest",
+				"userID", subscription.UserID, "kind", subscription.Kind, "err", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to send %d of %d digests", failed, len(subscriptions))
+	}
+
+	return nil
+}
+
+func (s *Scheduler) sendDigest(ctx context.Context, subscription storage.DigestSubscription, now time.Time) error {
+	loc, err := time.LoadLocation(subscription.Timezone)
+	if err != nil {
+		return fmt.Errorf("wrong timezone %q: %w", subscription.Timezone, err)
+	}
+
+	local := now.In(loc)
+	periodStart := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	var periodEnd time.Time
+	switch subscription.Kind {
+	case storage.DigestDaily:
+		periodEnd = periodStart.AddDate(0, 0, 1)
+	case storage.DigestWeekly:
+		daysSinceMonday := (int(local.Weekday()) + 6) % 7
+		periodStart = periodStart.AddDate(0, 0, -daysSinceMonday)
+		periodEnd = periodStart.AddDate(0, 0, 7)
+	default:
+		return fmt.Errorf("unknown digest kind %q", subscription.Kind)
+	}
+	if local.Before(periodStart.Add(subscription.SendAt)) {
+		return nil
+	}
+
+	// The period runs from midnight to midnight in the user's time zone,
+	// which need not be a day of the database.
+	events, err := s.storage.GetUserEvents(ctx, subscription.UserID, periodStart, periodEnd)
+	if err != nil {
+		return fmt.Errorf("failed get events: %w", err)
+	}
+
+	// The claim expires with its lease if this replica dies before the
+	// digest is published, and is dropped right away if publishing fails.
+	claimed, err := s.storage.ClaimDigest(ctx, subscription.UserID, subscription.Kind, periodStart,
+		s.currentConfig().Lease)
+	if err != nil {
+		return fmt.Errorf("failed claim digest: %w", err)
+	}
+	if !claimed {
+		return nil
+	}
+
+	digest := Digest{
+		Type:        MessageDigest,
+		UserID:      subscription.UserID,
+		Kind:        subscription.Kind,
+		Timezone:    subscription.Timezone,
+		PeriodStart: periodStart,
+		Events:      []DigestEvent{},
+	}
+	for _, event := range events {
+		digest.Events = append(digest.Events, DigestEvent{ID: event.ID, Title: event.Title, Date: event.Date})
+	}
+	err = s.queue.Publish(ctx, digest)
+	// Settle the claim even if ctx was cancelled meanwhile.
+	settleCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), markTimeout)
+	defer cancel()
+	if err != nil {
+		metrics.SchedulerPublishFailures.WithLabelValues(MessageDigest).Inc()
+		if releaseErr := s.storage.ReleaseDigest(settleCtx, digest.UserID, digest.Kind, periodStart); releaseErr != nil {
+			s.logger.Warn("failed to release digest", "userID", digest.UserID, "kind", digest.Kind, "err", releaseErr)
+		}
+		return fmt.Errorf("failed to publish digest: %w", err)
+	}
+	s.logger.Info("digest published", "userID", digest.UserID, "kind", digest.Kind, "events", len(digest.Events))
+	err = s.storage.MarkDigestPublished(settleCtx, digest.UserID, digest.Kind, periodStart)
+	if err != nil {
+		s.logger.Warn("failed to mark digest published", "userID", digest.UserID, "kind", digest.Kind, "err", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/clock"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+	memorystorage "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage/memory"
+	"github.com/stretchr/testify/require"
+)
+
+const otherUserID = "fd3195e5-17a9-4b61-8d9d-0d1bbb4edf93"
+
+func createUserEvent(t *testing.T, store *memorystorage.Storage, id, user string, date time.Time) {
+	t.Helper()
+	err := store.CreateEvent(context.TODO(), storage.Event{
+		ID:      id,
+		Title:   "event " + id,
+		Date:    date,
+		EndDate: date.Add(time.Hour),
+		UserID:  user,
+	})
+	require.NoError(t, err)
+}
+
+func TestDailyDigest(t *testing.T) {
+	// 04:50 UTC is 07:50 in Moscow.
+	start := time.Date(2024, time.October, 10, 4, 50, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+	store := memorystorage.New(fake)
+	require.NoError(t, store.SetDigestSubscription(context.TODO(), storage.DigestSubscription{
+		UserID:   userID,
+		Kind:     storage.DigestDaily,
+		Timezone: "Europe/Moscow",
+		SendAt:   8 * time.Hour,
+	}))
+	createUserEvent(t, store, "late", userID, time.Date(2024, time.October, 10, 15, 0, 0, 0, time.UTC))
+	createUserEvent(t, store, "early", userID, time.Date(2024, time.October, 10, 9, 0, 0, 0, time.UTC))
+	createUserEvent(t, store, "other user", otherUserID, time.Date(2024, time.October, 10, 9, 0, 0, 0, time.UTC))
+	createUserEvent(t, store, "tomorrow", userID, time.Date(2024, time.October, 11, 9, 0, 0, 0, time.UTC))
+
+	q := &fakeQueue{}
+	s := newScheduler(t, "worker", q, store, fake)
+
+	var sentAt time.Time
+	for range 30 {
+		require.NoError(t, s.digestJob(context.TODO()))
+		if sentAt.IsZero() && len(q.Digests()) > 0 {
+			sentAt = fake.Now()
+		}
+		fake.Advance(time.Minute)
+	}
+
+	require.Equal(t, time.Date(2024, time.October, 10, 5, 0, 0, 0, time.UTC), sentAt)
+	require.Len(t, q.Digests(), 1, "digest is sent once per day")
+
+	digest := q.Digests()[0]
+	require.Equal(t, MessageDigest, digest.Type)
+	require.Equal(t, userID, digest.UserID)
+	require.Equal(t, storage.DigestDaily, digest.Kind)
+	require.Equal(t, time.Date(2024, time.October, 10, 0, 0, 0, 0, time.FixedZone("MSK", 3*60*60)).Unix(),
+		digest.PeriodStart.Unix())
+	require.Len(t, digest.Events, 2)
+	require.Equal(t, "early", digest.Events[0].ID)
+	require.Equal(t, "late", digest.Events[1].ID)
+
+	fake.Advance(24 * time.Hour)
+	require.NoError(t, s.digestJob(context.TODO()))
+	require.Len(t, q.Digests(), 2, "next day gets its own digest")
+	require.Equal(t, "tomorrow", q.Digests()[1].Events[0].ID)
+}
+
+func TestWeeklyDigest(t *testing.T) {
+	// 2024-10-16 is a Wednesday.
+	now := time.Date(2024, time.October, 16, 12, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(now)
+	store := memorystorage.New(fake)
+	require.NoError(t, store.SetDigestSubscription(context.TODO(), storage.DigestSubscription{
+		UserID:   userID,
+		Kind:     storage.DigestWeekly,
+		Timezone: "UTC",
+		SendAt:   9 * time.Hour,
+	}))
+	createUserEvent(t, store, "monday", userID, time.Date(2024, time.October, 14, 10, 0, 0, 0, time.UTC))
+	createUserEvent(t, store, "sunday", userID, time.Date(2024, time.October, 20, 10, 0, 0, 0, time.UTC))
+	createUserEvent(t, store, "next week", userID, time.Date(2024, time.October, 21, 10, 0, 0, 0, time.UTC))
+
+	q := &fakeQueue{}
+	s := newScheduler(t, "worker", q, store, fake)
+
+	require.NoError(t, s.digestJob(context.TODO()))
+	require.NoError(t, s.digestJob(context.TODO()))
+
+	require.Len(t, q.Digests(), 1)
+	digest := q.Digests()[0]
+	require.Equal(t, time.Date(2024, time.October, 14, 0, 0, 0, 0, time.UTC), digest.PeriodStart)
+	require.Len(t, digest.Events, 2)
+	require.Equal(t, "monday", digest.Events[0].ID)
+	require.Equal(t, "sunday", digest.Events[1].ID)
+}
+
+// TestDigestDayBoundary checks that the day of a user ahead of UTC runs
+// from their midnight to the next, not along the UTC date.
+func TestDigestDayBoundary(t *testing.T) {
+	brisbane, err := time.LoadLocation("Australia/Brisbane") // UTC+10 all year
+	require.NoError(t, err)
+	fake := clock.NewFake(time.Date(2024, time.October, 10, 8, 0, 0, 0, brisbane))
+	store := memorystorage.New(fake)
+	require.NoError(t, store.SetDigestSubscription(context.TODO(), storage.DigestSubscription{
+		UserID:   userID,
+		Kind:     storage.DigestDaily,
+		Timezone: "Australia/Brisbane",
+		SendAt:   8 * time.Hour,
+	}))
+	// The first two fall on October 9 in UTC, the last two on October 10.
+	createUserEvent(t, store, "yesterday", userID, time.Date(2024, time.October, 9, 23, 30, 0, 0, brisbane))
+	createUserEvent(t, store, "after midnight", userID, time.Date(2024, time.October, 10, 0, 30, 0, 0, brisbane))
+	createUserEvent(t, store, "before midnight", userID, time.Date(2024, time.October, 10, 23, 30, 0, 0, brisbane))
+	createUserEvent(t, store, "tomorrow", userID, time.Date(2024, time.October, 11, 0, 30, 0, 0, brisbane))
+
+	q := &fakeQueue{}
+	s := newScheduler(t, "worker", q, store, fake)
+	require.NoError(t, s.digestJob(context.TODO()))
+
+	require.Len(t, q.Digests(), 1)
+	digest := q.Digests()[0]
+	require.True(t, digest.PeriodStart.Equal(time.Date(2024, time.October, 10, 0, 0, 0, 0, brisbane)))
+	require.Len(t, digest.Events, 2)
+	require.Equal(t, "after midnight", digest.Events[0].ID)
+	require.Equal(t, "before midnight", digest.Events[1].ID)
+}
+
+func TestDigestWrongTimezone(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, time.October, 16, 12, 0, 0, 0, time.UTC))
+	store := memorystorage.New(fake)
+	require.NoError(t, store.SetDigestSubscription(context.TODO(), storage.DigestSubscription{
+		UserID:   userID,
+		Kind:     storage.DigestDaily,
+		Timezone: "Mars/Olympus",
+	}))
+
+	q := &fakeQueue{}
+	s := newScheduler(t, "worker", q, store, fake)
+
+	require.ErrorContains(t, s.digestJob(context.TODO()), "failed to send 1 of 1 digests")
+	require.Empty(t, q.Digests())
+}
+
+// failingQueue fails to publish until it is told otherwise.
+type failingQueue struct {
+	fakeQueue
+	failing bool
+}
+
+func (q *failingQueue) Publish(ctx context.Context, body interface{}) error {
+	if q.failing {
+		return errors.New("broker is down")
+	}
+
+	return q.fakeQueue.Publish(ctx, body)
+}
+
+func TestDigestRetriedAfterPublishFailure(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, time.October, 10, 9, 0, 0, 0, time.UTC))
+	store := memorystorage.New(fake)
+	require.NoError(t, store.SetDigestSubscription(context.TODO(), storage.DigestSubscription{
+		UserID:   userID,
+		Kind:     storage.DigestDaily,
+		Timezone: "UTC",
+		SendAt:   8 * time.Hour,
+	}))
+
+	q := &failingQueue{failing: true}
+	s := newScheduler(t, "worker", q, store, fake)
+	require.ErrorContains(t, s.digestJob(context.TODO()), "failed to send 1 of 1 digests")
+	require.Empty(t, q.Digests())
+
+	q.failing = false
+	require.NoError(t, s.digestJob(context.TODO()))
+	require.Len(t, q.Digests(), 1, "released claim is retried on the next run")
+
+	require.NoError(t, s.digestJob(context.TODO()))
+	fake.Advance(2 * time.Minute)
+	require.NoError(t, s.digestJob(context.TODO()))
+	require.Len(t, q.Digests(), 1, "published digest is not sent again")
+}
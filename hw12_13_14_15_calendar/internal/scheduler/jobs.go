@@ -0,0 +1,248 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/clock"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/tracing"
+	"github.com/robfig/cron/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = tracing.Tracer("scheduler")
+
+// jobsResolution is how often due jobs are checked. Cron expressions are
+// minute based, "@every" descriptors may go down to seconds.
+const jobsResolution = time.Second
+
+var cronParser = cron.NewParser(
+	cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor,
+)
+
+type JobConfig struct {
+	// Schedule is a cron expression ("0 3 * * *") or a descriptor
+	// ("@every 10s", "@daily").
+	Schedule string
+	// Jitter delays each run by a random duration in [0, Jitter).
+	Jitter time.Duration
+	// Timeout cancels the run's context, zero means no timeout.
+	Timeout time.Duration
+}
+
+type JobStatus struct {
+	Running      bool
+	LastStart    time.Time
+	LastDuration time.Duration
+	LastError    string
+	Runs         int
+	// Skipped counts runs dropped because the previous one was still going.
+	Skipped int
+	Next    time.Time
+}
+
+type job struct {
+	name     string
+	config   JobConfig
+	schedule cron.Schedule
+	run      func(context.Context) error
+
+	mu     sync.Mutex
+	status JobStatus
+}
+
+type Jobs struct {
+	clock  clock.Clock
+	logger Logger
+	jitter func(time.Duration) time.Duration
+	wg     sync.WaitGroup
+
+	mu   sync.RWMutex
+	jobs []*job
+}
+
+func NewJobs(clock clock.Clock, logger Logger) *Jobs {
+	return &Jobs{
+		clock:  clock,
+		logger: logger,
+		jitter: randomJitter,
+	}
+}
+
+func randomJitter(limit time.Duration) time.Duration {
+	if limit <= 0 {
+		return 0
+	}
+
+	return rand.N(limit)
+}
+
+func (j *Jobs) Register(name string, config JobConfig, run func(context.Context) error) error {
+	schedule, err := cronParser.Parse(config.Schedule)
+	if err != nil {
+		return fmt.Errorf("job %q: wrong schedule %q: %w", name, config.Schedule, err)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, registered := range j.jobs {
+		if registered.name == name {
+			return fmt.Errorf("job %q: already registered", name)
+		}
+	}
+
+	j.jobs = append(j.jobs, &job{
+		name:     name,
+		config:   config,
+		schedule: schedule,
+		run:      run,
+	})
+
+	return nil
+}
+
+// Reschedule changes the schedule, jitter and timeout of registered jobs,
+// all or none of them. Runs in progress keep their timeout.
+func (j *Jobs) Reschedule(configs map[string]JobConfig) error {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	schedules := make(map[*job]cron.Schedule, len(configs))
+	for name, config := range configs {
+		schedule, err := cronParser.Parse(config.Schedule)
+		if err != nil {
+			return fmt.Errorf("job %q: wrong schedule %q: %w", name, config.Schedule, err)
+		}
+		idx := slices.IndexFunc(j.jobs, func(job *job) bool { return job.name == name })
+		if idx < 0 {
+			return fmt.Errorf("job %q: not registered", name)
+		}
+		schedules[j.jobs[idx]] = schedule
+	}
+
+	now := j.clock.Now()
+	for job, schedule := range schedules {
+		job.mu.Lock()
+		job.config = configs[job.name]
+		job.schedule = schedule
+		if !job.status.Next.IsZero() {
+			job.status.Next = j.next(job, now)
+		}
+		job.mu.Unlock()
+	}
+
+	return nil
+}
+
+func (j *Jobs) Status() map[string]JobStatus {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	result := make(map[string]JobStatus, len(j.jobs))
+	for _, job := range j.jobs {
+		job.mu.Lock()
+		result[job.name] = job.status
+		job.mu.Unlock()
+	}
+
+	return result
+}
+
+// Run fires jobs until ctx is done and then waits for running jobs to return.
+func (j *Jobs) Run(ctx context.Context) {
+	now := j.clock.Now()
+	j.mu.RLock()
+	for _, job := range j.jobs {
+		job.mu.Lock()
+		job.status.Next = j.next(job, now)
+		job.mu.Unlock()
+	}
+	j.mu.RUnlock()
+
+	ticker := j.clock.NewTicker(jobsResolution)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			j.wg.Wait()
+			return
+		case <-ticker.C():
+			j.tick(ctx)
+		}
+	}
+}
+
+func (j *Jobs) next(job *job, now time.Time) time.Time {
+	return job.schedule.Next(now).Add(j.jitter(job.config.Jitter))
+}
+
+func (j *Jobs) tick(ctx context.Context) {
+	now := j.clock.Now()
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	for _, job := range j.jobs {
+		job.mu.Lock()
+		if now.Before(job.status.Next) {
+			job.mu.Unlock()
+			continue
+		}
+		job.status.Next = j.next(job, now)
+
+		if job.status.Running {
+			job.status.Skipped++
+			job.mu.Unlock()
+			j.logger.Warn("job is still running, skipping", "job", job.name)
+			continue
+		}
+		job.status.Running = true
+		job.status.LastStart = now
+		timeout := job.config.Timeout
+		job.mu.Unlock()
+
+		j.wg.Add(1)
+		go func() {
+			defer j.wg.Done()
+			j.launch(ctx, job, now, timeout)
+		}()
+	}
+}
+
+func (j *Jobs) launch(ctx context.Context, job *job, start time.Time, timeout time.Duration) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// Each run is the root of one trace, so everything it publishes can be
+	// followed into the sender.
+	ctx, span := tracer.Start(ctx, "job "+job.name,
+		trace.WithNewRoot(),
+		trace.WithAttributes(attribute.String("job.name", job.name)),
+	)
+	err := job.run(ctx)
+	tracing.End(span, err)
+	duration := j.clock.Now().Sub(start)
+
+	job.mu.Lock()
+	job.status.Running = false
+	job.status.Runs++
+	job.status.LastDuration = duration
+	job.status.LastError = ""
+	if err != nil {
+		job.status.LastError = err.Error()
+	}
+	job.mu.Unlock()
+
+	if err != nil {
+		j.logger.Error("job failed", "job", job.name, "duration", duration, "err", err)
+		return
+	}
+	j.logger.Info("job finished", "job", job.name, "duration", duration)
+}
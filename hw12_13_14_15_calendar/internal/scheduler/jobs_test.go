@@ -0,0 +1,160 @@
+package scheduler
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func runJobs(t *testing.T, jobs *Jobs) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		jobs.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestJobsRegister(t *testing.T) {
+	jobs := NewJobs(clock.New(), newLogger(t))
+	noop := func(context.Context) error { return nil }
+
+	require.NoError(t, jobs.Register("every", JobConfig{Schedule: "@every 10s"}, noop))
+	require.NoError(t, jobs.Register("cron", JobConfig{Schedule: "0 3 * * *"}, noop))
+	require.ErrorContains(t, jobs.Register("cron", JobConfig{Schedule: "0 3 * * *"}, noop), "already registered")
+	require.ErrorContains(t, jobs.Register("wrong", JobConfig{Schedule: "every day"}, noop), "wrong schedule")
+}
+
+func TestJobsFireOnSchedule(t *testing.T) {
+	start := time.Date(2024, time.October, 10, 9, 0, 30, 0, time.UTC)
+	fake := clock.NewFake(start)
+	jobs := NewJobs(fake, newLogger(t))
+
+	runs := make(chan time.Time, 10)
+	err := jobs.Register("five", JobConfig{Schedule: "*/5 * * * *"}, func(context.Context) error {
+		runs <- fake.Now()
+		return nil
+	})
+	require.NoError(t, err)
+	runJobs(t, jobs)
+
+	require.Eventually(t, func() bool {
+		return !jobs.Status()["five"].Next.IsZero()
+	}, time.Second, time.Millisecond)
+	require.Equal(t, time.Date(2024, time.October, 10, 9, 5, 0, 0, time.UTC), jobs.Status()["five"].Next)
+
+	for _, want := range []time.Time{
+		time.Date(2024, time.October, 10, 9, 5, 0, 0, time.UTC),
+		time.Date(2024, time.October, 10, 9, 10, 0, 0, time.UTC),
+	} {
+		for fake.Now().Before(want) {
+			fake.Advance(30 * time.Second)
+			time.Sleep(time.Millisecond)
+		}
+		require.Equal(t, want, <-runs)
+	}
+}
+
+func TestJobsPreventOverlap(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, time.October, 10, 9, 0, 0, 0, time.UTC))
+	jobs := NewJobs(fake, newLogger(t))
+
+	release := make(chan struct{})
+	var running, maxRunning atomic.Int32
+	err := jobs.Register("slow", JobConfig{Schedule: "* * * * *"}, func(context.Context) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		if n > maxRunning.Load() {
+			maxRunning.Store(n)
+		}
+		<-release
+		return nil
+	})
+	require.NoError(t, err)
+	runJobs(t, jobs)
+
+	require.Eventually(t, func() bool {
+		fake.Advance(time.Minute)
+		return jobs.Status()["slow"].Skipped >= 2
+	}, time.Second, time.Millisecond)
+	require.True(t, jobs.Status()["slow"].Running)
+	require.Equal(t, 0, jobs.Status()["slow"].Runs)
+
+	close(release)
+	require.Eventually(t, func() bool {
+		return jobs.Status()["slow"].Runs > 0
+	}, time.Second, time.Millisecond)
+	require.Equal(t, int32(1), maxRunning.Load())
+}
+
+func TestJobsTimeout(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, time.October, 10, 9, 0, 0, 0, time.UTC))
+	jobs := NewJobs(fake, newLogger(t))
+
+	err := jobs.Register("stuck", JobConfig{Schedule: "* * * * *", Timeout: 10 * time.Millisecond},
+		func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+	require.NoError(t, err)
+	runJobs(t, jobs)
+
+	require.Eventually(t, func() bool {
+		fake.Advance(time.Minute)
+		return jobs.Status()["stuck"].Runs == 1
+	}, time.Second, time.Millisecond)
+	require.Equal(t, context.DeadlineExceeded.Error(), jobs.Status()["stuck"].LastError)
+}
+
+func TestJobsJitter(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, time.October, 10, 9, 0, 0, 0, time.UTC))
+	jobs := NewJobs(fake, newLogger(t))
+	jobs.jitter = func(limit time.Duration) time.Duration {
+		return limit / 2
+	}
+
+	err := jobs.Register("nightly", JobConfig{Schedule: "0 3 * * *", Jitter: 10 * time.Minute},
+		func(context.Context) error { return nil })
+	require.NoError(t, err)
+	runJobs(t, jobs)
+
+	require.Eventually(t, func() bool {
+		return !jobs.Status()["nightly"].Next.IsZero()
+	}, time.Second, time.Millisecond)
+	require.Equal(t, time.Date(2024, time.October, 11, 3, 5, 0, 0, time.UTC), jobs.Status()["nightly"].Next)
+}
+
+func TestJobsReschedule(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, time.October, 10, 9, 0, 30, 0, time.UTC))
+	jobs := NewJobs(fake, newLogger(t))
+	noop := func(context.Context) error { return nil }
+	require.NoError(t, jobs.Register("a", JobConfig{Schedule: "0 3 * * *"}, noop))
+	require.NoError(t, jobs.Register("b", JobConfig{Schedule: "0 3 * * *"}, noop))
+	runJobs(t, jobs)
+
+	require.Eventually(t, func() bool {
+		return !jobs.Status()["a"].Next.IsZero()
+	}, time.Second, time.Millisecond)
+
+	err := jobs.Reschedule(map[string]JobConfig{
+		"a": {Schedule: "*/5 * * * *"},
+		"b": {Schedule: "every day"},
+	})
+	require.ErrorContains(t, err, "wrong schedule")
+	require.ErrorContains(t, jobs.Reschedule(map[string]JobConfig{"c": {Schedule: "* * * * *"}}), "not registered")
+	require.Equal(t, time.Date(2024, time.October, 11, 3, 0, 0, 0, time.UTC), jobs.Status()["a"].Next,
+		"a failed reschedule changes nothing")
+
+	require.NoError(t, jobs.Reschedule(map[string]JobConfig{"a": {Schedule: "*/5 * * * *"}}))
+	require.Equal(t, time.Date(2024, time.October, 10, 9, 5, 0, 0, time.UTC), jobs.Status()["a"].Next)
+	require.Equal(t, time.Date(2024, time.October, 11, 3, 0, 0, 0, time.UTC), jobs.Status()["b"].Next)
+}
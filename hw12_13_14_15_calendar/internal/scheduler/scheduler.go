@@ -2,18 +2,56 @@ package scheduler
 
 import (
 	"context"
+	"fmt"
+	"maps"
+	"sync"
 	"time"
 
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/clock"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/metrics"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
 )
 
 type Scheduler struct {
-	clearInterval int
-	interval      int
-	storage       Storage
-	logger        Logger
-	queue         Queue
+	configMu sync.RWMutex
+	config   Config
+
+	storage Storage
+	logger  Logger
+	queue   Queue
+	clock   clock.Clock
+	locker  Locker
+	jobs    *Jobs
+
+	leaderMu sync.Mutex
+	leader   bool
+}
+
+type Config struct {
+	// ClearInterval is the age in days after which events are deleted.
+	ClearInterval int
+	// WorkerID tags the events claimed by this replica.
+	WorkerID string
+	// BatchSize caps how many events one poll claims.
+	BatchSize int
+	// Lease is how long claimed events and digests stay reserved before
+	// another replica may pick them up again.
+	Lease time.Duration
+	// Jobs overrides DefaultJobs by job name.
+	Jobs map[string]JobConfig
+}
+
+const (
+	JobNotify  = "notify"
+	JobCleanup = "cleanup"
+	JobDigest  = "digest"
+)
+
+var DefaultJobs = map[string]JobConfig{
+	JobNotify:  {Schedule: "@every 10s", Timeout: time.Minute},
+	JobCleanup: {Schedule: "0 3 * * *", Jitter: time.Minute, Timeout: 10 * time.Minute},
+	JobDigest:  {Schedule: "* * * * *", Timeout: time.Minute},
 }
 
 type Logger interface {
@@ -21,77 +59,248 @@ type Logger interface {
 }
 
 type Queue interface {
-	Publish(interface{}) error
+	Publish(context.Context, interface{}) error
+}
+
+// markTimeout bounds recording published notifications during shutdown.
+const markTimeout = 5 * time.Second
+
+// LockKey identifies the lock shared by all scheduler replicas.
+const LockKey int64 = 4242
+
+type Locker interface {
+	storage.Locker
 }
 
 type Storage interface {
-	GetEventsToNotify(context.Context) ([]storage.Event, error)
-	MarkNotified(context.Context, []string) error
+	ClaimEventsToNotify(ctx context.Context, workerID string, limit int, lease time.Duration) ([]storage.Event, error)
+	ReleaseExpiredClaims(context.Context) error
+	MarkNotified(ctx context.Context, workerID string, ids []string) error
 	ClearEvents(context.Context, time.Duration) error
+	GetUserEvents(ctx context.Context, userID string, from, to time.Time) ([]storage.Event, error)
+	GetDigestSubscriptions(context.Context) ([]storage.DigestSubscription, error)
+	ClaimDigest(
+		ctx context.Context,
+		userID string,
+		kind storage.DigestKind,
+		periodStart time.Time,
+		lease time.Duration,
+	) (bool, error)
+	MarkDigestPublished(ctx context.Context, userID string, kind storage.DigestKind, periodStart time.Time) error
+	ReleaseDigest(ctx context.Context, userID string, kind storage.DigestKind, periodStart time.Time) error
 }
 
+// Message types let the sender tell apart the payloads sharing one queue.
+const (
+	MessageNotification = "notification"
+	MessageDigest       = "digest"
+)
+
 type Notification struct {
+	Type   string
 	ID     string
 	Title  string
 	Date   time.Time
 	UserID string
 }
 
-func NewScheduler(queue Queue, clearInteval int, interval int, logger Logger, storage Storage) Scheduler {
-	return Scheduler{
-		queue:         queue,
-		clearInterval: clearInteval,
-		interval:      interval,
-		logger:        logger,
-		storage:       storage,
+func NewScheduler(
+	config Config,
+	queue Queue,
+	logger Logger,
+	storage Storage,
+	clock clock.Clock,
+	locker Locker,
+) *Scheduler {
+	return &Scheduler{
+		config:  config,
+		queue:   queue,
+		logger:  logger,
+		storage: storage,
+		clock:   clock,
+		locker:  locker,
+		jobs:    NewJobs(clock, logger),
+	}
+}
+
+// jobConfigs fills in DefaultJobs for the jobs overrides leaves out.
+func jobConfigs(overrides map[string]JobConfig) (map[string]JobConfig, error) {
+	for name := range overrides {
+		if _, ok := DefaultJobs[name]; !ok {
+			return nil, fmt.Errorf("unknown job %q", name)
+		}
+	}
+	configs := maps.Clone(DefaultJobs)
+	maps.Copy(configs, overrides)
+
+	return configs, nil
+}
+
+func (s *Scheduler) currentConfig() Config {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+
+	return s.config
+}
+
+// SetConfig applies a new configuration to the running scheduler. The
+// WorkerID stays as it was.
+func (s *Scheduler) SetConfig(config Config) error {
+	jobs, err := jobConfigs(config.Jobs)
+	if err != nil {
+		return fmt.Errorf("scheduler.SetConfig: %w", err)
+	}
+	if err := s.jobs.Reschedule(jobs); err != nil {
+		return fmt.Errorf("scheduler.SetConfig: %w", err)
 	}
+
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	config.WorkerID = s.config.WorkerID
+	s.config = config
+
+	return nil
 }
 
-func (s *Scheduler) Start(ctx context.Context) {
+func (s *Scheduler) Start(ctx context.Context) error {
+	runs := map[string]func(context.Context) error{
+		JobNotify:  s.notifyJob,
+		JobCleanup: s.cleanupJob,
+		JobDigest:  s.digestJob,
+	}
+	jobs, err := jobConfigs(s.currentConfig().Jobs)
+	if err != nil {
+		return fmt.Errorf("scheduler.Start: %w", err)
+	}
+	for name, run := range runs {
+		err := s.jobs.Register(name, jobs[name], run)
+		if err != nil {
+			return fmt.Errorf("scheduler.Start: %w", err)
+		}
+	}
+
 	s.logger.Info("starting scheduler")
-	ticker := time.NewTicker(time.Duration(s.interval) * time.Second)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			s.notifyEvents(ctx)
-			s.storage.ClearEvents(ctx, time.Duration(s.clearInterval)*24*time.Hour)
+	defer s.resign()
+	s.jobs.Run(ctx)
+
+	return nil
+}
+
+func (s *Scheduler) JobStatus() map[string]JobStatus {
+	return s.jobs.Status()
+}
+
+func (s *Scheduler) notifyJob(ctx context.Context) error {
+	if s.elect(ctx) {
+		s.releaseExpiredClaims(ctx)
+	}
+
+	return s.notifyEvents(ctx)
+}
+
+func (s *Scheduler) cleanupJob(ctx context.Context) error {
+	if !s.elect(ctx) {
+		return nil
+	}
+
+	err := s.storage.ClearEvents(ctx, time.Duration(s.currentConfig().ClearInterval)*24*time.Hour)
+	if err != nil {
+		return fmt.Errorf("failed to clear events: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Scheduler) elect(ctx context.Context) bool {
+	s.leaderMu.Lock()
+	defer s.leaderMu.Unlock()
+
+	leader, err := s.locker.TryLock(ctx)
+	if err != nil {
+		s.logger.Error("failed to acquire scheduler lock", "err", err)
+	}
+
+	if leader != s.leader {
+		if leader {
+			s.logger.Info("became scheduler leader")
+		} else {
+			s.logger.Warn("lost scheduler leadership")
 		}
+		s.leader = leader
 	}
+
+	return leader
 }
 
-func (s *Scheduler) notifyEvents(ctx context.Context) {
+func (s *Scheduler) resign() {
+	s.leaderMu.Lock()
+	defer s.leaderMu.Unlock()
+
+	if !s.leader {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := s.locker.Unlock(ctx)
+	if err != nil {
+		s.logger.Error("failed to release scheduler lock", "err", err)
+	}
+	s.leader = false
+}
+
+func (s *Scheduler) releaseExpiredClaims(ctx context.Context) {
+	err := s.storage.ReleaseExpiredClaims(ctx)
+	if err != nil {
+		s.logger.Error("failed to release expired claims", "err", err)
+	}
+}
+
+func (s *Scheduler) notifyEvents(ctx context.Context) error {
 	logg := s.logger.With("at", "notifyEvents")
-	events, err := s.storage.GetEventsToNotify(ctx)
+	config := s.currentConfig()
+	events, err := s.storage.ClaimEventsToNotify(ctx, config.WorkerID, config.BatchSize, config.Lease)
 	if err != nil {
-		logg.Error("failed get events to notify", "err", err)
+		return fmt.Errorf("failed claim events to notify: %w", err)
 	}
+	metrics.SchedulerBatchSize.Observe(float64(len(events)))
 
-	sended := make([]string, len(events))
-	for i, event := range events {
+	sended := make([]string, 0, len(events))
+	for _, event := range events {
+		if ctx.Err() != nil {
+			// Shutting down: the rest stay claimed and are retried once
+			// their lease expires.
+			break
+		}
 		notification := Notification{
+			Type:   MessageNotification,
 			ID:     event.ID,
 			Title:  event.Title,
 			Date:   event.Date,
 			UserID: event.UserID,
 		}
 
-		err = s.queue.Publish(notification)
+		err = s.queue.Publish(ctx, notification)
 		if err != nil {
+			metrics.SchedulerPublishFailures.WithLabelValues(MessageNotification).Inc()
 			logg.Warn("failed to publish notification", "id", notification.ID, "err", err)
 			continue
 		}
-		sended[i] = notification.ID
+		sended = append(sended, notification.ID)
 		logg.Info("notification published", "id", notification.ID)
 	}
 
 	if len(sended) > 0 {
-		err = s.storage.MarkNotified(ctx, sended)
+		// Record what was already published even if ctx was cancelled, or
+		// it would be sent again.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), markTimeout)
+		defer cancel()
+		err = s.storage.MarkNotified(ctx, config.WorkerID, sended)
 		if err != nil {
 			logg.Warn("failed to mark notification", "ids", sended, "err", err)
 		}
 	}
+
+	return nil
 }
@@ -0,0 +1,337 @@
+package scheduler
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/clock"
+	loggerslog "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger/slog"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+	memorystorage "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeQueue struct {
+	mu        sync.Mutex
+	published []Notification
+	digests   []Digest
+}
+
+func (q *fakeQueue) Publish(_ context.Context, body interface{}) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	switch body := body.(type) {
+	case Notification:
+		q.published = append(q.published, body)
+	case Digest:
+		q.digests = append(q.digests, body)
+	}
+	return nil
+}
+
+func (q *fakeQueue) Digests() []Digest {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return append([]Digest(nil), q.digests...)
+}
+
+func (q *fakeQueue) Published() []Notification {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return append([]Notification(nil), q.published...)
+}
+
+func newLogger(t *testing.T) *loggerslog.Logger {
+	t.Helper()
+	logg, err := loggerslog.New(io.Discard, "INFO")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return logg
+}
+
+const userID = "66be96d3-3d5d-4aec-af9c-5b3769d0169a"
+
+func newScheduler(
+	t *testing.T,
+	workerID string,
+	q Queue,
+	store *memorystorage.Storage,
+	fake *clock.Fake,
+) *Scheduler {
+	t.Helper()
+	config := Config{
+		ClearInterval: 365,
+		WorkerID:      workerID,
+		BatchSize:     10,
+		Lease:         time.Minute,
+		Jobs: map[string]JobConfig{
+			JobNotify:  {Schedule: "* * * * *"},
+			JobCleanup: {Schedule: "0 3 * * *"},
+		},
+	}
+
+	return NewScheduler(config, q, newLogger(t), store, fake, store.NewLocker(LockKey))
+}
+
+func TestNotifyEventsFiresAtRightMinute(t *testing.T) {
+	start := time.Date(2024, time.October, 10, 9, 40, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+	store := memorystorage.New(fake)
+	err := store.CreateEvent(context.TODO(), storage.Event{
+		ID:                        "1",
+		Title:                     "meeting",
+		Date:                      time.Date(2024, time.October, 10, 10, 0, 0, 0, time.UTC),
+		EndDate:                   time.Date(2024, time.October, 10, 11, 0, 0, 0, time.UTC),
+		UserID:                    userID,
+		AdvanceNotificationPeriod: 15 * time.Minute,
+		NotificationStatus:        storage.StatusIdle,
+	})
+	require.NoError(t, err)
+
+	q := &fakeQueue{}
+	s := newScheduler(t, "worker", q, store, fake)
+
+	var firedAt time.Time
+	for range 30 {
+		require.NoError(t, s.notifyEvents(context.TODO()))
+		if firedAt.IsZero() && len(q.Published()) > 0 {
+			firedAt = fake.Now()
+		}
+		fake.Advance(time.Minute)
+	}
+
+	require.Equal(t, time.Date(2024, time.October, 10, 9, 45, 0, 0, time.UTC), firedAt)
+	require.Len(t, q.Published(), 1)
+	require.Equal(t, "1", q.Published()[0].ID)
+}
+
+func TestStartRunsNotifyJob(t *testing.T) {
+	start := time.Date(2024, time.October, 10, 9, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+	store := memorystorage.New(fake)
+	err := store.CreateEvent(context.TODO(), storage.Event{
+		ID:                 "1",
+		Title:              "meeting",
+		Date:               start.Add(5 * time.Minute),
+		EndDate:            start.Add(time.Hour),
+		UserID:             userID,
+		NotificationStatus: storage.StatusIdle,
+	})
+	require.NoError(t, err)
+
+	q := &fakeQueue{}
+	s := newScheduler(t, "worker", q, store, fake)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Start(ctx)
+	}()
+
+	require.Eventually(t, func() bool {
+		fake.Advance(time.Minute)
+		return len(q.Published()) > 0
+	}, time.Second, time.Millisecond)
+	require.False(t, fake.Now().Before(start.Add(5*time.Minute)))
+
+	cancel()
+	<-done
+	require.Len(t, q.Published(), 1)
+}
+
+type replicas struct {
+	queues  []*fakeQueue
+	cancels []context.CancelFunc
+	done    []chan struct{}
+}
+
+func startReplicas(t *testing.T, n int, store *memorystorage.Storage, fake *clock.Fake) *replicas {
+	t.Helper()
+	r := &replicas{}
+	for i := range n {
+		q := &fakeQueue{}
+		s := newScheduler(t, strconv.Itoa(i), q, store, fake)
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			assert.NoError(t, s.Start(ctx))
+		}()
+
+		r.queues = append(r.queues, q)
+		r.cancels = append(r.cancels, cancel)
+		r.done = append(r.done, done)
+	}
+
+	return r
+}
+
+func (r *replicas) stop(i int) {
+	r.cancels[i]()
+	<-r.done[i]
+}
+
+func (r *replicas) published() map[string]int {
+	result := make(map[string]int)
+	for _, q := range r.queues {
+		for _, n := range q.Published() {
+			result[n.ID]++
+		}
+	}
+
+	return result
+}
+
+func createDueEvent(t *testing.T, store *memorystorage.Storage, id string, date time.Time) {
+	t.Helper()
+	err := store.CreateEvent(context.TODO(), storage.Event{
+		ID:                 id,
+		Title:              "meeting",
+		Date:               date,
+		EndDate:            date.Add(time.Hour),
+		UserID:             userID,
+		NotificationStatus: storage.StatusIdle,
+	})
+	require.NoError(t, err)
+}
+
+func TestReplicasShareLoadWithoutDuplicates(t *testing.T) {
+	start := time.Date(2024, time.October, 10, 9, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+	store := memorystorage.New(fake)
+	const events = 50
+	for i := range events {
+		createDueEvent(t, store, strconv.Itoa(i), start)
+	}
+
+	r := startReplicas(t, 3, store, fake)
+
+	require.Eventually(t, func() bool {
+		fake.Advance(time.Minute)
+		return len(r.published()) == events
+	}, time.Second, time.Millisecond)
+
+	for id, count := range r.published() {
+		require.Equal(t, 1, count, "event %s", id)
+	}
+}
+
+func TestExpiredClaimIsRetriedAfterLeaderFailover(t *testing.T) {
+	start := time.Date(2024, time.October, 10, 9, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+	store := memorystorage.New(fake)
+	probe := store.NewLocker(LockKey)
+
+	leader := startReplicas(t, 1, store, fake)
+	require.Eventually(t, func() bool {
+		fake.Advance(time.Minute)
+		locked, err := probe.TryLock(context.TODO())
+		require.NoError(t, err)
+		if locked {
+			require.NoError(t, probe.Unlock(context.TODO()))
+		}
+		return !locked
+	}, time.Second, time.Millisecond)
+	follower := startReplicas(t, 1, store, fake)
+
+	createDueEvent(t, store, "1", fake.Now())
+	claimed, err := store.ClaimEventsToNotify(context.TODO(), "crashed", 10, time.Hour)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	leaseExpiresAt := claimed[0].LeaseExpiresAt
+
+	leader.stop(0)
+
+	fake.Advance(30 * time.Minute)
+	require.Never(t, func() bool {
+		return len(follower.published()) > 0
+	}, 50*time.Millisecond, time.Millisecond, "lease has not expired yet")
+
+	require.Eventually(t, func() bool {
+		fake.Advance(time.Minute)
+		return follower.published()["1"] == 1
+	}, time.Second, time.Millisecond)
+	require.True(t, fake.Now().After(leaseExpiresAt))
+}
+
+func TestStartRejectsUnknownJob(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, time.October, 10, 9, 0, 0, 0, time.UTC))
+	store := memorystorage.New(fake)
+	s := newScheduler(t, "worker", &fakeQueue{}, store, fake)
+	s.config.Jobs["unknown"] = JobConfig{Schedule: "* * * * *"}
+
+	err := s.Start(context.TODO())
+	require.ErrorContains(t, err, `unknown job "unknown"`)
+}
+
+func TestSetConfig(t *testing.T) {
+	start := time.Date(2024, time.October, 10, 9, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+	store := memorystorage.New(fake)
+	for i := range 5 {
+		createDueEvent(t, store, strconv.Itoa(i), start)
+	}
+	q := &fakeQueue{}
+	s := newScheduler(t, "worker", q, store, fake)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		assert.NoError(t, s.Start(ctx))
+	}()
+	require.Eventually(t, func() bool {
+		return !s.JobStatus()[JobNotify].Next.IsZero()
+	}, time.Second, time.Millisecond)
+
+	config := s.currentConfig()
+	config.WorkerID = "other"
+	config.BatchSize = 2
+	config.Jobs = map[string]JobConfig{JobNotify: {Schedule: "@every 10s"}, "unknown": {Schedule: "* * * * *"}}
+	require.ErrorContains(t, s.SetConfig(config), `unknown job "unknown"`)
+
+	delete(config.Jobs, "unknown")
+	require.NoError(t, s.SetConfig(config))
+	require.Equal(t, "worker", s.currentConfig().WorkerID)
+	require.Equal(t, start.Add(10*time.Second), s.JobStatus()[JobNotify].Next)
+
+	require.NoError(t, s.notifyEvents(context.TODO()))
+	require.Len(t, q.Published(), 2)
+}
+
+func TestCleanupJobRunsNightly(t *testing.T) {
+	start := time.Date(2024, time.October, 11, 2, 50, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+	store := memorystorage.New(fake)
+	createDueEvent(t, store, "old", start.AddDate(-2, 0, 0))
+
+	s := newScheduler(t, "worker", &fakeQueue{}, store, fake)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		assert.NoError(t, s.Start(ctx))
+	}()
+
+	require.Eventually(t, func() bool {
+		fake.Advance(time.Minute)
+		return s.JobStatus()[JobCleanup].Runs == 1
+	}, time.Second, time.Millisecond)
+
+	status := s.JobStatus()[JobCleanup]
+	nightly := time.Date(2024, time.October, 11, 3, 0, 0, 0, time.UTC)
+	require.WithinRange(t, status.LastStart, nightly, nightly.Add(5*time.Minute))
+	require.Empty(t, status.LastError)
+	_, err := store.GetEvent(context.TODO(), "old")
+	require.ErrorIs(t, err, storage.ErrEventDoesntExist)
+}
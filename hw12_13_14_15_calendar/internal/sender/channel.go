@@ -0,0 +1,23 @@
+package sender
+
+import "context"
+
+// Channel delivers rendered messages to a user.
+type Channel interface {
+	Send(ctx context.Context, userID string, message string) error
+}
+
+// LogChannel "delivers" messages by writing them to the log.
+type LogChannel struct {
+	logger Logger
+}
+
+func NewLogChannel(logger Logger) LogChannel {
+	return LogChannel{logger: logger}
+}
+
+func (c LogChannel) Send(_ context.Context, userID string, message string) error {
+	c.logger.Info("message delivered", "userID", userID, "message", message)
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package sender
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/clock"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/queue"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/scheduler"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+	memorystorage "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage/memory"
+	"github.com/stretchr/testify/require"
+)
+
+func (c *fakeChannel) Delivered() []delivery {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]delivery(nil), c.delivered...)
+}
+
+// TestPipelineWithoutExternalServices runs scheduler and sender against the
+// in-process broker and memory storage.
+func TestPipelineWithoutExternalServices(t *testing.T) {
+	start := time.Date(2024, time.October, 10, 9, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+	store := memorystorage.New(fake)
+	require.NoError(t, store.CreateEvent(context.TODO(), storage.Event{
+		ID:                 "1",
+		Title:              "meeting",
+		Date:               start.Add(time.Minute),
+		EndDate:            start.Add(time.Hour),
+		UserID:             userID,
+		NotificationStatus: storage.StatusIdle,
+	}))
+
+	broker := queue.NewMemoryBroker()
+	defer broker.Stop()
+	pub, err := broker.Publisher("notification_queue")
+	require.NoError(t, err)
+	sub, err := broker.Subscriber("notification_queue")
+	require.NoError(t, err)
+
+	logg := newLogger(t)
+	sch := scheduler.NewScheduler(scheduler.Config{
+		ClearInterval: 365,
+		WorkerID:      "worker",
+		BatchSize:     10,
+		Lease:         time.Minute,
+		Jobs: map[string]scheduler.JobConfig{
+			scheduler.JobNotify: {Schedule: "@every 1s"},
+		},
+	}, pub, logg, store, fake, store.NewLocker(scheduler.LockKey))
+	channel := &fakeChannel{}
+	snd := NewSender(sub, logg, store, channel)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		require.NoError(t, sch.Start(ctx))
+	}()
+	go func() {
+		defer wg.Done()
+		require.NoError(t, snd.Start(ctx))
+	}()
+
+	require.Eventually(t, func() bool {
+		fake.Advance(time.Second)
+		return len(channel.Delivered()) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	wg.Wait()
+
+	require.Equal(t, userID, channel.Delivered()[0].userID)
+	event, err := store.GetEvent(context.TODO(), "1")
+	require.NoError(t, err)
+	require.Equal(t, storage.StatusSent, event.NotificationStatus)
+}
@@ -0,0 +1,58 @@
+package sender
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+)
+
+type Digest struct {
+	Type        string
+	UserID      string
+	Kind        storage.DigestKind
+	Timezone    string
+	PeriodStart time.Time
+	Events      []DigestEvent
+}
+
+type DigestEvent struct {
+	ID    string
+	Title string
+	Date  time.Time
+}
+
+var digestTemplate = template.Must(template.New("digest").Parse(
+	`Your {{.Kind}} agenda from {{.PeriodStart.Format "Mon, 02 Jan 2006"}}:
+{{range .Events}}- {{.Date.Format "Mon 15:04"}} {{.Title}}
+{{else}}Nothing planned.
+{{end}}`))
+
+func renderNotification(notification Notification) string {
+	return fmt.Sprintf("Reminder: %q starts at %s", notification.Title, notification.Date.Format(time.RFC1123))
+}
+
+func renderDigest(digest Digest) (string, error) {
+	loc, err := time.LoadLocation(digest.Timezone)
+	if err != nil {
+		return "", fmt.Errorf("wrong timezone %q: %w", digest.Timezone, err)
+	}
+
+	digest.PeriodStart = digest.PeriodStart.In(loc)
+	events := make([]DigestEvent, len(digest.Events))
+	for i, event := range digest.Events {
+		event.Date = event.Date.In(loc)
+		events[i] = event
+	}
+	digest.Events = events
+
+	var b strings.Builder
+	err = digestTemplate.Execute(&b, digest)
+	if err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
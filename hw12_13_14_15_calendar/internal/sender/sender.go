@@ -3,62 +3,193 @@ package sender
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/backoff"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/metrics"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/queue"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+var tracer = tracing.Tracer("sender")
+
+// errMalformed marks messages that fail however often they are redelivered.
+var errMalformed = errors.New("malformed message")
+
+// retryBackoff spaces out redeliveries while the channel or the storage is
+// failing.
+var retryBackoff = backoff.Backoff{Initial: time.Second, Max: time.Minute}
+
 type Sender struct {
 	queue   Queue
 	logger  Logger
 	storage Storage
+	channel Channel
+	backoff backoff.Backoff
 }
 
 type Storage interface {
 	SetNotified(context.Context, string) error
+	SetDigestSent(ctx context.Context, userID string, kind storage.DigestKind, periodStart time.Time) error
 }
 
 type Queue interface {
-	Consume() (<-chan []byte, error)
+	Consume(ctx context.Context) (<-chan queue.Message, error)
 }
 
 type Logger interface {
 	logger.Logger
 }
 
-func NewSender(queue Queue, logger Logger, storage Storage) Sender {
+func NewSender(queue Queue, logger Logger, storage Storage, channel Channel) Sender {
 	return Sender{
 		queue:   queue,
 		logger:  logger,
 		storage: storage,
+		channel: channel,
+		backoff: retryBackoff,
 	}
 }
 
+const (
+	MessageNotification = "notification"
+	MessageDigest       = "digest"
+)
+
 type Notification struct {
+	Type   string
 	ID     string
 	Title  string
 	Date   time.Time
 	UserID string
 }
 
-func (s Sender) Start() error {
+// Start handles messages until ctx is done and the messages already received
+// are drained. Handled messages are acked. Malformed ones are dropped, since
+// redelivering them would fail again; the others are requeued after a
+// backoff that grows while failures follow each other.
+func (s Sender) Start(ctx context.Context) error {
 	s.logger.Info("starting sender")
-	msgs, err := s.queue.Consume()
+	msgs, err := s.queue.Consume(ctx)
 	if err != nil {
 		return fmt.Errorf("faield consume notification queue: %w", err)
 	}
 
+	var failures int
 	for msg := range msgs {
-		var notification Notification
-		err := json.Unmarshal(msg, &notification)
-		if err != nil {
-			s.logger.Error("Got wrong body", "err", err)
+		msgType, err := s.handle(msg.Context(), msg.Body)
+		if err == nil {
+			failures = 0
+			if err := msg.Ack(); err != nil {
+				s.logger.Error("failed to ack message", "err", err)
+				continue
+			}
+			metrics.SenderAcked.WithLabelValues(msgType).Inc()
 			continue
 		}
-		s.storage.SetNotified(context.TODO(), notification.ID)
-		s.logger.Info("Received notification", "notification", msg)
+
+		requeue := !errors.Is(err, errMalformed)
+		s.logger.Error("failed to handle message", "requeue", requeue, "err", err)
+		if requeue {
+			s.backoff.Wait(ctx, nil, failures)
+			failures++
+		}
+		if err := msg.Nack(requeue); err != nil {
+			s.logger.Error("failed to nack message", "err", err)
+		}
+	}
+	s.logger.Info("sender drained")
+
+	return nil
+}
+
+// handle returns the type of msg as a metric label: types the sender does
+// not know are all labelled "unknown".
+func (s Sender) handle(ctx context.Context, msg []byte) (msgType string, err error) {
+	ctx, span := tracer.Start(ctx, "Sender.handle")
+	defer func() { tracing.End(span, err) }()
+
+	var envelope struct {
+		Type string
+	}
+	err = json.Unmarshal(msg, &envelope)
+	if err != nil {
+		metrics.SenderConsumed.WithLabelValues("unknown").Inc()
+		metrics.SenderFailures.WithLabelValues("unknown").Inc()
+		return "unknown", fmt.Errorf("%w: got wrong body: %w", errMalformed, err)
+	}
+	if envelope.Type == "" {
+		envelope.Type = MessageNotification
+	}
+	msgType = envelope.Type
+	if msgType != MessageNotification && msgType != MessageDigest {
+		msgType = "unknown"
+	}
+	metrics.SenderConsumed.WithLabelValues(msgType).Inc()
+	span.SetAttributes(attribute.String("message.type", envelope.Type))
+
+	switch envelope.Type {
+	case MessageNotification:
+		err = s.handleNotification(ctx, msg)
+	case MessageDigest:
+		err = s.handleDigest(ctx, msg)
+	default:
+		err = fmt.Errorf("%w: unknown message type %q", errMalformed, envelope.Type)
+	}
+	if err != nil {
+		metrics.SenderFailures.WithLabelValues(msgType).Inc()
+		return msgType, err
+	}
+
+	return msgType, nil
+}
+
+func (s Sender) handleNotification(ctx context.Context, msg []byte) error {
+	var notification Notification
+	err := json.Unmarshal(msg, &notification)
+	if err != nil {
+		return fmt.Errorf("%w: got wrong notification body: %w", errMalformed, err)
+	}
+
+	err = s.channel.Send(ctx, notification.UserID, renderNotification(notification))
+	if err != nil {
+		return fmt.Errorf("failed to deliver notification %s: %w", notification.ID, err)
+	}
+	err = s.storage.SetNotified(ctx, notification.ID)
+	if err != nil {
+		return fmt.Errorf("failed to record notification %s: %w", notification.ID, err)
+	}
+	s.logger.Info("Received notification", "notification", msg)
+
+	return nil
+}
+
+func (s Sender) handleDigest(ctx context.Context, msg []byte) error {
+	var digest Digest
+	err := json.Unmarshal(msg, &digest)
+	if err != nil {
+		return fmt.Errorf("%w: got wrong digest body: %w", errMalformed, err)
+	}
+
+	text, err := renderDigest(digest)
+	if err != nil {
+		return fmt.Errorf("%w: failed to render digest: %w", errMalformed, err)
+	}
+	err = s.channel.Send(ctx, digest.UserID, text)
+	if err != nil {
+		return fmt.Errorf("failed to deliver digest: %w", err)
+	}
+
+	err = s.storage.SetDigestSent(ctx, digest.UserID, digest.Kind, digest.PeriodStart)
+	if err != nil {
+		return fmt.Errorf("failed to record digest: %w", err)
 	}
+	s.logger.Info("Received digest", "userID", digest.UserID, "kind", digest.Kind)
 
-	return err
+	return nil
 }
@@ -0,0 +1,259 @@
+package sender
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/backoff"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/clock"
+	loggerslog "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger/slog"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/metrics"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/queue"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+	memorystorage "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage/memory"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeQueue struct {
+	msgs chan queue.Message
+}
+
+func (q fakeQueue) Consume(_ context.Context) (<-chan queue.Message, error) {
+	return q.msgs, nil
+}
+
+type delivery struct {
+	userID  string
+	message string
+}
+
+type fakeChannel struct {
+	mu        sync.Mutex
+	delivered []delivery
+}
+
+func (c *fakeChannel) Send(_ context.Context, userID string, message string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.delivered = append(c.delivered, delivery{userID: userID, message: message})
+	return nil
+}
+
+func newLogger(t *testing.T) *loggerslog.Logger {
+	t.Helper()
+	logg, err := loggerslog.New(io.Discard, "INFO")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return logg
+}
+
+type fakeAcker struct {
+	acked, nacked, requeued bool
+	ackErr                  error
+}
+
+func (a *fakeAcker) Ack() error {
+	a.acked = true
+	return a.ackErr
+}
+
+func (a *fakeAcker) Nack(requeue bool) error {
+	a.nacked = true
+	a.requeued = requeue
+	return nil
+}
+
+func send(t *testing.T, store Storage, channel Channel, messages ...interface{}) []*fakeAcker {
+	t.Helper()
+	q := fakeQueue{msgs: make(chan queue.Message, len(messages))}
+	ackers := make([]*fakeAcker, 0, len(messages))
+	for _, m := range messages {
+		b, ok := m.([]byte)
+		if !ok {
+			var err error
+			b, err = json.Marshal(m)
+			require.NoError(t, err)
+		}
+		acker := &fakeAcker{}
+		ackers = append(ackers, acker)
+		q.msgs <- queue.NewMessage(context.Background(), b, acker)
+	}
+	close(q.msgs)
+
+	s := NewSender(q, newLogger(t), store, channel)
+	s.backoff = backoff.Backoff{}
+	require.NoError(t, s.Start(context.Background()))
+
+	return ackers
+}
+
+const userID = "66be96d3-3d5d-4aec-af9c-5b3769d0169a"
+
+func TestSenderNotification(t *testing.T) {
+	store := memorystorage.New(clock.New())
+	date := time.Date(2024, time.October, 10, 9, 0, 0, 0, time.UTC)
+	require.NoError(t, store.CreateEvent(context.TODO(), storage.Event{ID: "1", UserID: userID, Date: date}))
+	channel := &fakeChannel{}
+
+	send(t, store, channel, Notification{Type: MessageNotification, ID: "1", Title: "meeting", Date: date, UserID: userID})
+
+	require.Equal(t, []delivery{{
+		userID:  userID,
+		message: `Reminder: "meeting" starts at Thu, 10 Oct 2024 09:00:00 UTC`,
+	}}, channel.delivered)
+	event, err := store.GetEvent(context.TODO(), "1")
+	require.NoError(t, err)
+	require.Equal(t, storage.StatusSent, event.NotificationStatus)
+}
+
+func TestSenderDigest(t *testing.T) {
+	store := memorystorage.New(clock.New())
+	moscow := time.FixedZone("MSK", 3*60*60)
+	periodStart := time.Date(2024, time.October, 10, 0, 0, 0, 0, moscow)
+	claimed, err := store.ClaimDigest(context.TODO(), userID, storage.DigestDaily, periodStart, time.Minute)
+	require.NoError(t, err)
+	require.True(t, claimed)
+	weekStart := time.Date(2024, time.October, 7, 0, 0, 0, 0, time.UTC)
+	claimed, err = store.ClaimDigest(context.TODO(), userID, storage.DigestWeekly, weekStart, time.Minute)
+	require.NoError(t, err)
+	require.True(t, claimed)
+	channel := &fakeChannel{}
+
+	send(t, store, channel,
+		Digest{
+			Type:        MessageDigest,
+			UserID:      userID,
+			Kind:        storage.DigestDaily,
+			Timezone:    "Europe/Moscow",
+			PeriodStart: periodStart,
+			Events: []DigestEvent{
+				{ID: "1", Title: "standup", Date: time.Date(2024, time.October, 10, 7, 0, 0, 0, time.UTC)},
+				{ID: "2", Title: "review", Date: time.Date(2024, time.October, 10, 12, 30, 0, 0, time.UTC)},
+			},
+		},
+		Digest{
+			Type:        MessageDigest,
+			UserID:      userID,
+			Kind:        storage.DigestWeekly,
+			Timezone:    "UTC",
+			PeriodStart: weekStart,
+			Events:      []DigestEvent{},
+		},
+	)
+
+	require.Len(t, channel.delivered, 2)
+	require.Equal(t, `Your daily agenda from Thu, 10 Oct 2024:
+- Thu 10:00 standup
+- Thu 15:30 review
+`, channel.delivered[0].message)
+	require.Equal(t, `Your weekly agenda from Mon, 07 Oct 2024:
+Nothing planned.
+`, channel.delivered[1].message)
+
+	claimed, err = store.ClaimDigest(context.TODO(), userID, storage.DigestDaily, periodStart, time.Minute)
+	require.NoError(t, err)
+	require.False(t, claimed, "digest was recorded")
+}
+
+func TestSenderSettlesMessages(t *testing.T) {
+	store := memorystorage.New(clock.New())
+	date := time.Date(2024, time.October, 10, 9, 0, 0, 0, time.UTC)
+	require.NoError(t, store.CreateEvent(context.TODO(), storage.Event{ID: "1", UserID: userID, Date: date}))
+	channel := &fakeChannel{}
+
+	ackers := send(t, store, channel,
+		Notification{Type: MessageNotification, ID: "1", Title: "meeting", Date: date, UserID: userID},
+		[]byte("not json"),
+		map[string]string{"Type": "unknown"},
+	)
+
+	require.Equal(t, []*fakeAcker{
+		{acked: true},
+		{nacked: true},
+		{nacked: true},
+	}, ackers)
+	require.Len(t, channel.delivered, 1)
+}
+
+type failingChannel struct{}
+
+func (failingChannel) Send(context.Context, string, string) error {
+	return errors.New("gateway timeout")
+}
+
+func TestSenderRequeuesFailedDeliveries(t *testing.T) {
+	store := memorystorage.New(clock.New())
+	date := time.Date(2024, time.October, 10, 9, 0, 0, 0, time.UTC)
+	require.NoError(t, store.CreateEvent(context.TODO(), storage.Event{ID: "1", UserID: userID, Date: date}))
+
+	ackers := send(t, store, failingChannel{},
+		Notification{Type: MessageNotification, ID: "1", Title: "meeting", Date: date, UserID: userID},
+		Digest{Type: MessageDigest, UserID: userID, Kind: storage.DigestDaily, Timezone: "UTC", PeriodStart: date},
+		Digest{Type: MessageDigest, UserID: userID, Kind: storage.DigestDaily, Timezone: "Mars/Olympus"},
+	)
+
+	require.Equal(t, []*fakeAcker{
+		{nacked: true, requeued: true},
+		{nacked: true, requeued: true},
+		{nacked: true},
+	}, ackers, "only the digest that cannot be rendered is dropped")
+	event, err := store.GetEvent(context.TODO(), "1")
+	require.NoError(t, err)
+	require.NotEqual(t, storage.StatusSent, event.NotificationStatus)
+}
+
+type failingStorage struct{}
+
+func (failingStorage) SetNotified(context.Context, string) error {
+	return errors.New("connection refused")
+}
+
+func (failingStorage) SetDigestSent(context.Context, string, storage.DigestKind, time.Time) error {
+	return errors.New("connection refused")
+}
+
+func TestSenderRequeuesUnrecordedNotifications(t *testing.T) {
+	date := time.Date(2024, time.October, 10, 9, 0, 0, 0, time.UTC)
+	channel := &fakeChannel{}
+
+	ackers := send(t, failingStorage{}, channel,
+		Notification{Type: MessageNotification, ID: "1", Title: "meeting", Date: date, UserID: userID},
+	)
+
+	require.Equal(t, []*fakeAcker{{nacked: true, requeued: true}}, ackers)
+	require.Len(t, channel.delivered, 1)
+}
+
+func TestSenderMetrics(t *testing.T) {
+	store := memorystorage.New(clock.New())
+	date := time.Date(2024, time.October, 10, 9, 0, 0, 0, time.UTC)
+	require.NoError(t, store.CreateEvent(context.TODO(), storage.Event{ID: "1", UserID: userID, Date: date}))
+	series := testutil.CollectAndCount(metrics.SenderConsumed)
+	unknown := testutil.ToFloat64(metrics.SenderConsumed.WithLabelValues("unknown"))
+	acked := testutil.ToFloat64(metrics.SenderAcked.WithLabelValues(MessageNotification))
+
+	q := fakeQueue{msgs: make(chan queue.Message, 3)}
+	q.msgs <- queue.NewMessage(context.Background(), []byte(`{"Type":"surprise-1"}`), &fakeAcker{})
+	q.msgs <- queue.NewMessage(context.Background(), []byte(`{"Type":"surprise-2"}`), &fakeAcker{})
+	notification, err := json.Marshal(Notification{Type: MessageNotification, ID: "1", Date: date, UserID: userID})
+	require.NoError(t, err)
+	q.msgs <- queue.NewMessage(context.Background(), notification, &fakeAcker{ackErr: errors.New("channel closed")})
+	close(q.msgs)
+	s := NewSender(q, newLogger(t), store, &fakeChannel{})
+	s.backoff = backoff.Backoff{}
+	require.NoError(t, s.Start(context.Background()))
+
+	require.Equal(t, unknown+2, testutil.ToFloat64(metrics.SenderConsumed.WithLabelValues("unknown")))
+	require.LessOrEqual(t, testutil.CollectAndCount(metrics.SenderConsumed), series+1, "unknown types share one series")
+	require.Equal(t, acked, testutil.ToFloat64(metrics.SenderAcked.WithLabelValues(MessageNotification)),
+		"messages that were not acked are not counted")
+}
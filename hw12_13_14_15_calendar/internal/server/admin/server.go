@@ -0,0 +1,63 @@
+package internaladmin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/health"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/metrics"
+)
+
+// Server exposes operational endpoints of the background services.
+type Server struct {
+	logger Logger
+	server *http.Server
+	mux    *http.ServeMux
+}
+
+type Logger interface {
+	logger.Logger
+}
+
+func NewServer(logger Logger, health *health.Checker, host, port string) *Server {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Handler())
+	mux.Handle("GET /healthz", health.LivenessHandler())
+	mux.Handle("GET /readyz", health.ReadinessHandler())
+
+	return &Server{
+		logger: logger,
+		mux:    mux,
+		server: &http.Server{
+			Addr:              fmt.Sprintf("%s:%s", host, port),
+			Handler:           mux,
+			ReadHeaderTimeout: 2 * time.Second,
+		},
+	}
+}
+
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+func (s *Server) Start() error {
+	s.logger.Info("starting admin server", "addr", s.server.Addr)
+
+	if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("admin.Start: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Server) Stop(ctx context.Context) error {
+	err := s.server.Shutdown(ctx)
+	if err != nil {
+		return fmt.Errorf("admin.Stop: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package internalgrpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/api"
+	pbv2 "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/api/v2"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/app"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/clock"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/health"
+	memorystorage "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage/memory"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// newClients serves both API versions over one in-memory connection, backed
+// by the real application and memory storage.
+func newClients(t *testing.T) (pb.CalendarClient, pbv2.CalendarClient) {
+	t.Helper()
+	logg := newLogger(t)
+	calendar := app.New(logg, memorystorage.New(clock.New()), app.Config{})
+	server := NewServer(logg, calendar, health.New(health.DefaultTimeout), nil, nil, "", "")
+
+	l := bufconn.Listen(1 << 20)
+	go server.Serve(l)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return l.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewCalendarClient(conn), pbv2.NewCalendarClient(conn)
+}
+
+// TestVersionsShareEvents writes an event with one API version and reads it
+// back with the other, in both directions.
+func TestVersionsShareEvents(t *testing.T) {
+	ctx := context.Background()
+	date := time.Date(2024, time.September, 23, 10, 0, 0, 0, time.UTC)
+
+	v1Event := &pb.Event{
+		Id:                        eventID,
+		Title:                     "test",
+		Date:                      date.Unix(),
+		EndDate:                   date.Add(time.Hour).Unix(),
+		Description:               "description",
+		UserId:                    userID,
+		AdvanceNotificationPeriod: int64((15 * time.Minute).Seconds()),
+	}
+	v2Event := &pbv2.Event{
+		Id:                        eventID,
+		Title:                     "test",
+		Date:                      timestamppb.New(date),
+		EndDate:                   timestamppb.New(date.Add(time.Hour)),
+		Description:               "description",
+		UserId:                    userID,
+		AdvanceNotificationPeriod: durationpb.New(15 * time.Minute),
+	}
+
+	t.Run("written by v1", func(t *testing.T) {
+		v1, v2 := newClients(t)
+		_, err := v1.CreateEvent(ctx, &pb.CreateEventRequest{Event: v1Event})
+		require.NoError(t, err)
+
+		res, err := v2.GetEvent(ctx, &pbv2.GetEventRequest{Id: eventID})
+		require.NoError(t, err)
+		requireProto(t, v2Event, res.GetEvent())
+
+		list, err := v2.GetEventsDay(ctx, &pbv2.GetEventsDayRequest{Date: timestamppb.New(date.Truncate(24 * time.Hour))})
+		require.NoError(t, err)
+		require.Len(t, list.GetEvents(), 1)
+		requireProto(t, v2Event, list.GetEvents()[0])
+	})
+
+	t.Run("written by v2", func(t *testing.T) {
+		v1, v2 := newClients(t)
+		_, err := v2.CreateEvent(ctx, &pbv2.CreateEventRequest{Event: v2Event})
+		require.NoError(t, err)
+
+		res, err := v1.GetEvent(ctx, &pb.GetEventRequest{Id: eventID})
+		require.NoError(t, err)
+		requireProto(t, v1Event, res.GetEvent())
+
+		list, err := v1.GetEventsDay(ctx, &pb.GetEventsDayRequest{Date: date.Truncate(24 * time.Hour).Unix()})
+		require.NoError(t, err)
+		require.Len(t, list.GetEvents(), 1)
+		requireProto(t, v1Event, list.GetEvents()[0])
+	})
+
+	t.Run("edited by the other version", func(t *testing.T) {
+		v1, v2 := newClients(t)
+		_, err := v1.CreateEvent(ctx, &pb.CreateEventRequest{Event: v1Event})
+		require.NoError(t, err)
+
+		edited := &pbv2.Event{
+			Id:                        eventID,
+			Title:                     "edited",
+			Date:                      timestamppb.New(date.Add(time.Hour)),
+			EndDate:                   timestamppb.New(date.Add(2 * time.Hour)),
+			UserId:                    userID,
+			AdvanceNotificationPeriod: durationpb.New(time.Hour),
+		}
+		_, err = v2.EditEvent(ctx, &pbv2.EditEventRequest{Id: eventID, Event: edited})
+		require.NoError(t, err)
+
+		res, err := v1.GetEvent(ctx, &pb.GetEventRequest{Id: eventID})
+		require.NoError(t, err)
+		requireProto(t, &pb.Event{
+			Id:                        eventID,
+			Title:                     "edited",
+			Date:                      date.Add(time.Hour).Unix(),
+			EndDate:                   date.Add(2 * time.Hour).Unix(),
+			UserId:                    userID,
+			AdvanceNotificationPeriod: int64(time.Hour.Seconds()),
+		}, res.GetEvent())
+	})
+}
+
+func requireProto(t *testing.T, want, got proto.Message) {
+	t.Helper()
+	require.True(t, proto.Equal(want, got), "want %v, got %v", want, got)
+}
@@ -0,0 +1,85 @@
+package internalgrpc
+
+import (
+	"context"
+	"strings"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/app"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// errorDomain is the ErrorInfo domain of every error the service returns.
+const errorDomain = "calendar"
+
+func grpcCode(code app.Code) codes.Code {
+	switch code {
+	case app.CodeInvalidArgument:
+		return codes.InvalidArgument
+	case app.CodeNotFound:
+		return codes.NotFound
+	case app.CodeAlreadyExists:
+		return codes.AlreadyExists
+	case app.CodeQuotaExceeded, app.CodeRateLimited:
+		return codes.ResourceExhausted
+	case app.CodeUnavailable:
+		return codes.Unavailable
+	default:
+		return codes.Internal
+	}
+}
+
+// newStatus converts err into a status carrying an ErrorInfo with the app
+// code, plus BadRequest field violations for invalid arguments and a
+// QuotaFailure for exceeded quotas.
+func newStatus(err error) *status.Status {
+	appErr := app.AsError(err)
+	st := status.New(grpcCode(appErr.Code), appErr.Message)
+
+	details := []protoadapt.MessageV1{&errdetails.ErrorInfo{
+		Reason: strings.ToUpper(string(appErr.Code)),
+		Domain: errorDomain,
+	}}
+	switch appErr.Code {
+	case app.CodeInvalidArgument:
+		badRequest := &errdetails.BadRequest{}
+		for _, f := range appErr.Fields {
+			badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       f.Field,
+				Description: f.Description,
+			})
+		}
+		details = append(details, badRequest)
+	case app.CodeQuotaExceeded:
+		details = append(details, &errdetails.QuotaFailure{
+			Violations: []*errdetails.QuotaFailure_Violation{{
+				Subject:     "events",
+				Description: appErr.Message,
+			}},
+		})
+	default:
+	}
+
+	detailed, detailsErr := st.WithDetails(details...)
+	if detailsErr != nil {
+		return st
+	}
+
+	return detailed
+}
+
+// fail logs err with msg, as an error if it is internal and a warning
+// otherwise, and converts it into a status error.
+func fail(ctx context.Context, logg logger.Logger, msg string, err error) error {
+	st := newStatus(err)
+	if st.Code() == codes.Internal {
+		logg.ErrorContext(ctx, msg, "error", err)
+	} else {
+		logg.WarnContext(ctx, msg, "error", err)
+	}
+
+	return st.Err()
+}
@@ -0,0 +1,88 @@
+package internalgrpc
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/app"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+func TestNewStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		code    codes.Code
+		message string
+		details []protoadapt.MessageV1
+	}{
+		{
+			name:    "validation",
+			err:     app.ValidationError(map[string]string{"title": "too long", "id": "not valid uuid"}),
+			code:    codes.InvalidArgument,
+			message: "validation failed",
+			details: []protoadapt.MessageV1{
+				&errdetails.ErrorInfo{Reason: "INVALID_ARGUMENT", Domain: errorDomain},
+				&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+					{Field: "id", Description: "not valid uuid"},
+					{Field: "title", Description: "too long"},
+				}},
+			},
+		},
+		{
+			name:    "not found",
+			err:     fmt.Errorf("failed to get event: %w", storage.ErrEventDoesntExist),
+			code:    codes.NotFound,
+			message: "event not found",
+			details: []protoadapt.MessageV1{&errdetails.ErrorInfo{Reason: "NOT_FOUND", Domain: errorDomain}},
+		},
+		{
+			name:    "already exists",
+			err:     storage.ErrEventAlreadyExists,
+			code:    codes.AlreadyExists,
+			message: "event already exists",
+			details: []protoadapt.MessageV1{&errdetails.ErrorInfo{Reason: "ALREADY_EXISTS", Domain: errorDomain}},
+		},
+		{
+			name:    "quota exceeded",
+			err:     app.ErrQuotaExceeded,
+			code:    codes.ResourceExhausted,
+			message: "event quota exceeded",
+			details: []protoadapt.MessageV1{
+				&errdetails.ErrorInfo{Reason: "QUOTA_EXCEEDED", Domain: errorDomain},
+				&errdetails.QuotaFailure{Violations: []*errdetails.QuotaFailure_Violation{
+					{Subject: "events", Description: "event quota exceeded"},
+				}},
+			},
+		},
+		{
+			name:    "internal error hides the cause",
+			err:     errors.New("pq: connection refused"),
+			code:    codes.Internal,
+			message: "internal error",
+			details: []protoadapt.MessageV1{&errdetails.ErrorInfo{Reason: "INTERNAL", Domain: errorDomain}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newStatus(tt.err)
+
+			require.Equal(t, tt.code, st.Code())
+			require.Equal(t, tt.message, st.Message())
+			got := st.Details()
+			require.Len(t, got, len(tt.details))
+			for i, want := range tt.details {
+				detail, ok := got[i].(protoadapt.MessageV1)
+				require.True(t, ok, "detail %d: %v", i, got[i])
+				require.True(t, proto.Equal(protoadapt.MessageV2Of(want), protoadapt.MessageV2Of(detail)),
+					"detail %d: want %v, got %v", i, want, detail)
+			}
+		})
+	}
+}
@@ -2,30 +2,21 @@ package internalgrpc
 
 import (
 	"context"
-	"errors"
+	"time"
 
 	pb "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/api"
-	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 func (s *Server) CreateEvent(ctx context.Context, request *pb.CreateEventRequest) (*pb.CreateEventResponse, error) {
 	logg := s.logger.With("handler", "createEventHandler")
 	event, err := prepareEvent(request.GetEvent())
 	if err != nil {
-		logg.Warn("failed to prepare event", "error", err)
-		return nil, err
+		return nil, fail(ctx, logg, "invalid event", err)
 	}
 
 	err = s.app.CreateEvent(ctx, event)
 	if err != nil {
-		if errors.Is(err, storage.ErrEventAlreadyExists) {
-			logg.Warn("event already exists")
-			return nil, status.Error(codes.AlreadyExists, "event already exists")
-		}
-		logg.Error("failed create event", "error", err)
-		return nil, status.Error(codes.Internal, "Internal server error")
+		return nil, fail(ctx, logg, "failed create event", err)
 	}
 	return nil, nil
 }
@@ -34,12 +25,7 @@ func (s *Server) GetEvent(ctx context.Context, request *pb.GetEventRequest) (*pb
 	logg := s.logger.With("handler", "getEventHandler")
 	event, err := s.app.GetEvent(ctx, request.Id)
 	if err != nil {
-		if errors.Is(err, storage.ErrEventDoesntExist) {
-			logg.Warn("event doesn't exist")
-			return nil, status.Error(codes.NotFound, "event doesn't exist")
-		}
-		logg.Error("failed get event", "error", err)
-		return nil, status.Error(codes.Internal, "Internal server error")
+		return nil, fail(ctx, logg, "failed get event", err)
 	}
 	return &pb.GetEventResponse{Event: eventToProto(event)}, nil
 }
@@ -48,38 +34,28 @@ func (s *Server) EditEvent(ctx context.Context, request *pb.EditEventRequest) (*
 	logg := s.logger.With("handler", "editEventHandler")
 	event, err := prepareEvent(request.GetEvent())
 	if err != nil {
-		return nil, err
+		return nil, fail(ctx, logg, "invalid event", err)
 	}
 
 	err = s.app.EditEvent(ctx, request.Id, event)
 	if err != nil {
-		if errors.Is(err, storage.ErrEventDoesntExist) {
-			logg.Warn("event doesn't exist")
-			return nil, status.Error(codes.NotFound, "event doesn't exist")
-		}
-		logg.Error("failed edit event", "error", err)
-		return nil, status.Error(codes.Internal, "Internal server error")
+		return nil, fail(ctx, logg, "failed edit event", err)
 	}
 	return nil, nil
 }
 
 func (s *Server) DeleteEvent(ctx context.Context, request *pb.DeleteEventRequest) (*pb.DeleteEventResponse, error) {
-	logg := s.logger.With("handler", "createEventHandler")
+	logg := s.logger.With("handler", "deleteEventHandler")
 	err := s.app.DeleteEvent(ctx, request.Id)
 	if err != nil {
-		if errors.Is(err, storage.ErrEventDoesntExist) {
-			logg.Warn("event doesn't exist")
-			return nil, status.Error(codes.NotFound, "event doesn't exist")
-		}
-		logg.Error("failed delete event", "error", err)
-		return nil, status.Error(codes.Internal, "Internal server error")
+		return nil, fail(ctx, logg, "failed delete event", err)
 	}
 	return nil, nil
 }
 
 func (s *Server) GetEventsDay(ctx context.Context, request *pb.GetEventsDayRequest) (*pb.GetEventsDayResponse, error) {
 	logg := s.logger.With("handler", "getEventsDayEventHandler")
-	events, err := getEventsDate(ctx, logg, request.Date, s.app.GetEventsListDay)
+	events, err := getEventsDate(ctx, logg, time.Unix(request.Date, 0).UTC(), s.app.GetEventsListDay, eventToProto)
 	if err != nil {
 		return nil, err
 	}
@@ -91,7 +67,7 @@ func (s *Server) GetEventsWeek(
 	request *pb.GetEventsWeekRequest,
 ) (*pb.GetEventsWeekResponse, error) {
 	logg := s.logger.With("handler", "getEventsWeekEventHandler")
-	events, err := getEventsDate(ctx, logg, request.Date, s.app.GetEventsListWeek)
+	events, err := getEventsDate(ctx, logg, time.Unix(request.Date, 0).UTC(), s.app.GetEventsListWeek, eventToProto)
 	if err != nil {
 		return nil, err
 	}
@@ -103,7 +79,7 @@ func (s *Server) GetEventsMonth(
 	request *pb.GetEventsMonthRequest,
 ) (*pb.GetEventsMonthResponse, error) {
 	logg := s.logger.With("handler", "getEventsMonthEventHandler")
-	events, err := getEventsDate(ctx, logg, request.Date, s.app.GetEventsListMonth)
+	events, err := getEventsDate(ctx, logg, time.Unix(request.Date, 0).UTC(), s.app.GetEventsListMonth, eventToProto)
 	if err != nil {
 		return nil, err
 	}
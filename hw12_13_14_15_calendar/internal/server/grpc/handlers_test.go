@@ -3,17 +3,20 @@ package internalgrpc
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"testing"
 	"time"
 
 	pb "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/api"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/app"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/health"
 	logger "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger/slog"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/server/grpc/mocks"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
-	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -48,26 +51,69 @@ var (
 		UserID:                    userID,
 		AdvanceNotificationPeriod: 0,
 	}
+
+	// v1 carries the advance notification period in seconds.
+	describedMessage = pb.Event{
+		Id:                        eventID,
+		Title:                     "test",
+		Date:                      eventMessage.Date,
+		EndDate:                   eventMessage.EndDate,
+		Description:               "description",
+		UserId:                    userID,
+		AdvanceNotificationPeriod: 900,
+	}
+	describedStorage = storage.Event{
+		ID:                        eventID,
+		Title:                     "test",
+		Date:                      eventStorage.Date,
+		EndDate:                   eventStorage.EndDate,
+		Description:               "description",
+		UserID:                    userID,
+		AdvanceNotificationPeriod: 15 * time.Minute,
+	}
 )
 
+// requireStatus compares code and message only; details are checked by
+// the tests that care about them.
+func requireStatus(t *testing.T, want, got error) {
+	t.Helper()
+	require.Error(t, got)
+	wantSt, gotSt := status.Convert(want), status.Convert(got)
+	require.Equal(t, wantSt.Code(), gotSt.Code())
+	require.Equal(t, wantSt.Message(), gotSt.Message())
+}
+
 func validationErr(t *testing.T, err error) {
 	t.Helper()
 	st := status.Convert(err)
-	t.Log(st.Details())
+	require.Equal(t, codes.InvalidArgument, st.Code())
+	require.Equal(t, "validation failed", st.Message())
+
+	var violations []*errdetails.BadRequest_FieldViolation
+	for _, detail := range st.Details() {
+		if ty, ok := detail.(*errdetails.BadRequest); ok {
+			violations = ty.GetFieldViolations()
+		}
+	}
+	require.Equal(t, []string{"end_date", "id", "title", "user_id"}, fields(violations))
 	expect := map[string]string{
 		"id":       "not valid uuid",
 		"title":    "too long",
 		"end_date": "too early",
 		"user_id":  "not valid uuid",
 	}
+	for _, violation := range violations {
+		require.Equal(t, expect[violation.GetField()], violation.GetDescription(), "field %s", violation.GetField())
+	}
+}
 
-	for _, detail := range st.Details() {
-		if ty, ok := detail.(*pb.BadRequest); ok {
-			for _, violation := range ty.GetErrors() {
-				assert.Equal(t, expect[violation.Field], violation.GetDescription(), "field %s", violation.Field)
-			}
-		}
+func fields(violations []*errdetails.BadRequest_FieldViolation) []string {
+	result := make([]string, len(violations))
+	for i, violation := range violations {
+		result[i] = violation.GetField()
 	}
+
+	return result
 }
 
 func TestCreateEvent(t *testing.T) {
@@ -86,6 +132,14 @@ func TestCreateEvent(t *testing.T) {
 			event:     &eventMessage,
 			wantEvent: eventStorage,
 		},
+		{
+			name: "description and period in seconds",
+			returns: []interface{}{
+				nil,
+			},
+			event:     &describedMessage,
+			wantEvent: describedStorage,
+		},
 		{
 			name: "event already exists",
 			returns: []interface{}{
@@ -95,6 +149,15 @@ func TestCreateEvent(t *testing.T) {
 			wantEvent: eventStorage,
 			err:       status.Error(codes.AlreadyExists, "event already exists"),
 		},
+		{
+			name: "quota exceeded",
+			returns: []interface{}{
+				fmt.Errorf("app.CreateEvent: %w", app.ErrQuotaExceeded),
+			},
+			event:     &eventMessage,
+			wantEvent: eventStorage,
+			err:       status.Error(codes.ResourceExhausted, "event quota exceeded"),
+		},
 		{
 			name: "internal error",
 			returns: []interface{}{
@@ -102,7 +165,7 @@ func TestCreateEvent(t *testing.T) {
 			},
 			event:     &eventMessage,
 			wantEvent: eventStorage,
-			err:       status.Error(codes.Internal, "Internal server error"),
+			err:       status.Error(codes.Internal, "internal error"),
 		},
 	}
 	for _, tt := range tests {
@@ -110,14 +173,14 @@ func TestCreateEvent(t *testing.T) {
 			logg := newLogger(t)
 			app := mocks.NewApplication(t)
 			app.On("CreateEvent", mock.Anything, tt.wantEvent).Return(tt.returns...)
-			server := NewServer(logg, app, "", "")
+			server := NewServer(logg, app, health.New(health.DefaultTimeout), nil, nil, "", "")
 
 			_, err := server.CreateEvent(context.TODO(), &pb.CreateEventRequest{Event: tt.event})
 
 			if tt.err == nil {
 				require.NoError(t, err)
 			} else {
-				require.ErrorIs(t, err, tt.err)
+				requireStatus(t, tt.err, err)
 			}
 		})
 	}
@@ -126,7 +189,7 @@ func TestCreateEvent(t *testing.T) {
 func TestCreateEventVaidation(t *testing.T) {
 	logg := newLogger(t)
 	app := mocks.NewApplication(t)
-	server := NewServer(logg, app, "", "")
+	server := NewServer(logg, app, health.New(health.DefaultTimeout), nil, nil, "", "")
 
 	_, err := server.CreateEvent(context.TODO(), &pb.CreateEventRequest{Event: &pb.Event{
 		Id:                        "-c5a8-74c5-bf47-c87787247388",
@@ -157,13 +220,23 @@ func TestGetEvent(t *testing.T) {
 				Event: &eventMessage,
 			},
 		},
+		{
+			name: "description and period in seconds",
+			returns: []interface{}{
+				&describedStorage,
+				nil,
+			},
+			want: &pb.GetEventResponse{
+				Event: &describedMessage,
+			},
+		},
 		{
 			name: "event doesn't exist",
 			returns: []interface{}{
 				nil,
 				storage.ErrEventDoesntExist,
 			},
-			err: status.Error(codes.NotFound, "event doesn't exist"),
+			err: status.Error(codes.NotFound, "event not found"),
 		},
 		{
 			name: "internal error",
@@ -171,7 +244,7 @@ func TestGetEvent(t *testing.T) {
 				nil,
 				errors.New("internal error"),
 			},
-			err: status.Error(codes.Internal, "Internal server error"),
+			err: status.Error(codes.Internal, "internal error"),
 		},
 	}
 	for _, tt := range tests {
@@ -179,7 +252,7 @@ func TestGetEvent(t *testing.T) {
 			logg := newLogger(t)
 			app := mocks.NewApplication(t)
 			app.On("GetEvent", mock.Anything, eventID).Return(tt.returns...)
-			server := NewServer(logg, app, "", "")
+			server := NewServer(logg, app, health.New(health.DefaultTimeout), nil, nil, "", "")
 
 			res, err := server.GetEvent(context.TODO(), &pb.GetEventRequest{Id: eventID})
 
@@ -187,7 +260,7 @@ func TestGetEvent(t *testing.T) {
 				require.NoError(t, err)
 				require.Equal(t, tt.want, res)
 			} else {
-				require.ErrorIs(t, err, tt.err)
+				requireStatus(t, tt.err, err)
 				require.Nil(t, res)
 			}
 		})
@@ -217,7 +290,7 @@ func TestEditEvent(t *testing.T) {
 			},
 			event:     &eventMessage,
 			wantEvent: eventStorage,
-			err:       status.Error(codes.NotFound, "event doesn't exist"),
+			err:       status.Error(codes.NotFound, "event not found"),
 		},
 		{
 			name: "internal error",
@@ -226,7 +299,7 @@ func TestEditEvent(t *testing.T) {
 			},
 			event:     &eventMessage,
 			wantEvent: eventStorage,
-			err:       status.Error(codes.Internal, "Internal server error"),
+			err:       status.Error(codes.Internal, "internal error"),
 		},
 	}
 	for _, tt := range tests {
@@ -234,14 +307,14 @@ func TestEditEvent(t *testing.T) {
 			logg := newLogger(t)
 			app := mocks.NewApplication(t)
 			app.On("EditEvent", mock.Anything, eventID, tt.wantEvent).Return(tt.returns...)
-			server := NewServer(logg, app, "", "")
+			server := NewServer(logg, app, health.New(health.DefaultTimeout), nil, nil, "", "")
 
 			_, err := server.EditEvent(context.TODO(), &pb.EditEventRequest{Id: eventID, Event: tt.event})
 
 			if tt.err == nil {
 				require.NoError(t, err)
 			} else {
-				require.ErrorIs(t, err, tt.err)
+				requireStatus(t, tt.err, err)
 			}
 		})
 	}
@@ -250,7 +323,7 @@ func TestEditEvent(t *testing.T) {
 func TestEditEventVaidation(t *testing.T) {
 	logg := newLogger(t)
 	app := mocks.NewApplication(t)
-	server := NewServer(logg, app, "", "")
+	server := NewServer(logg, app, health.New(health.DefaultTimeout), nil, nil, "", "")
 
 	_, err := server.EditEvent(context.TODO(), &pb.EditEventRequest{Id: eventID, Event: &pb.Event{
 		Id:                        "-c5a8-74c5-bf47-c87787247388",
@@ -287,7 +360,7 @@ func TestDeleteEvent(t *testing.T) {
 			},
 			event:     &eventMessage,
 			wantEvent: eventStorage,
-			err:       status.Error(codes.NotFound, "event doesn't exist"),
+			err:       status.Error(codes.NotFound, "event not found"),
 		},
 		{
 			name: "internal error",
@@ -296,7 +369,7 @@ func TestDeleteEvent(t *testing.T) {
 			},
 			event:     &eventMessage,
 			wantEvent: eventStorage,
-			err:       status.Error(codes.Internal, "Internal server error"),
+			err:       status.Error(codes.Internal, "internal error"),
 		},
 	}
 	for _, tt := range tests {
@@ -304,14 +377,14 @@ func TestDeleteEvent(t *testing.T) {
 			logg := newLogger(t)
 			app := mocks.NewApplication(t)
 			app.On("DeleteEvent", mock.Anything, eventID).Return(tt.returns...)
-			server := NewServer(logg, app, "", "")
+			server := NewServer(logg, app, health.New(health.DefaultTimeout), nil, nil, "", "")
 
 			_, err := server.DeleteEvent(context.TODO(), &pb.DeleteEventRequest{Id: eventID})
 
 			if tt.err == nil {
 				require.NoError(t, err)
 			} else {
-				require.ErrorIs(t, err, tt.err)
+				requireStatus(t, tt.err, err)
 			}
 		})
 	}
@@ -347,7 +420,7 @@ var testsDate = []struct {
 			nil,
 			errors.New("internal error"),
 		},
-		err: status.Error(codes.Internal, "Internal server error"),
+		err: status.Error(codes.Internal, "internal error"),
 	},
 }
 
@@ -357,7 +430,7 @@ func TestGetEventsDay(t *testing.T) {
 			logg := newLogger(t)
 			app := mocks.NewApplication(t)
 			app.On("GetEventsListDay", mock.Anything, time.Now().Truncate(time.Second).UTC()).Return(tt.returns...)
-			server := NewServer(logg, app, "", "")
+			server := NewServer(logg, app, health.New(health.DefaultTimeout), nil, nil, "", "")
 
 			res, err := server.GetEventsDay(context.TODO(), &pb.GetEventsDayRequest{Date: time.Now().Unix()})
 
@@ -365,7 +438,7 @@ func TestGetEventsDay(t *testing.T) {
 				require.NoError(t, err)
 				require.Equal(t, &pb.GetEventsDayResponse{Events: []*pb.Event{&eventMessage}}, res)
 			} else {
-				require.ErrorIs(t, err, tt.err)
+				requireStatus(t, tt.err, err)
 				require.Nil(t, res)
 			}
 		})
@@ -378,7 +451,7 @@ func TestGetEventsWeek(t *testing.T) {
 			logg := newLogger(t)
 			app := mocks.NewApplication(t)
 			app.On("GetEventsListWeek", mock.Anything, time.Now().Truncate(time.Second).UTC()).Return(tt.returns...)
-			server := NewServer(logg, app, "", "")
+			server := NewServer(logg, app, health.New(health.DefaultTimeout), nil, nil, "", "")
 
 			res, err := server.GetEventsWeek(context.TODO(), &pb.GetEventsWeekRequest{Date: time.Now().Unix()})
 
@@ -386,7 +459,7 @@ func TestGetEventsWeek(t *testing.T) {
 				require.NoError(t, err)
 				require.Equal(t, &pb.GetEventsWeekResponse{Events: []*pb.Event{&eventMessage}}, res)
 			} else {
-				require.ErrorIs(t, err, tt.err)
+				requireStatus(t, tt.err, err)
 				require.Nil(t, res)
 			}
 		})
@@ -399,7 +472,7 @@ func TestGetEventsMonth(t *testing.T) {
 			logg := newLogger(t)
 			app := mocks.NewApplication(t)
 			app.On("GetEventsListMonth", mock.Anything, time.Now().Truncate(time.Second).UTC()).Return(tt.returns...)
-			server := NewServer(logg, app, "", "")
+			server := NewServer(logg, app, health.New(health.DefaultTimeout), nil, nil, "", "")
 
 			res, err := server.GetEventsMonth(context.TODO(), &pb.GetEventsMonthRequest{Date: time.Now().Unix()})
 
@@ -407,7 +480,7 @@ func TestGetEventsMonth(t *testing.T) {
 				require.NoError(t, err)
 				require.Equal(t, &pb.GetEventsMonthResponse{Events: []*pb.Event{&eventMessage}}, res)
 			} else {
-				require.ErrorIs(t, err, tt.err)
+				requireStatus(t, tt.err, err)
 				require.Nil(t, res)
 			}
 		})
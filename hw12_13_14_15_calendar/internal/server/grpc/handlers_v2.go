@@ -0,0 +1,120 @@
+package internalgrpc
+
+import (
+	"context"
+	"time"
+
+	pbv2 "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/api/v2"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// serverV2 implements event.v2.Calendar on top of the same application as
+// the v1 service, so both versions see the same events.
+type serverV2 struct {
+	pbv2.UnimplementedCalendarServer
+	logger Logger
+	app    Application
+}
+
+func (s *serverV2) CreateEvent(
+	ctx context.Context,
+	request *pbv2.CreateEventRequest,
+) (*pbv2.CreateEventResponse, error) {
+	logg := s.logger.With("handler", "createEventV2Handler")
+	event, err := prepareEventV2(request.GetEvent())
+	if err != nil {
+		return nil, fail(ctx, logg, "invalid event", err)
+	}
+
+	err = s.app.CreateEvent(ctx, event)
+	if err != nil {
+		return nil, fail(ctx, logg, "failed create event", err)
+	}
+	return &pbv2.CreateEventResponse{}, nil
+}
+
+func (s *serverV2) GetEvent(ctx context.Context, request *pbv2.GetEventRequest) (*pbv2.GetEventResponse, error) {
+	logg := s.logger.With("handler", "getEventV2Handler")
+	event, err := s.app.GetEvent(ctx, request.GetId())
+	if err != nil {
+		return nil, fail(ctx, logg, "failed get event", err)
+	}
+	return &pbv2.GetEventResponse{Event: eventToProtoV2(event)}, nil
+}
+
+func (s *serverV2) EditEvent(ctx context.Context, request *pbv2.EditEventRequest) (*pbv2.EditEventResponse, error) {
+	logg := s.logger.With("handler", "editEventV2Handler")
+	event, err := prepareEventV2(request.GetEvent())
+	if err != nil {
+		return nil, fail(ctx, logg, "invalid event", err)
+	}
+
+	err = s.app.EditEvent(ctx, request.GetId(), event)
+	if err != nil {
+		return nil, fail(ctx, logg, "failed edit event", err)
+	}
+	return &pbv2.EditEventResponse{}, nil
+}
+
+func (s *serverV2) DeleteEvent(
+	ctx context.Context,
+	request *pbv2.DeleteEventRequest,
+) (*pbv2.DeleteEventResponse, error) {
+	logg := s.logger.With("handler", "deleteEventV2Handler")
+	err := s.app.DeleteEvent(ctx, request.GetId())
+	if err != nil {
+		return nil, fail(ctx, logg, "failed delete event", err)
+	}
+	return &pbv2.DeleteEventResponse{}, nil
+}
+
+func (s *serverV2) listEvents(
+	ctx context.Context,
+	logg Logger,
+	date *timestamppb.Timestamp,
+	cb func(context.Context, time.Time) ([]storage.Event, error),
+) ([]*pbv2.Event, error) {
+	d, err := dateFromProto(date)
+	if err != nil {
+		return nil, fail(ctx, logg, "invalid date", err)
+	}
+
+	return getEventsDate(ctx, logg, d, cb, eventToProtoV2)
+}
+
+func (s *serverV2) GetEventsDay(
+	ctx context.Context,
+	request *pbv2.GetEventsDayRequest,
+) (*pbv2.GetEventsDayResponse, error) {
+	logg := s.logger.With("handler", "getEventsDayEventV2Handler")
+	events, err := s.listEvents(ctx, logg, request.GetDate(), s.app.GetEventsListDay)
+	if err != nil {
+		return nil, err
+	}
+	return &pbv2.GetEventsDayResponse{Events: events}, nil
+}
+
+func (s *serverV2) GetEventsWeek(
+	ctx context.Context,
+	request *pbv2.GetEventsWeekRequest,
+) (*pbv2.GetEventsWeekResponse, error) {
+	logg := s.logger.With("handler", "getEventsWeekEventV2Handler")
+	events, err := s.listEvents(ctx, logg, request.GetDate(), s.app.GetEventsListWeek)
+	if err != nil {
+		return nil, err
+	}
+	return &pbv2.GetEventsWeekResponse{Events: events}, nil
+}
+
+func (s *serverV2) GetEventsMonth(
+	ctx context.Context,
+	request *pbv2.GetEventsMonthRequest,
+) (*pbv2.GetEventsMonthResponse, error) {
+	logg := s.logger.With("handler", "getEventsMonthEventV2Handler")
+	events, err := s.listEvents(ctx, logg, request.GetDate(), s.app.GetEventsListMonth)
+	if err != nil {
+		return nil, err
+	}
+	return &pbv2.GetEventsMonthResponse{Events: events}, nil
+}
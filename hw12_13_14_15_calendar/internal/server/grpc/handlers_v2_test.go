@@ -0,0 +1,152 @@
+package internalgrpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pbv2 "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/api/v2"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/server/grpc/mocks"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func newServerV2(t *testing.T, app Application) *serverV2 {
+	t.Helper()
+	return &serverV2{logger: newLogger(t), app: app}
+}
+
+func TestCreateEventV2(t *testing.T) {
+	app := mocks.NewApplication(t)
+	want := eventStorage
+	want.AdvanceNotificationPeriod = 15 * time.Minute
+	app.On("CreateEvent", mock.Anything, want).Return(nil)
+
+	_, err := newServerV2(t, app).CreateEvent(context.TODO(), &pbv2.CreateEventRequest{Event: &pbv2.Event{
+		Id:                        eventID,
+		Title:                     "test",
+		Date:                      timestamppb.New(eventStorage.Date),
+		EndDate:                   timestamppb.New(eventStorage.EndDate),
+		UserId:                    userID,
+		AdvanceNotificationPeriod: durationpb.New(15 * time.Minute),
+	}})
+	require.NoError(t, err)
+}
+
+func TestCreateEventV2Validation(t *testing.T) {
+	tests := []struct {
+		name  string
+		event *pbv2.Event
+		want  map[string]string
+	}{
+		{
+			name: "missing times",
+			event: &pbv2.Event{
+				Id:     eventID,
+				Title:  "test",
+				UserId: userID,
+			},
+			want: map[string]string{
+				"date":     "not valid timestamp",
+				"end_date": "not valid timestamp",
+			},
+		},
+		{
+			name: "out of range",
+			event: &pbv2.Event{
+				Id:                        eventID,
+				Title:                     "test",
+				Date:                      &timestamppb.Timestamp{Seconds: -1 << 62},
+				EndDate:                   timestamppb.New(eventStorage.EndDate),
+				UserId:                    userID,
+				AdvanceNotificationPeriod: &durationpb.Duration{Seconds: 1, Nanos: -1},
+			},
+			want: map[string]string{
+				"date":                        "not valid timestamp",
+				"advance_notification_period": "not valid duration",
+			},
+		},
+		{
+			name: "invalid event",
+			event: &pbv2.Event{
+				Id:      "-c5a8-74c5-bf47-c87787247388",
+				Title:   "testtesttesttesttesttesttesttesttesttest",
+				Date:    timestamppb.New(eventStorage.EndDate),
+				EndDate: timestamppb.New(eventStorage.Date),
+				UserId:  "-c5a8-74c5-bf47-c87787247388",
+			},
+			want: map[string]string{
+				"id":       "not valid uuid",
+				"title":    "too long",
+				"end_date": "too early",
+				"user_id":  "not valid uuid",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newServerV2(t, mocks.NewApplication(t)).
+				CreateEvent(context.TODO(), &pbv2.CreateEventRequest{Event: tt.event})
+
+			st := status.Convert(err)
+			require.Equal(t, codes.InvalidArgument, st.Code())
+			got := map[string]string{}
+			for _, detail := range st.Details() {
+				if d, ok := detail.(*errdetails.BadRequest); ok {
+					for _, v := range d.GetFieldViolations() {
+						got[v.GetField()] = v.GetDescription()
+					}
+				}
+			}
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestGetEventV2(t *testing.T) {
+	app := mocks.NewApplication(t)
+	event := eventStorage
+	event.AdvanceNotificationPeriod = time.Hour
+	app.On("GetEvent", mock.Anything, eventID).Return(&event, nil)
+
+	res, err := newServerV2(t, app).GetEvent(context.TODO(), &pbv2.GetEventRequest{Id: eventID})
+	require.NoError(t, err)
+	require.Equal(t, eventStorage.Date, res.GetEvent().GetDate().AsTime())
+	require.Equal(t, eventStorage.EndDate, res.GetEvent().GetEndDate().AsTime())
+	require.Equal(t, time.Hour, res.GetEvent().GetAdvanceNotificationPeriod().AsDuration())
+}
+
+func TestGetEventsDayV2(t *testing.T) {
+	date := time.Date(2024, time.September, 23, 0, 0, 0, 0, time.UTC)
+
+	t.Run("success", func(t *testing.T) {
+		app := mocks.NewApplication(t)
+		app.On("GetEventsListDay", mock.Anything, date).Return([]storage.Event{eventStorage}, nil)
+
+		res, err := newServerV2(t, app).GetEventsDay(context.TODO(),
+			&pbv2.GetEventsDayRequest{Date: timestamppb.New(date)})
+		require.NoError(t, err)
+		require.Len(t, res.GetEvents(), 1)
+		require.Equal(t, eventID, res.GetEvents()[0].GetId())
+	})
+
+	t.Run("missing date", func(t *testing.T) {
+		_, err := newServerV2(t, mocks.NewApplication(t)).GetEventsDay(context.TODO(), &pbv2.GetEventsDayRequest{})
+		requireStatus(t, status.Error(codes.InvalidArgument, "validation failed"), err)
+	})
+
+	t.Run("no events found", func(t *testing.T) {
+		app := mocks.NewApplication(t)
+		app.On("GetEventsListDay", mock.Anything, date).Return(nil, storage.ErrNoEventsFound)
+
+		_, err := newServerV2(t, app).GetEventsDay(context.TODO(),
+			&pbv2.GetEventsDayRequest{Date: timestamppb.New(date)})
+		requireStatus(t, status.Error(codes.NotFound, "no events found"), err)
+	})
+}
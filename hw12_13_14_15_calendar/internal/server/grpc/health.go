@@ -0,0 +1,40 @@
+package internalgrpc
+
+import (
+	"context"
+	"time"
+
+	pb "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/api"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthInterval is how often dependency checks refresh the status reported
+// by grpc.health.v1.
+const healthInterval = 5 * time.Second
+
+// updateHealth runs the dependency checks and publishes the result both for
+// the whole server ("") and for the Calendar service.
+func (s *Server) updateHealth(ctx context.Context) {
+	status := healthpb.HealthCheckResponse_SERVING
+	if _, err := s.health.Check(ctx); err != nil {
+		s.logger.Warn("grpc server is not ready", "err", err)
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+
+	s.healthServer.SetServingStatus("", status)
+	s.healthServer.SetServingStatus(pb.Calendar_ServiceDesc.ServiceName, status)
+}
+
+func (s *Server) watchHealth() {
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.updateHealth(context.Background())
+		}
+	}
+}
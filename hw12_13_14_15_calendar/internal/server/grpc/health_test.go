@@ -0,0 +1,36 @@
+package internalgrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	pb "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/api"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/health"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/server/grpc/mocks"
+	"github.com/stretchr/testify/require"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestUpdateHealth(t *testing.T) {
+	logg := newLogger(t)
+	checker := health.New(health.DefaultTimeout)
+	var storageErr error
+	checker.Add("storage", func(context.Context) error { return storageErr })
+	server := NewServer(logg, mocks.NewApplication(t), checker, nil, nil, "", "")
+
+	status := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := server.healthServer.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		return resp.GetStatus()
+	}
+
+	server.updateHealth(context.Background())
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, status(""))
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, status(pb.Calendar_ServiceDesc.ServiceName))
+
+	storageErr = errors.New("connection refused")
+	server.updateHealth(context.Background())
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status(""))
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status(pb.Calendar_ServiceDesc.ServiceName))
+}
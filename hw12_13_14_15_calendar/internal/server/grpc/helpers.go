@@ -2,42 +2,28 @@ package internalgrpc
 
 import (
 	"context"
-	"errors"
-	"fmt"
 	"time"
 
 	pb "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/api"
+	pbv2 "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/api/v2"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/app"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/validator"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-func badRequestError(errors map[string]string) error {
-	st := status.New(codes.InvalidArgument, "validation error")
-	badRequest := &pb.BadRequest{}
-	for field, description := range errors {
-		badRequest.Errors = append(badRequest.Errors, &pb.BadRequest_FieldValiation{
-			Field:       field,
-			Description: description,
-		})
-	}
-	st, err := st.WithDetails(badRequest)
-	if err != nil {
-		panic(fmt.Sprintf("Unexpected error attaching metadata: %v", err))
-	}
-
-	return st.Err()
-}
-
+// protoToEvent reads a v1 event, whose times are Unix seconds and whose
+// advance notification period is in seconds.
 func protoToEvent(event *pb.Event) storage.Event {
 	return storage.Event{
-		ID:                        event.Id,
-		Title:                     event.Title,
-		Date:                      time.Unix(event.Date, 0).UTC(),
-		EndDate:                   time.Unix(event.EndDate, 0).UTC(),
-		UserID:                    event.UserId,
-		AdvanceNotificationPeriod: time.Duration(event.AdvanceNotificationPeriod),
+		ID:                        event.GetId(),
+		Title:                     event.GetTitle(),
+		Date:                      time.Unix(event.GetDate(), 0).UTC(),
+		EndDate:                   time.Unix(event.GetEndDate(), 0).UTC(),
+		Description:               event.GetDescription(),
+		UserID:                    event.GetUserId(),
+		AdvanceNotificationPeriod: time.Duration(event.GetAdvanceNotificationPeriod()) * time.Second,
 	}
 }
 
@@ -47,43 +33,102 @@ func eventToProto(event *storage.Event) *pb.Event {
 		Title:                     event.Title,
 		Date:                      event.Date.Unix(),
 		EndDate:                   event.EndDate.Unix(),
+		Description:               event.Description,
 		UserId:                    event.UserID,
 		AdvanceNotificationPeriod: int64(event.AdvanceNotificationPeriod.Seconds()),
 	}
 }
 
+// protoV2ToEvent reads a v2 event. Missing timestamps become the zero time.
+func protoV2ToEvent(event *pbv2.Event) storage.Event {
+	return storage.Event{
+		ID:                        event.GetId(),
+		Title:                     event.GetTitle(),
+		Date:                      timeFromProto(event.GetDate()),
+		EndDate:                   timeFromProto(event.GetEndDate()),
+		Description:               event.GetDescription(),
+		UserID:                    event.GetUserId(),
+		AdvanceNotificationPeriod: event.GetAdvanceNotificationPeriod().AsDuration(),
+	}
+}
+
+func eventToProtoV2(event *storage.Event) *pbv2.Event {
+	return &pbv2.Event{
+		Id:                        event.ID,
+		Title:                     event.Title,
+		Date:                      timestamppb.New(event.Date),
+		EndDate:                   timestamppb.New(event.EndDate),
+		Description:               event.Description,
+		UserId:                    event.UserID,
+		AdvanceNotificationPeriod: durationpb.New(event.AdvanceNotificationPeriod),
+	}
+}
+
+func timeFromProto(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+
+	return ts.AsTime()
+}
+
 func prepareEvent(event *pb.Event) (storage.Event, error) {
 	e := protoToEvent(event)
 
 	validator := validator.New()
 	storage.ValidateEvent(*validator, e)
 	if !validator.Valid() {
-		return storage.Event{}, badRequestError(validator.Errors)
+		return storage.Event{}, app.ValidationError(validator.Errors)
 	}
 
 	return e, nil
 }
 
-func getEventsDate(
+func prepareEventV2(event *pbv2.Event) (storage.Event, error) {
+	e := protoV2ToEvent(event)
+
+	validator := validator.New()
+	validator.Check(event.GetDate().CheckValid() != nil, "date", "not valid timestamp")
+	validator.Check(event.GetEndDate().CheckValid() != nil, "end_date", "not valid timestamp")
+	validator.Check(
+		event.GetAdvanceNotificationPeriod() != nil && event.GetAdvanceNotificationPeriod().CheckValid() != nil,
+		"advance_notification_period", "not valid duration",
+	)
+	if validator.Valid() {
+		storage.ValidateEvent(*validator, e)
+	}
+	if !validator.Valid() {
+		return storage.Event{}, app.ValidationError(validator.Errors)
+	}
+
+	return e, nil
+}
+
+// dateFromProto reads the date of a v2 list request, which is required.
+func dateFromProto(date *timestamppb.Timestamp) (time.Time, error) {
+	if err := date.CheckValid(); err != nil {
+		return time.Time{}, app.ValidationError(map[string]string{"date": "not valid timestamp"})
+	}
+
+	return date.AsTime(), nil
+}
+
+func getEventsDate[T any](
 	ctx context.Context,
 	logger Logger,
-	date int64,
+	date time.Time,
 	cb func(context.Context, time.Time) ([]storage.Event, error),
-) ([]*pb.Event, error) {
-	events, err := cb(ctx, time.Unix(date, 0).UTC())
+	convert func(*storage.Event) T,
+) ([]T, error) {
+	events, err := cb(ctx, date)
 	if err != nil {
-		if errors.Is(err, storage.ErrNoEventsFound) {
-			logger.Warn("no events found")
-			return nil, status.Error(codes.NotFound, "no events found")
-		}
-		logger.Error("failed get day events", "error", err)
-		return nil, status.Error(codes.Internal, "Internal server error")
+		return nil, fail(ctx, logger, "failed get events", err)
 	}
 
-	response := make([]*pb.Event, len(events))
+	response := make([]T, len(events))
 	for i, event := range events {
 		e := event
-		response[i] = eventToProto(&e)
+		response[i] = convert(&e)
 	}
 
 	return response, nil
@@ -2,25 +2,61 @@ package internalgrpc
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 
 	pb "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/api"
+	pbv2 "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/api/v2"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/app"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/auth"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/health"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/metrics"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/ratelimit"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/requestid"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/tlsconfig"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
+// ForwardedUserKey is the metadata in which the REST gateway passes on the
+// user it authenticated.
+const ForwardedUserKey = "x-forwarded-user"
+
+// ForwardedAddrKey is the metadata in which the REST gateway passes on the
+// address of its client. Unlike x-forwarded-for, which the gateway builds
+// from what the client sent, it holds nothing but the address of the peer.
+const ForwardedAddrKey = "x-forwarded-client-addr"
+
+var tracer = tracing.Tracer("server/grpc")
+
 type Server struct {
 	pb.UnimplementedCalendarServer
-	logger Logger
-	app    Application
-	server *grpc.Server
-	addr   string
+	logger       Logger
+	app          Application
+	health       *health.Checker
+	healthServer *grpchealth.Server
+	server       *grpc.Server
+	gateway      *gatewayListener
+	addr         string
+	done         chan struct{}
+	stopOnce     sync.Once
 }
 
 //go:generate mockery --name=Application
@@ -38,9 +74,40 @@ type Logger interface {
 	logger.Logger
 }
 
-func NewServer(logger Logger, app Application, host, port string) *Server {
-	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(LoggingInterceptor(logger)))
-	return &Server{logger: logger, app: app, addr: fmt.Sprintf("%s:%s", host, port), server: grpcServer}
+// NewServer builds the gRPC API. A nil limiter disables rate limiting; a nil
+// tlsConfig serves without TLS.
+func NewServer(
+	logger Logger,
+	app Application,
+	health *health.Checker,
+	limiter *ratelimit.Limiter,
+	tlsConfig *tls.Config,
+	host, port string,
+) *Server {
+	interceptors := []grpc.UnaryServerInterceptor{
+		RequestIDInterceptor(),
+		TracingInterceptor(),
+		LoggingInterceptor(logger),
+		AuthInterceptor(),
+	}
+	if limiter != nil {
+		interceptors = append(interceptors, RateLimitInterceptor(limiter))
+	}
+	opts := []grpc.ServerOption{grpc.ChainUnaryInterceptor(interceptors...)}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	grpcServer := grpc.NewServer(opts...)
+	return &Server{
+		logger:       logger,
+		app:          app,
+		health:       health,
+		healthServer: grpchealth.NewServer(),
+		addr:         fmt.Sprintf("%s:%s", host, port),
+		server:       grpcServer,
+		gateway:      newGatewayListener(),
+		done:         make(chan struct{}),
+	}
 }
 
 func (s *Server) Start() error {
@@ -50,10 +117,24 @@ func (s *Server) Start() error {
 		return fmt.Errorf("grpc.Start: %w", err)
 	}
 
+	return s.Serve(l)
+}
+
+// Serve serves on l, and to the connections of DialGateway, until Stop.
+func (s *Server) Serve(l net.Listener) error {
 	pb.RegisterCalendarServer(s.server, s)
+	pbv2.RegisterCalendarServer(s.server, &serverV2{logger: s.logger, app: s.app})
+	healthpb.RegisterHealthServer(s.server, s.healthServer)
+	s.updateHealth(context.Background())
+	go s.watchHealth()
+	go func() {
+		if err := s.server.Serve(s.gateway); err != nil {
+			s.logger.Error("failed to serve gateway connections", "err", err)
+		}
+	}()
 
 	if err := s.server.Serve(l); err != nil {
-		return fmt.Errorf("grpc.Start: %w", err)
+		return fmt.Errorf("grpc.Serve: %w", err)
 	}
 
 	return nil
@@ -65,9 +146,32 @@ func (s *Server) Stop() {
 	}
 
 	s.logger.Info("stopping grpc server")
+	s.stopOnce.Do(func() { close(s.done) })
+	s.healthServer.Shutdown()
 	s.server.GracefulStop()
 }
 
+// RequestIDInterceptor tags the call with the ID the client sent in
+// x-request-id metadata, or a new one, and returns it in the response header.
+func RequestIDInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		_ *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		var sent string
+		if values := metadata.ValueFromIncomingContext(ctx, requestid.MetadataKey); len(values) > 0 {
+			sent = values[0]
+		}
+		id := requestid.Accept(sent)
+		// Only fails outside a gRPC server, as in tests.
+		_ = grpc.SetHeader(ctx, metadata.Pairs(requestid.MetadataKey, id))
+
+		return handler(requestid.WithID(ctx, id), req)
+	}
+}
+
 func LoggingInterceptor(logger Logger) grpc.UnaryServerInterceptor {
 	return func(
 		ctx context.Context,
@@ -79,6 +183,8 @@ func LoggingInterceptor(logger Logger) grpc.UnaryServerInterceptor {
 		resp, err := handler(ctx, req)
 
 		latency := time.Since(date)
+		metrics.GRPCRequests.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+		metrics.GRPCRequestDuration.WithLabelValues(info.FullMethod).Observe(latency.Seconds())
 
 		p, ok := peer.FromContext(ctx)
 		ip := "unknown"
@@ -94,7 +200,7 @@ func LoggingInterceptor(logger Logger) grpc.UnaryServerInterceptor {
 			}
 		}
 
-		logger.Info("GRPC request handled",
+		logger.InfoContext(ctx, "GRPC request handled",
 			"ip", ip,
 			"method", info.FullMethod,
 			"date", date.Format(time.RFC822Z),
@@ -105,3 +211,144 @@ func LoggingInterceptor(logger Logger) grpc.UnaryServerInterceptor {
 		return resp, err
 	}
 }
+
+// metadataCarrier adapts incoming gRPC metadata to the OpenTelemetry
+// propagator.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	values := metadata.MD(c).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
+// TracingInterceptor continues the trace from the incoming metadata, if any,
+// and wraps the call in a server span named after the full method.
+func TracingInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			ctx = otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+		}
+		ctx, span := tracer.Start(ctx, info.FullMethod,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("rpc.system", "grpc"),
+				attribute.String("rpc.method", info.FullMethod),
+			),
+		)
+		defer span.End()
+
+		resp, err := handler(ctx, req)
+
+		code := status.Code(err)
+		span.SetAttributes(attribute.Int("rpc.grpc.status_code", int(code)))
+		if err != nil {
+			span.SetStatus(codes.Error, code.String())
+		}
+
+		return resp, err
+	}
+}
+
+// AuthInterceptor authenticates calls made with a verified client
+// certificate as the user named by it.
+func AuthInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		_ *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if userID, ok := clientIdentity(ctx); ok {
+			ctx = auth.WithUser(ctx, userID)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// clientIdentity is the user named by the caller's client certificate or,
+// for calls from the REST gateway, the user the gateway authenticated. The
+// gateway's own certificate names no user.
+func clientIdentity(ctx context.Context) (string, bool) {
+	if fromGateway(ctx) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if forwarded := md.Get(ForwardedUserKey); len(forwarded) > 0 && forwarded[0] != "" {
+			return forwarded[0], true
+		}
+		return "", false
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return "", false
+	}
+
+	return tlsconfig.ClientIdentity(&info.State)
+}
+
+// RateLimitInterceptor rejects clients that ran out of tokens for the method
+// with ResourceExhausted and a RetryInfo detail.
+func RateLimitInterceptor(limiter *ratelimit.Limiter) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		ok, retryAfter := limiter.Allow(info.FullMethod, ratelimit.ClientKey(ctx, clientAddr(ctx)))
+		if !ok {
+			st := newStatus(app.NewError(app.CodeRateLimited, "too many requests"))
+			if detailed, err := st.WithDetails(&errdetails.RetryInfo{
+				RetryDelay: durationpb.New(retryAfter),
+			}); err == nil {
+				st = detailed
+			}
+			return nil, st.Err()
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// clientAddr is the caller's address or, for calls from the REST gateway,
+// the address of its client.
+func clientAddr(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	if fromGateway(ctx) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if forwarded := md.Get(ForwardedAddrKey); len(forwarded) > 0 {
+			return forwarded[len(forwarded)-1]
+		}
+	}
+
+	return p.Addr.String()
+}
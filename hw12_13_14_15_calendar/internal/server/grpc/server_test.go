@@ -0,0 +1,198 @@
+package internalgrpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/auth"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/clock"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/ratelimit"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/requestid"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestRateLimitInterceptor(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, time.October, 1, 0, 0, 0, 0, time.UTC))
+	limiter := ratelimit.New(ratelimit.Config{Default: ratelimit.Rule{Rate: 0.5, Burst: 1}}, clk)
+	interceptor := RateLimitInterceptor(limiter)
+	info := &grpc.UnaryServerInfo{FullMethod: "/event.Calendar/CreateEvent"}
+	handler := func(context.Context, interface{}) (interface{}, error) { return "ok", nil }
+
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1000},
+	})
+
+	resp, err := interceptor(ctx, nil, info, handler)
+	require.NoError(t, err)
+	require.Equal(t, "ok", resp)
+
+	_, err = interceptor(ctx, nil, info, handler)
+	st, ok := status.FromError(err)
+	require.True(t, ok)
+	require.Equal(t, codes.ResourceExhausted, st.Code())
+	var retry *errdetails.RetryInfo
+	for _, detail := range st.Details() {
+		if d, ok := detail.(*errdetails.RetryInfo); ok {
+			retry = d
+		}
+	}
+	require.NotNil(t, retry)
+	require.Equal(t, 2*time.Second, retry.GetRetryDelay().AsDuration())
+
+	clk.Advance(2 * time.Second)
+	_, err = interceptor(ctx, nil, info, handler)
+	require.NoError(t, err)
+}
+
+func TestClientAddr(t *testing.T) {
+	tests := []struct {
+		name      string
+		peer      net.Addr
+		forwarded metadata.MD
+		want      string
+	}{
+		{name: "remote peer", peer: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1000}, want: "10.0.0.1:1000"},
+		{
+			name:      "remote peer ignores forwarded",
+			peer:      &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1000},
+			forwarded: metadata.Pairs(ForwardedAddrKey, "1.2.3.4:5", "x-forwarded-for", "1.2.3.4"),
+			want:      "10.0.0.1:1000",
+		},
+		{
+			name:      "loopback peer ignores forwarded",
+			peer:      &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1000},
+			forwarded: metadata.Pairs(ForwardedAddrKey, "1.2.3.4:5", "x-forwarded-for", "1.2.3.4"),
+			want:      "127.0.0.1:1000",
+		},
+		{
+			name:      "gateway",
+			peer:      gatewayAddr{},
+			forwarded: metadata.Pairs(ForwardedAddrKey, "10.0.0.2:5", "x-forwarded-for", "1.2.3.4, 10.0.0.2"),
+			want:      "10.0.0.2:5",
+		},
+		{name: "gateway without forwarded", peer: gatewayAddr{}, want: "gateway"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: tt.peer})
+			if tt.forwarded != nil {
+				ctx = metadata.NewIncomingContext(ctx, tt.forwarded)
+			}
+			require.Equal(t, tt.want, clientAddr(ctx))
+		})
+	}
+}
+
+func TestAuthInterceptor(t *testing.T) {
+	certFor := func(user string) credentials.TLSInfo {
+		return credentials.TLSInfo{State: tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: user}}},
+		}}
+	}
+	remote := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1000}
+	loopback := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1000}
+	tests := []struct {
+		name      string
+		peer      net.Addr
+		authInfo  credentials.AuthInfo
+		forwarded string
+		want      string
+	}{
+		{name: "client certificate", peer: remote, authInfo: certFor("bob"), want: "bob"},
+		{name: "no client certificate", peer: remote, authInfo: credentials.TLSInfo{}},
+		{name: "plaintext", peer: remote},
+		{name: "remote forwarding", peer: remote, authInfo: certFor("bob"), forwarded: "alice", want: "bob"},
+		{name: "loopback forwarding", peer: loopback, authInfo: certFor("bob"), forwarded: "alice", want: "bob"},
+		{name: "loopback forwarding without certificate", peer: loopback, forwarded: "alice"},
+		{name: "gateway", peer: gatewayAddr{}, authInfo: certFor("gateway"), forwarded: "alice", want: "alice"},
+		{name: "gateway without user", peer: gatewayAddr{}, authInfo: certFor("gateway")},
+		{name: "plaintext gateway", peer: gatewayAddr{}, forwarded: "alice", want: "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: tt.peer, AuthInfo: tt.authInfo})
+			if tt.forwarded != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(ForwardedUserKey, tt.forwarded))
+			}
+
+			var got string
+			_, err := AuthInterceptor()(ctx, nil, &grpc.UnaryServerInfo{},
+				func(ctx context.Context, _ interface{}) (interface{}, error) {
+					got, _ = auth.UserFromContext(ctx)
+					return nil, nil
+				})
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRequestIDInterceptor(t *testing.T) {
+	call := func(ctx context.Context) string {
+		t.Helper()
+		var got string
+		_, err := RequestIDInterceptor()(ctx, nil, &grpc.UnaryServerInfo{},
+			func(ctx context.Context, _ interface{}) (interface{}, error) {
+				got, _ = requestid.FromContext(ctx)
+				return nil, nil
+			})
+		require.NoError(t, err)
+
+		return got
+	}
+
+	sent := metadata.NewIncomingContext(context.Background(), metadata.Pairs(requestid.MetadataKey, "abc-123"))
+	require.Equal(t, "abc-123", call(sent))
+
+	generated := call(context.Background())
+	require.NotEmpty(t, generated)
+	require.NotEqual(t, generated, call(context.Background()), "every call gets its own ID")
+
+	hostile := metadata.NewIncomingContext(context.Background(), metadata.Pairs(requestid.MetadataKey, "a\nb"))
+	require.NotEqual(t, "a\nb", call(hostile))
+}
+
+func TestGatewayListener(t *testing.T) {
+	l := newGatewayListener()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+		close(accepted)
+	}()
+
+	client, err := l.DialContext(context.Background())
+	require.NoError(t, err)
+	defer client.Close()
+	server := <-accepted
+	require.NotNil(t, server)
+	defer server.Close()
+	require.Equal(t, gatewayAddr{}, server.RemoteAddr())
+
+	go client.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	_, err = server.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+
+	require.NoError(t, l.Close())
+	require.NoError(t, l.Close(), "closing twice is harmless")
+	_, err = l.Accept()
+	require.ErrorIs(t, err, errGatewayClosed)
+	_, err = l.DialContext(context.Background())
+	require.ErrorIs(t, err, errGatewayClosed)
+}
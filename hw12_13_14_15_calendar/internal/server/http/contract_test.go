@@ -0,0 +1,204 @@
+package internalhttp
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/health"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/server/http/mocks"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// The v1 contract: these bodies are what v1 clients parse. Changing them
+// is a breaking change and belongs in a new version instead.
+const (
+	contractEventBody = `{
+	"id": "66be96d3-3d5d-4aec-af9c-5b3769d0169a",
+	"title": "standup",
+	"date": "2024-09-23T10:00:00Z",
+	"end_date": "2024-09-23T10:15:00Z",
+	"description": "daily",
+	"user_id": "0f7b9c4e-8a43-4f3c-9f6e-0c2b1d7e5a11",
+	"advance_notification_period": 900000000000
+}`
+	contractGetBody = `{
+	"event": {
+		"id": "66be96d3-3d5d-4aec-af9c-5b3769d0169a",
+		"title": "standup",
+		"date": "2024-09-23T10:00:00Z",
+		"end_date": "2024-09-23T10:15:00Z",
+		"description": "daily",
+		"user_id": "0f7b9c4e-8a43-4f3c-9f6e-0c2b1d7e5a11",
+		"advance_notification_period": 900000000000
+	}
+}`
+	contractListBody = `{
+	"events": [
+		{
+			"id": "66be96d3-3d5d-4aec-af9c-5b3769d0169a",
+			"title": "standup",
+			"date": "2024-09-23T10:00:00Z",
+			"end_date": "2024-09-23T10:15:00Z",
+			"description": "daily",
+			"user_id": "0f7b9c4e-8a43-4f3c-9f6e-0c2b1d7e5a11",
+			"advance_notification_period": 900000000000
+		}
+	]
+}`
+	contractEmptyListBody = `{
+	"events": null
+}`
+)
+
+var contractEvent = storage.Event{
+	ID:                        "66be96d3-3d5d-4aec-af9c-5b3769d0169a",
+	Title:                     "standup",
+	Date:                      time.Date(2024, time.September, 23, 10, 0, 0, 0, time.UTC),
+	EndDate:                   time.Date(2024, time.September, 23, 10, 15, 0, 0, time.UTC),
+	Description:               "daily",
+	UserID:                    "0f7b9c4e-8a43-4f3c-9f6e-0c2b1d7e5a11",
+	AdvanceNotificationPeriod: 15 * time.Minute,
+}
+
+type contractCase struct {
+	name    string
+	method  string
+	target  string
+	body    string
+	call    []interface{}
+	returns []interface{}
+	want    string
+}
+
+func contractCases(prefix map[string]string) []contractCase {
+	date := time.Date(2024, time.September, 23, 0, 0, 0, 0, time.UTC)
+	return []contractCase{
+		{
+			name:    "create",
+			method:  http.MethodPost,
+			target:  prefix["create"],
+			body:    contractEventBody,
+			call:    []interface{}{"CreateEvent", mock.Anything, contractEvent},
+			returns: []interface{}{nil},
+			want:    successBody,
+		},
+		{
+			name:    "get",
+			method:  http.MethodGet,
+			target:  prefix["get"] + contractEvent.ID,
+			call:    []interface{}{"GetEvent", mock.Anything, contractEvent.ID},
+			returns: []interface{}{&contractEvent, nil},
+			want:    contractGetBody,
+		},
+		{
+			name:    "edit",
+			method:  http.MethodPut,
+			target:  prefix["edit"] + contractEvent.ID,
+			body:    contractEventBody,
+			call:    []interface{}{"EditEvent", mock.Anything, contractEvent.ID, contractEvent},
+			returns: []interface{}{nil},
+			want:    successBody,
+		},
+		{
+			name:    "delete",
+			method:  http.MethodDelete,
+			target:  prefix["delete"] + contractEvent.ID,
+			call:    []interface{}{"DeleteEvent", mock.Anything, contractEvent.ID},
+			returns: []interface{}{nil},
+			want:    successBody,
+		},
+		{
+			name:    "day",
+			method:  http.MethodGet,
+			target:  prefix["list"] + "day/2024-09-23",
+			call:    []interface{}{"GetEventsListDay", mock.Anything, date},
+			returns: []interface{}{[]storage.Event{contractEvent}, nil},
+			want:    contractListBody,
+		},
+		{
+			name:    "week",
+			method:  http.MethodGet,
+			target:  prefix["list"] + "week/2024-09-23",
+			call:    []interface{}{"GetEventsListWeek", mock.Anything, date},
+			returns: []interface{}{[]storage.Event{contractEvent}, nil},
+			want:    contractListBody,
+		},
+		{
+			name:    "empty month",
+			method:  http.MethodGet,
+			target:  prefix["list"] + "month/2024-09-23",
+			call:    []interface{}{"GetEventsListMonth", mock.Anything, date},
+			returns: []interface{}{[]storage.Event(nil), nil},
+			want:    contractEmptyListBody,
+		},
+	}
+}
+
+// TestV1Contract runs the same requests against /v1 and the legacy routes,
+// which must both keep answering in the v1 format byte for byte.
+func TestV1Contract(t *testing.T) {
+	trees := []struct {
+		name       string
+		prefix     map[string]string
+		deprecated bool
+	}{
+		{
+			name: "v1",
+			prefix: map[string]string{
+				"create": "/v1/events",
+				"get":    "/v1/events/",
+				"edit":   "/v1/events/",
+				"delete": "/v1/events/",
+				"list":   "/v1/events/",
+			},
+		},
+		{
+			name: "legacy",
+			prefix: map[string]string{
+				"create": "/event/create",
+				"get":    "/event/",
+				"edit":   "/event/edit/",
+				"delete": "/event/delete/",
+				"list":   "/event/",
+			},
+			deprecated: true,
+		},
+	}
+	for _, tree := range trees {
+		for _, tt := range contractCases(tree.prefix) {
+			t.Run(tree.name+"/"+tt.name, func(t *testing.T) {
+				application := mocks.NewApplication(t)
+				application.On(tt.call[0].(string), tt.call[1:]...).Return(tt.returns...)
+				server := NewServer(newLogger(t), application, health.New(health.DefaultTimeout), nil, nil, nil, "", "")
+
+				w := httptest.NewRecorder()
+				server.server.Handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, bytes.NewBufferString(tt.body)))
+
+				require.Equal(t, http.StatusOK, w.Code)
+				require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+				require.Equal(t, tt.want, w.Body.String())
+				if tree.deprecated {
+					require.Equal(t, "@1792368000", w.Header().Get("Deprecation"))
+					require.Equal(t, `</docs>; rel="deprecation"`, w.Header().Get("Link"))
+				} else {
+					require.Empty(t, w.Header().Get("Deprecation"))
+				}
+			})
+		}
+	}
+}
+
+// TestV1ContractFields fails when a field is added to, removed from or
+// renamed in the v1 event.
+func TestV1ContractFields(t *testing.T) {
+	want := []string{
+		"advance_notification_period", "date", "description", "end_date", "id", "title", "user_id",
+	}
+	require.Equal(t, want, keys(jsonFields(reflect.TypeOf(eventV1{}))))
+}
@@ -0,0 +1,128 @@
+package internalhttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+)
+
+// The wire formats of the API versions are defined here rather than by the
+// json tags of storage.Event, so storage changes cannot leak into them.
+
+// eventV1 is an event in /v1 and the legacy routes. Its format is frozen:
+// contract_test.go pins it.
+type eventV1 struct {
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	Date        time.Time `json:"date"`
+	EndDate     time.Time `json:"end_date"`
+	Description string    `json:"description"`
+	UserID      string    `json:"user_id"`
+	// AdvanceNotificationPeriod is in nanoseconds.
+	AdvanceNotificationPeriod int64 `json:"advance_notification_period"`
+}
+
+func eventV1FromStorage(event storage.Event) eventV1 {
+	return eventV1{
+		ID:                        event.ID,
+		Title:                     event.Title,
+		Date:                      event.Date,
+		EndDate:                   event.EndDate,
+		Description:               event.Description,
+		UserID:                    event.UserID,
+		AdvanceNotificationPeriod: int64(event.AdvanceNotificationPeriod),
+	}
+}
+
+func (e eventV1) toStorage() storage.Event {
+	return storage.Event{
+		ID:                        e.ID,
+		Title:                     e.Title,
+		Date:                      e.Date,
+		EndDate:                   e.EndDate,
+		Description:               e.Description,
+		UserID:                    e.UserID,
+		AdvanceNotificationPeriod: time.Duration(e.AdvanceNotificationPeriod),
+	}
+}
+
+func eventsV1FromStorage(events []storage.Event) []eventV1 {
+	if events == nil {
+		return nil
+	}
+	result := make([]eventV1, len(events))
+	for i, event := range events {
+		result[i] = eventV1FromStorage(event)
+	}
+
+	return result
+}
+
+// duration is a time.Duration written as a Go duration string, such as
+// "15m0s".
+type duration time.Duration
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string: %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("duration: %w", err)
+	}
+	*d = duration(parsed)
+
+	return nil
+}
+
+// eventV2 is an event in /v2. The id may be left out when creating.
+type eventV2 struct {
+	ID                        string    `json:"id"`
+	Title                     string    `json:"title"`
+	Date                      time.Time `json:"date"`
+	EndDate                   time.Time `json:"end_date"`
+	Description               string    `json:"description"`
+	UserID                    string    `json:"user_id"`
+	AdvanceNotificationPeriod duration  `json:"advance_notification_period"`
+}
+
+func eventV2FromStorage(event storage.Event) eventV2 {
+	return eventV2{
+		ID:                        event.ID,
+		Title:                     event.Title,
+		Date:                      event.Date,
+		EndDate:                   event.EndDate,
+		Description:               event.Description,
+		UserID:                    event.UserID,
+		AdvanceNotificationPeriod: duration(event.AdvanceNotificationPeriod),
+	}
+}
+
+func (e eventV2) toStorage() storage.Event {
+	return storage.Event{
+		ID:                        e.ID,
+		Title:                     e.Title,
+		Date:                      e.Date,
+		EndDate:                   e.EndDate,
+		Description:               e.Description,
+		UserID:                    e.UserID,
+		AdvanceNotificationPeriod: time.Duration(e.AdvanceNotificationPeriod),
+	}
+}
+
+// eventsV2FromStorage never returns nil, so an empty list is written as [].
+func eventsV2FromStorage(events []storage.Event) []eventV2 {
+	result := make([]eventV2, len(events))
+	for i, event := range events {
+		result[i] = eventV2FromStorage(event)
+	}
+
+	return result
+}
@@ -0,0 +1,222 @@
+package internalhttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	pb "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/api"
+	pbv2 "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/api/v2"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/app"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/auth"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/requestid"
+	internalgrpc "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/server/grpc"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// gatewayErrorDomain must match the ErrorInfo domain set by internalgrpc.
+const gatewayErrorDomain = "calendar"
+
+// gatewayRoutes are the google.api.http mappings of both versions of
+// EventService.proto.
+var gatewayRoutes = []string{
+	"POST /api/v1/events",
+	"GET /api/v1/events/{id}",
+	"PUT /api/v1/events/{id}",
+	"DELETE /api/v1/events/{id}",
+	"GET /api/v1/events/day/{date}",
+	"GET /api/v1/events/week/{date}",
+	"GET /api/v1/events/month/{date}",
+	"POST /api/v2/events",
+	"GET /api/v2/events/{id}",
+	"PUT /api/v2/events/{id}",
+	"DELETE /api/v2/events/{id}",
+	"GET /api/v2/events/day/{date}",
+	"GET /api/v2/events/week/{date}",
+	"GET /api/v2/events/month/{date}",
+}
+
+// NewGateway serves both versions of the gRPC Calendar service as JSON over
+// HTTP under /api/v1 and /api/v2, following the google.api.http annotations
+// in EventService.proto. Calls are proxied to the gRPC server at endpoint,
+// so its interceptors apply to them too. The server trusts the user and
+// client address passed on by the gateway only when opts dial it through
+// internalgrpc.Server.DialGateway. The connection is closed when ctx is
+// done.
+func NewGateway(ctx context.Context, endpoint string, opts ...grpc.DialOption) (http.Handler, error) {
+	mux := runtime.NewServeMux(
+		runtime.WithMarshalerOption(runtime.MIMEWildcard, newGatewayMarshaler()),
+		runtime.WithMetadata(traceMetadata),
+		runtime.WithMetadata(userMetadata),
+		runtime.WithMetadata(clientAddrMetadata),
+		runtime.WithMetadata(requestIDMetadata),
+		runtime.WithIncomingHeaderMatcher(gatewayHeaderMatcher),
+		runtime.WithErrorHandler(gatewayErrorHandler),
+	)
+
+	err := pb.RegisterCalendarHandlerFromEndpoint(ctx, mux, endpoint, opts)
+	if err != nil {
+		return nil, fmt.Errorf("internalhttp.NewGateway: %w", err)
+	}
+	err = pbv2.RegisterCalendarHandlerFromEndpoint(ctx, mux, endpoint, opts)
+	if err != nil {
+		return nil, fmt.Errorf("internalhttp.NewGateway: %w", err)
+	}
+
+	return mux, nil
+}
+
+// newGatewayMarshaler writes proto field names, like the legacy routes, and
+// always includes every field.
+func newGatewayMarshaler() *runtime.JSONPb {
+	return &runtime.JSONPb{
+		MarshalOptions:   protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true},
+		UnmarshalOptions: protojson.UnmarshalOptions{DiscardUnknown: true},
+	}
+}
+
+// metadataCarrier lets the propagator write into outgoing gRPC metadata,
+// whose keys must be lower case.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	values := c[strings.ToLower(key)]
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	c[strings.ToLower(key)] = []string{value}
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
+// traceMetadata continues the HTTP request's trace in the proxied gRPC call.
+func traceMetadata(ctx context.Context, _ *http.Request) metadata.MD {
+	md := metadata.MD{}
+	otel.GetTextMapPropagator().Inject(ctx, metadataCarrier(md))
+
+	return md
+}
+
+// userMetadata passes on the user authenticated by clientCertMiddleware.
+func userMetadata(ctx context.Context, _ *http.Request) metadata.MD {
+	userID, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	return metadata.Pairs(internalgrpc.ForwardedUserKey, userID)
+}
+
+// clientAddrMetadata passes on the address the client connected from, which
+// the gRPC server rate limits by.
+func clientAddrMetadata(_ context.Context, r *http.Request) metadata.MD {
+	return metadata.Pairs(internalgrpc.ForwardedAddrKey, r.RemoteAddr)
+}
+
+// requestIDMetadata passes on the ID given by requestIDMiddleware, so the
+// gRPC server logs the call under the same ID.
+func requestIDMetadata(ctx context.Context, _ *http.Request) metadata.MD {
+	id, ok := requestid.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	return metadata.Pairs(requestid.MetadataKey, id)
+}
+
+// reservedMetadata are the keys set by the gateway itself.
+var reservedMetadata = []string{
+	internalgrpc.ForwardedUserKey,
+	internalgrpc.ForwardedAddrKey,
+	requestid.MetadataKey,
+}
+
+// gatewayHeaderMatcher keeps clients from naming a user or an address
+// themselves through a Grpc-Metadata- header, and from sending a second
+// request ID that way.
+func gatewayHeaderMatcher(key string) (string, bool) {
+	name, ok := runtime.DefaultHeaderMatcher(key)
+	for _, reserved := range reservedMetadata {
+		if strings.EqualFold(name, reserved) {
+			return "", false
+		}
+	}
+
+	return name, ok
+}
+
+func gatewayErrorHandler(
+	_ context.Context,
+	_ *runtime.ServeMux,
+	_ runtime.Marshaler,
+	w http.ResponseWriter,
+	r *http.Request,
+	err error,
+) {
+	writeProblem(w, r, errorFromStatus(status.Convert(err)))
+}
+
+// errorFromStatus turns a gRPC status back into the app error it was made
+// from, so gateway and REST clients see the same problem documents.
+func errorFromStatus(st *status.Status) *app.Error {
+	appErr := &app.Error{Code: codeFromGRPC(st.Code()), Message: st.Message()}
+	for _, detail := range st.Details() {
+		switch d := detail.(type) {
+		case *errdetails.ErrorInfo:
+			if d.GetDomain() == gatewayErrorDomain {
+				appErr.Code = app.Code(strings.ToLower(d.GetReason()))
+			}
+		case *errdetails.BadRequest:
+			for _, v := range d.GetFieldViolations() {
+				appErr.Fields = append(appErr.Fields, app.FieldViolation{
+					Field:       v.GetField(),
+					Description: v.GetDescription(),
+				})
+			}
+		}
+	}
+	if appErr.Code == app.CodeInternal {
+		appErr.Message = "internal error"
+	}
+
+	return appErr
+}
+
+// codeFromGRPC classifies statuses that carry no ErrorInfo, such as the
+// gateway's own decoding errors.
+func codeFromGRPC(code codes.Code) app.Code {
+	switch code {
+	case codes.InvalidArgument:
+		return app.CodeInvalidArgument
+	case codes.NotFound:
+		return app.CodeNotFound
+	case codes.AlreadyExists:
+		return app.CodeAlreadyExists
+	case codes.ResourceExhausted:
+		return app.CodeRateLimited
+	case codes.Unavailable:
+		return app.CodeUnavailable
+	default:
+		return app.CodeInternal
+	}
+}
@@ -0,0 +1,398 @@
+package internalhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	pb "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/api"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/app"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/auth"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/clock"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/health"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/ratelimit"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/requestid"
+	internalgrpc "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/server/grpc"
+	grpcmocks "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/server/grpc/mocks"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/server/http/mocks"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/tlsconfig"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/tlsconfig/tlstest"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// newGatewayServer runs the real gRPC server, limited by limiter, and
+// returns the REST server with a gateway connected to it in-process.
+func newGatewayServer(t *testing.T, application *grpcmocks.Application, limiter *ratelimit.Limiter) http.Handler {
+	t.Helper()
+	logg := newLogger(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	grpcServer := internalgrpc.NewServer(logg, application, health.New(health.DefaultTimeout), limiter, nil, "", "")
+	go grpcServer.Serve(l)
+	t.Cleanup(grpcServer.Stop)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	gateway, err := NewGateway(ctx, "passthrough:///"+l.Addr().String(),
+		grpc.WithContextDialer(grpcServer.DialGateway),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+
+	server := NewServer(logg, mocks.NewApplication(t), health.New(health.DefaultTimeout), nil, gateway, nil, "", "")
+	return server.server.Handler
+}
+
+func TestGateway(t *testing.T) {
+	const id = "66be96d3-3d5d-4aec-af9c-5b3769d0169a"
+	date := time.Date(2024, time.September, 23, 0, 0, 0, 0, time.UTC)
+	event := storage.Event{
+		ID:                        id,
+		Title:                     "test",
+		UserID:                    id,
+		Date:                      date,
+		EndDate:                   date.Add(time.Hour),
+		AdvanceNotificationPeriod: time.Hour,
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		body        string
+		setup       func(*grpcmocks.Application)
+		status      int
+		contentType string
+		want        string
+	}{
+		{
+			name:   "get event",
+			method: http.MethodGet,
+			target: "/api/v1/events/" + id,
+			setup: func(a *grpcmocks.Application) {
+				a.On("GetEvent", mock.Anything, id).Return(&event, nil)
+			},
+			status:      http.StatusOK,
+			contentType: "application/json",
+			want: `{"event": {
+				"id": "66be96d3-3d5d-4aec-af9c-5b3769d0169a",
+				"title": "test",
+				"date": "1727049600",
+				"end_date": "1727053200",
+				"description": "",
+				"user_id": "66be96d3-3d5d-4aec-af9c-5b3769d0169a",
+				"advance_notification_period": "3600"
+			}}`,
+		},
+		{
+			name:   "create event",
+			method: http.MethodPost,
+			target: "/api/v1/events",
+			body: `{"id": "66be96d3-3d5d-4aec-af9c-5b3769d0169a", "title": "test",
+				"user_id": "66be96d3-3d5d-4aec-af9c-5b3769d0169a", "date": "1727049600", "end_date": 1727053200}`,
+			setup: func(a *grpcmocks.Application) {
+				created := event
+				created.AdvanceNotificationPeriod = 0
+				a.On("CreateEvent", mock.Anything, created).Return(nil)
+			},
+			status:      http.StatusOK,
+			contentType: "application/json",
+			want:        `{}`,
+		},
+		{
+			name:   "list events",
+			method: http.MethodGet,
+			target: "/api/v1/events/week/1727049600",
+			setup: func(a *grpcmocks.Application) {
+				a.On("GetEventsListWeek", mock.Anything, date).Return([]storage.Event{}, nil)
+			},
+			status:      http.StatusOK,
+			contentType: "application/json",
+			want:        `{"events": []}`,
+		},
+		{
+			name:   "get event v2",
+			method: http.MethodGet,
+			target: "/api/v2/events/" + id,
+			setup: func(a *grpcmocks.Application) {
+				a.On("GetEvent", mock.Anything, id).Return(&event, nil)
+			},
+			status:      http.StatusOK,
+			contentType: "application/json",
+			want: `{"event": {
+				"id": "66be96d3-3d5d-4aec-af9c-5b3769d0169a",
+				"title": "test",
+				"date": "2024-09-23T00:00:00Z",
+				"end_date": "2024-09-23T01:00:00Z",
+				"description": "",
+				"user_id": "66be96d3-3d5d-4aec-af9c-5b3769d0169a",
+				"advance_notification_period": "3600s"
+			}}`,
+		},
+		{
+			name:   "list events v2",
+			method: http.MethodGet,
+			target: "/api/v2/events/week/2024-09-23T00:00:00Z",
+			setup: func(a *grpcmocks.Application) {
+				a.On("GetEventsListWeek", mock.Anything, date).Return([]storage.Event{}, nil)
+			},
+			status:      http.StatusOK,
+			contentType: "application/json",
+			want:        `{"events": []}`,
+		},
+		{
+			name:   "not found",
+			method: http.MethodDelete,
+			target: "/api/v1/events/" + id,
+			setup: func(a *grpcmocks.Application) {
+				a.On("DeleteEvent", mock.Anything, id).Return(storage.ErrEventDoesntExist)
+			},
+			status:      http.StatusNotFound,
+			contentType: "application/problem+json",
+			want: `{"type": "about:blank", "title": "Not Found", "status": 404, "detail": "event not found",
+				"instance": "/api/v1/events/66be96d3-3d5d-4aec-af9c-5b3769d0169a", "code": "not_found"}`,
+		},
+		{
+			name:        "validation failed",
+			method:      http.MethodPut,
+			target:      "/api/v1/events/" + id,
+			body:        `{"id": "1", "title": "test", "user_id": "66be96d3-3d5d-4aec-af9c-5b3769d0169a"}`,
+			setup:       func(*grpcmocks.Application) {},
+			status:      http.StatusBadRequest,
+			contentType: "application/problem+json",
+			want: `{"type": "about:blank", "title": "Bad Request", "status": 400, "detail": "validation failed",
+				"instance": "/api/v1/events/66be96d3-3d5d-4aec-af9c-5b3769d0169a", "code": "invalid_argument",
+				"invalid_params": [{"name": "id", "reason": "not valid uuid"}]}`,
+		},
+		{
+			name:        "malformed body",
+			method:      http.MethodPost,
+			target:      "/api/v1/events",
+			body:        `{"title": `,
+			setup:       func(*grpcmocks.Application) {},
+			status:      http.StatusBadRequest,
+			contentType: "application/problem+json",
+		},
+		{
+			name:   "internal error",
+			method: http.MethodGet,
+			target: "/api/v1/events/" + id,
+			setup: func(a *grpcmocks.Application) {
+				a.On("GetEvent", mock.Anything, id).Return(nil, errors.New("pq: connection refused"))
+			},
+			status:      http.StatusInternalServerError,
+			contentType: "application/problem+json",
+			want: `{"type": "about:blank", "title": "Internal Server Error", "status": 500, "detail": "internal error",
+				"instance": "/api/v1/events/66be96d3-3d5d-4aec-af9c-5b3769d0169a", "code": "internal"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			application := grpcmocks.NewApplication(t)
+			tt.setup(application)
+			handler := newGatewayServer(t, application, nil)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, bytes.NewBufferString(tt.body)))
+
+			require.Equal(t, tt.status, w.Code, w.Body.String())
+			require.Equal(t, tt.contentType, w.Header().Get("Content-Type"))
+			if tt.want != "" {
+				require.JSONEq(t, tt.want, w.Body.String())
+			} else {
+				require.True(t, json.Valid(w.Body.Bytes()))
+			}
+		})
+	}
+}
+
+// TestGatewayRateLimit checks that clients of the gateway are limited by
+// the address they connect from, whatever X-Forwarded-For they send.
+func TestGatewayRateLimit(t *testing.T) {
+	const id = "66be96d3-3d5d-4aec-af9c-5b3769d0169a"
+	event := storage.Event{ID: id, Title: "test", UserID: id}
+	limiter := ratelimit.New(ratelimit.Config{Default: ratelimit.Rule{Rate: 0.001, Burst: 1}},
+		clock.NewFake(time.Date(2024, time.October, 1, 0, 0, 0, 0, time.UTC)))
+
+	application := grpcmocks.NewApplication(t)
+	application.On("GetEvent", mock.Anything, id).Return(&event, nil).Twice()
+	handler := newGatewayServer(t, application, limiter)
+
+	get := func(remoteAddr, forwardedFor string) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/events/"+id, nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		req.Header.Set("Grpc-Metadata-X-Forwarded-Client-Addr", forwardedFor+":1")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusOK, get("10.0.0.1:1000", "1.1.1.1"))
+	require.Equal(t, http.StatusTooManyRequests, get("10.0.0.1:1000", "2.2.2.2"))
+	require.Equal(t, http.StatusTooManyRequests, get("10.0.0.1:1001", "3.3.3.3"))
+	require.Equal(t, http.StatusOK, get("10.0.0.2:1000", "1.1.1.1"), "other clients have their own bucket")
+}
+
+func TestErrorFromStatusWithoutDetails(t *testing.T) {
+	appErr := errorFromStatus(status.New(codes.NotFound, "Not Found"))
+	require.Equal(t, app.CodeNotFound, appErr.Code)
+
+	appErr = errorFromStatus(status.New(codes.Unknown, "pq: connection refused"))
+	require.Equal(t, app.CodeInternal, appErr.Code)
+	require.Equal(t, "internal error", appErr.Message)
+}
+
+func userIs(want string) interface{} {
+	return mock.MatchedBy(func(ctx context.Context) bool {
+		got, _ := auth.UserFromContext(ctx)
+		return got == want
+	})
+}
+
+// TestMutualTLS serves both ports with client certificates required and
+// checks that the user named by the client's certificate reaches the
+// application through the REST routes and through the gateway.
+func TestMutualTLS(t *testing.T) {
+	const id = "66be96d3-3d5d-4aec-af9c-5b3769d0169a"
+	event := storage.Event{ID: id, Title: "test", UserID: id}
+	logg := newLogger(t)
+
+	ca := tlstest.NewCA(t, "calendar CA")
+	certFile, keyFile := ca.Server(t, "calendar")
+	certs, err := tlsconfig.New(tlsconfig.Config{CertFile: certFile, KeyFile: keyFile, ClientCAFile: ca.CertFile}, logg)
+	require.NoError(t, err)
+	serverTLS := certs.ServerConfig()
+
+	grpcApplication := grpcmocks.NewApplication(t)
+	grpcApplication.On("GetEvent", userIs("alice"), id).Return(&event, nil).Twice()
+	grpcApplication.On("GetEvent", userIs("bob"), id).Return(&event, nil).Once()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	grpcServer := internalgrpc.NewServer(logg, grpcApplication, health.New(health.DefaultTimeout), nil, serverTLS, "", "")
+	go grpcServer.Serve(l)
+	t.Cleanup(grpcServer.Stop)
+
+	gatewayCert, gatewayKey := ca.Client(t, "gateway")
+	gatewayTLS, err := tlsconfig.ClientConfig(ca.CertFile, gatewayCert, gatewayKey, "localhost")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	gateway, err := NewGateway(ctx, "passthrough:///"+l.Addr().String(),
+		grpc.WithContextDialer(grpcServer.DialGateway),
+		grpc.WithTransportCredentials(credentials.NewTLS(gatewayTLS)))
+	require.NoError(t, err)
+
+	application := mocks.NewApplication(t)
+	application.On("GetEvent", userIs("alice"), id).Return(&event, nil).Once()
+	server := NewServer(logg, application, health.New(health.DefaultTimeout), nil, gateway, serverTLS, "", "")
+	ts := httptest.NewUnstartedServer(server.server.Handler)
+	ts.Listener = tls.NewListener(ts.Listener, serverTLS)
+	ts.Start()
+	t.Cleanup(ts.Close)
+
+	aliceCert, aliceKey := ca.Client(t, "alice")
+	aliceTLS, err := tlsconfig.ClientConfig(ca.CertFile, aliceCert, aliceKey, "localhost")
+	require.NoError(t, err)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: aliceTLS}}
+	base := "https://" + ts.Listener.Addr().String()
+
+	for _, target := range []string{"/v2/events/" + id, "/api/v2/events/" + id} {
+		resp, err := client.Get(base + target)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode, target)
+	}
+
+	// Clients cannot name another user through gRPC metadata headers.
+	req, err := http.NewRequest(http.MethodGet, base+"/api/v1/events/"+id, nil)
+	require.NoError(t, err)
+	req.Header.Set("Grpc-Metadata-X-Forwarded-User", "mallory")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Nor can local gRPC clients, which are not the gateway however they
+	// connect.
+	bobCert, bobKey := ca.Client(t, "bob")
+	bobTLS, err := tlsconfig.ClientConfig(ca.CertFile, bobCert, bobKey, "localhost")
+	require.NoError(t, err)
+	conn, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(bobTLS)))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	_, err = pb.NewCalendarClient(conn).GetEvent(
+		metadata.AppendToOutgoingContext(context.Background(), internalgrpc.ForwardedUserKey, "alice"),
+		&pb.GetEventRequest{Id: id})
+	require.NoError(t, err)
+
+	noCertTLS, err := tlsconfig.ClientConfig(ca.CertFile, "", "", "localhost")
+	require.NoError(t, err)
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: noCertTLS}}
+	_, err = client.Get(base + "/v2/events/" + id)
+	require.Error(t, err)
+}
+
+func requestIDIs(want string) interface{} {
+	return mock.MatchedBy(func(ctx context.Context) bool {
+		got, _ := requestid.FromContext(ctx)
+		return got == want
+	})
+}
+
+// TestRequestID checks that the ID a client sends, or the one made up for
+// it, reaches the application through the gateway and comes back.
+func TestRequestID(t *testing.T) {
+	const id = "66be96d3-3d5d-4aec-af9c-5b3769d0169a"
+	event := storage.Event{ID: id, Title: "test", UserID: id}
+
+	application := grpcmocks.NewApplication(t)
+	application.On("GetEvent", requestIDIs("abc-123"), id).Return(&event, nil).Once()
+	var generated string
+	application.On("GetEvent", mock.MatchedBy(func(ctx context.Context) bool {
+		generated, _ = requestid.FromContext(ctx)
+		return generated != "abc-123"
+	}), id).Return(&event, nil).Twice()
+	handler := newGatewayServer(t, application, nil)
+
+	get := func(header map[string]string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/events/"+id, nil)
+		for key, value := range header {
+			req.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		return w
+	}
+
+	w := get(map[string]string{requestid.Header: "abc-123"})
+	require.Equal(t, "abc-123", w.Header().Get(requestid.Header))
+
+	w = get(map[string]string{requestid.Header: "two words"})
+	require.NotEqual(t, "two words", w.Header().Get(requestid.Header))
+	require.Equal(t, generated, w.Header().Get(requestid.Header))
+
+	// A second ID in a gRPC metadata header is ignored.
+	w = get(map[string]string{"Grpc-Metadata-X-Request-Id": "abc-123"})
+	require.Equal(t, generated, w.Header().Get(requestid.Header))
+}
@@ -1,44 +1,60 @@
 package internalhttp
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/app"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/validator"
 )
 
-func (s *Server) createEventHandler(w http.ResponseWriter, r *http.Request) {
-	logg := s.logger.With("handler", "createEventHandler")
-	var event storage.Event
+// decodeJSON reads the request body into dst.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
 	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
-	err := json.NewDecoder(r.Body).Decode(&event)
+	err := json.NewDecoder(r.Body).Decode(dst)
 	if err != nil {
-		logg.Error("event failed to decode json", "error", err)
-		s.errorResponse(w, http.StatusBadRequest, "Bad request")
-		return
+		return &app.Error{Code: app.CodeInvalidArgument, Message: "malformed request body", Err: err}
 	}
 
+	return nil
+}
+
+func validateEvent(event storage.Event) error {
 	validator := validator.New()
 	storage.ValidateEvent(*validator, event)
 	if !validator.Valid() {
-		logg.Warn("event validation failed", "error", validator.Errors)
-		s.errorResponse(w, http.StatusPartialContent, validator.Errors)
+		return app.ValidationError(validator.Errors)
+	}
+
+	return nil
+}
+
+// decodeEvent reads and validates the v1 event in the request body.
+func decodeEvent(w http.ResponseWriter, r *http.Request) (storage.Event, error) {
+	var dto eventV1
+	if err := decodeJSON(w, r, &dto); err != nil {
+		return storage.Event{}, err
+	}
+	event := dto.toStorage()
+
+	return event, validateEvent(event)
+}
+
+func (s *Server) createEventHandler(w http.ResponseWriter, r *http.Request) {
+	logg := s.logger.With("handler", "createEventHandler")
+	event, err := decodeEvent(w, r)
+	if err != nil {
+		s.fail(w, r, logg, "invalid event", err)
 		return
 	}
 
 	err = s.app.CreateEvent(r.Context(), event)
 	if err != nil {
-		if errors.Is(err, storage.ErrEventAlreadyExists) {
-			logg.Warn("event already exist")
-			s.errorResponse(w, http.StatusConflict, "Event already exist")
-			return
-		}
-		logg.Error("failed create event", "error", err)
-		s.errorResponse(w, http.StatusInternalServerError, "Unknown error")
+		s.fail(w, r, logg, "failed create event", err)
 		return
 	}
 
@@ -51,17 +67,11 @@ func (s *Server) getEventHandler(w http.ResponseWriter, r *http.Request) {
 
 	event, err := s.app.GetEvent(r.Context(), id)
 	if err != nil {
-		if errors.Is(err, storage.ErrEventDoesntExist) {
-			logg.Warn("event not found")
-			s.errorResponse(w, http.StatusNotFound, "Event not found")
-			return
-		}
-		logg.Error("failed get event", "error", err)
-		s.errorResponse(w, http.StatusInternalServerError, "Unknown error")
+		s.fail(w, r, logg, "failed get event", err)
 		return
 	}
 
-	s.writeJSON(w, http.StatusOK, wrapper{"event": event})
+	s.writeJSON(w, http.StatusOK, wrapper{"event": eventV1FromStorage(*event)})
 }
 
 func (s *Server) deleteEventHandler(w http.ResponseWriter, r *http.Request) {
@@ -70,13 +80,7 @@ func (s *Server) deleteEventHandler(w http.ResponseWriter, r *http.Request) {
 
 	err := s.app.DeleteEvent(r.Context(), id)
 	if err != nil {
-		if errors.Is(err, storage.ErrEventDoesntExist) {
-			logg.Warn("Delete event not found")
-			s.errorResponse(w, http.StatusNotFound, "Event not found")
-			return
-		}
-		logg.Error("Failed delete event", "error", err)
-		s.errorResponse(w, http.StatusInternalServerError, "Unknown error")
+		s.fail(w, r, logg, "failed delete event", err)
 		return
 	}
 
@@ -85,33 +89,16 @@ func (s *Server) deleteEventHandler(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) editEventHandler(w http.ResponseWriter, r *http.Request) {
 	logg := s.logger.With("handler", "editEventHandler")
-	var event storage.Event
-	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
-	err := json.NewDecoder(r.Body).Decode(&event)
+	event, err := decodeEvent(w, r)
 	if err != nil {
-		logg.Error("failed to decode json", "error", err)
-		s.errorResponse(w, http.StatusBadRequest, "Bad request")
+		s.fail(w, r, logg, "invalid event", err)
 		return
 	}
 	id := r.PathValue("id")
 
-	validator := validator.New()
-	storage.ValidateEvent(*validator, event)
-	if !validator.Valid() {
-		logg.Warn("event validation failed", "error", validator.Errors)
-		s.errorResponse(w, http.StatusPartialContent, validator.Errors)
-		return
-	}
-
 	err = s.app.EditEvent(r.Context(), id, event)
 	if err != nil {
-		if errors.Is(err, storage.ErrEventDoesntExist) {
-			logg.Warn("event not found")
-			s.errorResponse(w, http.StatusNotFound, "Event not found")
-			return
-		}
-		logg.Error("failed edit event", "error", err)
-		s.errorResponse(w, http.StatusInternalServerError, "Unknown error")
+		s.fail(w, r, logg, "failed edit event", err)
 		return
 	}
 
@@ -123,80 +110,59 @@ func getDateParam(r *http.Request) (time.Time, error) {
 
 	date, err := time.Parse("2006-01-02", param)
 	if err != nil {
-		return time.Time{}, fmt.Errorf("wrong date parameter")
+		appErr := app.ValidationError(map[string]string{"date": "must be YYYY-MM-DD"})
+		appErr.Err = fmt.Errorf("wrong date parameter: %w", err)
+		return time.Time{}, appErr
 	}
 
 	return date, nil
 }
 
-func (s *Server) getEventsDayHandler(w http.ResponseWriter, r *http.Request) {
-	logg := s.logger.With("handler", "getEventsDayEventHandler")
+// listEvents runs list for the date in the path. On failure it writes the
+// error response and returns false.
+func (s *Server) listEvents(
+	w http.ResponseWriter,
+	r *http.Request,
+	logg Logger,
+	list func(context.Context, time.Time) ([]storage.Event, error),
+) ([]storage.Event, bool) {
 	date, err := getDateParam(r)
 	if err != nil {
-		logg.Warn("wrong date parameter")
-		s.errorResponse(w, http.StatusBadRequest, "Wrong date parameter")
-		return
+		s.fail(w, r, logg, "wrong date parameter", err)
+		return nil, false
 	}
 
-	events, err := s.app.GetEventsListDay(r.Context(), date)
+	events, err := list(r.Context(), date)
 	if err != nil {
-		if errors.Is(err, storage.ErrNoEventsFound) {
-			logg.Warn("no events found for day %s", date.Format("2006-01-02"))
-			s.errorResponse(w, http.StatusNotFound, "No events found")
-			return
-		}
-		logg.Error("failed get day events")
-		s.errorResponse(w, http.StatusInternalServerError, "Unknown error")
-		return
+		s.fail(w, r, logg, "failed get events", err)
+		return nil, false
 	}
 
-	s.writeJSON(w, http.StatusOK, wrapper{"events": events})
+	return events, true
 }
 
-func (s *Server) getEventsWeekHandler(w http.ResponseWriter, r *http.Request) {
-	logg := s.logger.With("handler", "getEventsWeekEventHandler")
-	date, err := getDateParam(r)
-	if err != nil {
-		logg.Warn("wrong date parameter")
-		s.errorResponse(w, http.StatusBadRequest, "Wrong date parameter")
-		return
-	}
-
-	events, err := s.app.GetEventsListWeek(r.Context(), date)
-	if err != nil {
-		if errors.Is(err, storage.ErrNoEventsFound) {
-			logg.Warn("no events found for day %s", date.Format("2006-01-02"))
-			s.errorResponse(w, http.StatusNotFound, "No events found")
-			return
-		}
-		logg.Error("failed get day events")
-		s.errorResponse(w, http.StatusInternalServerError, "Unknown error")
+func (s *Server) getEventsDateHandler(
+	w http.ResponseWriter,
+	r *http.Request,
+	handler string,
+	list func(context.Context, time.Time) ([]storage.Event, error),
+) {
+	events, ok := s.listEvents(w, r, s.logger.With("handler", handler), list)
+	if !ok {
 		return
 	}
 
-	s.writeJSON(w, http.StatusOK, wrapper{"events": events})
+	s.writeJSON(w, http.StatusOK, wrapper{"events": eventsV1FromStorage(events)})
 }
 
-func (s *Server) getEventsMonthHandler(w http.ResponseWriter, r *http.Request) {
-	logg := s.logger.With("handler", "getEventsMonthEventHandler")
-	date, err := getDateParam(r)
-	if err != nil {
-		logg.Warn("wrong date parameter")
-		s.errorResponse(w, http.StatusBadRequest, "Wrong date parameter")
-		return
-	}
+func (s *Server) getEventsDayHandler(w http.ResponseWriter, r *http.Request) {
+	s.getEventsDateHandler(w, r, "getEventsDayEventHandler", s.app.GetEventsListDay)
+}
 
-	events, err := s.app.GetEventsListMonth(r.Context(), date)
-	if err != nil {
-		if errors.Is(err, storage.ErrNoEventsFound) {
-			logg.Warn("no events found for day %s", date.Format("2006-01-02"))
-			s.errorResponse(w, http.StatusNotFound, "No events found")
-			return
-		}
-		logg.Error("failed get day events")
-		s.errorResponse(w, http.StatusInternalServerError, "Unknown error")
-		return
-	}
+func (s *Server) getEventsWeekHandler(w http.ResponseWriter, r *http.Request) {
+	s.getEventsDateHandler(w, r, "getEventsWeekEventHandler", s.app.GetEventsListWeek)
+}
 
-	s.writeJSON(w, http.StatusOK, wrapper{"events": events})
+func (s *Server) getEventsMonthHandler(w http.ResponseWriter, r *http.Request) {
+	s.getEventsDateHandler(w, r, "getEventsMonthEventHandler", s.app.GetEventsListMonth)
 }
@@ -2,13 +2,16 @@ package internalhttp
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/app"
 	logger "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger/slog"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/server/http/mocks"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
@@ -35,345 +38,320 @@ func newServer(t *testing.T, path string, handler http.Handler) *http.Server {
 	}
 }
 
-func TestGetEventHandler(t *testing.T) {
-	tests := []struct {
-		name    string
-		returns []interface{}
-		want    string
-		status  int
-	}{
-		{
-			name:    "success",
-			returns: []interface{}{&storage.Event{ID: "1", Title: "test"}, nil},
-			status:  http.StatusOK,
-			want: `{
-	"event": {
-		"id": "1",
-		"title": "test",
-		"date": "0001-01-01T00:00:00Z",
-		"end_date": "0001-01-01T00:00:00Z",
-		"description": "",
-		"user_id": "",
-		"advance_notification_period": 0
-	}
-}`,
-		},
-		{
-			name:    "not found",
-			returns: []interface{}{nil, storage.ErrEventDoesntExist},
-			status:  http.StatusNotFound,
-			want: `{
-	"error": "Event not found"
-}`,
-		},
-		{
-			name:    "internal error",
-			returns: []interface{}{nil, errors.New("internal error")},
-			status:  http.StatusInternalServerError,
-			want: `{
-	"error": "Unknown error"
-}`,
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			req := httptest.NewRequest(http.MethodGet, "/event/1", nil)
-			w := httptest.NewRecorder()
-
-			logg := newLogger(t)
-			app := mocks.NewApplication(t)
-			app.On("GetEvent", mock.Anything, "1").Return(tt.returns...)
-
-			server := &Server{
-				logger: logg,
-				app:    app,
-			}
-			server.server = newServer(t, "GET /event/{id}", http.HandlerFunc(server.getEventHandler))
-			server.server.Handler.ServeHTTP(w, req)
-
-			require.Equal(t, tt.status, w.Code)
-			require.Equal(t, tt.want, w.Body.String())
-		})
-	}
-}
-
-func TestCreateEventHandler(t *testing.T) {
-	eventArg := storage.Event{
-		ID:                        "66be96d3-3d5d-4aec-af9c-5b3769d0169a",
-		Title:                     "test",
-		UserID:                    "66be96d3-3d5d-4aec-af9c-5b3769d0169a",
-		Date:                      time.Date(2024, time.September, 23, 0, 0, 0, 0, time.UTC),
-		EndDate:                   time.Date(2024, time.September, 25, 0, 0, 0, 0, time.UTC),
-		Description:               "",
-		AdvanceNotificationPeriod: 0,
-	}
-	event := `{
+const (
+	validEventBody = `{
 	"id": "66be96d3-3d5d-4aec-af9c-5b3769d0169a",
 	"title": "test",
 	"user_id": "66be96d3-3d5d-4aec-af9c-5b3769d0169a",
 	"date": "2024-09-23T00:00:00Z",
 	"end_date": "2024-09-25T00:00:00Z"
 }`
-	tests := []struct {
-		name    string
-		body    string
-		returns []interface{}
-		want    string
-		status  int
-	}{
-		{
-			name:    "success",
-			body:    event,
-			returns: []interface{}{nil},
-			status:  http.StatusOK,
-			want: `{
-	"message": "Success"
-}`,
-		},
-		{
-			name:    "event already exist",
-			body:    event,
-			returns: []interface{}{storage.ErrEventAlreadyExists},
-			status:  http.StatusConflict,
-			want: `{
-	"error": "Event already exist"
-}`,
-		},
-		{
-			name:    "event already exist",
-			body:    event,
-			returns: []interface{}{errors.New("internal error")},
-			status:  http.StatusInternalServerError,
-			want: `{
-	"error": "Unknown error"
-}`,
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			req := httptest.NewRequest(http.MethodPost, "/event/create", bytes.NewBufferString(tt.body))
-			w := httptest.NewRecorder()
-
-			logg := newLogger(t)
-			app := mocks.NewApplication(t)
-			app.On("CreateEvent", mock.Anything, eventArg).Return(tt.returns...)
-
-			server := &Server{
-				logger: logg,
-				app:    app,
-			}
-			server.server = newServer(t, "POST /event/create", http.HandlerFunc(server.createEventHandler))
-			server.server.Handler.ServeHTTP(w, req)
-
-			require.Equal(t, tt.status, w.Code)
-			require.Equal(t, tt.want, w.Body.String())
-		})
-	}
-}
-
-func TestCreateEventHandlerValidation(t *testing.T) {
-	body := `{
+	invalidEventBody = `{
 	"id": "66be96d3-3d5d-4aec",
 	"title": "testtitletoolongtesttitletoolong",
 	"user_id": "66be96d3-3d5d-4aec",
 	"date": "2024-09-23T00:00:00Z",
 	"end_date": "2024-09-20T00:00:00Z"
 }`
-	req := httptest.NewRequest(http.MethodPost, "/event/create", bytes.NewBufferString(body))
-	w := httptest.NewRecorder()
+	successBody = `{
+	"message": "Success"
+}`
+)
 
-	logg := newLogger(t)
-	app := mocks.NewApplication(t)
+var validEvent = storage.Event{
+	ID:      "66be96d3-3d5d-4aec-af9c-5b3769d0169a",
+	Title:   "test",
+	UserID:  "66be96d3-3d5d-4aec-af9c-5b3769d0169a",
+	Date:    time.Date(2024, time.September, 23, 0, 0, 0, 0, time.UTC),
+	EndDate: time.Date(2024, time.September, 25, 0, 0, 0, 0, time.UTC),
+}
 
-	server := &Server{
-		logger: logg,
-		app:    app,
-	}
-	server.server = newServer(t, "POST /event/create", http.HandlerFunc(server.createEventHandler))
-	server.server.Handler.ServeHTTP(w, req)
+var eventViolations = []invalidParam{
+	{Name: "end_date", Reason: "too early"},
+	{Name: "id", Reason: "not valid uuid"},
+	{Name: "title", Reason: "too long"},
+	{Name: "user_id", Reason: "not valid uuid"},
+}
 
-	require.Equal(t, http.StatusPartialContent, w.Code)
-	require.Equal(t, `{
-	"error": {
-		"end_date": "too early",
-		"id": "not valid uuid",
-		"title": "too long",
-		"user_id": "not valid uuid"
+func newProblem(status int, code app.Code, detail, instance string, params ...invalidParam) *problem {
+	return &problem{
+		Type:          "about:blank",
+		Title:         http.StatusText(status),
+		Status:        status,
+		Detail:        detail,
+		Instance:      instance,
+		Code:          code,
+		InvalidParams: params,
 	}
-}`, w.Body.String())
 }
 
-func TestDeleteEventHandler(t *testing.T) {
-	tests := []struct {
-		name    string
-		returns []interface{}
-		want    string
-		status  int
-	}{
-		{
-			name:    "success",
-			returns: []interface{}{nil},
-			status:  http.StatusOK,
-			want: `{
-	"message": "Success"
-}`,
-		},
-		{
-			name:    "not found",
-			returns: []interface{}{storage.ErrEventDoesntExist},
-			status:  http.StatusNotFound,
-			want: `{
-	"error": "Event not found"
-}`,
-		},
-		{
-			name:    "internal error",
-			returns: []interface{}{errors.New("internal error")},
-			status:  http.StatusInternalServerError,
-			want: `{
-	"error": "Unknown error"
-}`,
-		},
-	}
+// handlerCase is one request against a handler. call is nil when the
+// request must be rejected before reaching the app. Successful responses
+// are compared with want, failures with problem.
+type handlerCase struct {
+	name    string
+	body    string
+	call    []interface{}
+	returns []interface{}
+	status  int
+	want    string
+	problem *problem
+}
+
+func runHandlerCases(
+	t *testing.T,
+	pattern, method, target string,
+	handler func(*Server) http.HandlerFunc,
+	tests []handlerCase,
+) {
+	t.Helper()
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			req := httptest.NewRequest(http.MethodDelete, "/event/delete/1", nil)
+			req := httptest.NewRequest(method, target, bytes.NewBufferString(tt.body))
 			w := httptest.NewRecorder()
 
-			logg := newLogger(t)
-			app := mocks.NewApplication(t)
-			app.On("DeleteEvent", mock.Anything, "1").Return(tt.returns...)
+			application := mocks.NewApplication(t)
+			if tt.call != nil {
+				application.On(tt.call[0].(string), tt.call[1:]...).Return(tt.returns...)
+			}
 
 			server := &Server{
-				logger: logg,
-				app:    app,
+				logger: newLogger(t),
+				app:    application,
 			}
-			server.server = newServer(t, "DELETE /event/delete/{id}", http.HandlerFunc(server.deleteEventHandler))
+			server.server = newServer(t, pattern, handler(server))
 			server.server.Handler.ServeHTTP(w, req)
 
 			require.Equal(t, tt.status, w.Code)
-			require.Equal(t, tt.want, w.Body.String())
+			if tt.problem == nil {
+				require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+				require.Equal(t, tt.want, w.Body.String())
+				return
+			}
+
+			require.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+			var got problem
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+			require.Equal(t, *tt.problem, got)
 		})
 	}
 }
 
-func TestEditEventHandler(t *testing.T) {
-	eventArg := storage.Event{
-		ID:                        "66be96d3-3d5d-4aec-af9c-5b3769d0169a",
-		Title:                     "test",
-		UserID:                    "66be96d3-3d5d-4aec-af9c-5b3769d0169a",
-		Date:                      time.Date(2024, time.September, 23, 0, 0, 0, 0, time.UTC),
-		EndDate:                   time.Date(2024, time.September, 25, 0, 0, 0, 0, time.UTC),
-		Description:               "",
-		AdvanceNotificationPeriod: 0,
-	}
-	event := `{
-	"id": "66be96d3-3d5d-4aec-af9c-5b3769d0169a",
-	"title": "test",
-	"user_id": "66be96d3-3d5d-4aec-af9c-5b3769d0169a",
-	"date": "2024-09-23T00:00:00Z",
-	"end_date": "2024-09-25T00:00:00Z"
-}`
-	tests := []struct {
-		name    string
-		body    string
-		returns []interface{}
-		want    string
-		status  int
-	}{
-		{
-			name:    "success",
-			body:    event,
-			returns: []interface{}{nil},
-			status:  http.StatusOK,
-			want: `{
-	"message": "Success"
-}`,
-		},
-		{
-			name:    "not found",
-			body:    event,
-			returns: []interface{}{storage.ErrEventDoesntExist},
-			status:  http.StatusNotFound,
-			want: `{
-	"error": "Event not found"
-}`,
-		},
+func TestErrorResponseFormat(t *testing.T) {
+	w := httptest.NewRecorder()
+	server := &Server{logger: newLogger(t)}
+	server.errorResponse(w, httptest.NewRequest(http.MethodPost, "/event/create", nil),
+		app.ValidationError(map[string]string{"title": "too long"}))
+
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+	require.Equal(t, `{
+	"type": "about:blank",
+	"title": "Bad Request",
+	"status": 400,
+	"detail": "validation failed",
+	"instance": "/event/create",
+	"code": "invalid_argument",
+	"invalid_params": [
 		{
-			name:    "event already exist",
-			body:    event,
-			returns: []interface{}{errors.New("internal error")},
-			status:  http.StatusInternalServerError,
-			want: `{
-	"error": "Unknown error"
-}`,
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			req := httptest.NewRequest(http.MethodPut, "/event/edit/1", bytes.NewBufferString(tt.body))
-			w := httptest.NewRecorder()
+			"name": "title",
+			"reason": "too long"
+		}
+	]
+}`, w.Body.String())
+}
 
-			logg := newLogger(t)
-			app := mocks.NewApplication(t)
-			app.On("EditEvent", mock.Anything, "1", eventArg).Return(tt.returns...)
+func TestErrorResponseHidesInternalErrors(t *testing.T) {
+	w := httptest.NewRecorder()
+	server := &Server{logger: newLogger(t)}
+	server.errorResponse(w, httptest.NewRequest(http.MethodGet, "/event/1", nil),
+		errors.New("pq: connection refused"))
 
-			server := &Server{
-				logger: logg,
-				app:    app,
-			}
-			server.server = newServer(t, "PUT /event/edit/{id}", http.HandlerFunc(server.editEventHandler))
-			server.server.Handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	require.NotContains(t, w.Body.String(), "connection refused")
+}
 
-			require.Equal(t, tt.status, w.Code)
-			require.Equal(t, tt.want, w.Body.String())
-		})
+func TestGetEventHandler(t *testing.T) {
+	const instance = "/event/1"
+	runHandlerCases(t, "GET /event/{id}", http.MethodGet, instance,
+		func(s *Server) http.HandlerFunc { return s.getEventHandler },
+		[]handlerCase{
+			{
+				name:    "success",
+				call:    []interface{}{"GetEvent", mock.Anything, "1"},
+				returns: []interface{}{&storage.Event{ID: "1", Title: "test"}, nil},
+				status:  http.StatusOK,
+				want: `{
+	"event": {
+		"id": "1",
+		"title": "test",
+		"date": "0001-01-01T00:00:00Z",
+		"end_date": "0001-01-01T00:00:00Z",
+		"description": "",
+		"user_id": "",
+		"advance_notification_period": 0
 	}
+}`,
+			},
+			{
+				name:    "not found",
+				call:    []interface{}{"GetEvent", mock.Anything, "1"},
+				returns: []interface{}{nil, fmt.Errorf("failed to get event: %w", storage.ErrEventDoesntExist)},
+				status:  http.StatusNotFound,
+				problem: newProblem(http.StatusNotFound, app.CodeNotFound, "event not found", instance),
+			},
+			{
+				name:    "internal error",
+				call:    []interface{}{"GetEvent", mock.Anything, "1"},
+				returns: []interface{}{nil, errors.New("internal error")},
+				status:  http.StatusInternalServerError,
+				problem: newProblem(http.StatusInternalServerError, app.CodeInternal, "internal error", instance),
+			},
+		})
 }
 
-func TestEditEventHandlerValidation(t *testing.T) {
-	body := `{
-	"id": "66be96d3-3d5d-4aec",
-	"title": "testtitletoolongtesttitletoolong",
-	"user_id": "66be96d3-3d5d-4aec",
-	"date": "2024-09-23T00:00:00Z",
-	"end_date": "2024-09-20T00:00:00Z"
-}`
-	req := httptest.NewRequest(http.MethodPut, "/event/edit/1", bytes.NewBufferString(body))
-	w := httptest.NewRecorder()
-
-	logg := newLogger(t)
-	app := mocks.NewApplication(t)
+func TestCreateEventHandler(t *testing.T) {
+	const instance = "/event/create"
+	call := []interface{}{"CreateEvent", mock.Anything, validEvent}
+	runHandlerCases(t, "POST /event/create", http.MethodPost, instance,
+		func(s *Server) http.HandlerFunc { return s.createEventHandler },
+		[]handlerCase{
+			{
+				name:    "success",
+				body:    validEventBody,
+				call:    call,
+				returns: []interface{}{nil},
+				status:  http.StatusOK,
+				want:    successBody,
+			},
+			{
+				name:    "malformed body",
+				body:    `{"title":`,
+				status:  http.StatusBadRequest,
+				problem: newProblem(http.StatusBadRequest, app.CodeInvalidArgument, "malformed request body", instance),
+			},
+			{
+				name:   "validation failed",
+				body:   invalidEventBody,
+				status: http.StatusBadRequest,
+				problem: newProblem(http.StatusBadRequest, app.CodeInvalidArgument, "validation failed", instance,
+					eventViolations...),
+			},
+			{
+				name:    "event already exists",
+				body:    validEventBody,
+				call:    call,
+				returns: []interface{}{storage.ErrEventAlreadyExists},
+				status:  http.StatusConflict,
+				problem: newProblem(http.StatusConflict, app.CodeAlreadyExists, "event already exists", instance),
+			},
+			{
+				name:    "quota exceeded",
+				body:    validEventBody,
+				call:    call,
+				returns: []interface{}{fmt.Errorf("app.CreateEvent: %w", app.ErrQuotaExceeded)},
+				status:  http.StatusForbidden,
+				problem: newProblem(http.StatusForbidden, app.CodeQuotaExceeded, "event quota exceeded", instance),
+			},
+			{
+				name:    "internal error",
+				body:    validEventBody,
+				call:    call,
+				returns: []interface{}{errors.New("internal error")},
+				status:  http.StatusInternalServerError,
+				problem: newProblem(http.StatusInternalServerError, app.CodeInternal, "internal error", instance),
+			},
+		})
+}
 
-	server := &Server{
-		logger: logg,
-		app:    app,
-	}
-	server.server = newServer(t, "PUT /event/edit/{id}", http.HandlerFunc(server.editEventHandler))
-	server.server.Handler.ServeHTTP(w, req)
+func TestDeleteEventHandler(t *testing.T) {
+	const instance = "/event/delete/1"
+	call := []interface{}{"DeleteEvent", mock.Anything, "1"}
+	runHandlerCases(t, "DELETE /event/delete/{id}", http.MethodDelete, instance,
+		func(s *Server) http.HandlerFunc { return s.deleteEventHandler },
+		[]handlerCase{
+			{
+				name:    "success",
+				call:    call,
+				returns: []interface{}{nil},
+				status:  http.StatusOK,
+				want:    successBody,
+			},
+			{
+				name:    "not found",
+				call:    call,
+				returns: []interface{}{storage.ErrEventDoesntExist},
+				status:  http.StatusNotFound,
+				problem: newProblem(http.StatusNotFound, app.CodeNotFound, "event not found", instance),
+			},
+			{
+				name:    "internal error",
+				call:    call,
+				returns: []interface{}{errors.New("internal error")},
+				status:  http.StatusInternalServerError,
+				problem: newProblem(http.StatusInternalServerError, app.CodeInternal, "internal error", instance),
+			},
+		})
+}
 
-	require.Equal(t, http.StatusPartialContent, w.Code)
-	require.Equal(t, `{
-	"error": {
-		"end_date": "too early",
-		"id": "not valid uuid",
-		"title": "too long",
-		"user_id": "not valid uuid"
-	}
-}`, w.Body.String())
+func TestEditEventHandler(t *testing.T) {
+	const instance = "/event/edit/1"
+	call := []interface{}{"EditEvent", mock.Anything, "1", validEvent}
+	runHandlerCases(t, "PUT /event/edit/{id}", http.MethodPut, instance,
+		func(s *Server) http.HandlerFunc { return s.editEventHandler },
+		[]handlerCase{
+			{
+				name:    "success",
+				body:    validEventBody,
+				call:    call,
+				returns: []interface{}{nil},
+				status:  http.StatusOK,
+				want:    successBody,
+			},
+			{
+				name:    "malformed body",
+				body:    `[]`,
+				status:  http.StatusBadRequest,
+				problem: newProblem(http.StatusBadRequest, app.CodeInvalidArgument, "malformed request body", instance),
+			},
+			{
+				name:   "validation failed",
+				body:   invalidEventBody,
+				status: http.StatusBadRequest,
+				problem: newProblem(http.StatusBadRequest, app.CodeInvalidArgument, "validation failed", instance,
+					eventViolations...),
+			},
+			{
+				name:    "not found",
+				body:    validEventBody,
+				call:    call,
+				returns: []interface{}{storage.ErrEventDoesntExist},
+				status:  http.StatusNotFound,
+				problem: newProblem(http.StatusNotFound, app.CodeNotFound, "event not found", instance),
+			},
+			{
+				name:    "internal error",
+				body:    validEventBody,
+				call:    call,
+				returns: []interface{}{errors.New("internal error")},
+				status:  http.StatusInternalServerError,
+				problem: newProblem(http.StatusInternalServerError, app.CodeInternal, "internal error", instance),
+			},
+		})
 }
 
-var testsEventList = []struct {
-	name    string
-	returns []interface{}
-	want    string
-	status  int
-}{
-	{
-		name:    "success",
-		returns: []interface{}{[]storage.Event{{ID: "1", Title: "test"}}, nil},
-		status:  http.StatusOK,
-		want: `{
+func eventListCases(method, period string) []handlerCase {
+	date := time.Date(2024, time.September, 23, 0, 0, 0, 0, time.UTC)
+	instance := "/event/" + period + "/2024-09-23"
+	call := []interface{}{method, mock.Anything, date}
+
+	return []handlerCase{
+		{
+			name:    "success",
+			call:    call,
+			returns: []interface{}{[]storage.Event{{ID: "1", Title: "test"}}, nil},
+			status:  http.StatusOK,
+			want: `{
 	"events": [
 		{
 			"id": "1",
@@ -386,100 +364,47 @@ var testsEventList = []struct {
 		}
 	]
 }`,
-	},
-	{
-		name:    "not found",
-		returns: []interface{}{nil, storage.ErrNoEventsFound},
-		status:  http.StatusNotFound,
-		want: `{
-	"error": "No events found"
-}`,
-	},
-	{
-		name:    "internal error",
-		returns: []interface{}{nil, errors.New("internal error")},
-		status:  http.StatusInternalServerError,
-		want: `{
-	"error": "Unknown error"
-}`,
-	},
-}
-
-func getDate(t *testing.T) time.Time {
-	t.Helper()
-	date, err := time.Parse("2006-01-02", "2024-09-23")
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	return date
-}
-
-func TestGetEventsDayHandler(t *testing.T) {
-	for _, tt := range testsEventList {
-		t.Run(tt.name, func(t *testing.T) {
-			req := httptest.NewRequest(http.MethodGet, "/event/day/2024-09-23", nil)
-			w := httptest.NewRecorder()
-
-			logg := newLogger(t)
-			app := mocks.NewApplication(t)
-			app.On("GetEventsListDay", mock.Anything, getDate(t)).Return(tt.returns...)
-
-			server := &Server{
-				logger: logg,
-				app:    app,
-			}
-			server.server = newServer(t, "GET /event/day/{date}", http.HandlerFunc(server.getEventsDayHandler))
-			server.server.Handler.ServeHTTP(w, req)
-
-			require.Equal(t, tt.status, w.Code)
-			require.Equal(t, tt.want, w.Body.String())
-		})
+		},
+		{
+			name:    "not found",
+			call:    call,
+			returns: []interface{}{nil, storage.ErrNoEventsFound},
+			status:  http.StatusNotFound,
+			problem: newProblem(http.StatusNotFound, app.CodeNotFound, "no events found", instance),
+		},
+		{
+			name:    "internal error",
+			call:    call,
+			returns: []interface{}{nil, errors.New("internal error")},
+			status:  http.StatusInternalServerError,
+			problem: newProblem(http.StatusInternalServerError, app.CodeInternal, "internal error", instance),
+		},
 	}
 }
 
-func TestGetEventsWeekHandler(t *testing.T) {
-	for _, tt := range testsEventList {
-		t.Run(tt.name, func(t *testing.T) {
-			req := httptest.NewRequest(http.MethodGet, "/event/week/2024-09-23", nil)
-			w := httptest.NewRecorder()
-
-			logg := newLogger(t)
-			app := mocks.NewApplication(t)
-			app.On("GetEventsListWeek", mock.Anything, getDate(t)).Return(tt.returns...)
-
-			server := &Server{
-				logger: logg,
-				app:    app,
-			}
-			server.server = newServer(t, "GET /event/week/{date}", http.HandlerFunc(server.getEventsWeekHandler))
-			server.server.Handler.ServeHTTP(w, req)
-
-			require.Equal(t, tt.status, w.Code)
-			require.Equal(t, tt.want, w.Body.String())
-		})
+func TestGetEventsListHandlers(t *testing.T) {
+	tests := []struct {
+		period  string
+		method  string
+		handler func(*Server) http.HandlerFunc
+	}{
+		{"day", "GetEventsListDay", func(s *Server) http.HandlerFunc { return s.getEventsDayHandler }},
+		{"week", "GetEventsListWeek", func(s *Server) http.HandlerFunc { return s.getEventsWeekHandler }},
+		{"month", "GetEventsListMonth", func(s *Server) http.HandlerFunc { return s.getEventsMonthHandler }},
 	}
-}
-
-func TestGetEventsMonthHandler(t *testing.T) {
-	for _, tt := range testsEventList {
-		t.Run(tt.name, func(t *testing.T) {
-			req := httptest.NewRequest(http.MethodGet, "/event/month/2024-09-23", nil)
-			w := httptest.NewRecorder()
-
-			logg := newLogger(t)
-			app := mocks.NewApplication(t)
-			app.On("GetEventsListMonth", mock.Anything, getDate(t)).Return(tt.returns...)
-
-			server := &Server{
-				logger: logg,
-				app:    app,
-			}
-			server.server = newServer(t, "GET /event/month/{date}", http.HandlerFunc(server.getEventsMonthHandler))
-			server.server.Handler.ServeHTTP(w, req)
-
-			require.Equal(t, tt.status, w.Code)
-			require.Equal(t, tt.want, w.Body.String())
+	for _, tt := range tests {
+		t.Run(tt.period, func(t *testing.T) {
+			pattern := "GET /event/" + tt.period + "/{date}"
+			runHandlerCases(t, pattern, http.MethodGet, "/event/"+tt.period+"/2024-09-23", tt.handler,
+				eventListCases(tt.method, tt.period))
+
+			instance := "/event/" + tt.period + "/23-09-2024"
+			runHandlerCases(t, pattern, http.MethodGet, instance, tt.handler, []handlerCase{{
+				name:   "wrong date",
+				status: http.StatusBadRequest,
+				problem: newProblem(http.StatusBadRequest, app.CodeInvalidArgument, "validation failed", instance,
+					invalidParam{Name: "date", Reason: "must be YYYY-MM-DD"}),
+			}})
 		})
 	}
 }
@@ -0,0 +1,116 @@
+package internalhttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+)
+
+// The /v2 handlers differ from v1 in the event format (see eventV2), in
+// answering writes with 201 or 204 and no body, and in listing no events
+// as an empty list rather than a 404.
+
+// decodeEventV2 reads and validates the v2 event in the request body.
+func decodeEventV2(w http.ResponseWriter, r *http.Request) (storage.Event, error) {
+	var dto eventV2
+	if err := decodeJSON(w, r, &dto); err != nil {
+		return storage.Event{}, err
+	}
+	event := dto.toStorage()
+
+	return event, validateEvent(event)
+}
+
+func (s *Server) createEventV2Handler(w http.ResponseWriter, r *http.Request) {
+	logg := s.logger.With("handler", "createEventV2Handler")
+	event, err := decodeEventV2(w, r)
+	if err != nil {
+		s.fail(w, r, logg, "invalid event", err)
+		return
+	}
+
+	err = s.app.CreateEvent(r.Context(), event)
+	if err != nil {
+		s.fail(w, r, logg, "failed create event", err)
+		return
+	}
+
+	// No Location: the SQL storage assigns its own ID, which is not known
+	// here.
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) getEventV2Handler(w http.ResponseWriter, r *http.Request) {
+	logg := s.logger.With("handler", "getEventV2Handler")
+	event, err := s.app.GetEvent(r.Context(), r.PathValue("id"))
+	if err != nil {
+		s.fail(w, r, logg, "failed get event", err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, wrapper{"event": eventV2FromStorage(*event)})
+}
+
+func (s *Server) editEventV2Handler(w http.ResponseWriter, r *http.Request) {
+	logg := s.logger.With("handler", "editEventV2Handler")
+	event, err := decodeEventV2(w, r)
+	if err != nil {
+		s.fail(w, r, logg, "invalid event", err)
+		return
+	}
+
+	err = s.app.EditEvent(r.Context(), r.PathValue("id"), event)
+	if err != nil {
+		s.fail(w, r, logg, "failed edit event", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) deleteEventV2Handler(w http.ResponseWriter, r *http.Request) {
+	logg := s.logger.With("handler", "deleteEventV2Handler")
+	err := s.app.DeleteEvent(r.Context(), r.PathValue("id"))
+	if err != nil {
+		s.fail(w, r, logg, "failed delete event", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getEventsDateV2Handler(
+	w http.ResponseWriter,
+	r *http.Request,
+	handler string,
+	list func(context.Context, time.Time) ([]storage.Event, error),
+) {
+	noneFound := func(ctx context.Context, date time.Time) ([]storage.Event, error) {
+		events, err := list(ctx, date)
+		if errors.Is(err, storage.ErrNoEventsFound) {
+			return nil, nil
+		}
+		return events, err
+	}
+	events, ok := s.listEvents(w, r, s.logger.With("handler", handler), noneFound)
+	if !ok {
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, wrapper{"events": eventsV2FromStorage(events)})
+}
+
+func (s *Server) getEventsDayV2Handler(w http.ResponseWriter, r *http.Request) {
+	s.getEventsDateV2Handler(w, r, "getEventsDayEventV2Handler", s.app.GetEventsListDay)
+}
+
+func (s *Server) getEventsWeekV2Handler(w http.ResponseWriter, r *http.Request) {
+	s.getEventsDateV2Handler(w, r, "getEventsWeekEventV2Handler", s.app.GetEventsListWeek)
+}
+
+func (s *Server) getEventsMonthV2Handler(w http.ResponseWriter, r *http.Request) {
+	s.getEventsDateV2Handler(w, r, "getEventsMonthEventV2Handler", s.app.GetEventsListMonth)
+}
@@ -0,0 +1,173 @@
+package internalhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/app"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/health"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/server/http/mocks"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const eventV2Body = `{
+	"id": "66be96d3-3d5d-4aec-af9c-5b3769d0169a",
+	"title": "standup",
+	"date": "2024-09-23T10:00:00Z",
+	"end_date": "2024-09-23T10:15:00Z",
+	"description": "daily",
+	"user_id": "0f7b9c4e-8a43-4f3c-9f6e-0c2b1d7e5a11",
+	"advance_notification_period": "15m"
+}`
+
+func TestHandlersV2(t *testing.T) {
+	date := time.Date(2024, time.September, 23, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		body    string
+		call    []interface{}
+		returns []interface{}
+		status  int
+		want    string
+		problem app.Code
+	}{
+		{
+			name:    "create",
+			method:  http.MethodPost,
+			target:  "/v2/events",
+			body:    eventV2Body,
+			call:    []interface{}{"CreateEvent", mock.Anything, contractEvent},
+			returns: []interface{}{nil},
+			status:  http.StatusCreated,
+		},
+		{
+			name:    "create with nanosecond period",
+			method:  http.MethodPost,
+			target:  "/v2/events",
+			body:    `{"title": "t", "user_id": "0f7b9c4e-8a43-4f3c-9f6e-0c2b1d7e5a11", "advance_notification_period": 900}`,
+			status:  http.StatusBadRequest,
+			problem: app.CodeInvalidArgument,
+		},
+		{
+			name:    "create with bad period",
+			method:  http.MethodPost,
+			target:  "/v2/events",
+			body:    `{"title": "t", "user_id": "0f7b9c4e-8a43-4f3c-9f6e-0c2b1d7e5a11", "advance_notification_period": "soon"}`,
+			status:  http.StatusBadRequest,
+			problem: app.CodeInvalidArgument,
+		},
+		{
+			name:    "get",
+			method:  http.MethodGet,
+			target:  "/v2/events/66be96d3-3d5d-4aec-af9c-5b3769d0169a",
+			call:    []interface{}{"GetEvent", mock.Anything, contractEvent.ID},
+			returns: []interface{}{&contractEvent, nil},
+			status:  http.StatusOK,
+			want: `{"event": {
+				"id": "66be96d3-3d5d-4aec-af9c-5b3769d0169a",
+				"title": "standup",
+				"date": "2024-09-23T10:00:00Z",
+				"end_date": "2024-09-23T10:15:00Z",
+				"description": "daily",
+				"user_id": "0f7b9c4e-8a43-4f3c-9f6e-0c2b1d7e5a11",
+				"advance_notification_period": "15m0s"
+			}}`,
+		},
+		{
+			name:    "get missing",
+			method:  http.MethodGet,
+			target:  "/v2/events/1",
+			call:    []interface{}{"GetEvent", mock.Anything, "1"},
+			returns: []interface{}{nil, storage.ErrEventDoesntExist},
+			status:  http.StatusNotFound,
+			problem: app.CodeNotFound,
+		},
+		{
+			name:    "edit",
+			method:  http.MethodPut,
+			target:  "/v2/events/66be96d3-3d5d-4aec-af9c-5b3769d0169a",
+			body:    eventV2Body,
+			call:    []interface{}{"EditEvent", mock.Anything, contractEvent.ID, contractEvent},
+			returns: []interface{}{nil},
+			status:  http.StatusNoContent,
+		},
+		{
+			name:    "delete",
+			method:  http.MethodDelete,
+			target:  "/v2/events/1",
+			call:    []interface{}{"DeleteEvent", mock.Anything, "1"},
+			returns: []interface{}{nil},
+			status:  http.StatusNoContent,
+		},
+		{
+			name:    "list",
+			method:  http.MethodGet,
+			target:  "/v2/events/week/2024-09-23",
+			call:    []interface{}{"GetEventsListWeek", mock.Anything, date},
+			returns: []interface{}{[]storage.Event{{ID: "1", Title: "test"}}, nil},
+			status:  http.StatusOK,
+			want: `{"events": [{
+				"id": "1",
+				"title": "test",
+				"date": "0001-01-01T00:00:00Z",
+				"end_date": "0001-01-01T00:00:00Z",
+				"description": "",
+				"user_id": "",
+				"advance_notification_period": "0s"
+			}]}`,
+		},
+		{
+			name:    "list nothing found",
+			method:  http.MethodGet,
+			target:  "/v2/events/day/2024-09-23",
+			call:    []interface{}{"GetEventsListDay", mock.Anything, date},
+			returns: []interface{}{nil, storage.ErrNoEventsFound},
+			status:  http.StatusOK,
+			want:    `{"events": []}`,
+		},
+		{
+			name:    "list failed",
+			method:  http.MethodGet,
+			target:  "/v2/events/month/2024-09-23",
+			call:    []interface{}{"GetEventsListMonth", mock.Anything, date},
+			returns: []interface{}{nil, errors.New("internal error")},
+			status:  http.StatusInternalServerError,
+			problem: app.CodeInternal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			application := mocks.NewApplication(t)
+			if tt.call != nil {
+				application.On(tt.call[0].(string), tt.call[1:]...).Return(tt.returns...)
+			}
+			server := NewServer(newLogger(t), application, health.New(health.DefaultTimeout), nil, nil, nil, "", "")
+
+			w := httptest.NewRecorder()
+			server.server.Handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, bytes.NewBufferString(tt.body)))
+
+			require.Equal(t, tt.status, w.Code, w.Body.String())
+			require.Empty(t, w.Header().Get("Location"))
+			require.Empty(t, w.Header().Get("Deprecation"))
+			switch {
+			case tt.problem != "":
+				var got problem
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+				require.Equal(t, tt.problem, got.Code)
+			case tt.want != "":
+				require.JSONEq(t, tt.want, w.Body.String())
+			default:
+				require.Empty(t, w.Body.String())
+			}
+		})
+	}
+}
@@ -19,12 +19,3 @@ func (s *Server) writeJSON(w http.ResponseWriter, status int, data wrapper) erro
 
 	return nil
 }
-
-func (s *Server) errorResponse(w http.ResponseWriter, status int, message interface{}) {
-	wr := wrapper{"error": message}
-	err := s.writeJSON(w, status, wr)
-	if err != nil {
-		s.logger.Info("failed error responel", "error", err)
-		w.WriteHeader(500)
-	}
-}
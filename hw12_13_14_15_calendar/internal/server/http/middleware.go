@@ -1,13 +1,30 @@
 package internalhttp
 
 import (
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/app"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/auth"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/metrics"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/ratelimit"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/requestid"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/tlsconfig"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = tracing.Tracer("server/http")
+
 type statusRecorder struct {
 	http.ResponseWriter
 	statusCode int
@@ -18,7 +35,18 @@ func (sr *statusRecorder) WriteHeader(code int) {
 	sr.ResponseWriter.WriteHeader(code)
 }
 
-func loggingMiddleware(logger logger.Logger, next http.Handler) http.Handler {
+// routeLabel strips the method from a ServeMux pattern, so "GET /event/{id}"
+// becomes "/event/{id}".
+func routeLabel(pattern string) string {
+	if i := strings.IndexByte(pattern, ' '); i >= 0 {
+		return pattern[i+1:]
+	}
+
+	return pattern
+}
+
+func loggingMiddleware(logger logger.Logger, pattern string, next http.Handler) http.Handler {
+	route := routeLabel(pattern)
 	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
 		addr := strings.Split(req.RemoteAddr, ":")
 		if len(addr) > 0 {
@@ -31,8 +59,10 @@ func loggingMiddleware(logger logger.Logger, next http.Handler) http.Handler {
 		next.ServeHTTP(sr, req)
 
 		latency := time.Since(date)
+		metrics.HTTPRequests.WithLabelValues(route, req.Method, strconv.Itoa(sr.statusCode)).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(route, req.Method).Observe(latency.Seconds())
 
-		logger.Info("HTTP request handled",
+		logger.InfoContext(req.Context(), "HTTP request handled",
 			"ip", ip,
 			"date", date.Format(time.RFC822Z),
 			"method", req.Method,
@@ -44,3 +74,111 @@ func loggingMiddleware(logger logger.Logger, next http.Handler) http.Handler {
 		)
 	})
 }
+
+// requestIDMiddleware tags the request with the ID the client sent in
+// X-Request-ID, or a new one, and echoes it in the response.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		id := requestid.Accept(req.Header.Get(requestid.Header))
+		res.Header().Set(requestid.Header, id)
+		next.ServeHTTP(res, req.WithContext(requestid.WithID(req.Context(), id)))
+	})
+}
+
+// clientCertMiddleware authenticates requests made with a verified client
+// certificate as the user named by it.
+func clientCertMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if userID, ok := tlsconfig.ClientIdentity(req.TLS); ok {
+			req = req.WithContext(auth.WithUser(req.Context(), userID))
+		}
+		next.ServeHTTP(res, req)
+	})
+}
+
+// FeatureToggle serves a handler only while it is enabled, so a feature can
+// be switched on and off without a restart.
+type FeatureToggle struct {
+	handler http.Handler
+	enabled atomic.Bool
+}
+
+func NewFeatureToggle(handler http.Handler, enabled bool) *FeatureToggle {
+	t := &FeatureToggle{handler: handler}
+	t.enabled.Store(enabled)
+
+	return t
+}
+
+func (t *FeatureToggle) SetEnabled(enabled bool) {
+	t.enabled.Store(enabled)
+}
+
+func (t *FeatureToggle) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !t.enabled.Load() {
+		writeProblem(w, r, app.NewError(app.CodeNotFound, "not found"))
+		return
+	}
+	t.handler.ServeHTTP(w, r)
+}
+
+// legacyDeprecation is when the unversioned routes were deprecated in favor
+// of /v1.
+var legacyDeprecation = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+
+// deprecatedMiddleware marks responses with an RFC 9745 Deprecation header
+// and links to the documentation of the versioned API.
+func deprecatedMiddleware(next http.Handler) http.Handler {
+	deprecation := "@" + strconv.FormatInt(legacyDeprecation.Unix(), 10)
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Deprecation", deprecation)
+		res.Header().Set("Link", `</docs>; rel="deprecation"`)
+		next.ServeHTTP(res, req)
+	})
+}
+
+// tracingMiddleware continues the trace from the incoming W3C headers, if any,
+// and wraps the request in a server span named after its route.
+func tracingMiddleware(pattern string, next http.Handler) http.Handler {
+	route := routeLabel(pattern)
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+		ctx, span := tracer.Start(ctx, req.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", req.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", req.URL.Path),
+				attribute.String("user_agent.original", req.UserAgent()),
+			),
+		)
+		defer span.End()
+
+		sr := &statusRecorder{ResponseWriter: res, statusCode: http.StatusOK}
+		next.ServeHTTP(sr, req.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.response.status_code", sr.statusCode))
+		if sr.statusCode >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(sr.statusCode))
+		}
+	})
+}
+
+// rateLimitMiddleware rejects clients that ran out of tokens for pattern with
+// 429 and a Retry-After in whole seconds.
+func (s *Server) rateLimitMiddleware(pattern string, next http.Handler) http.Handler {
+	if s.limiter == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		ok, retryAfter := s.limiter.Allow(pattern, ratelimit.ClientKey(req.Context(), req.RemoteAddr))
+		if !ok {
+			res.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			s.errorResponse(res, req, app.NewError(app.CodeRateLimited, "too many requests"))
+			return
+		}
+
+		next.ServeHTTP(res, req)
+	})
+}
@@ -0,0 +1,130 @@
+package internalhttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/clock"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/metrics"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/ratelimit"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestRouteLabel(t *testing.T) {
+	require.Equal(t, "/event/{id}", routeLabel("GET /event/{id}"))
+	require.Equal(t, "/hello", routeLabel("/hello"))
+}
+
+func TestLoggingMiddlewareMetrics(t *testing.T) {
+	const pattern = "GET /test/{id}"
+	handler := loggingMiddleware(newLogger(t), pattern, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	mux := http.NewServeMux()
+	mux.Handle(pattern, handler)
+
+	counter := metrics.HTTPRequests.WithLabelValues("/test/{id}", http.MethodGet, "418")
+	before := testutil.ToFloat64(counter)
+
+	for _, id := range []string{"1", "2"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test/"+id, nil))
+		require.Equal(t, http.StatusTeapot, w.Code)
+	}
+
+	require.Equal(t, before+2, testutil.ToFloat64(counter), "requests are grouped by route, not path")
+	require.Positive(t, testutil.CollectAndCount(metrics.HTTPRequestDuration))
+}
+
+func TestTracingMiddlewareContinuesTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		provider.Shutdown(context.Background())
+	})
+
+	const pattern = "GET /trace/{id}"
+	var handlerSpan trace.SpanContext
+	mux := http.NewServeMux()
+	mux.Handle(pattern, tracingMiddleware(pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = trace.SpanContextFromContext(r.Context())
+		w.WriteHeader(http.StatusInternalServerError)
+	})))
+
+	req := httptest.NewRequest(http.MethodGet, "/trace/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	require.Equal(t, "GET /trace/{id}", spans[0].Name())
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext().TraceID().String())
+	require.Equal(t, "00f067aa0ba902b7", spans[0].Parent().SpanID().String())
+	require.Equal(t, codes.Error, spans[0].Status().Code)
+	require.Equal(t, spans[0].SpanContext().SpanID(), handlerSpan.SpanID(), "handler runs inside the span")
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	const pattern = "POST /event/create"
+	clk := clock.NewFake(time.Date(2024, time.October, 1, 0, 0, 0, 0, time.UTC))
+	server := &Server{
+		logger:  newLogger(t),
+		limiter: ratelimit.New(ratelimit.Config{Default: ratelimit.Rule{Rate: 0.5, Burst: 1}}, clk),
+	}
+	handler := server.rateLimitMiddleware(pattern, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	send := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/event/create", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	require.Equal(t, http.StatusOK, send("10.0.0.1:1000").Code)
+
+	w := send("10.0.0.1:1001")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "2", w.Header().Get("Retry-After"))
+	require.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+	require.Contains(t, w.Body.String(), `"code": "rate_limited"`)
+
+	require.Equal(t, http.StatusOK, send("10.0.0.2:1000").Code)
+
+	clk.Advance(2 * time.Second)
+	require.Equal(t, http.StatusOK, send("10.0.0.1:1002").Code)
+}
+
+func TestFeatureToggle(t *testing.T) {
+	toggle := NewFeatureToggle(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), false)
+	send := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		toggle.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/events/1", nil))
+		return w
+	}
+
+	w := send()
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+
+	toggle.SetEnabled(true)
+	require.Equal(t, http.StatusOK, send().Code)
+}
@@ -0,0 +1,38 @@
+package internalhttp
+
+import (
+	"embed"
+	"net/http"
+)
+
+// openAPISpec documents every route registered in NewServer; openapi_test.go
+// keeps the two in sync.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// docsPage renders openAPISpec with Swagger UI.
+//
+//go:embed docs.html
+var docsPage []byte
+
+// swaggerUI holds the files of swagger-ui-dist 5.18.2 that docsPage loads,
+// so the page works without internet access.
+//
+//go:embed swagger-ui/swagger-ui-bundle.js swagger-ui/swagger-ui.css
+var swaggerUI embed.FS
+
+func (s *Server) openAPIHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+func (s *Server) docsHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docsPage)
+}
+
+// docsAssetHandler serves the Swagger UI files that docsPage loads.
+func (s *Server) docsAssetHandler(w http.ResponseWriter, r *http.Request) {
+	http.ServeFileFS(w, r, swaggerUI, "swagger-ui/"+r.PathValue("file"))
+}
@@ -0,0 +1,244 @@
+package internalhttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/api"
+	pbv2 "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/api/v2"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/health"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/server/http/mocks"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type openAPIDocument struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]openAPISchema `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPISchema struct {
+	Type       string                   `json:"type"`
+	Format     string                   `json:"format"`
+	Required   []string                 `json:"required"`
+	Properties map[string]openAPISchema `json:"properties"`
+}
+
+func loadSpec(t *testing.T) openAPIDocument {
+	t.Helper()
+	var doc openAPIDocument
+	require.NoError(t, json.Unmarshal(openAPISpec, &doc))
+
+	return doc
+}
+
+// splitPattern turns a ServeMux pattern into an OpenAPI operation. Patterns
+// without a method match any method and are documented as GET.
+func splitPattern(pattern string) (method, path string) {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok {
+		return "get", pattern
+	}
+
+	return strings.ToLower(method), path
+}
+
+func TestOpenAPIDescribesEveryRoute(t *testing.T) {
+	doc := loadSpec(t)
+	server := NewServer(newLogger(t), mocks.NewApplication(t), health.New(health.DefaultTimeout), nil,
+		http.NotFoundHandler(), nil, "", "")
+
+	registered := map[string]bool{}
+	for _, r := range server.routes() {
+		method, path := splitPattern(r.pattern)
+		registered[method+" "+path] = true
+		_, ok := doc.Paths[path][method]
+		require.True(t, ok, "route %q is missing from openapi.json", r.pattern)
+	}
+
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			require.True(t, registered[method+" "+path], "openapi.json documents %s %s, which is not served", method, path)
+		}
+	}
+}
+
+// jsonSchemaOf derives the OpenAPI type and format encoding/json produces
+// for t.
+func jsonSchemaOf(t reflect.Type) openAPISchema {
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return openAPISchema{Type: "string", Format: "date-time"}
+	case reflect.TypeOf(time.Duration(0)):
+		return openAPISchema{Type: "integer", Format: "int64"}
+	case reflect.TypeOf(duration(0)):
+		return openAPISchema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return openAPISchema{Type: "string"}
+	case reflect.Bool:
+		return openAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return openAPISchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return openAPISchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return openAPISchema{Type: "array"}
+	default:
+		return openAPISchema{Type: "object"}
+	}
+}
+
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+
+	return fields
+}
+
+func keys[V any](m map[string]V) []string {
+	result := make([]string, 0, len(m))
+	for k := range m {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+func TestOpenAPISchemasMatchJSON(t *testing.T) {
+	doc := loadSpec(t)
+	tests := []struct {
+		schema string
+		value  interface{}
+	}{
+		{"Event", eventV1{}},
+		{"EventV2", eventV2{}},
+		{"Problem", problem{}},
+		{"InvalidParam", invalidParam{}},
+		{"HealthReport", health.Report{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.schema, func(t *testing.T) {
+			schema, ok := doc.Components.Schemas[tt.schema]
+			require.True(t, ok, "schema %s is missing", tt.schema)
+
+			fields := jsonFields(reflect.TypeOf(tt.value))
+			require.Equal(t, keys(fields), keys(schema.Properties), "properties of %s", tt.schema)
+			for name, typ := range fields {
+				want := jsonSchemaOf(typ)
+				got := schema.Properties[name]
+				require.Equal(t, want.Type, got.Type, "type of %s.%s", tt.schema, name)
+				if want.Format != "" {
+					require.Equal(t, want.Format, got.Format, "format of %s.%s", tt.schema, name)
+				}
+			}
+			for _, name := range schema.Required {
+				require.Contains(t, fields, name, "required property %s.%s", tt.schema, name)
+			}
+		})
+	}
+}
+
+// TestOpenAPIProtoSchemaMatchesGateway checks the proto event schemas
+// against what the gateway's marshaler actually writes for a fully
+// populated event.
+func TestOpenAPIProtoSchemaMatchesGateway(t *testing.T) {
+	doc := loadSpec(t)
+	tests := []struct {
+		schema string
+		value  proto.Message
+	}{
+		{"ProtoEvent", &pb.Event{}},
+		{"ProtoEventV2", &pbv2.Event{
+			Date:                      timestamppb.Now(),
+			EndDate:                   timestamppb.Now(),
+			AdvanceNotificationPeriod: durationpb.New(time.Minute),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.schema, func(t *testing.T) {
+			schema, ok := doc.Components.Schemas[tt.schema]
+			require.True(t, ok)
+
+			marshaler := newGatewayMarshaler()
+			b, err := marshaler.Marshal(tt.value)
+			require.NoError(t, err)
+			var fields map[string]interface{}
+			require.NoError(t, json.Unmarshal(b, &fields))
+
+			require.Equal(t, keys(fields), keys(schema.Properties))
+			for name, value := range fields {
+				var want string
+				switch value.(type) {
+				case string:
+					want = "string"
+				case float64:
+					want = "number"
+				case bool:
+					want = "boolean"
+				}
+				require.Equal(t, want, schema.Properties[name].Type, "type of %s.%s", tt.schema, name)
+			}
+		})
+	}
+}
+
+func TestOpenAPIServed(t *testing.T) {
+	server := NewServer(newLogger(t), mocks.NewApplication(t), health.New(health.DefaultTimeout), nil, nil, nil, "", "")
+
+	w := httptest.NewRecorder()
+	server.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	require.JSONEq(t, string(openAPISpec), w.Body.String())
+
+	w = httptest.NewRecorder()
+	server.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `url: "/openapi.json"`)
+	require.NotContains(t, w.Body.String(), "https://", "the page loads nothing from outside")
+
+	for _, asset := range []string{"/docs/swagger-ui-bundle.js", "/docs/swagger-ui.css"} {
+		w = httptest.NewRecorder()
+		server.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, asset, nil))
+		require.Equal(t, http.StatusOK, w.Code, asset)
+		require.NotEmpty(t, w.Body.Bytes(), asset)
+	}
+
+	w = httptest.NewRecorder()
+	server.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/docs/LICENSE", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// TestMetricsNotPublic checks that metrics are left to the admin listener.
+func TestMetricsNotPublic(t *testing.T) {
+	server := NewServer(newLogger(t), mocks.NewApplication(t), health.New(health.DefaultTimeout), nil, nil, nil, "", "")
+
+	w := httptest.NewRecorder()
+	server.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
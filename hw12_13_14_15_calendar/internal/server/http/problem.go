@@ -0,0 +1,93 @@
+package internalhttp
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/app"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger"
+)
+
+// problem is an RFC 7807 problem details document. Type is left as
+// about:blank, so Title is the HTTP status text; clients branch on Code.
+type problem struct {
+	Type          string         `json:"type"`
+	Title         string         `json:"title"`
+	Status        int            `json:"status"`
+	Detail        string         `json:"detail"`
+	Instance      string         `json:"instance,omitempty"`
+	Code          app.Code       `json:"code"`
+	InvalidParams []invalidParam `json:"invalid_params,omitempty"`
+}
+
+type invalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+func httpStatus(code app.Code) int {
+	switch code {
+	case app.CodeInvalidArgument:
+		return http.StatusBadRequest
+	case app.CodeNotFound:
+		return http.StatusNotFound
+	case app.CodeAlreadyExists:
+		return http.StatusConflict
+	case app.CodeQuotaExceeded:
+		return http.StatusForbidden
+	case app.CodeRateLimited:
+		return http.StatusTooManyRequests
+	case app.CodeUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// fail logs err with msg, as an error if it is internal and a warning
+// otherwise, and answers with it.
+func (s *Server) fail(w http.ResponseWriter, r *http.Request, logg logger.Logger, msg string, err error) {
+	appErr := app.AsError(err)
+	if appErr.Code == app.CodeInternal {
+		logg.ErrorContext(r.Context(), msg, "error", err)
+	} else {
+		logg.WarnContext(r.Context(), msg, "error", err)
+	}
+	s.errorResponse(w, r, appErr)
+}
+
+// errorResponse writes err as problem+json. Errors the app does not
+// classify are reported as internal without exposing their text.
+func (s *Server) errorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	if err := writeProblem(w, r, err); err != nil {
+		s.logger.ErrorContext(r.Context(), "failed to encode problem", "error", err)
+	}
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, err error) error {
+	appErr := app.AsError(err)
+	status := httpStatus(appErr.Code)
+	p := problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   appErr.Message,
+		Instance: r.URL.Path,
+		Code:     appErr.Code,
+	}
+	for _, f := range appErr.Fields {
+		p.InvalidParams = append(p.InvalidParams, invalidParam{Name: f.Field, Reason: f.Description})
+	}
+
+	js, err := json.MarshalIndent(p, "", "\t")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	w.Write(js)
+
+	return nil
+}
@@ -2,19 +2,25 @@ package internalhttp
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/health"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/ratelimit"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
 )
 
 type Server struct {
-	logger logger.Logger
-	app    Application
-	server *http.Server
-	addr   string
+	logger  logger.Logger
+	app     Application
+	health  *health.Checker
+	limiter *ratelimit.Limiter
+	gateway http.Handler
+	server  *http.Server
+	addr    string
 }
 
 //go:generate mockery --name=Application
@@ -32,31 +38,130 @@ type Logger interface {
 	logger.Logger
 }
 
-func NewServer(logger Logger, app Application, host, port string) *Server {
-	s := &Server{logger: logger, app: app, addr: fmt.Sprintf("%s:%s", host, port)}
+// NewServer builds the REST API. A nil limiter disables rate limiting; a
+// nil gateway (see NewGateway) leaves the /api/v1 and /api/v2 routes out; a
+// nil tlsConfig serves plain HTTP.
+func NewServer(
+	logger Logger,
+	app Application,
+	health *health.Checker,
+	limiter *ratelimit.Limiter,
+	gateway http.Handler,
+	tlsConfig *tls.Config,
+	host, port string,
+) *Server {
+	s := &Server{
+		logger:  logger,
+		app:     app,
+		health:  health,
+		limiter: limiter,
+		gateway: gateway,
+		addr:    fmt.Sprintf("%s:%s", host, port),
+	}
 	mux := http.NewServeMux()
-	mux.Handle("/hello", loggingMiddleware(s.logger, http.HandlerFunc(s.hello)))
-	mux.Handle("POST /event/create", loggingMiddleware(s.logger, http.HandlerFunc(s.createEventHandler)))
-	mux.Handle("DELETE /event/delete/{id}", loggingMiddleware(s.logger, http.HandlerFunc(s.deleteEventHandler)))
-	mux.Handle("PUT /event/edit/{id}", loggingMiddleware(s.logger, http.HandlerFunc(s.editEventHandler)))
-	mux.Handle("GET /event/day/{date}", loggingMiddleware(s.logger, http.HandlerFunc(s.getEventsDayHandler)))
-	mux.Handle("GET /event/week/{date}", loggingMiddleware(s.logger, http.HandlerFunc(s.getEventsWeekHandler)))
-	mux.Handle("GET /event/month/{date}", loggingMiddleware(s.logger, http.HandlerFunc(s.getEventsMonthHandler)))
-	mux.Handle("GET /event/{id}", loggingMiddleware(s.logger, http.HandlerFunc(s.getEventHandler)))
+	for _, r := range s.routes() {
+		handler := r.handler
+		if r.limited {
+			handler = s.rateLimitMiddleware(r.pattern, handler)
+		}
+		if r.api {
+			handler = tracingMiddleware(r.pattern, loggingMiddleware(s.logger, r.pattern, handler))
+		}
+		mux.Handle(r.pattern, handler)
+	}
 
 	s.server = &http.Server{
 		Addr:              s.addr,
-		Handler:           mux,
+		Handler:           requestIDMiddleware(clientCertMiddleware(mux)),
+		TLSConfig:         tlsConfig,
 		ReadHeaderTimeout: 2 * time.Second,
 	}
 
 	return s
 }
 
+type route struct {
+	pattern string
+	handler http.Handler
+	// api routes are traced and logged; probes are not.
+	api bool
+	// limited routes are rate limited here. Gateway routes are limited by
+	// the gRPC server they proxy to.
+	limited bool
+}
+
+// routes lists everything NewServer serves. openapi.json must describe
+// each of them.
+func (s *Server) routes() []route {
+	routes := []route{
+		{"/hello", http.HandlerFunc(s.hello), true, true},
+		{"GET /openapi.json", http.HandlerFunc(s.openAPIHandler), true, true},
+		{"GET /docs", http.HandlerFunc(s.docsHandler), true, true},
+		{"GET /docs/{file}", http.HandlerFunc(s.docsAssetHandler), true, true},
+		{"GET /healthz", s.health.LivenessHandler(), false, false},
+		{"GET /readyz", s.health.ReadinessHandler(), false, false},
+	}
+	routes = append(routes, s.legacyRoutes()...)
+	routes = append(routes, s.v1Routes()...)
+	routes = append(routes, s.v2Routes()...)
+	if s.gateway != nil {
+		for _, pattern := range gatewayRoutes {
+			routes = append(routes, route{pattern, s.gateway, true, false})
+		}
+	}
+
+	return routes
+}
+
+// legacyRoutes are the unversioned routes that predate /v1. They serve the
+// v1 format and announce their deprecation.
+func (s *Server) legacyRoutes() []route {
+	return []route{
+		{"POST /event/create", deprecatedMiddleware(http.HandlerFunc(s.createEventHandler)), true, true},
+		{"DELETE /event/delete/{id}", deprecatedMiddleware(http.HandlerFunc(s.deleteEventHandler)), true, true},
+		{"PUT /event/edit/{id}", deprecatedMiddleware(http.HandlerFunc(s.editEventHandler)), true, true},
+		{"GET /event/day/{date}", deprecatedMiddleware(http.HandlerFunc(s.getEventsDayHandler)), true, true},
+		{"GET /event/week/{date}", deprecatedMiddleware(http.HandlerFunc(s.getEventsWeekHandler)), true, true},
+		{"GET /event/month/{date}", deprecatedMiddleware(http.HandlerFunc(s.getEventsMonthHandler)), true, true},
+		{"GET /event/{id}", deprecatedMiddleware(http.HandlerFunc(s.getEventHandler)), true, true},
+	}
+}
+
+func (s *Server) v1Routes() []route {
+	return []route{
+		{"POST /v1/events", http.HandlerFunc(s.createEventHandler), true, true},
+		{"GET /v1/events/{id}", http.HandlerFunc(s.getEventHandler), true, true},
+		{"PUT /v1/events/{id}", http.HandlerFunc(s.editEventHandler), true, true},
+		{"DELETE /v1/events/{id}", http.HandlerFunc(s.deleteEventHandler), true, true},
+		{"GET /v1/events/day/{date}", http.HandlerFunc(s.getEventsDayHandler), true, true},
+		{"GET /v1/events/week/{date}", http.HandlerFunc(s.getEventsWeekHandler), true, true},
+		{"GET /v1/events/month/{date}", http.HandlerFunc(s.getEventsMonthHandler), true, true},
+	}
+}
+
+func (s *Server) v2Routes() []route {
+	return []route{
+		{"POST /v2/events", http.HandlerFunc(s.createEventV2Handler), true, true},
+		{"GET /v2/events/{id}", http.HandlerFunc(s.getEventV2Handler), true, true},
+		{"PUT /v2/events/{id}", http.HandlerFunc(s.editEventV2Handler), true, true},
+		{"DELETE /v2/events/{id}", http.HandlerFunc(s.deleteEventV2Handler), true, true},
+		{"GET /v2/events/day/{date}", http.HandlerFunc(s.getEventsDayV2Handler), true, true},
+		{"GET /v2/events/week/{date}", http.HandlerFunc(s.getEventsWeekV2Handler), true, true},
+		{"GET /v2/events/month/{date}", http.HandlerFunc(s.getEventsMonthV2Handler), true, true},
+	}
+}
+
 func (s *Server) Start() error {
-	s.logger.Info("starting server")
+	s.logger.Info("starting server", "tls", s.server.TLSConfig != nil)
 
-	if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	var err error
+	if s.server.TLSConfig != nil {
+		// The certificate comes from TLSConfig.GetCertificate.
+		err = s.server.ListenAndServeTLS("", "")
+	} else {
+		err = s.server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
 		return fmt.Errorf("server.Start: %w", err)
 	}
 
@@ -0,0 +1,268 @@
+// Package cachestorage keeps the reads of the calendar in memory, in front of
+// any storage.
+package cachestorage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	hw04lrucache "github.com/AndreyChufelin/homework/hw04_lru_cache"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/clock"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/metrics"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+)
+
+type Backend interface {
+	CreateEvent(context.Context, storage.Event) error
+	CountUserEvents(ctx context.Context, userID string) (int, error)
+	GetEvent(context.Context, string) (*storage.Event, error)
+	EditEvent(context.Context, string, storage.Event) error
+	DeleteEvent(context.Context, string) error
+	GetEventsListDay(ctx context.Context, date time.Time) ([]storage.Event, error)
+	GetEventsListWeek(ctx context.Context, date time.Time) ([]storage.Event, error)
+	GetEventsListMonth(ctx context.Context, date time.Time) ([]storage.Event, error)
+}
+
+// Config bounds the cache to Size entries, each served for at most TTL.
+// When the backend reads from a replica, ReplicaLag is how far behind the
+// primary it may be: reads made that soon after a write are not cached, as
+// they may not see it yet.
+type Config struct {
+	Size       int
+	TTL        time.Duration
+	ReplicaLag time.Duration
+}
+
+var DefaultConfig = Config{Size: 1024, TTL: 30 * time.Second}
+
+// Storage serves GetEvent and the event lists from an LRU cache, falling
+// back to the backend on a miss. Writes through Storage drop the entries
+// they affect, so a read that follows a write never sees the data from
+// before it. Writes made elsewhere, by another replica for one, show up
+// once the entries expire.
+type Storage struct {
+	next  Backend
+	ttl   time.Duration
+	lag   time.Duration
+	clock clock.Clock
+
+	mu    sync.Mutex
+	cache hw04lrucache.Cache
+	size  int
+	// live holds the keys whose cached entries may be served, with the dates
+	// each covers. The LRU cache cannot drop single keys, so invalidating a
+	// key removes it from live instead.
+	live map[hw04lrucache.Key]span
+	// writes counts invalidations, so that a read that raced a write does
+	// not cache what it read before the write.
+	writes uint64
+	// lastWrite is when the last invalidation happened.
+	lastWrite time.Time
+}
+
+// span is the range [from, to) of event dates behind a cached list; it is
+// zero for a single event.
+type span struct {
+	from, to time.Time
+}
+
+// covers reports whether an event on date may be in the list. The range is
+// widened by a day on each side, as backends cut days in their own time
+// zone.
+func (s span) covers(date time.Time) bool {
+	return !s.from.IsZero() && !date.Before(s.from.AddDate(0, 0, -1)) && date.Before(s.to.AddDate(0, 0, 1))
+}
+
+type entry struct {
+	value   interface{}
+	err     error
+	expires time.Time
+}
+
+func New(next Backend, config Config, clock clock.Clock) *Storage {
+	if config.Size <= 0 {
+		config.Size = DefaultConfig.Size
+	}
+	if config.TTL <= 0 {
+		config.TTL = DefaultConfig.TTL
+	}
+
+	return &Storage{
+		next:  next,
+		ttl:   config.TTL,
+		lag:   config.ReplicaLag,
+		clock: clock,
+		cache: hw04lrucache.NewCache(config.Size),
+		size:  config.Size,
+		live:  make(map[hw04lrucache.Key]span),
+	}
+}
+
+func eventKey(id string) hw04lrucache.Key {
+	return hw04lrucache.Key("event/" + id)
+}
+
+func listKey(kind string, date time.Time) hw04lrucache.Key {
+	return hw04lrucache.Key(kind + "/" + date.Format(time.RFC3339Nano))
+}
+
+func (s *Storage) lookup(key hw04lrucache.Key) (entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.live[key]; !ok {
+		return entry{}, false
+	}
+	value, ok := s.cache.Get(key)
+	if !ok || !s.clock.Now().Before(value.(entry).expires) {
+		delete(s.live, key)
+		return entry{}, false
+	}
+
+	return value.(entry), true
+}
+
+func (s *Storage) store(key hw04lrucache.Key, span span, writes uint64, e entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.writes != writes || s.clock.Now().Before(s.lastWrite.Add(s.lag)) {
+		return
+	}
+	// Keys evicted by the cache stay in live until invalidated; start over
+	// before they outnumber the entries.
+	if len(s.live) >= 2*s.size {
+		s.cache.Clear()
+		clear(s.live)
+	}
+	s.cache.Set(key, e)
+	s.live[key] = span
+}
+
+// read answers from the cache or, on a miss, from fetch, caching what it
+// returns. Not finding an event is cached too.
+func read[T any](
+	s *Storage,
+	operation string,
+	key hw04lrucache.Key,
+	span span,
+	fetch func() (T, error),
+	clone func(T) T,
+) (T, error) {
+	if e, ok := s.lookup(key); ok {
+		metrics.CacheRequests.WithLabelValues(operation, "hit").Inc()
+		value, _ := e.value.(T)
+		return clone(value), e.err
+	}
+	metrics.CacheRequests.WithLabelValues(operation, "miss").Inc()
+
+	s.mu.Lock()
+	writes := s.writes
+	s.mu.Unlock()
+
+	value, err := fetch()
+	if err == nil || errors.Is(err, storage.ErrEventDoesntExist) || errors.Is(err, storage.ErrNoEventsFound) {
+		s.store(key, span, writes, entry{value: clone(value), err: err, expires: s.clock.Now().Add(s.ttl)})
+	}
+
+	return value, err
+}
+
+func cloneEvent(event *storage.Event) *storage.Event {
+	if event == nil {
+		return nil
+	}
+	clone := *event
+
+	return &clone
+}
+
+// invalidate drops the cached events with ids and the lists that may hold
+// an event on one of dates. With all set, every list is dropped.
+func (s *Storage) invalidate(ids []string, all bool, dates ...time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.writes++
+	s.lastWrite = s.clock.Now()
+	for _, id := range ids {
+		delete(s.live, eventKey(id))
+	}
+	for key, span := range s.live {
+		if span.from.IsZero() {
+			continue
+		}
+		if all || slices.ContainsFunc(dates, span.covers) {
+			delete(s.live, key)
+		}
+	}
+}
+
+func (s *Storage) GetEvent(ctx context.Context, id string) (*storage.Event, error) {
+	return read(s, "GetEvent", eventKey(id), span{}, func() (*storage.Event, error) {
+		return s.next.GetEvent(ctx, id)
+	}, cloneEvent)
+}
+
+func (s *Storage) GetEventsListDay(ctx context.Context, date time.Time) ([]storage.Event, error) {
+	return read(s, "GetEventsListDay", listKey("day", date), span{date, date.AddDate(0, 0, 1)},
+		func() ([]storage.Event, error) {
+			return s.next.GetEventsListDay(ctx, date)
+		}, slices.Clone)
+}
+
+func (s *Storage) GetEventsListWeek(ctx context.Context, date time.Time) ([]storage.Event, error) {
+	return read(s, "GetEventsListWeek", listKey("week", date), span{date, date.AddDate(0, 0, 7)},
+		func() ([]storage.Event, error) {
+			return s.next.GetEventsListWeek(ctx, date)
+		}, slices.Clone)
+}
+
+func (s *Storage) GetEventsListMonth(ctx context.Context, date time.Time) ([]storage.Event, error) {
+	return read(s, "GetEventsListMonth", listKey("month", date), span{date, date.AddDate(0, 1, 0)},
+		func() ([]storage.Event, error) {
+			return s.next.GetEventsListMonth(ctx, date)
+		}, slices.Clone)
+}
+
+// CountUserEvents is not cached: quotas must see every event created.
+func (s *Storage) CountUserEvents(ctx context.Context, userID string) (int, error) {
+	return s.next.CountUserEvents(ctx, userID)
+}
+
+func (s *Storage) CreateEvent(ctx context.Context, event storage.Event) error {
+	err := s.next.CreateEvent(ctx, event)
+	s.invalidate([]string{event.ID}, false, event.Date)
+
+	return err
+}
+
+// EditEvent drops the lists of the old date of the event as well as of the
+// new one. The old date is read from the primary, as a replica may not have
+// the latest edit yet. When it cannot be read, every list is dropped.
+func (s *Storage) EditEvent(ctx context.Context, id string, update storage.Event) error {
+	old, oldErr := s.next.GetEvent(storage.WithPrimary(ctx), id)
+	err := s.next.EditEvent(ctx, id, update)
+	if oldErr != nil {
+		s.invalidate([]string{id}, !errors.Is(oldErr, storage.ErrEventDoesntExist), update.Date)
+		return err
+	}
+	s.invalidate([]string{id}, false, old.Date, update.Date)
+
+	return err
+}
+
+func (s *Storage) DeleteEvent(ctx context.Context, id string) error {
+	old, oldErr := s.next.GetEvent(storage.WithPrimary(ctx), id)
+	err := s.next.DeleteEvent(ctx, id)
+	if oldErr != nil {
+		s.invalidate([]string{id}, !errors.Is(oldErr, storage.ErrEventDoesntExist))
+		return err
+	}
+	s.invalidate([]string{id}, false, old.Date)
+
+	return err
+}
@@ -0,0 +1,370 @@
+package cachestorage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/clock"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/metrics"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+	memorystorage "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage/memory"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// countingBackend counts the reads that reach the backend. afterRead, when
+// set, runs after each of them, before the result is returned.
+type countingBackend struct {
+	Backend
+	reads     atomic.Int64
+	afterRead func()
+}
+
+func (b *countingBackend) read() {
+	b.reads.Add(1)
+	if b.afterRead != nil {
+		b.afterRead()
+	}
+}
+
+func (b *countingBackend) GetEvent(ctx context.Context, id string) (*storage.Event, error) {
+	defer b.read()
+	return b.Backend.GetEvent(ctx, id)
+}
+
+func (b *countingBackend) GetEventsListDay(ctx context.Context, date time.Time) ([]storage.Event, error) {
+	defer b.read()
+	return b.Backend.GetEventsListDay(ctx, date)
+}
+
+func (b *countingBackend) GetEventsListWeek(ctx context.Context, date time.Time) ([]storage.Event, error) {
+	defer b.read()
+	return b.Backend.GetEventsListWeek(ctx, date)
+}
+
+func (b *countingBackend) GetEventsListMonth(ctx context.Context, date time.Time) ([]storage.Event, error) {
+	defer b.read()
+	return b.Backend.GetEventsListMonth(ctx, date)
+}
+
+var (
+	october = time.Date(2024, time.October, 1, 0, 0, 0, 0, time.UTC)
+	userID  = "2f1a6a8e-52f4-4b7c-9d55-0f3e0a1c2b3d"
+)
+
+func newCached(config Config) (*Storage, *countingBackend, *clock.Fake) {
+	clk := clock.NewFake(october)
+	backend := &countingBackend{Backend: memorystorage.New(clk)}
+
+	return New(backend, config, clk), backend, clk
+}
+
+func ids(events []storage.Event) []string {
+	result := make([]string, len(events))
+	for i, event := range events {
+		result[i] = event.ID
+	}
+
+	return result
+}
+
+func TestReadThrough(t *testing.T) {
+	s, backend, _ := newCached(DefaultConfig)
+	ctx := context.Background()
+	require.NoError(t, s.CreateEvent(ctx, storage.Event{ID: "1", Date: october.Add(time.Hour), UserID: userID}))
+
+	hits := metrics.CacheRequests.WithLabelValues("GetEventsListMonth", "hit")
+	misses := metrics.CacheRequests.WithLabelValues("GetEventsListMonth", "miss")
+	hitsBefore, missesBefore := testutil.ToFloat64(hits), testutil.ToFloat64(misses)
+
+	for i := 0; i < 3; i++ {
+		events, err := s.GetEventsListMonth(ctx, october)
+		require.NoError(t, err)
+		require.Equal(t, []string{"1"}, ids(events))
+		events[0].Title = "changed by the caller"
+	}
+	require.Equal(t, int64(1), backend.reads.Load())
+	require.Equal(t, hitsBefore+2, testutil.ToFloat64(hits))
+	require.Equal(t, missesBefore+1, testutil.ToFloat64(misses))
+
+	event, err := s.GetEvent(ctx, "1")
+	require.NoError(t, err)
+	require.Empty(t, event.Title, "callers get copies of what is cached")
+
+	_, err = s.GetEvent(ctx, "missing")
+	require.ErrorIs(t, err, storage.ErrEventDoesntExist)
+	_, err = s.GetEvent(ctx, "missing")
+	require.ErrorIs(t, err, storage.ErrEventDoesntExist)
+	require.Equal(t, int64(3), backend.reads.Load(), "missing events are cached too")
+}
+
+func TestNoStaleReadsAfterWrites(t *testing.T) {
+	ctx := context.Background()
+	november := october.AddDate(0, 1, 0)
+	lists := map[string]func(*Storage, time.Time) ([]storage.Event, error){
+		"day": func(s *Storage, date time.Time) ([]storage.Event, error) {
+			return s.GetEventsListDay(ctx, date)
+		},
+		"week": func(s *Storage, date time.Time) ([]storage.Event, error) {
+			return s.GetEventsListWeek(ctx, date)
+		},
+		"month": func(s *Storage, date time.Time) ([]storage.Event, error) {
+			return s.GetEventsListMonth(ctx, date)
+		},
+	}
+	requireList := func(t *testing.T, s *Storage, date time.Time, want ...string) {
+		t.Helper()
+		for name, list := range lists {
+			events, err := list(s, date)
+			if len(want) == 0 {
+				require.ErrorIs(t, err, storage.ErrNoEventsFound, name)
+				continue
+			}
+			require.NoError(t, err, name)
+			require.ElementsMatch(t, want, ids(events), name)
+		}
+	}
+
+	t.Run("create", func(t *testing.T) {
+		s, _, _ := newCached(DefaultConfig)
+		requireList(t, s, october)
+		_, err := s.GetEvent(ctx, "1")
+		require.ErrorIs(t, err, storage.ErrEventDoesntExist)
+
+		require.NoError(t, s.CreateEvent(ctx, storage.Event{ID: "1", Date: october.Add(time.Hour), UserID: userID}))
+		requireList(t, s, october, "1")
+		_, err = s.GetEvent(ctx, "1")
+		require.NoError(t, err)
+	})
+
+	t.Run("edit moving the event to another month", func(t *testing.T) {
+		s, _, _ := newCached(DefaultConfig)
+		require.NoError(t, s.CreateEvent(ctx, storage.Event{ID: "1", Date: october.Add(time.Hour), UserID: userID}))
+		requireList(t, s, october, "1")
+		requireList(t, s, november)
+
+		require.NoError(t, s.EditEvent(ctx, "1", storage.Event{
+			Title: "moved", Date: november.Add(time.Hour), UserID: userID,
+		}))
+		requireList(t, s, october)
+		requireList(t, s, november, "1")
+		event, err := s.GetEvent(ctx, "1")
+		require.NoError(t, err)
+		require.Equal(t, "moved", event.Title)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		s, _, _ := newCached(DefaultConfig)
+		require.NoError(t, s.CreateEvent(ctx, storage.Event{ID: "1", Date: october.Add(time.Hour), UserID: userID}))
+		require.NoError(t, s.CreateEvent(ctx, storage.Event{ID: "2", Date: october.Add(2 * time.Hour), UserID: userID}))
+		requireList(t, s, october, "1", "2")
+		_, err := s.GetEvent(ctx, "1")
+		require.NoError(t, err)
+
+		require.NoError(t, s.DeleteEvent(ctx, "1"))
+		requireList(t, s, october, "2")
+		_, err = s.GetEvent(ctx, "1")
+		require.ErrorIs(t, err, storage.ErrEventDoesntExist)
+	})
+
+	t.Run("unrelated lists stay cached", func(t *testing.T) {
+		s, backend, _ := newCached(DefaultConfig)
+		requireList(t, s, november)
+		reads := backend.reads.Load()
+
+		require.NoError(t, s.CreateEvent(ctx, storage.Event{ID: "1", Date: october.Add(time.Hour), UserID: userID}))
+		requireList(t, s, november)
+		require.Equal(t, reads, backend.reads.Load())
+	})
+}
+
+func TestReadRacingWrite(t *testing.T) {
+	s, backend, _ := newCached(DefaultConfig)
+	ctx := context.Background()
+
+	// The write lands after the first read got the empty day from the
+	// backend and before it stores it.
+	read, written := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	backend.afterRead = func() {
+		once.Do(func() {
+			close(read)
+			<-written
+		})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.GetEventsListDay(ctx, october)
+	}()
+	<-read
+	require.NoError(t, s.CreateEvent(ctx, storage.Event{ID: "1", Date: october.Add(time.Hour), UserID: userID}))
+	close(written)
+	<-done
+
+	events, err := s.GetEventsListDay(ctx, october)
+	require.NoError(t, err)
+	require.Equal(t, []string{"1"}, ids(events))
+}
+
+func TestLimits(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("ttl", func(t *testing.T) {
+		s, backend, clk := newCached(Config{Size: 10, TTL: time.Minute})
+		s.GetEventsListDay(ctx, october)
+		s.GetEventsListDay(ctx, october)
+		require.Equal(t, int64(1), backend.reads.Load())
+
+		clk.Advance(time.Minute)
+		s.GetEventsListDay(ctx, october)
+		require.Equal(t, int64(2), backend.reads.Load(), "expired entries are read again")
+	})
+
+	t.Run("size", func(t *testing.T) {
+		s, backend, _ := newCached(Config{Size: 2, TTL: time.Minute})
+		for day := 0; day < 3; day++ {
+			s.GetEventsListDay(ctx, october.AddDate(0, 0, day))
+		}
+		s.GetEventsListDay(ctx, october.AddDate(0, 0, 2))
+		require.Equal(t, int64(3), backend.reads.Load())
+
+		s.GetEventsListDay(ctx, october)
+		require.Equal(t, int64(4), backend.reads.Load(), "the least recently used entry is evicted")
+	})
+}
+
+// TestConcurrentReadsSeeOwnWrites has writers check that each of their
+// writes is visible to the read that follows it, while others keep the
+// cache busy with the same lists.
+func TestConcurrentReadsSeeOwnWrites(t *testing.T) {
+	s, _, _ := newCached(Config{Size: 16, TTL: time.Hour})
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				id := fmt.Sprintf("%d-%d", w, i)
+				date := october.AddDate(0, 0, i%5).Add(time.Duration(w+1) * time.Minute)
+				if err := s.CreateEvent(ctx, storage.Event{ID: id, Date: date, UserID: userID}); err != nil {
+					t.Error(err)
+					return
+				}
+				events, _ := s.GetEventsListWeek(ctx, october)
+				if !contains(events, id) {
+					t.Errorf("created event %s is missing", id)
+				}
+				if err := s.DeleteEvent(ctx, id); err != nil {
+					t.Error(err)
+					return
+				}
+				events, _ = s.GetEventsListWeek(ctx, october)
+				if contains(events, id) {
+					t.Errorf("deleted event %s is still listed", id)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func contains(events []storage.Event, id string) bool {
+	for _, event := range events {
+		if event.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// laggingBackend writes to a primary and reads from a replica that gets the
+// writes only on catchUp, unless the read asks for the primary.
+type laggingBackend struct {
+	*memorystorage.Storage
+	replica      *memorystorage.Storage
+	pending      []func() error
+	primaryReads int
+}
+
+func newLaggingBackend(clk clock.Clock) *laggingBackend {
+	return &laggingBackend{Storage: memorystorage.New(clk), replica: memorystorage.New(clk)}
+}
+
+func (b *laggingBackend) catchUp(t *testing.T) {
+	t.Helper()
+	for _, write := range b.pending {
+		require.NoError(t, write())
+	}
+	b.pending = nil
+}
+
+func (b *laggingBackend) CreateEvent(ctx context.Context, event storage.Event) error {
+	b.pending = append(b.pending, func() error { return b.replica.CreateEvent(ctx, event) })
+	return b.Storage.CreateEvent(ctx, event)
+}
+
+func (b *laggingBackend) EditEvent(ctx context.Context, id string, update storage.Event) error {
+	b.pending = append(b.pending, func() error { return b.replica.EditEvent(ctx, id, update) })
+	return b.Storage.EditEvent(ctx, id, update)
+}
+
+func (b *laggingBackend) DeleteEvent(ctx context.Context, id string) error {
+	b.pending = append(b.pending, func() error { return b.replica.DeleteEvent(ctx, id) })
+	return b.Storage.DeleteEvent(ctx, id)
+}
+
+func (b *laggingBackend) GetEvent(ctx context.Context, id string) (*storage.Event, error) {
+	if storage.ReadsPrimary(ctx) {
+		b.primaryReads++
+		return b.Storage.GetEvent(ctx, id)
+	}
+
+	return b.replica.GetEvent(ctx, id)
+}
+
+func (b *laggingBackend) GetEventsListDay(ctx context.Context, date time.Time) ([]storage.Event, error) {
+	return b.replica.GetEventsListDay(ctx, date)
+}
+
+func TestReplicaLag(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFake(october)
+	backend := newLaggingBackend(clk)
+	s := New(backend, Config{Size: 10, TTL: time.Minute, ReplicaLag: 5 * time.Second}, clk)
+	date := october.Add(time.Hour)
+
+	require.NoError(t, s.CreateEvent(ctx, storage.Event{ID: "1", Date: date, UserID: userID}))
+	_, err := s.GetEvent(ctx, "1")
+	require.ErrorIs(t, err, storage.ErrEventDoesntExist, "the replica lags behind")
+	_, err = s.GetEventsListDay(ctx, october)
+	require.ErrorIs(t, err, storage.ErrNoEventsFound)
+
+	backend.catchUp(t)
+	_, err = s.GetEvent(ctx, "1")
+	require.NoError(t, err, "reads right after a write are not cached")
+	events, err := s.GetEventsListDay(ctx, october)
+	require.NoError(t, err)
+	require.Equal(t, []string{"1"}, ids(events))
+
+	clk.Advance(5 * time.Second)
+	require.NoError(t, s.EditEvent(ctx, "1", storage.Event{Title: "edited", Date: date, UserID: userID}))
+	require.NoError(t, s.DeleteEvent(ctx, "1"))
+	require.Equal(t, 2, backend.primaryReads, "writes look up the old event on the primary")
+
+	backend.catchUp(t)
+	clk.Advance(5 * time.Second)
+	_, err = s.GetEvent(ctx, "1")
+	require.ErrorIs(t, err, storage.ErrEventDoesntExist)
+	require.NoError(t, backend.replica.CreateEvent(ctx, storage.Event{ID: "1", Date: date, UserID: userID}))
+	_, err = s.GetEvent(ctx, "1")
+	require.ErrorIs(t, err, storage.ErrEventDoesntExist, "reads are cached once the replica caught up")
+}
@@ -0,0 +1,20 @@
+package storage
+
+import "time"
+
+type DigestKind string
+
+const (
+	DigestDaily  DigestKind = "daily"
+	DigestWeekly DigestKind = "weekly"
+)
+
+// DigestSubscription is a user's wish to receive an agenda digest. Daily
+// digests cover the current day, weekly ones the week starting on Monday.
+type DigestSubscription struct {
+	UserID   string
+	Kind     DigestKind
+	Timezone string
+	// SendAt is the local time of day as an offset from midnight.
+	SendAt time.Duration
+}
@@ -25,6 +25,8 @@ type Event struct {
 	UserID                    string             `json:"user_id"`
 	AdvanceNotificationPeriod time.Duration      `json:"advance_notification_period"`
 	NotificationStatus        NotificationStatus `json:"-"`
+	ClaimedBy                 string             `json:"-"`
+	LeaseExpiresAt            time.Time          `json:"-"`
 }
 
 var (
@@ -32,6 +34,7 @@ var (
 	ErrEventAlreadyExists = errors.New("event already exists")
 	ErrEventDoesntExist   = errors.New("event doesn't exist")
 	ErrNoEventsFound      = errors.New("no events found")
+	ErrNotConnected       = errors.New("storage is not connected")
 )
 
 func ValidateEvent(validator validator.Validator, event Event) {
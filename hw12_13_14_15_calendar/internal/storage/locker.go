@@ -0,0 +1,11 @@
+package storage
+
+import "context"
+
+// Locker is a cluster-wide mutex. TryLock never blocks: it reports whether
+// the caller holds the lock after the call, so it can be polled to both
+// acquire the lock and confirm it is still held.
+type Locker interface {
+	TryLock(ctx context.Context) (bool, error)
+	Unlock(ctx context.Context) error
+}
@@ -0,0 +1,129 @@
+package memorystorage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+)
+
+type subscriptionKey struct {
+	userID string
+	kind   storage.DigestKind
+}
+
+type digestKey struct {
+	userID      string
+	kind        storage.DigestKind
+	periodStart string
+}
+
+// digest is a claimed digest. A zero leaseExpiresAt means it was published.
+type digest struct {
+	status         storage.NotificationStatus
+	leaseExpiresAt time.Time
+}
+
+func newDigestKey(userID string, kind storage.DigestKind, periodStart time.Time) digestKey {
+	return digestKey{userID: userID, kind: kind, periodStart: periodStart.Format(time.DateOnly)}
+}
+
+func (s *Storage) SetDigestSubscription(_ context.Context, subscription storage.DigestSubscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.subscriptions[subscriptionKey{userID: subscription.UserID, kind: subscription.Kind}] = subscription
+
+	return nil
+}
+
+func (s *Storage) GetDigestSubscriptions(_ context.Context) ([]storage.DigestSubscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]storage.DigestSubscription, 0, len(s.subscriptions))
+	for _, subscription := range s.subscriptions {
+		result = append(result, subscription)
+	}
+
+	return result, nil
+}
+
+func (s *Storage) ClaimDigest(
+	_ context.Context,
+	userID string,
+	kind storage.DigestKind,
+	periodStart time.Time,
+	lease time.Duration,
+) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	key := newDigestKey(userID, kind, periodStart)
+	if d, ok := s.digests[key]; ok {
+		expired := d.status == storage.StatusSending && !d.leaseExpiresAt.IsZero() && d.leaseExpiresAt.Before(now)
+		if !expired {
+			return false, nil
+		}
+	}
+	s.digests[key] = digest{status: storage.StatusSending, leaseExpiresAt: now.Add(lease)}
+
+	return true, nil
+}
+
+func (s *Storage) MarkDigestPublished(
+	_ context.Context,
+	userID string,
+	kind storage.DigestKind,
+	periodStart time.Time,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := newDigestKey(userID, kind, periodStart)
+	d, ok := s.digests[key]
+	if !ok {
+		return fmt.Errorf("memorystorage.MarkDigestPublished: digest was not claimed")
+	}
+	d.leaseExpiresAt = time.Time{}
+	s.digests[key] = d
+
+	return nil
+}
+
+func (s *Storage) ReleaseDigest(
+	_ context.Context,
+	userID string,
+	kind storage.DigestKind,
+	periodStart time.Time,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := newDigestKey(userID, kind, periodStart)
+	if d, ok := s.digests[key]; ok && !d.leaseExpiresAt.IsZero() {
+		delete(s.digests, key)
+	}
+
+	return nil
+}
+
+func (s *Storage) SetDigestSent(
+	_ context.Context,
+	userID string,
+	kind storage.DigestKind,
+	periodStart time.Time,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := newDigestKey(userID, kind, periodStart)
+	if _, ok := s.digests[key]; !ok {
+		return fmt.Errorf("memorystorage.SetDigestSent: digest was not claimed")
+	}
+	s.digests[key] = digest{status: storage.StatusSent}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package memorystorage
+
+import (
+	"context"
+	"sync"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+)
+
+type locks struct {
+	mu      sync.Mutex
+	holders map[int64]*Locker
+}
+
+type Locker struct {
+	key   int64
+	locks *locks
+}
+
+func (s *Storage) NewLocker(key int64) storage.Locker {
+	return &Locker{key: key, locks: &s.locks}
+}
+
+func (l *Locker) TryLock(_ context.Context) (bool, error) {
+	l.locks.mu.Lock()
+	defer l.locks.mu.Unlock()
+
+	if l.locks.holders == nil {
+		l.locks.holders = make(map[int64]*Locker)
+	}
+
+	holder, ok := l.locks.holders[l.key]
+	if !ok {
+		l.locks.holders[l.key] = l
+		return true, nil
+	}
+
+	return holder == l, nil
+}
+
+func (l *Locker) Unlock(_ context.Context) error {
+	l.locks.mu.Lock()
+	defer l.locks.mu.Unlock()
+
+	if l.locks.holders[l.key] == l {
+		delete(l.locks.holders, l.key)
+	}
+
+	return nil
+}
@@ -3,20 +3,36 @@ package memorystorage
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/clock"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
 	"github.com/google/uuid"
 )
 
 type Storage struct {
-	events map[string]storage.Event
-	mu     sync.RWMutex
+	events        map[string]storage.Event
+	subscriptions map[subscriptionKey]storage.DigestSubscription
+	digests       map[digestKey]digest
+	mu            sync.RWMutex
+	clock         clock.Clock
+	locks         locks
 }
 
-func New() *Storage {
-	return &Storage{events: make(map[string]storage.Event)}
+func New(clock clock.Clock) *Storage {
+	return &Storage{
+		events:        make(map[string]storage.Event),
+		subscriptions: make(map[subscriptionKey]storage.DigestSubscription),
+		digests:       make(map[digestKey]digest),
+		clock:         clock,
+	}
+}
+
+// Ping always succeeds: there is nothing to connect to.
+func (s *Storage) Ping(_ context.Context) error {
+	return nil
 }
 
 func (s *Storage) CreateEvent(_ context.Context, event storage.Event) error {
@@ -37,6 +53,20 @@ func (s *Storage) CreateEvent(_ context.Context, event storage.Event) error {
 	return nil
 }
 
+func (s *Storage) CountUserEvents(_ context.Context, userID string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for _, event := range s.events {
+		if event.UserID == userID {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
 func (s *Storage) GetEvent(_ context.Context, id string) (*storage.Event, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -113,6 +143,25 @@ func (s *Storage) GetEventsListMonth(_ context.Context, date time.Time) ([]stora
 	return events, nil
 }
 
+// GetUserEvents returns the events of userID dated in [from, to), earliest
+// first.
+func (s *Storage) GetUserEvents(_ context.Context, userID string, from, to time.Time) ([]storage.Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := []storage.Event{}
+	for _, event := range s.events {
+		if event.UserID == userID && !event.Date.Before(from) && event.Date.Before(to) {
+			result = append(result, event)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Date.Before(result[j].Date)
+	})
+
+	return result, nil
+}
+
 func (s *Storage) getEventsListTo(start time.Time, end time.Time) ([]storage.Event, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -135,19 +184,72 @@ func (s *Storage) GetEventsToNotify(_ context.Context) ([]storage.Event, error)
 	defer s.mu.RUnlock()
 
 	var result []storage.Event
-	currentDate := time.Now()
+	now := s.clock.Now()
+
+	for _, event := range s.events {
+		if !event.Date.Add(-event.AdvanceNotificationPeriod).After(now) &&
+			event.NotificationStatus == storage.StatusIdle {
+			result = append(result, event)
+		}
+	}
+
+	return result, nil
+}
+
+func (s *Storage) ClaimEventsToNotify(
+	_ context.Context,
+	workerID string,
+	limit int,
+	lease time.Duration,
+) ([]storage.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result []storage.Event
+	now := s.clock.Now()
 
 	for _, event := range s.events {
-		if event.Date.Add(-event.AdvanceNotificationPeriod).Before(currentDate) &&
+		if !event.Date.Add(-event.AdvanceNotificationPeriod).After(now) &&
 			event.NotificationStatus == storage.StatusIdle {
 			result = append(result, event)
 		}
 	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Date.Before(result[j].Date)
+	})
+	if len(result) > limit {
+		result = result[:limit]
+	}
+
+	for i := range result {
+		result[i].NotificationStatus = storage.StatusSending
+		result[i].ClaimedBy = workerID
+		result[i].LeaseExpiresAt = now.Add(lease)
+		s.events[result[i].ID] = result[i]
+	}
 
 	return result, nil
 }
 
-func (s *Storage) MarkNotified(_ context.Context, ids []string) error {
+func (s *Storage) ReleaseExpiredClaims(_ context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	for id, event := range s.events {
+		if event.NotificationStatus == storage.StatusSending &&
+			!event.LeaseExpiresAt.IsZero() && event.LeaseExpiresAt.Before(now) {
+			event.NotificationStatus = storage.StatusIdle
+			event.ClaimedBy = ""
+			event.LeaseExpiresAt = time.Time{}
+			s.events[id] = event
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) MarkNotified(_ context.Context, workerID string, ids []string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -157,8 +259,10 @@ func (s *Storage) MarkNotified(_ context.Context, ids []string) error {
 	}
 
 	for id, event := range s.events {
-		if _, exists := idSet[id]; exists {
-			event.NotificationStatus = storage.StatusSending
+		_, exists := idSet[id]
+		if exists && event.NotificationStatus == storage.StatusSending && event.ClaimedBy == workerID {
+			event.ClaimedBy = ""
+			event.LeaseExpiresAt = time.Time{}
 			s.events[id] = event
 		}
 	}
@@ -170,7 +274,7 @@ func (s *Storage) ClearEvents(_ context.Context, duration time.Duration) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	date := time.Now().Add(-duration)
+	date := s.clock.Now().Add(-duration)
 	for id, event := range s.events {
 		if event.Date.Before(date) {
 			delete(s.events, id)
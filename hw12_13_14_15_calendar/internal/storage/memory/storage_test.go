@@ -7,18 +7,24 @@ import (
 	"testing"
 	"time"
 
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/clock"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
 	"github.com/stretchr/testify/require"
 )
 
 func TestStorage(t *testing.T) {
-	s := New()
-	require.Equal(t, &Storage{events: make(map[string]storage.Event)}, s)
+	s := New(clock.New())
+	require.Equal(t, &Storage{
+		events:        make(map[string]storage.Event),
+		subscriptions: make(map[subscriptionKey]storage.DigestSubscription),
+		digests:       make(map[digestKey]digest),
+		clock:         clock.New(),
+	}, s)
 }
 
 func TestCreateEvent(t *testing.T) {
 	t.Run("creates event in storage", func(t *testing.T) {
-		s := New()
+		s := New(clock.New())
 		event := storage.Event{ID: "1"}
 		err := s.CreateEvent(context.TODO(), event)
 
@@ -27,7 +33,7 @@ func TestCreateEvent(t *testing.T) {
 	})
 
 	t.Run("concurency", func(t *testing.T) {
-		s := New()
+		s := New(clock.New())
 
 		const goroutines = 100
 		var wg sync.WaitGroup
@@ -45,9 +51,26 @@ func TestCreateEvent(t *testing.T) {
 	})
 }
 
+func TestCountUserEvents(t *testing.T) {
+	s := New(clock.New())
+	s.events = map[string]storage.Event{
+		"1": {ID: "1", UserID: "a"},
+		"2": {ID: "2", UserID: "a"},
+		"3": {ID: "3", UserID: "b"},
+	}
+
+	count, err := s.CountUserEvents(context.TODO(), "a")
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+
+	count, err = s.CountUserEvents(context.TODO(), "c")
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
+
 func TestGetEvent(t *testing.T) {
 	t.Run("returns event by id", func(t *testing.T) {
-		s := New()
+		s := New(clock.New())
 		e := storage.Event{ID: "1"}
 		err := s.CreateEvent(context.TODO(), e)
 		require.NoError(t, err)
@@ -59,7 +82,7 @@ func TestGetEvent(t *testing.T) {
 	})
 
 	t.Run("returns error if event doesn't exist", func(t *testing.T) {
-		s := New()
+		s := New(clock.New())
 		event, err := s.GetEvent(context.TODO(), "1")
 
 		require.ErrorIs(t, err, storage.ErrEventDoesntExist)
@@ -67,7 +90,7 @@ func TestGetEvent(t *testing.T) {
 	})
 
 	t.Run("concurency", func(t *testing.T) {
-		s := New()
+		s := New(clock.New())
 		const goroutines = 100
 		var wg sync.WaitGroup
 		wg.Add(goroutines)
@@ -93,7 +116,7 @@ func TestGetEvent(t *testing.T) {
 
 func TestDeleteEvent(t *testing.T) {
 	t.Run("deletes event by id", func(t *testing.T) {
-		s := New()
+		s := New(clock.New())
 		err := s.CreateEvent(context.TODO(), storage.Event{ID: "1"})
 		require.NoError(t, err)
 
@@ -103,13 +126,13 @@ func TestDeleteEvent(t *testing.T) {
 	})
 
 	t.Run("returns error if event doesn't exist", func(t *testing.T) {
-		s := New()
+		s := New(clock.New())
 		err := s.DeleteEvent(context.TODO(), "1")
 		require.ErrorIs(t, err, storage.ErrEventDoesntExist)
 	})
 
 	t.Run("concurency", func(t *testing.T) {
-		s := New()
+		s := New(clock.New())
 
 		const goroutines = 100
 		var wg sync.WaitGroup
@@ -133,7 +156,7 @@ func TestDeleteEvent(t *testing.T) {
 
 func TestEditEvent(t *testing.T) {
 	t.Run("changes event data by id", func(t *testing.T) {
-		s := New()
+		s := New(clock.New())
 		s.CreateEvent(context.TODO(), storage.Event{ID: "1"})
 
 		err := s.EditEvent(context.TODO(), "1", storage.Event{ID: "1", Title: "Event #1"})
@@ -143,14 +166,14 @@ func TestEditEvent(t *testing.T) {
 	})
 
 	t.Run("returns error if event doesn't exist", func(t *testing.T) {
-		s := New()
+		s := New(clock.New())
 		err := s.EditEvent(context.TODO(), "1", storage.Event{Title: "Event #1"})
 
 		require.ErrorIs(t, err, storage.ErrEventDoesntExist)
 	})
 
 	t.Run("concurency", func(t *testing.T) {
-		s := New()
+		s := New(clock.New())
 
 		const goroutines = 100
 		var wg sync.WaitGroup
@@ -173,7 +196,7 @@ func TestEditEvent(t *testing.T) {
 }
 
 func TestGetEventListDay(t *testing.T) {
-	s := New()
+	s := New(clock.New())
 	s.CreateEvent(context.TODO(), storage.Event{ID: "1", Date: time.Now().Add(-time.Hour * 25), EndDate: time.Now()})
 	e2 := storage.Event{ID: "2", Date: time.Now().Add(-time.Hour * 10), EndDate: time.Now()}
 	s.CreateEvent(context.TODO(), e2)
@@ -191,7 +214,7 @@ func TestGetEventListDay(t *testing.T) {
 }
 
 func TestGetEventListWeek(t *testing.T) {
-	s := New()
+	s := New(clock.New())
 	s.CreateEvent(context.TODO(), storage.Event{ID: "1", Date: time.Now().AddDate(0, 0, -7), EndDate: time.Now()})
 	e2 := storage.Event{ID: "2", Date: time.Now().AddDate(0, 0, 3), EndDate: time.Now().AddDate(0, 0, 5)}
 	s.CreateEvent(context.TODO(), e2)
@@ -209,7 +232,7 @@ func TestGetEventListWeek(t *testing.T) {
 }
 
 func TestGetEventListMonth(t *testing.T) {
-	s := New()
+	s := New(clock.New())
 	s.CreateEvent(context.TODO(), storage.Event{ID: "1", Date: time.Now().AddDate(0, -2, 0), EndDate: time.Now()})
 	e2 := storage.Event{ID: "2", Date: time.Now().AddDate(0, 0, 3), EndDate: time.Now().AddDate(0, 0, 5)}
 	s.CreateEvent(context.TODO(), e2)
@@ -226,28 +249,52 @@ func TestGetEventListMonth(t *testing.T) {
 	require.Equal(t, []storage.Event{e2}, list)
 }
 
+func TestGetUserEvents(t *testing.T) {
+	from := time.Date(2024, time.October, 10, 0, 0, 0, 0, time.FixedZone("AEST", 10*60*60))
+	to := from.AddDate(0, 0, 1)
+	s := New(clock.New())
+	events := []storage.Event{
+		{ID: "before", UserID: "alice", Date: from.Add(-time.Minute)},
+		{ID: "late", UserID: "alice", Date: to.Add(-time.Minute)},
+		{ID: "start", UserID: "alice", Date: from},
+		{ID: "end", UserID: "alice", Date: to},
+		{ID: "other user", UserID: "bob", Date: from.Add(time.Hour)},
+	}
+	for _, event := range events {
+		require.NoError(t, s.CreateEvent(context.TODO(), event))
+	}
+
+	list, err := s.GetUserEvents(context.TODO(), "alice", from, to)
+	require.NoError(t, err)
+	require.Equal(t, []storage.Event{events[2], events[1]}, list)
+
+	list, err = s.GetUserEvents(context.TODO(), "carol", from, to)
+	require.NoError(t, err)
+	require.Empty(t, list)
+}
+
 func TestGetEventsToNotify(t *testing.T) {
-	s := New()
-	currentTime := time.Now()
+	currentTime := time.Date(2024, time.October, 10, 9, 0, 0, 0, time.UTC)
+	s := New(clock.NewFake(currentTime))
 	s.events["1"] = storage.Event{
 		ID:                        "1",
 		Date:                      currentTime,
 		EndDate:                   currentTime,
-		AdvanceNotificationPeriod: 3600,
+		AdvanceNotificationPeriod: time.Hour,
 		NotificationStatus:        storage.StatusIdle,
 	}
 	s.events["2"] = storage.Event{
 		ID:                        "2",
 		Date:                      currentTime.Add(48 * time.Hour),
 		EndDate:                   currentTime.Add(48 * time.Hour),
-		AdvanceNotificationPeriod: 3600,
+		AdvanceNotificationPeriod: time.Hour,
 		NotificationStatus:        storage.StatusIdle,
 	}
 	s.events["3"] = storage.Event{
 		ID:                        "3",
 		Date:                      currentTime,
 		EndDate:                   currentTime,
-		AdvanceNotificationPeriod: 3600,
+		AdvanceNotificationPeriod: time.Hour,
 		NotificationStatus:        storage.StatusSending,
 	}
 
@@ -259,23 +306,64 @@ func TestGetEventsToNotify(t *testing.T) {
 	require.Equal(t, events[0], s.events["1"])
 }
 
-func TestMarkNotified(t *testing.T) {
-	s := New()
-	s.events["1"] = storage.Event{ID: "1", NotificationStatus: storage.StatusIdle}
-	s.events["2"] = storage.Event{ID: "2", NotificationStatus: storage.StatusIdle}
-	s.events["3"] = storage.Event{ID: "3", NotificationStatus: storage.StatusIdle}
+func TestGetEventsToNotifyPrecision(t *testing.T) {
+	start := time.Date(2024, time.October, 10, 9, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+	s := New(fake)
+	s.events["1"] = storage.Event{
+		ID:                        "1",
+		Date:                      start.Add(14 * time.Hour),
+		EndDate:                   start.Add(15 * time.Hour),
+		AdvanceNotificationPeriod: 30 * time.Minute,
+		NotificationStatus:        storage.StatusIdle,
+	}
 
-	err := s.MarkNotified(context.TODO(), []string{"1", "3"})
+	fake.Set(start.Add(13*time.Hour + 29*time.Minute))
+	events, err := s.GetEventsToNotify(context.TODO())
+	require.NoError(t, err)
+	require.Empty(t, events, "same day, but still a minute early")
 
+	fake.Advance(time.Minute)
+	events, err = s.GetEventsToNotify(context.TODO())
 	require.NoError(t, err)
-	require.Equal(t, s.events["1"].NotificationStatus, storage.StatusSending)
-	require.Equal(t, s.events["2"].NotificationStatus, storage.StatusIdle)
-	require.Equal(t, s.events["3"].NotificationStatus, storage.StatusSending)
+	require.Len(t, events, 1)
+}
+
+func TestMarkNotified(t *testing.T) {
+	currentTime := time.Date(2024, time.October, 10, 9, 0, 0, 0, time.UTC)
+	s := New(clock.NewFake(currentTime))
+	for _, id := range []string{"1", "2", "3", "4"} {
+		s.events[id] = storage.Event{ID: id, Date: currentTime, NotificationStatus: storage.StatusIdle}
+	}
+	_, err := s.ClaimEventsToNotify(context.TODO(), "worker", 3, time.Minute)
+	require.NoError(t, err)
+	var claimed []string
+	var idle string
+	for id, event := range s.events {
+		if event.ClaimedBy == "worker" {
+			claimed = append(claimed, id)
+		} else {
+			idle = id
+		}
+	}
+	require.Len(t, claimed, 3)
+
+	// The sender got to the first event before the scheduler marked it.
+	require.NoError(t, s.SetNotified(context.TODO(), claimed[0]))
+	require.NoError(t, s.MarkNotified(context.TODO(), "other", []string{claimed[2]}))
+	require.NoError(t, s.MarkNotified(context.TODO(), "worker", append(claimed[:2:2], idle)))
+
+	require.Equal(t, storage.StatusSent, s.events[claimed[0]].NotificationStatus, "sent is kept")
+	require.Equal(t, storage.StatusSending, s.events[claimed[1]].NotificationStatus)
+	require.Empty(t, s.events[claimed[1]].ClaimedBy)
+	require.True(t, s.events[claimed[1]].LeaseExpiresAt.IsZero())
+	require.Equal(t, "worker", s.events[claimed[2]].ClaimedBy, "claims of other workers are kept")
+	require.Equal(t, storage.StatusIdle, s.events[idle].NotificationStatus, "unclaimed events are kept")
 }
 
 func TestClearEvents(t *testing.T) {
-	s := New()
-	currentTime := time.Now()
+	currentTime := time.Date(2024, time.October, 10, 9, 0, 0, 0, time.UTC)
+	s := New(clock.NewFake(currentTime))
 	s.events["1"] = storage.Event{
 		ID:      "1",
 		Date:    currentTime,
@@ -297,3 +385,116 @@ func TestClearEvents(t *testing.T) {
 	_, ok = s.events["2"]
 	require.False(t, ok)
 }
+
+func TestLocker(t *testing.T) {
+	s := New(clock.New())
+	first := s.NewLocker(1)
+	second := s.NewLocker(1)
+	other := s.NewLocker(2)
+
+	locked, err := first.TryLock(context.TODO())
+	require.NoError(t, err)
+	require.True(t, locked)
+
+	locked, err = first.TryLock(context.TODO())
+	require.NoError(t, err)
+	require.True(t, locked, "holder keeps the lock")
+
+	locked, err = second.TryLock(context.TODO())
+	require.NoError(t, err)
+	require.False(t, locked)
+
+	locked, err = other.TryLock(context.TODO())
+	require.NoError(t, err)
+	require.True(t, locked, "different key")
+
+	require.NoError(t, second.Unlock(context.TODO()))
+	locked, err = first.TryLock(context.TODO())
+	require.NoError(t, err)
+	require.True(t, locked, "unlock by non-holder is a no-op")
+
+	require.NoError(t, first.Unlock(context.TODO()))
+	locked, err = second.TryLock(context.TODO())
+	require.NoError(t, err)
+	require.True(t, locked)
+}
+
+func TestClaimEventsToNotify(t *testing.T) {
+	currentTime := time.Date(2024, time.October, 10, 9, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(currentTime)
+	s := New(fake)
+	s.events["1"] = storage.Event{ID: "1", Date: currentTime, NotificationStatus: storage.StatusIdle}
+	s.events["2"] = storage.Event{ID: "2", Date: currentTime.Add(-time.Hour), NotificationStatus: storage.StatusIdle}
+	s.events["3"] = storage.Event{ID: "3", Date: currentTime.Add(-2 * time.Hour), NotificationStatus: storage.StatusSent}
+	s.events["4"] = storage.Event{ID: "4", Date: currentTime.Add(time.Hour), NotificationStatus: storage.StatusIdle}
+
+	events, err := s.ClaimEventsToNotify(context.TODO(), "worker", 1, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, "2", events[0].ID, "earliest event first")
+	require.Equal(t, storage.StatusSending, s.events["2"].NotificationStatus)
+	require.Equal(t, "worker", s.events["2"].ClaimedBy)
+	require.Equal(t, currentTime.Add(time.Minute), s.events["2"].LeaseExpiresAt)
+
+	events, err = s.ClaimEventsToNotify(context.TODO(), "other", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, "1", events[0].ID)
+
+	events, err = s.ClaimEventsToNotify(context.TODO(), "other", 10, time.Minute)
+	require.NoError(t, err)
+	require.Empty(t, events)
+}
+
+func TestReleaseExpiredClaims(t *testing.T) {
+	currentTime := time.Date(2024, time.October, 10, 9, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(currentTime)
+	s := New(fake)
+	s.events["1"] = storage.Event{ID: "1", Date: currentTime, NotificationStatus: storage.StatusIdle}
+	s.events["2"] = storage.Event{ID: "2", Date: currentTime, NotificationStatus: storage.StatusIdle}
+
+	_, err := s.ClaimEventsToNotify(context.TODO(), "worker", 10, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, s.MarkNotified(context.TODO(), "worker", []string{"2"}))
+
+	require.NoError(t, s.ReleaseExpiredClaims(context.TODO()))
+	require.Equal(t, storage.StatusSending, s.events["1"].NotificationStatus, "lease still valid")
+
+	fake.Advance(2 * time.Minute)
+	require.NoError(t, s.ReleaseExpiredClaims(context.TODO()))
+	require.Equal(t, storage.Event{ID: "1", Date: currentTime, NotificationStatus: storage.StatusIdle}, s.events["1"])
+	require.Equal(t, storage.StatusSending, s.events["2"].NotificationStatus, "published events are not reclaimed")
+}
+
+func TestClaimDigest(t *testing.T) {
+	const user = "66be96d3-3d5d-4aec-af9c-5b3769d0169a"
+	day := time.Date(2024, time.October, 10, 0, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(day.Add(9 * time.Hour))
+	s := New(fake)
+	ctx := context.TODO()
+
+	claim := func() bool {
+		t.Helper()
+		claimed, err := s.ClaimDigest(ctx, user, storage.DigestDaily, day, time.Minute)
+		require.NoError(t, err)
+
+		return claimed
+	}
+
+	require.True(t, claim())
+	require.False(t, claim(), "lease still valid")
+	fake.Advance(2 * time.Minute)
+	require.True(t, claim(), "expired lease is claimed again")
+
+	require.NoError(t, s.ReleaseDigest(ctx, user, storage.DigestDaily, day))
+	require.True(t, claim(), "released digest is claimed again")
+
+	require.NoError(t, s.MarkDigestPublished(ctx, user, storage.DigestDaily, day))
+	fake.Advance(2 * time.Minute)
+	require.False(t, claim(), "published digest is not reclaimed")
+	require.NoError(t, s.ReleaseDigest(ctx, user, storage.DigestDaily, day))
+	require.False(t, claim(), "published digest is not released")
+
+	require.NoError(t, s.SetDigestSent(ctx, user, storage.DigestDaily, day))
+	require.False(t, claim())
+}
@@ -0,0 +1,18 @@
+package storage
+
+import "context"
+
+type primaryKey struct{}
+
+// WithPrimary returns a copy of ctx asking storages that read from a
+// replica to read from the primary instead, for reads that must see the
+// writes made just before.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// ReadsPrimary reports whether ctx asks to read from the primary.
+func ReadsPrimary(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryKey{}).(bool)
+	return primary
+}
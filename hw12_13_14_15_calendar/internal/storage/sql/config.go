@@ -0,0 +1,212 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/backoff"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var ErrNoHost = errors.New("neither a DSN nor a host is set")
+
+// Config tells how to reach Postgres. DSN, a postgres:// URL or a libpq
+// key=value string, wins over the fields it would otherwise be built from.
+// Zero pool settings leave the database/sql defaults.
+type Config struct {
+	DSN            string
+	User           string
+	Password       string
+	Name           string
+	Host           string
+	Port           string
+	SSLMode        string // "disable" when empty
+	SSLRootCert    string
+	ConnectTimeout time.Duration
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+
+	// ConnectRetry applies while Connect waits for the server to come up,
+	// QueryRetry to each query.
+	ConnectRetry Retry
+	QueryRetry   Retry
+
+	// Replica, when its DSN or Host is set, serves GetEvent and the event
+	// lists.
+	Replica Replica
+}
+
+// Replica is reached with the credentials and options of the primary unless
+// it has a DSN of its own. Port defaults to the port of the primary.
+type Replica struct {
+	DSN  string
+	Host string
+	Port string
+}
+
+func (r Replica) enabled() bool {
+	return r.DSN != "" || r.Host != ""
+}
+
+// Retry makes up to Attempts tries, waiting as Backoff tells in between.
+// Attempts of 1 disables retrying, 0 takes the default.
+type Retry struct {
+	Attempts int
+	backoff.Backoff
+}
+
+var (
+	DefaultConnectRetry = Retry{
+		Attempts: 10,
+		Backoff:  backoff.Backoff{Initial: 500 * time.Millisecond, Max: 10 * time.Second},
+	}
+	DefaultQueryRetry = Retry{
+		Attempts: 3,
+		Backoff:  backoff.Backoff{Initial: 50 * time.Millisecond, Max: time.Second},
+	}
+)
+
+func (c Config) withDefaults() Config {
+	if c.SSLMode == "" {
+		c.SSLMode = "disable"
+	}
+	if c.ConnectRetry.Attempts == 0 {
+		c.ConnectRetry = DefaultConnectRetry
+	}
+	if c.QueryRetry.Attempts == 0 {
+		c.QueryRetry = DefaultQueryRetry
+	}
+
+	return c
+}
+
+// dsn builds the libpq key=value connection string.
+func (c Config) dsn() (string, error) {
+	if c.DSN != "" {
+		return c.DSN, nil
+	}
+	if c.Host == "" {
+		return "", ErrNoHost
+	}
+
+	var parts []string
+	for _, kv := range [][2]string{
+		{"host", c.Host},
+		{"port", c.Port},
+		{"user", c.User},
+		{"password", c.Password},
+		{"dbname", c.Name},
+		{"sslmode", c.SSLMode},
+		{"sslrootcert", c.SSLRootCert},
+	} {
+		if kv[1] != "" {
+			parts = append(parts, kv[0]+"="+quoteDSN(kv[1]))
+		}
+	}
+	if c.ConnectTimeout > 0 {
+		parts = append(parts, "connect_timeout="+strconv.Itoa(int(math.Ceil(c.ConnectTimeout.Seconds()))))
+	}
+
+	return strings.Join(parts, " "), nil
+}
+
+func (c Config) replicaDSN() (string, error) {
+	if c.Replica.DSN != "" {
+		return c.Replica.DSN, nil
+	}
+	if c.DSN != "" {
+		return "", fmt.Errorf("replica: needs a DSN when the primary has one")
+	}
+	replica := c
+	replica.Host = c.Replica.Host
+	if c.Replica.Port != "" {
+		replica.Port = c.Replica.Port
+	}
+
+	return replica.dsn()
+}
+
+// quoteDSN quotes a value of a key=value connection string as libpq expects.
+func quoteDSN(value string) string {
+	if value != "" && !strings.ContainsAny(value, ` '\`) {
+		return value
+	}
+
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// open opens a pool for dsn and waits, as ConnectRetry allows, for the
+// server to accept connections.
+func (c Config) open(ctx context.Context, dsn string) (*sqlx.DB, error) {
+	db, err := sqlx.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(c.MaxOpenConns)
+	if c.MaxIdleConns != 0 {
+		db.SetMaxIdleConns(c.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(c.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(c.ConnMaxIdleTime)
+
+	err = retry(ctx, c.ConnectRetry, true, func() error {
+		return db.PingContext(ctx)
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// retry runs fn until it succeeds, fails for a reason that will not go
+// away or runs out of attempts. Unless the operation is idempotent, it is
+// only retried when Postgres certainly did not apply it.
+func retry(ctx context.Context, r Retry, idempotent bool, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt+1 >= r.Attempts || !transient(err, idempotent) || !r.Wait(ctx, nil, attempt) {
+			return err
+		}
+	}
+}
+
+// transient reports whether err may go away on a retry. A connection lost
+// mid-query may have left the statement applied, so that counts only for
+// idempotent operations.
+func transient(err error, idempotent bool) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "40001", // serialization_failure
+			"40P01", // deadlock_detected
+			"53300", // too_many_connections
+			"57P01", // admin_shutdown
+			"57P02", // crash_shutdown
+			"57P03": // cannot_connect_now
+			return true
+		}
+		return pqErr.Code.Class() == "08" // connection_exception
+	}
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	if !idempotent {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
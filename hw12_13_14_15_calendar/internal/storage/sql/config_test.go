@@ -0,0 +1,131 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/backoff"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigDSN(t *testing.T) {
+	config := Config{
+		User:           "calendar",
+		Password:       `it's a \secret`,
+		Name:           "calendar",
+		Host:           "db",
+		Port:           "5432",
+		ConnectTimeout: 1500 * time.Millisecond,
+	}.withDefaults()
+
+	dsn, err := config.dsn()
+	require.NoError(t, err)
+	require.Equal(t,
+		`host=db port=5432 user=calendar password='it\'s a \\secret' dbname=calendar sslmode=disable connect_timeout=2`,
+		dsn)
+
+	config.DSN = "postgres://calendar@db/calendar"
+	dsn, err = config.dsn()
+	require.NoError(t, err)
+	require.Equal(t, "postgres://calendar@db/calendar", dsn, "a DSN wins over the fields")
+
+	_, err = Config{}.dsn()
+	require.ErrorIs(t, err, ErrNoHost)
+}
+
+func TestConfigReplicaDSN(t *testing.T) {
+	config := Config{User: "calendar", Name: "calendar", Host: "primary", Port: "5432", SSLMode: "require"}
+
+	config.Replica = Replica{Host: "replica"}
+	dsn, err := config.replicaDSN()
+	require.NoError(t, err)
+	require.Equal(t, "host=replica port=5432 user=calendar dbname=calendar sslmode=require", dsn)
+
+	config.Replica = Replica{Host: "replica", Port: "6432"}
+	dsn, err = config.replicaDSN()
+	require.NoError(t, err)
+	require.Contains(t, dsn, "host=replica port=6432 ")
+
+	config.DSN = "postgres://primary/calendar"
+	_, err = config.replicaDSN()
+	require.Error(t, err, "the replica cannot borrow the fields of a DSN")
+
+	config.Replica = Replica{DSN: "postgres://replica/calendar"}
+	dsn, err = config.replicaDSN()
+	require.NoError(t, err)
+	require.Equal(t, "postgres://replica/calendar", dsn)
+}
+
+func TestTransient(t *testing.T) {
+	netErr := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+	tests := []struct {
+		name          string
+		err           error
+		idempotent    bool
+		notIdempotent bool
+	}{
+		{name: "serialization failure", err: &pq.Error{Code: "40001"}, idempotent: true, notIdempotent: true},
+		{name: "cannot connect now", err: &pq.Error{Code: "57P03"}, idempotent: true, notIdempotent: true},
+		{name: "connection failure", err: &pq.Error{Code: "08006"}, idempotent: true, notIdempotent: true},
+		{name: "bad connection", err: fmt.Errorf("query: %w", driver.ErrBadConn), idempotent: true, notIdempotent: true},
+		{name: "network", err: fmt.Errorf("query: %w", netErr), idempotent: true},
+		{name: "unique violation", err: &pq.Error{Code: "23505"}},
+		{name: "syntax error", err: &pq.Error{Code: "42601"}},
+		{name: "canceled", err: context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.idempotent, transient(tt.err, true))
+			require.Equal(t, tt.notIdempotent, transient(tt.err, false))
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	r := Retry{Attempts: 3, Backoff: backoff.Backoff{Initial: time.Millisecond, Max: time.Millisecond}}
+	busy := &pq.Error{Code: "53300"}
+
+	calls := 0
+	err := retry(context.Background(), r, false, func() error {
+		calls++
+		if calls < 3 {
+			return busy
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+
+	calls = 0
+	err = retry(context.Background(), r, true, func() error {
+		calls++
+		return busy
+	})
+	require.ErrorIs(t, err, busy)
+	require.Equal(t, 3, calls, "gives up after Attempts")
+
+	calls = 0
+	err = retry(context.Background(), r, true, func() error {
+		calls++
+		return &pq.Error{Code: "23505"}
+	})
+	require.Error(t, err)
+	require.Equal(t, 1, calls, "permanent errors are not retried")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls = 0
+	r.Backoff = backoff.Backoff{Initial: time.Hour, Max: time.Hour}
+	err = retry(ctx, r, true, func() error {
+		calls++
+		return busy
+	})
+	require.ErrorIs(t, err, busy)
+	require.Equal(t, 1, calls, "stops waiting when the context is done")
+}
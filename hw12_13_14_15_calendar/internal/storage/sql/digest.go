@@ -0,0 +1,155 @@
+package sqlstorage
+
+import (
+	"context"
+	"time"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+)
+
+type digestSubscriptionSQL struct {
+	UserID   string             `db:"user_id"`
+	Kind     storage.DigestKind `db:"kind"`
+	Timezone string             `db:"timezone"`
+	SendAt   time.Time          `db:"send_at"`
+}
+
+// SetDigestSubscription ignores a user id that is not a uuid, as no events
+// can belong to it.
+func (s *Storage) SetDigestSubscription(ctx context.Context, subscription storage.DigestSubscription) error {
+	if !validID(subscription.UserID) {
+		return nil
+	}
+	sendAt := time.Time{}.Add(subscription.SendAt).Format(time.TimeOnly)
+	_, err := s.exec(ctx, true,
+		`INSERT INTO digest_subscriptions (user_id, kind, timezone, send_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, kind) DO UPDATE SET timezone = EXCLUDED.timezone, send_at = EXCLUDED.send_at`,
+		subscription.UserID, subscription.Kind, subscription.Timezone, sendAt,
+	)
+	if err != nil {
+		return wrapError("SetDigestSubscription", err)
+	}
+
+	return nil
+}
+
+func (s *Storage) GetDigestSubscriptions(ctx context.Context) ([]storage.DigestSubscription, error) {
+	var subscriptionsSQL []digestSubscriptionSQL
+	err := s.selectAll(ctx, s.stmts, true, &subscriptionsSQL,
+		"SELECT user_id, kind, timezone, send_at FROM digest_subscriptions",
+	)
+	if err != nil {
+		return nil, wrapError("GetDigestSubscriptions", err)
+	}
+
+	subscriptions := make([]storage.DigestSubscription, len(subscriptionsSQL))
+	midnight := time.Date(0, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for i, subscription := range subscriptionsSQL {
+		subscriptions[i] = storage.DigestSubscription{
+			UserID:   subscription.UserID,
+			Kind:     subscription.Kind,
+			Timezone: subscription.Timezone,
+			SendAt:   subscription.SendAt.Sub(midnight),
+		}
+	}
+
+	return subscriptions, nil
+}
+
+// ClaimDigest reserves the digest for lease, unless it was published or is
+// claimed by a lease that has not expired.
+func (s *Storage) ClaimDigest(
+	ctx context.Context,
+	userID string,
+	kind storage.DigestKind,
+	periodStart time.Time,
+	lease time.Duration,
+) (bool, error) {
+	if !validID(userID) {
+		return false, nil
+	}
+	now := s.clock.Now().UTC()
+	res, err := s.exec(ctx, false,
+		`INSERT INTO digests (user_id, kind, period_start, lease_expires_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, kind, period_start) DO UPDATE SET lease_expires_at = EXCLUDED.lease_expires_at
+		WHERE digests.notification_status = 'sending' AND digests.lease_expires_at < $5`,
+		userID, kind, periodStart.Format(time.DateOnly), now.Add(lease), now,
+	)
+	if err != nil {
+		return false, wrapError("ClaimDigest", err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, wrapError("ClaimDigest", err)
+	}
+
+	return rows == 1, nil
+}
+
+// MarkDigestPublished ends the lease of a claimed digest, which stays
+// reserved until the sender sets it sent.
+func (s *Storage) MarkDigestPublished(
+	ctx context.Context,
+	userID string,
+	kind storage.DigestKind,
+	periodStart time.Time,
+) error {
+	if !validID(userID) {
+		return nil
+	}
+	_, err := s.exec(ctx, true,
+		`UPDATE digests SET lease_expires_at = NULL
+		WHERE user_id = $1 AND kind = $2 AND period_start = $3`,
+		userID, kind, periodStart.Format(time.DateOnly),
+	)
+	if err != nil {
+		return wrapError("MarkDigestPublished", err)
+	}
+
+	return nil
+}
+
+// ReleaseDigest drops the claim of a digest that was not published, so the
+// next run may claim it again.
+func (s *Storage) ReleaseDigest(
+	ctx context.Context,
+	userID string,
+	kind storage.DigestKind,
+	periodStart time.Time,
+) error {
+	if !validID(userID) {
+		return nil
+	}
+	_, err := s.exec(ctx, true,
+		`DELETE FROM digests
+		WHERE user_id = $1 AND kind = $2 AND period_start = $3 AND lease_expires_at IS NOT NULL`,
+		userID, kind, periodStart.Format(time.DateOnly),
+	)
+	if err != nil {
+		return wrapError("ReleaseDigest", err)
+	}
+
+	return nil
+}
+
+func (s *Storage) SetDigestSent(
+	ctx context.Context,
+	userID string,
+	kind storage.DigestKind,
+	periodStart time.Time,
+) error {
+	if !validID(userID) {
+		return nil
+	}
+	_, err := s.exec(ctx, true,
+		`UPDATE digests SET notification_status = 'sent'
+		WHERE user_id = $1 AND kind = $2 AND period_start = $3`,
+		userID, kind, periodStart.Format(time.DateOnly),
+	)
+	if err != nil {
+		return wrapError("SetDigestSent", err)
+	}
+
+	return nil
+}
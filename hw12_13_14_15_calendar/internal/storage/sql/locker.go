@@ -0,0 +1,79 @@
+package sqlstorage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+	"github.com/jmoiron/sqlx"
+)
+
+// Locker wraps a Postgres session-level advisory lock. The lock lives as long
+// as the dedicated connection that took it, so if the holder dies the server
+// drops the session and another replica can acquire the lock.
+type Locker struct {
+	mu   sync.Mutex
+	key  int64
+	db   *sqlx.DB
+	conn *sqlx.Conn
+}
+
+func (s *Storage) NewLocker(key int64) storage.Locker {
+	return &Locker{key: key, db: s.db}
+}
+
+func (l *Locker) TryLock(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn != nil {
+		err := l.conn.PingContext(ctx)
+		if err == nil {
+			return true, nil
+		}
+		l.conn.Close()
+		l.conn = nil
+		return false, fmt.Errorf("sqlstorage.TryLock: lost lock connection: %w", err)
+	}
+
+	conn, err := l.db.Connx(ctx)
+	if err != nil {
+		return false, fmt.Errorf("sqlstorage.TryLock: %w", err)
+	}
+
+	var locked bool
+	err = conn.GetContext(ctx, &locked, "SELECT pg_try_advisory_lock($1)", l.key)
+	if err != nil {
+		conn.Close()
+		return false, fmt.Errorf("sqlstorage.TryLock: %w", err)
+	}
+	if !locked {
+		conn.Close()
+		return false, nil
+	}
+
+	l.conn = conn
+
+	return true, nil
+}
+
+func (l *Locker) Unlock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return nil
+	}
+	defer func() {
+		l.conn.Close()
+		l.conn = nil
+	}()
+
+	_, err := l.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", l.key)
+	if err != nil {
+		return fmt.Errorf("sqlstorage.Unlock: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,323 @@
+package sqlstorage
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+	"github.com/jmoiron/sqlx"
+)
+
+// MigrationLockKey is the advisory lock held while migrating, so replicas
+// starting together apply each migration once.
+const MigrationLockKey int64 = 0x63616c6d6967 // "calmig"
+
+var ErrNoMigration = errors.New("no migration to roll back")
+
+// Migration is one migrations/*.sql file in the format of goose: the
+// statements after "-- +goose Up" apply it, those after "-- +goose Down"
+// revert it. Versions are recorded in goose_db_version like goose does, so
+// databases migrated by either tool can be handled by the other.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+var migrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.sql$`)
+
+// LoadMigrations reads the *.sql files at the root of fsys, ordered by
+// version.
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, fmt.Errorf("sqlstorage.LoadMigrations: %w", err)
+	}
+
+	migrations := make([]Migration, 0, len(files))
+	versions := make(map[int64]string, len(files))
+	for _, file := range files {
+		match := migrationFile.FindStringSubmatch(path.Base(file))
+		if match == nil {
+			return nil, fmt.Errorf("sqlstorage.LoadMigrations: %s: name is not <version>_<name>.sql", file)
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || version == 0 {
+			return nil, fmt.Errorf("sqlstorage.LoadMigrations: %s: wrong version", file)
+		}
+		if other, ok := versions[version]; ok {
+			return nil, fmt.Errorf("sqlstorage.LoadMigrations: %s and %s have the same version", other, file)
+		}
+		versions[version] = file
+
+		content, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, fmt.Errorf("sqlstorage.LoadMigrations: %w", err)
+		}
+		up, down, err := parseMigration(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("sqlstorage.LoadMigrations: %s: %w", file, err)
+		}
+		migrations = append(migrations, Migration{Version: version, Name: match[2], Up: up, Down: down})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// parseMigration splits a migration into its Up and Down statements. Each
+// part runs as one batch in a transaction, so StatementBegin and
+// StatementEnd need no special handling.
+func parseMigration(content string) (up, down string, err error) {
+	var (
+		section        *strings.Builder
+		upSQL, downSQL strings.Builder
+		seenUp         bool
+	)
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		annotation, ok := strings.CutPrefix(strings.TrimSpace(line), "-- +goose ")
+		if !ok {
+			if section != nil {
+				section.WriteString(line)
+				section.WriteByte('\n')
+			} else if strings.TrimSpace(line) != "" && !strings.HasPrefix(strings.TrimSpace(line), "--") {
+				return "", "", fmt.Errorf("statement before -- +goose Up")
+			}
+			continue
+		}
+
+		switch strings.TrimSpace(annotation) {
+		case "Up":
+			section, seenUp = &upSQL, true
+		case "Down":
+			section = &downSQL
+		case "StatementBegin", "StatementEnd":
+		default:
+			return "", "", fmt.Errorf("unsupported annotation %q", annotation)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", err
+	}
+	if !seenUp {
+		return "", "", fmt.Errorf("no -- +goose Up section")
+	}
+
+	return strings.TrimSpace(upSQL.String()), strings.TrimSpace(downSQL.String()), nil
+}
+
+// createVersionTable creates goose_db_version with the row for version 0
+// that goose expects in it.
+const createVersionTable = `
+DO $$
+BEGIN
+  IF to_regclass('goose_db_version') IS NULL THEN
+    CREATE TABLE goose_db_version (
+      id serial PRIMARY KEY,
+      version_id bigint NOT NULL,
+      is_applied boolean NOT NULL,
+      tstamp timestamp NULL DEFAULT now()
+    );
+    INSERT INTO goose_db_version (version_id, is_applied) VALUES (0, true);
+  END IF;
+END $$`
+
+// appliedMigrations returns when each applied version was applied. The
+// latest row of a version decides, as goose once recorded rollbacks as rows
+// with is_applied false.
+func appliedMigrations(ctx context.Context, q sqlx.QueryerContext) (map[int64]time.Time, error) {
+	var exists bool
+	err := sqlx.GetContext(ctx, q, &exists, "SELECT to_regclass('goose_db_version') IS NOT NULL")
+	if err != nil || !exists {
+		return map[int64]time.Time{}, err
+	}
+
+	var rows []struct {
+		Version   int64        `db:"version_id"`
+		IsApplied bool         `db:"is_applied"`
+		Tstamp    sql.NullTime `db:"tstamp"`
+	}
+	err = sqlx.SelectContext(ctx, q, &rows, `
+		SELECT DISTINCT ON (version_id) version_id, is_applied, tstamp
+		FROM goose_db_version
+		WHERE version_id > 0
+		ORDER BY version_id, id DESC`)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int64]time.Time, len(rows))
+	for _, row := range rows {
+		if row.IsApplied {
+			applied[row.Version] = row.Tstamp.Time
+		}
+	}
+
+	return applied, nil
+}
+
+// withMigrationLock runs fn on a connection holding MigrationLockKey,
+// waiting for other replicas to finish first.
+func (s *Storage) withMigrationLock(ctx context.Context, fn func(*sqlx.Conn) error) error {
+	if s.db == nil {
+		return storage.ErrNotConnected
+	}
+	conn, err := s.db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", MigrationLockKey); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", MigrationLockKey)
+
+	if _, err := conn.ExecContext(ctx, createVersionTable); err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+func runMigration(ctx context.Context, conn *sqlx.Conn, statements, record string, version int64) error {
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if statements != "" {
+		if _, err := tx.ExecContext(ctx, statements); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, record, version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MigrateUp applies the migrations that are not applied yet, in order, each
+// in its own transaction, and returns them.
+func (s *Storage) MigrateUp(ctx context.Context, migrations []Migration) ([]Migration, error) {
+	var done []Migration
+	err := s.withMigrationLock(ctx, func(conn *sqlx.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			err := runMigration(ctx, conn, m.Up,
+				"INSERT INTO goose_db_version (version_id, is_applied) VALUES ($1, true)", m.Version)
+			if err != nil {
+				return fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+			}
+			done = append(done, m)
+		}
+		return nil
+	})
+	if err != nil {
+		return done, fmt.Errorf("sqlstorage.MigrateUp: %w", err)
+	}
+
+	return done, nil
+}
+
+// MigrateDown reverts the latest applied migration and returns it.
+func (s *Storage) MigrateDown(ctx context.Context, migrations []Migration) (*Migration, error) {
+	var reverted *Migration
+	err := s.withMigrationLock(ctx, func(conn *sqlx.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		version := latest(applied)
+		if version == 0 {
+			return ErrNoMigration
+		}
+		for i := range migrations {
+			if migrations[i].Version == version {
+				reverted = &migrations[i]
+			}
+		}
+		if reverted == nil {
+			return fmt.Errorf("applied migration %d is unknown", version)
+		}
+
+		err = runMigration(ctx, conn, reverted.Down, "DELETE FROM goose_db_version WHERE version_id = $1", version)
+		if err != nil {
+			return fmt.Errorf("migration %d %s: %w", reverted.Version, reverted.Name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sqlstorage.MigrateDown: %w", err)
+	}
+
+	return reverted, nil
+}
+
+// MigrationStatus tells which of migrations are applied.
+func (s *Storage) MigrationStatus(ctx context.Context, migrations []Migration) ([]MigrationStatus, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("sqlstorage.MigrationStatus: %w", storage.ErrNotConnected)
+	}
+	applied, err := appliedMigrations(ctx, s.db)
+	if err != nil {
+		return nil, fmt.Errorf("sqlstorage.MigrationStatus: %w", err)
+	}
+
+	status := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		at, ok := applied[m.Version]
+		status[i] = MigrationStatus{Migration: m, Applied: ok, AppliedAt: at}
+	}
+
+	return status, nil
+}
+
+// SchemaVersion is the latest applied migration, 0 if there is none.
+func (s *Storage) SchemaVersion(ctx context.Context) (int64, error) {
+	if s.db == nil {
+		return 0, fmt.Errorf("sqlstorage.SchemaVersion: %w", storage.ErrNotConnected)
+	}
+	applied, err := appliedMigrations(ctx, s.db)
+	if err != nil {
+		return 0, fmt.Errorf("sqlstorage.SchemaVersion: %w", err)
+	}
+
+	return latest(applied), nil
+}
+
+func latest(applied map[int64]time.Time) int64 {
+	var version int64
+	for v := range applied {
+		version = max(version, v)
+	}
+
+	return version
+}
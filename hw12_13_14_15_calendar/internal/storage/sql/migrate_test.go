@@ -0,0 +1,75 @@
+package sqlstorage
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/migrations"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"00002_add_column.sql": {Data: []byte(`-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE t ADD COLUMN c INT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE t DROP COLUMN c;
+-- +goose StatementEnd
+`)},
+		"00001_create_table.sql": {Data: []byte("-- creates t\n-- +goose Up\nCREATE TABLE t ();\n")},
+		"README.md":              {Data: []byte("not a migration")},
+	}
+
+	got, err := LoadMigrations(fsys)
+	require.NoError(t, err)
+	require.Equal(t, []Migration{
+		{Version: 1, Name: "create_table", Up: "CREATE TABLE t ();"},
+		{Version: 2, Name: "add_column", Up: "ALTER TABLE t ADD COLUMN c INT;", Down: "ALTER TABLE t DROP COLUMN c;"},
+	}, got)
+}
+
+func TestLoadMigrationsErrors(t *testing.T) {
+	tests := map[string]fstest.MapFS{
+		"no version":          {"create_table.sql": {Data: []byte("-- +goose Up\n")}},
+		"version 0":           {"00000_create_table.sql": {Data: []byte("-- +goose Up\n")}},
+		"same version":        {"1_a.sql": {Data: []byte("-- +goose Up\n")}, "001_b.sql": {Data: []byte("-- +goose Up\n")}},
+		"no up":               {"1_a.sql": {Data: []byte("CREATE TABLE t ();\n")}},
+		"unknown annotation":  {"1_a.sql": {Data: []byte("-- +goose Up\n-- +goose NO TRANSACTION\n")}},
+		"statement before up": {"1_a.sql": {Data: []byte("DROP TABLE t;\n-- +goose Up\n")}},
+	}
+	for name, fsys := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadMigrations(fsys)
+			require.Error(t, err)
+		})
+	}
+}
+
+// TestEmbeddedMigrations checks the migrations shipped with the binaries.
+func TestEmbeddedMigrations(t *testing.T) {
+	got, err := LoadMigrations(migrations.FS)
+	require.NoError(t, err)
+	require.NotEmpty(t, got)
+
+	for i, m := range got {
+		require.Equal(t, int64(i+1), m.Version, "versions have no gaps")
+		require.NotEmpty(t, m.Up, m.Name)
+		require.NotEmpty(t, m.Down, m.Name)
+		for _, statements := range []string{m.Up, m.Down} {
+			require.NotContains(t, statements, "your_table", m.Name)
+		}
+		// Every type an Up creates is dropped by its Down, so the
+		// migration can be applied again.
+		for _, line := range strings.Split(m.Up, "\n") {
+			if name, ok := strings.CutPrefix(line, "CREATE TYPE "); ok {
+				typ := strings.Fields(name)[0]
+				require.Contains(t, m.Down, "DROP TYPE "+typ, m.Name)
+			}
+		}
+	}
+}
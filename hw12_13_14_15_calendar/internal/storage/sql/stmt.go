@@ -0,0 +1,143 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// statements prepares each query once per pool. database/sql prepares a
+// statement again on every connection it is run on, so the cached ones
+// outlive reconnects.
+type statements struct {
+	db *sqlx.DB
+
+	mu    sync.Mutex
+	stmts map[string]*sqlx.Stmt
+}
+
+func newStatements(db *sqlx.DB) *statements {
+	return &statements{db: db, stmts: make(map[string]*sqlx.Stmt)}
+}
+
+func (c *statements) prepare(ctx context.Context, query string) (*sqlx.Stmt, error) {
+	if c == nil {
+		return nil, storage.ErrNotConnected
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if stmt, ok := c.stmts[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := c.db.PreparexContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	c.stmts[query] = stmt
+
+	return stmt, nil
+}
+
+func (c *statements) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var errs []error
+	for query, stmt := range c.stmts {
+		errs = append(errs, stmt.Close())
+		delete(c.stmts, query)
+	}
+
+	return errors.Join(errs...)
+}
+
+// exec runs query on the primary as a prepared statement.
+func (s *Storage) exec(ctx context.Context, idempotent bool, query string, args ...interface{}) (sql.Result, error) {
+	var res sql.Result
+	err := s.retry(ctx, idempotent, func() error {
+		stmt, err := s.stmts.prepare(ctx, query)
+		if err != nil {
+			return err
+		}
+		res, err = stmt.ExecContext(ctx, args...)
+		return err
+	})
+
+	return res, err
+}
+
+// get scans the single row of query on pool into dest.
+func (s *Storage) get(
+	ctx context.Context,
+	pool *statements,
+	dest interface{},
+	query string,
+	args ...interface{},
+) error {
+	return s.retry(ctx, true, func() error {
+		stmt, err := pool.prepare(ctx, query)
+		if err != nil {
+			return err
+		}
+		return stmt.GetContext(ctx, dest, args...)
+	})
+}
+
+// selectAll scans the rows of query on pool into dest, a pointer to a slice.
+func (s *Storage) selectAll(
+	ctx context.Context,
+	pool *statements,
+	idempotent bool,
+	dest interface{},
+	query string,
+	args ...interface{},
+) error {
+	return s.retry(ctx, idempotent, func() error {
+		stmt, err := pool.prepare(ctx, query)
+		if err != nil {
+			return err
+		}
+		// A retry starts over rather than appending to the rows scanned so far.
+		slice := reflect.ValueOf(dest).Elem()
+		slice.Set(reflect.Zero(slice.Type()))
+		return stmt.SelectContext(ctx, dest, args...)
+	})
+}
+
+// validID reports whether id can be the uuid of a row. Postgres rejects
+// anything else with an error, where no row with id is what callers mean.
+func validID(id string) bool {
+	_, err := uuid.Parse(id)
+	return err == nil && len(id) == 36
+}
+
+// validIDs drops the ids that cannot match a row.
+func validIDs(ids []string) []string {
+	valid := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if validID(id) {
+			valid = append(valid, id)
+		}
+	}
+
+	return valid
+}
+
+// wrapError wraps err with the storage sentinel it stands for, if any.
+func wrapError(op string, err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" { // unique_violation
+		return fmt.Errorf("sqlstorage.%s: %w: %w", op, storage.ErrEventAlreadyExists, err)
+	}
+
+	return fmt.Errorf("sqlstorage.%s: %w", op, err)
+}
@@ -8,45 +8,89 @@ import (
 	"strings"
 	"time"
 
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/clock"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
+// Storage keeps events in Postgres. Reads that may lag behind writes go to
+// the replica pool when there is one.
 type Storage struct {
-	db       *sqlx.DB
-	user     string
-	password string
-	name     string
-	host     string
-	port     string
+	db           *sqlx.DB
+	replica      *sqlx.DB
+	stmts        *statements
+	replicaStmts *statements
+	config       Config
+	clock        clock.Clock
 }
 
-func New(user, password, name, host, port string) *Storage {
+func New(config Config, clock clock.Clock) *Storage {
 	return &Storage{
-		user:     user,
-		password: password,
-		name:     name,
-		host:     host,
-		port:     port,
+		config: config.withDefaults(),
+		clock:  clock,
 	}
 }
 
 func (s *Storage) Connect(ctx context.Context) error {
-	db, err := sqlx.ConnectContext(ctx, "postgres",
-		fmt.Sprintf(
-			"user=%s dbname=%s sslmode=disable password=%s host=%s port=%s",
-			s.user,
-			s.name,
-			s.password,
-			s.host,
-			s.port,
-		),
-	)
+	dsn, err := s.config.dsn()
+	if err != nil {
+		return fmt.Errorf("sqlstorage.Connect: %w", err)
+	}
+	db, err := s.config.open(ctx, dsn)
 	if err != nil {
 		return fmt.Errorf("sqlstorage.Connect: %w", err)
 	}
 
+	if s.config.Replica.enabled() {
+		dsn, err := s.config.replicaDSN()
+		if err == nil {
+			s.replica, err = s.config.open(ctx, dsn)
+		}
+		if err != nil {
+			db.Close()
+			return fmt.Errorf("sqlstorage.Connect: replica: %w", err)
+		}
+	}
+
 	s.db = db
+	s.stmts = newStatements(db)
+	if s.replica != nil {
+		s.replicaStmts = newStatements(s.replica)
+	}
+
+	return nil
+}
+
+// reader holds the statements for reads that may lag behind writes, unless
+// ctx asks for the primary with storage.WithPrimary.
+func (s *Storage) reader(ctx context.Context) *statements {
+	if s.replicaStmts != nil && !storage.ReadsPrimary(ctx) {
+		return s.replicaStmts
+	}
+
+	return s.stmts
+}
+
+// retry runs fn as QueryRetry allows.
+func (s *Storage) retry(ctx context.Context, idempotent bool, fn func() error) error {
+	return retry(ctx, s.config.QueryRetry, idempotent, fn)
+}
+
+func (s *Storage) Ping(ctx context.Context) error {
+	if s.db == nil {
+		return fmt.Errorf("sqlstorage.Ping: %w", storage.ErrNotConnected)
+	}
+
+	err := s.db.PingContext(ctx)
+	if err != nil {
+		return fmt.Errorf("sqlstorage.Ping: %w", err)
+	}
+	if s.replica != nil {
+		if err := s.replica.PingContext(ctx); err != nil {
+			return fmt.Errorf("sqlstorage.Ping: replica: %w", err)
+		}
+	}
 
 	return nil
 }
@@ -56,12 +100,16 @@ func (s *Storage) Close() error {
 		return fmt.Errorf("sqlstorage.Close: no connection to close")
 	}
 
-	err := s.db.Close()
+	err := errors.Join(s.stmts.close(), s.db.Close())
+	if s.replica != nil {
+		err = errors.Join(err, s.replicaStmts.close(), s.replica.Close())
+	}
 	if err != nil {
 		return fmt.Errorf("sqlstorage.Close: %w", err)
 	}
 
-	s.db = nil
+	s.db, s.replica = nil, nil
+	s.stmts, s.replicaStmts = nil, nil
 
 	return nil
 }
@@ -75,6 +123,8 @@ type eventSQL struct {
 	UserID                    string                     `db:"user_id"`
 	AdvanceNotificationPeriod sql.NullString             `db:"advance_notification_period"`
 	NotificationStatus        storage.NotificationStatus `db:"notification_status"`
+	ClaimedBy                 sql.NullString             `db:"claimed_by"`
+	LeaseExpiresAt            sql.NullTime               `db:"lease_expires_at"`
 }
 
 func (eSQL eventSQL) sqlToEvent() storage.Event {
@@ -98,29 +148,71 @@ func (eSQL eventSQL) sqlToEvent() storage.Event {
 	event.EndDate = eSQL.EndDate
 	event.UserID = eSQL.UserID
 	event.NotificationStatus = eSQL.NotificationStatus
+	event.ClaimedBy = eSQL.ClaimedBy.String
+	event.LeaseExpiresAt = eSQL.LeaseExpiresAt.Time
 
 	return event
 }
 
+// interval formats d as a Postgres interval. A bare integer would be read
+// as seconds, not as the nanoseconds of a time.Duration.
+func interval(d time.Duration) string {
+	return fmt.Sprintf("%d microseconds", d.Microseconds())
+}
+
+// eventColumns are listed rather than selected with *, which a prepared
+// statement would keep returning in the shape the table had when it was
+// prepared.
+const eventColumns = `id, title, date, end_date, description, user_id, advance_notification_period,
+	notification_status, claimed_by, lease_expires_at`
+
+func eventsFromSQL(eventsSQL []eventSQL) []storage.Event {
+	events := make([]storage.Event, len(eventsSQL))
+	for i, event := range eventsSQL {
+		events[i] = event.sqlToEvent()
+	}
+
+	return events
+}
+
 func (s *Storage) CreateEvent(ctx context.Context, event storage.Event) error {
-	_, err := s.db.NamedExecContext(ctx, `INSERT INTO events 
-		(title, date, end_date, description, user_id, advance_notification_period) 
-		VALUES (:title, :date, :enddate, :description, :userid, :advancenotificationperiod)`, &event)
+	_, err := s.exec(ctx, false, `INSERT INTO events
+		(title, date, end_date, description, user_id, advance_notification_period)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		event.Title, event.Date, event.EndDate, event.Description, event.UserID,
+		interval(event.AdvanceNotificationPeriod),
+	)
 	if err != nil {
-		return fmt.Errorf("creating event: %w", err)
+		return wrapError("CreateEvent", err)
 	}
 
 	return nil
 }
 
+func (s *Storage) CountUserEvents(ctx context.Context, userID string) (int, error) {
+	if !validID(userID) {
+		return 0, nil
+	}
+	var count int
+	err := s.get(ctx, s.stmts, &count, "SELECT count(*) FROM events WHERE user_id = $1", userID)
+	if err != nil {
+		return 0, wrapError("CountUserEvents", err)
+	}
+
+	return count, nil
+}
+
 func (s *Storage) GetEvent(ctx context.Context, id string) (*storage.Event, error) {
+	if !validID(id) {
+		return nil, fmt.Errorf("sqlstorage.GetEvent: %w", storage.ErrEventDoesntExist)
+	}
 	var event eventSQL
-	err := s.db.GetContext(ctx, &event, "SELECT * FROM events WHERE id=$1", id)
+	err := s.get(ctx, s.reader(ctx), &event, "SELECT "+eventColumns+" FROM events WHERE id = $1", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("sqlstorage.GetEvent: %w", storage.ErrEventDoesntExist)
+	}
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("storagesql.GetEvent: %w", storage.ErrEventDoesntExist)
-		}
-		return nil, fmt.Errorf("getting event with id %s: %w", id, err)
+		return nil, wrapError("GetEvent", err)
 	}
 
 	e := event.sqlToEvent()
@@ -129,137 +221,194 @@ func (s *Storage) GetEvent(ctx context.Context, id string) (*storage.Event, erro
 }
 
 func (s *Storage) EditEvent(ctx context.Context, id string, update storage.Event) error {
-	params := map[string]interface{}{
-		"query_id":                  id,
-		"id":                        update.ID,
-		"title":                     update.Title,
-		"date":                      update.Date,
-		"enddate":                   update.EndDate,
-		"description":               update.Description,
-		"userid":                    update.UserID,
-		"advancenotificationperiod": update.AdvanceNotificationPeriod,
-	}
-	res, err := s.db.NamedExecContext(ctx, `UPDATE events SET 
-		title = :title, date = :date, end_date = :enddate, description = :description,
-		user_id = :userid, advance_notification_period = :advancenotificationperiod
-		WHERE id = :query_id`, params)
+	if !validID(id) {
+		return fmt.Errorf("sqlstorage.EditEvent: %w", storage.ErrEventDoesntExist)
+	}
+	res, err := s.exec(ctx, true, `UPDATE events SET
+		title = $1, date = $2, end_date = $3, description = $4, user_id = $5, advance_notification_period = $6
+		WHERE id = $7`,
+		update.Title, update.Date, update.EndDate, update.Description, update.UserID,
+		interval(update.AdvanceNotificationPeriod), id,
+	)
 	if err != nil {
-		return fmt.Errorf("edit event with id %s: %w", id, err)
+		return wrapError("EditEvent", err)
 	}
 
 	rows, err := res.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("faild get rows in storagesql.EditEvent: %w", err)
+		return wrapError("EditEvent", err)
 	}
 	if rows != 1 {
-		return fmt.Errorf("edit event with id %s: %w", id, storage.ErrEventDoesntExist)
+		return fmt.Errorf("sqlstorage.EditEvent: %w", storage.ErrEventDoesntExist)
 	}
 
 	return nil
 }
 
+// DeleteEvent is not retried: a retry of a delete that was applied would
+// find no event and report a false storage.ErrEventDoesntExist.
 func (s *Storage) DeleteEvent(ctx context.Context, id string) error {
-	_, err := s.db.ExecContext(ctx, "DELETE FROM events WHERE id = $1", id)
+	if !validID(id) {
+		return fmt.Errorf("sqlstorage.DeleteEvent: %w", storage.ErrEventDoesntExist)
+	}
+	res, err := s.exec(ctx, false, "DELETE FROM events WHERE id = $1", id)
 	if err != nil {
-		return fmt.Errorf("deleting event with id %s: %w", id, err)
+		return wrapError("DeleteEvent", err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return wrapError("DeleteEvent", err)
+	}
+	if rows == 0 {
+		return fmt.Errorf("sqlstorage.DeleteEvent: %w", storage.ErrEventDoesntExist)
 	}
 
 	return nil
 }
 
-func (s *Storage) GetEventsListDay(ctx context.Context, date time.Time) ([]storage.Event, error) {
+// listEvents runs a list query on the replica, if any. No match is an empty
+// list, not an error.
+func (s *Storage) listEvents(ctx context.Context, op, query string, args ...interface{}) ([]storage.Event, error) {
 	var eventsSQL []eventSQL
-	err := s.db.SelectContext(ctx, &eventsSQL, "SELECT * FROM events WHERE date::date=$1", date)
+	err := s.selectAll(ctx, s.reader(ctx), true, &eventsSQL, query, args...)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("storagesql.GetEventsListDay: %w", storage.ErrNoEventsFound)
-		}
-		return nil, fmt.Errorf("event list day %s: %w", date.Format("2006-01-02"), err)
+		return nil, wrapError(op, err)
 	}
 
-	events := make([]storage.Event, len(eventsSQL))
-	for i, event := range eventsSQL {
-		events[i] = event.sqlToEvent()
-	}
+	return eventsFromSQL(eventsSQL), nil
+}
 
-	return events, nil
+func (s *Storage) GetEventsListDay(ctx context.Context, date time.Time) ([]storage.Event, error) {
+	return s.listEvents(ctx, "GetEventsListDay",
+		"SELECT "+eventColumns+" FROM events WHERE date::date = $1", date,
+	)
 }
 
 func (s *Storage) GetEventsListWeek(ctx context.Context, date time.Time) ([]storage.Event, error) {
-	var eventsSQL []eventSQL
-	err := s.db.SelectContext(ctx, &eventsSQL, "SELECT * FROM events WHERE date::date>$1 and date::date<$2",
+	return s.listEvents(ctx, "GetEventsListWeek",
+		"SELECT "+eventColumns+" FROM events WHERE date::date > $1 AND date::date < $2",
 		date, date.AddDate(0, 0, 7),
 	)
-	if err != nil {
-		return nil, fmt.Errorf("event list week %s: %w", date.Format("2006-01-02"), err)
-	}
+}
 
-	events := make([]storage.Event, len(eventsSQL))
-	for i, event := range eventsSQL {
-		events[i] = event.sqlToEvent()
+func (s *Storage) GetEventsListMonth(ctx context.Context, date time.Time) ([]storage.Event, error) {
+	return s.listEvents(ctx, "GetEventsListMonth",
+		"SELECT "+eventColumns+" FROM events WHERE date::date > $1 AND date::date < $2",
+		date, date.AddDate(0, 1, 0),
+	)
+}
+
+// GetUserEvents returns the events of userID dated in [from, to), earliest
+// first. Unlike the lists, it compares instants rather than dates, so the
+// range may start at midnight in any time zone.
+func (s *Storage) GetUserEvents(ctx context.Context, userID string, from, to time.Time) ([]storage.Event, error) {
+	if !validID(userID) {
+		return []storage.Event{}, nil
+	}
+	var eventsSQL []eventSQL
+	err := s.selectAll(ctx, s.reader(ctx), true, &eventsSQL,
+		"SELECT "+eventColumns+" FROM events WHERE user_id = $1 AND date >= $2 AND date < $3 ORDER BY date",
+		userID, from.UTC(), to.UTC(),
+	)
+	if err != nil {
+		return nil, wrapError("GetUserEvents", err)
 	}
 
-	return events, nil
+	return eventsFromSQL(eventsSQL), nil
 }
 
-func (s *Storage) GetEventsListMonth(ctx context.Context, date time.Time) ([]storage.Event, error) {
+func (s *Storage) GetEventsToNotify(ctx context.Context) ([]storage.Event, error) {
 	var eventsSQL []eventSQL
-	err := s.db.SelectContext(ctx, &eventsSQL, "SELECT * FROM events WHERE date::date>$1 and date::date<$2",
-		date, date.AddDate(0, 1, 0),
+	err := s.selectAll(ctx, s.stmts, true, &eventsSQL,
+		`SELECT id, title, date, user_id FROM events
+		WHERE date - advance_notification_period <= $1 AND notification_status = 'idle'`,
+		s.clock.Now().UTC(),
 	)
 	if err != nil {
-		return nil, fmt.Errorf("event list month %s: %w", date.Format("2006-01-02"), err)
-	}
-	events := make([]storage.Event, len(eventsSQL))
-	for i, event := range eventsSQL {
-		events[i] = event.sqlToEvent()
+		return nil, wrapError("GetEventsToNotify", err)
 	}
 
-	return events, nil
+	return eventsFromSQL(eventsSQL), nil
 }
 
-func (s *Storage) GetEventsToNotify(ctx context.Context) ([]storage.Event, error) {
+func (s *Storage) ClaimEventsToNotify(
+	ctx context.Context,
+	workerID string,
+	limit int,
+	lease time.Duration,
+) ([]storage.Event, error) {
+	now := s.clock.Now().UTC()
 	var eventsSQL []eventSQL
-	err := s.db.SelectContext(ctx,
-		&eventsSQL,
-		`SELECT id, title, date, user_id FROM events 
-		WHERE date - advance_notification_period <= CURRENT_DATE AND notification_status = 'idle'`,
+	err := s.selectAll(ctx, s.stmts, false, &eventsSQL,
+		`UPDATE events SET notification_status = 'sending', claimed_by = $1, lease_expires_at = $2
+		WHERE id IN (
+			SELECT id FROM events
+			WHERE date - advance_notification_period <= $3 AND notification_status = 'idle'
+			ORDER BY date
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, title, date, user_id, notification_status, claimed_by, lease_expires_at`,
+		workerID, now.Add(lease), now, limit,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("sql.GetEventsToNotify: %w", err)
+		return nil, wrapError("ClaimEventsToNotify", err)
 	}
-	events := make([]storage.Event, len(eventsSQL))
-	for i, event := range eventsSQL {
-		events[i] = event.sqlToEvent()
+
+	return eventsFromSQL(eventsSQL), nil
+}
+
+func (s *Storage) ReleaseExpiredClaims(ctx context.Context) error {
+	_, err := s.exec(ctx, true,
+		`UPDATE events SET notification_status = 'idle', claimed_by = NULL, lease_expires_at = NULL
+		WHERE notification_status = 'sending' AND lease_expires_at < $1`,
+		s.clock.Now().UTC(),
+	)
+	if err != nil {
+		return wrapError("ReleaseExpiredClaims", err)
 	}
 
-	return events, nil
+	return nil
 }
 
-func (s *Storage) MarkNotified(ctx context.Context, ids []string) error {
-	q := fmt.Sprintf("UPDATE events SET notification_status='sending' WHERE id IN (%s)", "'"+strings.Join(ids, "', '")+"'")
-	_, err := s.db.ExecContext(ctx, q)
+// MarkNotified binds ids as one array parameter; ids that are not uuids
+// match no event and are left out. Only claims of workerID still pending
+// are ended: the sender may have set an event sent already.
+func (s *Storage) MarkNotified(ctx context.Context, workerID string, ids []string) error {
+	ids = validIDs(ids)
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := s.exec(ctx, true,
+		`UPDATE events SET claimed_by = NULL, lease_expires_at = NULL
+		WHERE id = ANY($1::uuid[]) AND notification_status = 'sending' AND claimed_by = $2`,
+		pq.Array(ids), workerID,
+	)
 	if err != nil {
-		return fmt.Errorf("sqlstorage.MarkNotified: %w", err)
+		return wrapError("MarkNotified", err)
 	}
 
 	return nil
 }
 
 func (s *Storage) ClearEvents(ctx context.Context, duration time.Duration) error {
-	date := time.Now().Add(-duration)
-	_, err := s.db.ExecContext(ctx, "DELETE FROM events WHERE date < $1", date)
+	date := s.clock.Now().UTC().Add(-duration)
+	_, err := s.exec(ctx, true, "DELETE FROM events WHERE date < $1", date)
 	if err != nil {
-		return fmt.Errorf("failed to clear events: %w", err)
+		return wrapError("ClearEvents", err)
 	}
 
 	return nil
 }
 
 func (s *Storage) SetNotified(ctx context.Context, id string) error {
-	_, err := s.db.ExecContext(ctx, "UPDATE events SET notification_status='sent' WHERE id = $1", id)
+	if !validID(id) {
+		return nil
+	}
+	_, err := s.exec(ctx, true, "UPDATE events SET notification_status = 'sent' WHERE id = $1", id)
 	if err != nil {
-		return fmt.Errorf("sqlstorage.MarkNotified: %w", err)
+		return wrapError("SetNotified", err)
 	}
 
 	return nil
@@ -0,0 +1,287 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/clock"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+// pgStub stands in for Postgres. It records the text of every statement
+// prepared, answers with no rows and, like Postgres, rejects parameters
+// compared with or inserted into a uuid column that are not uuids.
+type pgStub struct {
+	mu       sync.Mutex
+	prepared map[string]struct{}
+}
+
+var uuidParam = regexp.MustCompile(`\b(?:id|user_id) = \$(\d+)|ANY\(\$(\d+)::uuid\[\]\)`)
+
+var insertParams = regexp.MustCompile(`(?s)^INSERT INTO \w+\s*\(([^)]*)\)\s*VALUES \(([^)]*)\)`)
+
+func (pg *pgStub) Connect(context.Context) (driver.Conn, error) {
+	return stubConn{pg}, nil
+}
+
+func (pg *pgStub) Driver() driver.Driver {
+	return pg
+}
+
+func (pg *pgStub) Open(string) (driver.Conn, error) {
+	return stubConn{pg}, nil
+}
+
+func (pg *pgStub) statements() int {
+	pg.mu.Lock()
+	defer pg.mu.Unlock()
+
+	return len(pg.prepared)
+}
+
+type stubConn struct {
+	pg *pgStub
+}
+
+func (c stubConn) Prepare(query string) (driver.Stmt, error) {
+	c.pg.mu.Lock()
+	defer c.pg.mu.Unlock()
+	c.pg.prepared[query] = struct{}{}
+
+	return stubStmt{query: query}, nil
+}
+
+func (c stubConn) Close() error {
+	return nil
+}
+
+func (c stubConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("pgStub: transactions are not supported")
+}
+
+type stubStmt struct {
+	query string
+}
+
+func (s stubStmt) Close() error {
+	return nil
+}
+
+func (s stubStmt) NumInput() int {
+	return -1
+}
+
+func (s stubStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if err := s.checkUUIDs(args); err != nil {
+		return nil, err
+	}
+
+	return driver.RowsAffected(0), nil
+}
+
+func (s stubStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if err := s.checkUUIDs(args); err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(s.query, "SELECT count(*)") {
+		return &stubRows{columns: []string{"count"}, rows: [][]driver.Value{{int64(0)}}}, nil
+	}
+
+	return &stubRows{}, nil
+}
+
+func (s stubStmt) checkUUIDs(args []driver.Value) error {
+	for _, match := range uuidParam.FindAllStringSubmatch(s.query, -1) {
+		n, _ := strconv.Atoi(match[1] + match[2])
+		values := []string{asString(args[n-1])}
+		if match[2] != "" {
+			var array pq.StringArray
+			if err := array.Scan(args[n-1]); err != nil {
+				return err
+			}
+			values = array
+		}
+		if err := checkUUIDs(values); err != nil {
+			return err
+		}
+	}
+
+	// Values inserted into a uuid column are checked as well.
+	if match := insertParams.FindStringSubmatch(s.query); match != nil {
+		columns := strings.Split(match[1], ",")
+		params := strings.Split(match[2], ",")
+		for i, column := range columns {
+			column = strings.TrimSpace(column)
+			if (column != "id" && column != "user_id") || i >= len(params) {
+				continue
+			}
+			n, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(params[i]), "$"))
+			if err != nil {
+				continue
+			}
+			if err := checkUUIDs([]string{asString(args[n-1])}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func checkUUIDs(values []string) error {
+	for _, v := range values {
+		if _, err := uuid.Parse(v); err != nil || len(v) != 36 {
+			return &pq.Error{Code: "22P02", Message: "invalid input syntax for type uuid"}
+		}
+	}
+
+	return nil
+}
+
+func asString(v driver.Value) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return ""
+	}
+}
+
+type stubRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *stubRows) Columns() []string {
+	return r.columns
+}
+
+func (r *stubRows) Close() error {
+	return nil
+}
+
+func (r *stubRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+
+	return nil
+}
+
+func newStubStorage(pg *pgStub) *Storage {
+	clk := clock.NewFake(time.Date(2024, time.October, 1, 0, 0, 0, 0, time.UTC))
+	s := New(Config{QueryRetry: Retry{Attempts: 1}}, clk)
+	s.db = sqlx.NewDb(sql.OpenDB(pg), "postgres")
+	s.stmts = newStatements(s.db)
+
+	return s
+}
+
+// callAll passes id to every method that takes one.
+func callAll(t testing.TB, s *Storage, id string) {
+	t.Helper()
+	ctx := context.Background()
+	userID := "2f1a6a8e-52f4-4b7c-9d55-0f3e0a1c2b3d"
+	day := time.Date(2024, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+	require.NoError(t, s.CreateEvent(ctx, storage.Event{ID: id, Title: id, Description: id, UserID: userID}))
+	_, err := s.CountUserEvents(ctx, id)
+	require.NoError(t, err)
+	_, err = s.GetEvent(ctx, id)
+	require.ErrorIs(t, err, storage.ErrEventDoesntExist)
+	err = s.EditEvent(ctx, id, storage.Event{Title: id, Description: id, UserID: userID})
+	require.ErrorIs(t, err, storage.ErrEventDoesntExist)
+	require.ErrorIs(t, s.DeleteEvent(ctx, id), storage.ErrEventDoesntExist)
+	require.NoError(t, s.MarkNotified(ctx, id, []string{userID, id}))
+	require.NoError(t, s.SetNotified(ctx, id))
+	_, err = s.ClaimEventsToNotify(ctx, id, 10, time.Minute)
+	require.NoError(t, err)
+
+	for _, list := range []func(context.Context, time.Time) ([]storage.Event, error){
+		s.GetEventsListDay, s.GetEventsListWeek, s.GetEventsListMonth,
+	} {
+		events, err := list(ctx, day)
+		require.NoError(t, err)
+		require.Empty(t, events)
+	}
+	_, err = s.GetEventsToNotify(ctx)
+	require.NoError(t, err)
+	require.NoError(t, s.ReleaseExpiredClaims(ctx))
+	require.NoError(t, s.ClearEvents(ctx, time.Hour))
+
+	require.NoError(t, s.SetDigestSubscription(ctx, storage.DigestSubscription{
+		UserID: id, Kind: storage.DigestDaily, Timezone: id,
+	}))
+	_, err = s.GetDigestSubscriptions(ctx)
+	require.NoError(t, err)
+	_, err = s.ClaimDigest(ctx, id, storage.DigestDaily, day, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, s.MarkDigestPublished(ctx, id, storage.DigestDaily, day))
+	require.NoError(t, s.ReleaseDigest(ctx, id, storage.DigestDaily, day))
+	require.NoError(t, s.SetDigestSent(ctx, id, storage.DigestDaily, day))
+}
+
+// FuzzHostileIDs checks that no ID ever becomes part of the text of a
+// statement: after every statement is prepared once, hostile IDs neither
+// prepare new ones nor get past the uuid checks of Postgres.
+func FuzzHostileIDs(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"0c8a8e3e-6f2b-4f1e-9a53-3f5b8f6c1a2b",
+		"'; DROP TABLE events; --",
+		"1' OR '1'='1",
+		`0c8a8e3e-6f2b-4f1e-9a53-3f5b8f6c1a2b', '2f1a6a8e-52f4-4b7c-9d55-0f3e0a1c2b3d`,
+		`"},{"`,
+		"{0c8a8e3e-6f2b-4f1e-9a53-3f5b8f6c1a2b}",
+		"$1",
+		"\x00",
+		strings.Repeat("a", 4096),
+	} {
+		f.Add(seed)
+	}
+
+	pg := &pgStub{prepared: make(map[string]struct{})}
+	s := newStubStorage(pg)
+	f.Cleanup(func() { s.Close() })
+	callAll(f, s, "0c8a8e3e-6f2b-4f1e-9a53-3f5b8f6c1a2b")
+	statements := pg.statements()
+
+	f.Fuzz(func(t *testing.T, id string) {
+		callAll(t, s, id)
+		require.Equal(t, statements, pg.statements(), "the ID was written into a statement")
+	})
+}
+
+func TestStatementsAreCached(t *testing.T) {
+	pg := &pgStub{prepared: make(map[string]struct{})}
+	s := newStubStorage(pg)
+	defer s.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err := s.GetEvent(context.Background(), "0c8a8e3e-6f2b-4f1e-9a53-3f5b8f6c1a2b")
+		require.ErrorIs(t, err, storage.ErrEventDoesntExist)
+	}
+	require.Len(t, s.stmts.stmts, 1)
+	require.Equal(t, 1, pg.statements())
+
+	require.NoError(t, s.Close())
+	_, err := s.GetEvent(context.Background(), "0c8a8e3e-6f2b-4f1e-9a53-3f5b8f6c1a2b")
+	require.ErrorIs(t, err, storage.ErrNotConnected)
+}
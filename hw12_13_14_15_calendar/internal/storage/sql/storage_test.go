@@ -0,0 +1,25 @@
+package sqlstorage
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestAdvanceNotificationPeriod checks that a period is written in a unit
+// Postgres understands and read back from the way Postgres prints it.
+func TestAdvanceNotificationPeriod(t *testing.T) {
+	require.Equal(t, "900000000 microseconds", interval(15*time.Minute))
+	require.Equal(t, "0 microseconds", interval(0))
+
+	for printed, want := range map[string]time.Duration{
+		"00:15:00":        15 * time.Minute,
+		"26:00:00":        26 * time.Hour,
+		"00:00:01.500000": 1500 * time.Millisecond,
+	} {
+		event := eventSQL{AdvanceNotificationPeriod: sql.NullString{String: printed, Valid: true}}.sqlToEvent()
+		require.Equal(t, want, event.AdvanceNotificationPeriod, printed)
+	}
+}
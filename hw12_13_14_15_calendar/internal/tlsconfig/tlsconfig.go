@@ -0,0 +1,239 @@
+// Package tlsconfig builds server TLS configurations whose certificate and
+// client CA are reloaded from disk when the files change, so certificates
+// can be rotated without a restart.
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger"
+)
+
+var ErrNoClientCA = errors.New("client CA file has no certificates")
+
+type Config struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile enables mutual TLS: clients must present a certificate
+	// issued by one of these CAs.
+	ClientCAFile string
+	// MinVersion is "1.2" or "1.3". Empty means 1.2.
+	MinVersion string
+}
+
+// Reloader holds the current certificate and client CAs.
+type Reloader struct {
+	config     Config
+	minVersion uint16
+	logger     logger.Logger
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+}
+
+// New loads the files named by config.
+func New(config Config, logger logger.Logger) (*Reloader, error) {
+	minVersion, err := parseVersion(config.MinVersion)
+	if err != nil {
+		return nil, fmt.Errorf("tlsconfig.New: %w", err)
+	}
+
+	r := &Reloader{config: config, minVersion: minVersion, logger: logger}
+	if err := r.Reload(); err != nil {
+		return nil, fmt.Errorf("tlsconfig.New: %w", err)
+	}
+
+	return r, nil
+}
+
+func parseVersion(version string) (uint16, error) {
+	switch version {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS version %q", version)
+	}
+}
+
+func (r *Reloader) files() []string {
+	files := []string{r.config.CertFile, r.config.KeyFile}
+	if r.config.ClientCAFile != "" {
+		files = append(files, r.config.ClientCAFile)
+	}
+
+	return files
+}
+
+// Reload reads the certificate, key and client CAs again. On error the
+// previous ones stay in use.
+func (r *Reloader) Reload() error {
+	modTimes := make(map[string]time.Time)
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return fmt.Errorf("tlsconfig.Reload: %w", err)
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("tlsconfig.Reload: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.config.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.config.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("tlsconfig.Reload: %w", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("tlsconfig.Reload: %s: %w", r.config.ClientCAFile, ErrNoClientCA)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+
+	return nil
+}
+
+// changed reports whether any file was modified since the last reload.
+func (r *Reloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			// Possibly mid-rotation; look again on the next tick.
+			continue
+		}
+		if !info.ModTime().Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Watch reloads the files every interval while they keep changing, until ctx
+// is done.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				r.logger.Error("failed to reload TLS certificates", "err", err)
+				continue
+			}
+			r.logger.Info("reloaded TLS certificates")
+		}
+	}
+}
+
+// ServerConfig returns a configuration that always uses the current
+// certificate and client CAs. The client chain is verified here rather
+// than through ClientCAs so that reloaded CAs apply to new connections.
+func (r *Reloader) ServerConfig() *tls.Config {
+	config := &tls.Config{
+		MinVersion: r.minVersion,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return r.cert, nil
+		},
+	}
+	if r.config.ClientCAFile != "" {
+		config.ClientAuth = tls.RequireAnyClientCert
+		config.VerifyConnection = r.verifyClient
+	}
+
+	return config
+}
+
+func (r *Reloader) verifyClient(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("tlsconfig: client certificate required")
+	}
+
+	r.mu.RLock()
+	roots := r.clientCAs
+	r.mu.RUnlock()
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return fmt.Errorf("tlsconfig: verifying client certificate: %w", err)
+	}
+
+	return nil
+}
+
+// ClientIdentity is the common name of the client certificate. It is only
+// meaningful on connections accepted with mutual TLS, where ServerConfig has
+// verified that certificate.
+func ClientIdentity(cs *tls.ConnectionState) (string, bool) {
+	if cs == nil || len(cs.PeerCertificates) == 0 {
+		return "", false
+	}
+	name := cs.PeerCertificates[0].Subject.CommonName
+
+	return name, name != ""
+}
+
+// ClientConfig is for clients of a server using ServerConfig: it trusts the
+// CAs in caFile, or the system roots if caFile is empty, and presents the
+// certificate in certFile and keyFile, if given, for mutual TLS.
+func ClientConfig(caFile, certFile, keyFile, serverName string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: serverName}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("tlsconfig.ClientConfig: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tlsconfig.ClientConfig: %s has no certificates", caFile)
+		}
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tlsconfig.ClientConfig: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
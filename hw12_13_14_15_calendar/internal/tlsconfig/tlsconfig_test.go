@@ -0,0 +1,222 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	loggerslog "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger/slog"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/tlsconfig/tlstest"
+	"github.com/stretchr/testify/require"
+)
+
+func newLogger(t *testing.T) *loggerslog.Logger {
+	t.Helper()
+	logg, err := loggerslog.New(io.Discard, "INFO")
+	require.NoError(t, err)
+
+	return logg
+}
+
+type handshakeResult struct {
+	server    tls.ConnectionState
+	serverErr error
+	clientErr error
+}
+
+// handshake connects client to server over loopback.
+func handshake(t *testing.T, server, client *tls.Config) handshakeResult {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		conn, err := tls.Dial("tcp", l.Addr().String(), client)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+		// Under TLS 1.3 the server checks the client certificate after the
+		// client is done; reading waits for its verdict.
+		_, err = conn.Read(make([]byte, 1))
+		done <- err
+	}()
+
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	var result handshakeResult
+	srv := tls.Server(conn, server)
+	result.serverErr = srv.Handshake()
+	if result.serverErr == nil {
+		result.server = srv.ConnectionState()
+		_, result.serverErr = srv.Write([]byte{0})
+	} else {
+		conn.Close()
+	}
+	result.clientErr = <-done
+
+	return result
+}
+
+func clientConfig(t *testing.T, ca *tlstest.CA, certFile, keyFile string) *tls.Config {
+	t.Helper()
+	config, err := ClientConfig(ca.CertFile, certFile, keyFile, "localhost")
+	require.NoError(t, err)
+
+	return config
+}
+
+func TestServerConfig(t *testing.T) {
+	ca := tlstest.NewCA(t, "calendar CA")
+	certFile, keyFile := ca.Server(t, "calendar")
+
+	certs, err := New(Config{CertFile: certFile, KeyFile: keyFile}, newLogger(t))
+	require.NoError(t, err)
+
+	result := handshake(t, certs.ServerConfig(), clientConfig(t, ca, "", ""))
+	require.NoError(t, result.serverErr)
+	require.NoError(t, result.clientErr)
+	_, ok := ClientIdentity(&result.server)
+	require.False(t, ok)
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := tlstest.NewCA(t, "calendar CA")
+	certFile, keyFile := ca.Server(t, "calendar")
+	aliceCert, aliceKey := ca.Client(t, "alice")
+	other := tlstest.NewCA(t, "other CA")
+	malloryCert, malloryKey := other.Client(t, "mallory")
+	serverAsClientCert, serverAsClientKey := ca.Server(t, "bob")
+
+	certs, err := New(Config{CertFile: certFile, KeyFile: keyFile, ClientCAFile: ca.CertFile}, newLogger(t))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		certFile string
+		keyFile  string
+		user     string
+	}{
+		{name: "client certificate", certFile: aliceCert, keyFile: aliceKey, user: "alice"},
+		{name: "no client certificate"},
+		{name: "unknown issuer", certFile: malloryCert, keyFile: malloryKey},
+		{name: "not for client auth", certFile: serverAsClientCert, keyFile: serverAsClientKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := handshake(t, certs.ServerConfig(), clientConfig(t, ca, tt.certFile, tt.keyFile))
+
+			if tt.user == "" {
+				require.Error(t, result.serverErr)
+				require.Error(t, result.clientErr)
+				return
+			}
+			require.NoError(t, result.serverErr)
+			require.NoError(t, result.clientErr)
+			user, ok := ClientIdentity(&result.server)
+			require.True(t, ok)
+			require.Equal(t, tt.user, user)
+		})
+	}
+}
+
+func TestMinVersion(t *testing.T) {
+	ca := tlstest.NewCA(t, "calendar CA")
+	certFile, keyFile := ca.Server(t, "calendar")
+
+	certs, err := New(Config{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.3"}, newLogger(t))
+	require.NoError(t, err)
+
+	client := clientConfig(t, ca, "", "")
+	client.MaxVersion = tls.VersionTLS12
+	result := handshake(t, certs.ServerConfig(), client)
+	require.Error(t, result.serverErr)
+
+	result = handshake(t, certs.ServerConfig(), clientConfig(t, ca, "", ""))
+	require.NoError(t, result.serverErr)
+	require.Equal(t, uint16(tls.VersionTLS13), result.server.Version)
+
+	_, err = New(Config{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.1"}, newLogger(t))
+	require.Error(t, err)
+}
+
+func TestNewMissingFiles(t *testing.T) {
+	ca := tlstest.NewCA(t, "calendar CA")
+	certFile, keyFile := ca.Server(t, "calendar")
+
+	_, err := New(Config{CertFile: certFile, KeyFile: keyFile + ".missing"}, newLogger(t))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	_, err = New(Config{CertFile: certFile, KeyFile: keyFile, ClientCAFile: keyFile}, newLogger(t))
+	require.ErrorIs(t, err, ErrNoClientCA)
+}
+
+// replace overwrites dst with src and moves its modification time forward,
+// so the change is seen regardless of the file system's time granularity.
+func replace(t *testing.T, dst, src string) {
+	t.Helper()
+	data, err := os.ReadFile(src)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(dst, data, 0o600))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(dst, later, later))
+}
+
+func TestWatchReloads(t *testing.T) {
+	ca := tlstest.NewCA(t, "calendar CA")
+	certFile, keyFile := ca.Server(t, "old")
+	newCertFile, newKeyFile := ca.Server(t, "new")
+
+	certs, err := New(Config{CertFile: certFile, KeyFile: keyFile}, newLogger(t))
+	require.NoError(t, err)
+	server := certs.ServerConfig()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go certs.Watch(ctx, 10*time.Millisecond)
+
+	servedName := func() string {
+		client := clientConfig(t, ca, "", "")
+		var name string
+		client.VerifyConnection = func(cs tls.ConnectionState) error {
+			name = cs.PeerCertificates[0].Subject.CommonName
+			return nil
+		}
+		result := handshake(t, server, client)
+		require.NoError(t, result.clientErr)
+
+		return name
+	}
+	require.Equal(t, "old", servedName())
+
+	replace(t, keyFile, newKeyFile)
+	replace(t, certFile, newCertFile)
+	require.Eventually(t, func() bool { return servedName() == "new" }, time.Second, 10*time.Millisecond)
+}
+
+func TestReloadKeepsCertificateOnError(t *testing.T) {
+	ca := tlstest.NewCA(t, "calendar CA")
+	certFile, keyFile := ca.Server(t, "old")
+	_, otherKeyFile := ca.Server(t, "other")
+
+	certs, err := New(Config{CertFile: certFile, KeyFile: keyFile}, newLogger(t))
+	require.NoError(t, err)
+
+	// Half-way through a rotation the key no longer matches the certificate.
+	replace(t, keyFile, otherKeyFile)
+	require.Error(t, certs.Reload())
+
+	result := handshake(t, certs.ServerConfig(), clientConfig(t, ca, "", ""))
+	require.NoError(t, result.serverErr)
+	require.NoError(t, result.clientErr)
+}
@@ -6,6 +6,6 @@ ADD COLUMN notified BOOLEAN DEFAULT FALSE;
 
 -- +goose Down
 -- +goose StatementBegin
-ALTER TABLE your_table
+ALTER TABLE events
 DROP COLUMN notified;
 -- +goose StatementEnd
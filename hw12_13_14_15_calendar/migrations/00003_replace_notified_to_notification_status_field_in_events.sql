@@ -13,6 +13,7 @@ ADD COLUMN notification_status notification_status DEFAULT 'idle';
 -- +goose StatementBegin
 ALTER TABLE events
 DROP COLUMN notification_status;
+DROP TYPE notification_status;
 ALTER TABLE events
 ADD COLUMN notified BOOLEAN DEFAULT FALSE;
 -- +goose StatementEnd
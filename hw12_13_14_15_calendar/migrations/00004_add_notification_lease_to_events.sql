@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE events
+ADD COLUMN claimed_by TEXT,
+ADD COLUMN lease_expires_at TIMESTAMP;
+
+CREATE INDEX events_notification_status_date_idx ON events (notification_status, date);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX events_notification_status_date_idx;
+
+ALTER TABLE events
+DROP COLUMN lease_expires_at,
+DROP COLUMN claimed_by;
+-- +goose StatementEnd
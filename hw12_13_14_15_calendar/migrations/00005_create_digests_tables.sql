@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TYPE digest_kind AS ENUM ('daily', 'weekly');
+
+CREATE TABLE IF NOT EXISTS digest_subscriptions (
+  user_id uuid NOT NULL,
+  kind digest_kind NOT NULL,
+  timezone TEXT NOT NULL DEFAULT 'UTC',
+  send_at TIME NOT NULL DEFAULT '08:00',
+  PRIMARY KEY (user_id, kind)
+);
+
+CREATE TABLE IF NOT EXISTS digests (
+  user_id uuid NOT NULL,
+  kind digest_kind NOT NULL,
+  period_start DATE NOT NULL,
+  notification_status notification_status NOT NULL DEFAULT 'sending',
+  PRIMARY KEY (user_id, kind, period_start)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE digests;
+DROP TABLE digest_subscriptions;
+DROP TYPE digest_kind;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Claims of digests that are not published yet expire with their lease;
+-- rows published earlier have none.
+ALTER TABLE digests
+ADD COLUMN lease_expires_at TIMESTAMP;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE digests
+DROP COLUMN lease_expires_at;
+-- +goose StatementEnd
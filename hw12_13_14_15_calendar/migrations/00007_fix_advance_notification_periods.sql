@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Periods used to be written as a bare count of nanoseconds, which
+-- Postgres reads as seconds. No real period comes near a billion seconds,
+-- about 31 years, so any row that long holds nanoseconds.
+UPDATE events
+SET advance_notification_period = advance_notification_period / 1000000000
+WHERE advance_notification_period >= INTERVAL '1000000000 seconds';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- The periods were unreadable in the old layout, so they stay converted.
+-- +goose StatementEnd
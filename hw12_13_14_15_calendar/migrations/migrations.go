@@ -0,0 +1,8 @@
+// Package migrations embeds the schema migrations of the calendar database,
+// so the binaries can apply them without the files at hand.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
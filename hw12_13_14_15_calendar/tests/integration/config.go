@@ -6,7 +6,10 @@ import "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/helpe
 
 type Config struct {
 	ClearInterval int
-	Interval      int
+	WorkerID      string
+	BatchSize     int
+	Lease         int
+	Jobs          map[string]JobConf
 	Logger        LoggerConf
 	DB            DBConf
 	Storage       string
@@ -15,6 +18,12 @@ type Config struct {
 	Queue         QueueConf
 }
 
+type JobConf struct {
+	Schedule string
+	Jitter   int
+	Timeout  int
+}
+
 type LoggerConf struct {
 	Level string
 }
@@ -45,7 +54,7 @@ type QueueConf struct {
 }
 
 func LoadConfig(path string) (Config, error) {
-	config, err := helper.NewConfig[Config](path)
+	config, err := helper.LoadConfig(helper.ConfigOptions[Config]{Files: []string{path}})
 	if err != nil {
 		return Config{}, err
 	}
@@ -13,6 +13,8 @@ import (
 
 	pb "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/api"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/app"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/clock"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/health"
 	loggerslog "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/logger/slog"
 	internalgrpc "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/server/grpc"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage"
@@ -20,6 +22,8 @@ import (
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 	"github.com/stretchr/testify/suite"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
@@ -68,7 +72,13 @@ func TestMain(m *testing.M) {
 		log.Fatalf("failed to connect to db: %v", err)
 	}
 
-	store = sqlstorage.New(config.DB.User, config.DB.Password, config.DB.Name, config.DB.Host, config.DB.Port)
+	store = sqlstorage.New(sqlstorage.Config{
+		User:     config.DB.User,
+		Password: config.DB.Password,
+		Name:     config.DB.Name,
+		Host:     config.DB.Host,
+		Port:     config.DB.Port,
+	}, clock.New())
 	err = store.Connect(context.TODO())
 	if err != nil {
 		log.Fatalf("failed to connect storage: %v", err)
@@ -81,8 +91,9 @@ func TestMain(m *testing.M) {
 }
 
 func (s *IntegrationSuite) SetupSuite() {
-	app := app.New(logg, store)
-	s.handlers = internalgrpc.NewServer(logg, app, config.GRPC.Host, config.GRPC.Port)
+	app := app.New(logg, store, app.Config{})
+	s.handlers = internalgrpc.NewServer(logg, app, health.New(health.DefaultTimeout), nil, nil,
+		config.GRPC.Host, config.GRPC.Port)
 }
 
 func (s *IntegrationSuite) TearDownTest() {
@@ -105,6 +116,8 @@ type eventSQL struct {
 	UserID                    string                     `db:"user_id"`
 	AdvanceNotificationPeriod sql.NullString             `db:"advance_notification_period"`
 	NotificationStatus        storage.NotificationStatus `db:"notification_status"`
+	ClaimedBy                 sql.NullString             `db:"claimed_by"`
+	LeaseExpiresAt            sql.NullTime               `db:"lease_expires_at"`
 }
 
 func (s *IntegrationSuite) TestCreateEvent() {
@@ -144,12 +157,13 @@ func (s *IntegrationSuite) validationErr(err error) {
 		"user_id":  "not valid uuid",
 	}
 
-	var errs []*pb.BadRequest_FieldValiation
+	s.Equal(codes.InvalidArgument, st.Code())
+	var errs []*errdetails.BadRequest_FieldViolation
 	for _, detail := range st.Details() {
-		if ty, ok := detail.(*pb.BadRequest); ok {
-			errs = ty.GetErrors()
+		if ty, ok := detail.(*errdetails.BadRequest); ok {
+			errs = ty.GetFieldViolations()
 			for _, violation := range errs {
-				s.Equal(expect[violation.Field], violation.GetDescription(), "field %s", violation.Field)
+				s.Equal(expect[violation.GetField()], violation.GetDescription(), "field %s", violation.GetField())
 			}
 		}
 	}
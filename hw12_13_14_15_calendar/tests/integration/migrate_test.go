@@ -0,0 +1,32 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"testing"
+
+	sqlstorage "github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/storage/sql"
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/migrations"
+	"github.com/stretchr/testify/require"
+)
+
+// The database is migrated by the migrations service before the tests run.
+func TestMigrations(t *testing.T) {
+	all, err := sqlstorage.LoadMigrations(migrations.FS)
+	require.NoError(t, err)
+
+	applied, err := store.MigrateUp(context.TODO(), all)
+	require.NoError(t, err)
+	require.Empty(t, applied, "every migration is applied already")
+
+	version, err := store.SchemaVersion(context.TODO())
+	require.NoError(t, err)
+	require.Equal(t, all[len(all)-1].Version, version)
+
+	status, err := store.MigrationStatus(context.TODO(), all)
+	require.NoError(t, err)
+	for _, s := range status {
+		require.True(t, s.Applied, s.Name)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"log"
 	"time"
 
+	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/clock"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/queue"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/scheduler"
 	"github.com/AndreyChufelin/homework/hw12_13_14_15_calendar/internal/sender"
@@ -18,9 +19,9 @@ import (
 
 type NotificationSuite struct {
 	suite.Suite
-	queue    queue.Queue
-	producer queue.Producer
-	consumer queue.Consumer
+	queue    *queue.Queue
+	producer *queue.Producer
+	consumer *queue.Consumer
 	channel  *amqp.Channel
 }
 
@@ -36,32 +37,57 @@ func (s *NotificationSuite) SetupSuite() {
 		log.Fatalf("failed to connect channel queue: %v", err)
 	}
 
-	s.queue = queue.NewQueue(config.Queue.User, config.Queue.Password, config.Queue.Host, config.Queue.Port)
+	s.queue = queue.NewQueue(config.Queue.User, config.Queue.Password, config.Queue.Host, config.Queue.Port, logg)
 	err = s.queue.Start()
 	if err != nil {
 		log.Fatal("failed to start queue", err)
 	}
 
-	s.producer = queue.NewProducer(queueName, s.queue.Conn)
+	s.producer = queue.NewProducer(queueName, s.queue)
 	err = s.producer.Start()
 	if err != nil {
 		logg.Error("failed to start producer", "err", err)
 	}
 
+	jobs := make(map[string]scheduler.JobConfig, len(config.Jobs))
+	for name, job := range config.Jobs {
+		jobs[name] = scheduler.JobConfig{
+			Schedule: job.Schedule,
+			Jitter:   time.Duration(job.Jitter) * time.Second,
+			Timeout:  time.Duration(job.Timeout) * time.Second,
+		}
+	}
+
 	go func() {
-		sch := scheduler.NewScheduler(s.producer, config.ClearInterval, config.Interval, logg, store)
-		sch.Start(context.TODO())
+		sch := scheduler.NewScheduler(
+			scheduler.Config{
+				ClearInterval: config.ClearInterval,
+				WorkerID:      config.WorkerID,
+				BatchSize:     config.BatchSize,
+				Lease:         time.Duration(config.Lease) * time.Second,
+				Jobs:          jobs,
+			},
+			s.producer,
+			logg,
+			store,
+			clock.New(),
+			store.NewLocker(scheduler.LockKey),
+		)
+		err := sch.Start(context.TODO())
+		if err != nil {
+			log.Fatal("failed start scheduler", err)
+		}
 	}()
 
-	s.consumer = queue.NewConsumer(queueName, s.queue.Conn)
+	s.consumer = queue.NewConsumer(queueName, s.queue)
 	err = s.consumer.Start()
 	if err != nil {
 		log.Fatal("failed start consumer", err)
 	}
 
 	go func() {
-		sen := sender.NewSender(s.consumer, logg, store)
-		err = sen.Start()
+		sen := sender.NewSender(s.consumer, logg, store, sender.NewLogChannel(logg))
+		err = sen.Start(context.TODO())
 		if err != nil {
 			log.Fatal("failed start sender", err)
 		}
@@ -95,7 +121,7 @@ func (s *NotificationSuite) TestNotificationSends() {
 		VALUES (:title, :date, :enddate, :description, :userid, :advancenotificationperiod)`, &e)
 	s.NoError(err)
 
-	wait := 3 * time.Duration(config.Interval) * time.Second
+	wait := 3 * time.Second
 	s.Eventually(func() bool {
 		var events []eventSQL
 		err = db.Select(&events, "SELECT * FROM events")
@@ -119,7 +145,7 @@ func (s *NotificationSuite) TestEventClears() {
 		VALUES (:title, :date, :enddate, :description, :userid, :advancenotificationperiod)`, &e)
 	s.NoError(err)
 
-	wait := 3 * time.Duration(config.Interval) * time.Second
+	wait := 3 * time.Second
 	s.Eventually(func() bool {
 		var events []eventSQL
 		err = db.Select(&events, "SELECT * FROM events")